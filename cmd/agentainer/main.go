@@ -3,13 +3,18 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -17,17 +22,22 @@ import (
 
 	dockerclient "github.com/docker/docker/client"
 	"github.com/go-redis/redis/v8"
+	"github.com/moby/term"
 	"github.com/spf13/cobra"
 	"github.com/agentainer/agentainer-lab/internal/agent"
+	"github.com/agentainer/agentainer-lab/internal/alerts"
 	"github.com/agentainer/agentainer-lab/internal/api"
 	"github.com/agentainer/agentainer-lab/internal/backup"
 	"github.com/agentainer/agentainer-lab/internal/config"
 	"github.com/agentainer/agentainer-lab/internal/logging"
 	"github.com/agentainer/agentainer-lab/internal/requests"
+	"github.com/agentainer/agentainer-lab/internal/secrets"
+	"github.com/agentainer/agentainer-lab/internal/tokens"
 	"github.com/agentainer/agentainer-lab/internal/storage"
 	"github.com/agentainer/agentainer-lab/internal/sync"
 	"github.com/agentainer/agentainer-lab/pkg/docker"
 	"github.com/agentainer/agentainer-lab/pkg/metrics"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -127,6 +137,7 @@ var startCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		startAgent(args[0])
+		waitAfter(cmd, args[0])
 	},
 }
 
@@ -163,6 +174,23 @@ var resumeCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		resumeAgent(args[0])
+		waitAfter(cmd, args[0])
+	},
+}
+
+var waitCmd = &cobra.Command{
+	Use:   "wait [agent-id]",
+	Short: "Wait until an agent is running, healthy, stopped, paused or failed",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		target, _ := cmd.Flags().GetString("for")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		
+		if err := waitForAgent(args[0], target, timeout, interval); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Agent %s is %s\n", args[0], target)
 	},
 }
 
@@ -179,7 +207,9 @@ var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all agents",
 	Run: func(cmd *cobra.Command, args []string) {
-		listAgents()
+		namespace, _ := cmd.Flags().GetString("namespace")
+		filters, _ := cmd.Flags().GetStringSlice("filter")
+		listAgents(namespace, filters)
 	},
 }
 
@@ -192,6 +222,36 @@ var invokeCmd = &cobra.Command{
 	},
 }
 
+var execCmd = &cobra.Command{
+	Use:   "exec [agent-id] -- [command...]",
+	Short: "Run a command inside a running agent",
+	Long: `Run a command inside a running agent's container and exit with its exit code.
+
+Use -it for an interactive shell:
+  agentainer exec -it my-agent -- sh`,
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		interactive, _ := cmd.Flags().GetBool("interactive")
+		tty, _ := cmd.Flags().GetBool("tty")
+		os.Exit(execAgent(args[0], args[1:], interactive, tty))
+	},
+}
+
+var cpCmd = &cobra.Command{
+	Use:   "cp <agent-id>:<path> <local-path> | <local-path> <agent-id>:<path>",
+	Short: "Copy files or directories between an agent and the local filesystem",
+	Long: `Copy a file or directory out of an agent's container, or into it.
+Directories are copied recursively and file modes are preserved. The agent
+does not need to be running.
+
+  agentainer cp my-agent:/app/output ./output
+  agentainer cp ./config.json my-agent:/app/config.json`,
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		copyFiles(args[0], args[1])
+	},
+}
+
 var removeCmd = &cobra.Command{
 	Use:   "remove [agent-id]",
 	Short: "Remove an agent (stops container and deletes from system)",
@@ -208,60 +268,227 @@ This operation is irreversible. Use with caution.`,
 	},
 }
 
+var startAllCmd = &cobra.Command{
+	Use:   "start-all",
+	Short: "Start every agent matching the filters",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filters, _ := cmd.Flags().GetStringSlice("filter")
+		bulkAction(agent.BatchStart, filters, true)
+	},
+}
+
+var stopAllCmd = &cobra.Command{
+	Use:   "stop-all",
+	Short: "Stop every agent matching the filters",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filters, _ := cmd.Flags().GetStringSlice("filter")
+		bulkAction(agent.BatchStop, filters, true)
+	},
+}
+
+var removeAllCmd = &cobra.Command{
+	Use:   "remove-all",
+	Short: "Remove every agent matching the filters",
+	Long: `Remove every agent matching the filters. Replicas are removed with
+their parent. You are asked to confirm unless --yes is given.
+
+This operation is irreversible. Use with caution.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filters, _ := cmd.Flags().GetStringSlice("filter")
+		yes, _ := cmd.Flags().GetBool("yes")
+		bulkAction(agent.BatchRemove, filters, yes)
+	},
+}
+
+var scaleCmd = &cobra.Command{
+	Use:   "scale [agent-id]",
+	Short: "Set the number of running replicas of an agent",
+	Long: `Scale an agent to the given number of instances, counting the agent itself.
+Replicas share the agent's image, environment and volumes, and the proxy
+spreads requests across all running instances. Removing the agent also
+removes its replicas. Stopping, pausing or restarting the agent does not
+affect its replicas, but they only receive traffic while the agent itself is
+running; until then requests are queued for replay.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		replicas, _ := cmd.Flags().GetInt("replicas")
+		scaleAgent(args[0], replicas)
+	},
+}
+
 var requestsCmd = &cobra.Command{
 	Use:   "requests [agent-id]",
 	Short: "View pending requests for an agent",
 	Long: `View and manage persisted requests for an agent.
 
 This shows requests that were sent to the agent while it was not running,
-and are queued for replay when the agent starts.`,
+and are queued for replay when the agent starts. Requests are replayed
+highest X-Agentainer-Priority first, then in order of arrival.`,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		viewRequests(args[0])
 	},
 }
 
+var requestsDeadLetterCmd = &cobra.Command{
+	Use:   "deadletter [agent-id]",
+	Short: "View requests that exhausted their retries",
+	Long: `View requests that failed on every replay attempt and were moved to the
+agent's dead-letter queue. Requeue one with
+POST /agents/{id}/requests/{request-id}/requeue.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		viewDeadLetterRequests(args[0])
+	},
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.agentainer/config.yaml)")
 
 	deployCmd.Flags().StringP("config", "", "", "Deploy from YAML configuration file")
+	deployCmd.Flags().Bool("dry-run", false, "With --config, validate the file and print the resolved agents without deploying")
 	deployCmd.Flags().StringP("image", "i", "", "Docker image name (required for single deployment)")
 	deployCmd.Flags().StringP("name", "n", "", "Agent name (required for single deployment)")
+	deployCmd.Flags().String("namespace", "", "Namespace to deploy the agent into (default from config)")
+	deployCmd.Flags().StringSliceP("label", "l", []string{}, "Label the agent with key=value (can be used multiple times)")
 	deployCmd.Flags().StringSliceP("env", "e", []string{}, "Environment variables (key=value)")
+	deployCmd.Flags().StringSlice("env-file", []string{}, "Read environment variables from a .env file (can be used multiple times; --env takes precedence)")
 	deployCmd.Flags().StringP("cpu", "c", "", "CPU limit (e.g., 0.5, 1, 2 for cores)")
 	deployCmd.Flags().StringP("memory", "m", "", "Memory limit (e.g., 512M, 2G)")
+	deployCmd.Flags().String("size", "", "Resource preset from config (e.g., small, medium, large); --cpu/--memory override it")
+	deployCmd.Flags().String("memory-swap", "", "Memory plus swap limit (e.g., 1G); -1 for unlimited swap; requires --memory")
+	deployCmd.Flags().String("memory-reservation", "", "Soft memory limit enforced under host memory pressure (e.g., 256M); must not exceed --memory")
+	deployCmd.Flags().Int64("cpu-shares", 0, "Relative CPU weight under contention (default 1024 when unset)")
+	deployCmd.Flags().Int64("pids-limit", 0, "Maximum number of processes in the container (0 = unlimited)")
+	deployCmd.Flags().StringSlice("ulimit", []string{}, "Ulimit as name=soft[:hard] (e.g., nofile=1024:2048, can be used multiple times)")
+	deployCmd.Flags().StringSlice("network", []string{}, "Join an additional user-defined Docker network (can be used multiple times)")
+	deployCmd.Flags().StringSlice("network-alias", []string{}, "DNS name other agents can reach this agent by (can be used multiple times)")
+	deployCmd.Flags().Bool("inject-redis", false, "Set REDIS_HOST and REDIS_PORT so the agent can reach Agentainer's Redis")
+	deployCmd.Flags().Bool("inject-api", false, "Set AGENTAINER_API_URL so the agent can reach the Agentainer API")
+	deployCmd.Flags().String("gpus", "", "NVIDIA GPUs to give the agent: all, count=N or device IDs (e.g., 0,1)")
 	deployCmd.Flags().BoolP("auto-restart", "r", false, "Auto-restart on crash")
+	deployCmd.Flags().Float64("replay-rate", 0, "Maximum replays of queued requests per second for this agent (0 = server default)")
+	deployCmd.Flags().Float64("rate-limit", 0, "Maximum proxied requests per second for this agent (0 = unlimited)")
+	deployCmd.Flags().Int("rate-limit-burst", 0, "Requests allowed in a burst above the rate limit (default: rate rounded up)")
+	deployCmd.Flags().String("proxy-timeout", "", "How long the proxy waits for the agent's response headers, e.g. 30s (default: no limit)")
+	deployCmd.Flags().String("stop-signal", "", "Signal sent to stop the agent (e.g., SIGINT; default the image's STOPSIGNAL, usually SIGTERM)")
+	deployCmd.Flags().String("stop-timeout", "", "How long the agent has to exit after the stop signal before it is killed (e.g., 30s; default 10s)")
+	deployCmd.Flags().Int("proxy-retries", 0, "Times to retry proxied GET/HEAD requests on 502/503 or connection errors")
+	deployCmd.Flags().Int("max-restarts", 0, "Mark the agent failed after this many restarts within the restart window (0 = unlimited)")
+	deployCmd.Flags().String("restart-window", "", "Window for counting restarts (e.g., 5m, 1h; default 10m)")
 	deployCmd.Flags().StringP("token", "t", "", "Agent token")
+	deployCmd.Flags().Int("proxy-port", 0, "Container port the proxy forwards to (default 8000)")
+	deployCmd.Flags().StringSlice("route", []string{}, "Named proxy route to another container port (name=port, e.g., admin=9000 for /agent/{id}/admin/)")
 	deployCmd.Flags().StringSliceP("port", "p", []string{}, "DEPRECATED: Port mappings are no longer supported. All access is through proxy.")
 	deployCmd.Flags().StringSliceP("volume", "v", []string{}, "Volume mappings (host:container[:ro], e.g., ./data:/app/data or ./config:/app/config:ro)")
+	deployCmd.Flags().Bool("shared-volume", false, "Allow mounting host paths that another running agent already mounts")
+	deployCmd.Flags().Bool("allow-arch-mismatch", false, "Deploy an image built for a different CPU architecture than the Docker host")
+	deployCmd.Flags().StringSlice("secret", []string{}, "Inject a stored secret as an environment variable (ENV_VAR=secret-name, can be used multiple times)")
+	deployCmd.Flags().StringArray("build-arg", []string{}, "Build arg for a Dockerfile image (KEY=VALUE, or KEY to take the value from the environment; can be used multiple times)")
+	deployCmd.Flags().String("target", "", "Stage of a multi-stage Dockerfile to build")
+	deployCmd.Flags().Bool("no-cache", false, "Build a Dockerfile image without using cached layers")
+	deployCmd.Flags().Bool("pin-digest", false, "Create the container from the image's resolved digest rather than its tag")
+	deployCmd.Flags().String("pull", "", "Image pull policy: always, missing or never (default missing, or never if deploy.auto_pull is off)")
+	deployCmd.Flags().String("registry-auth", "", "Credentials for pulling the image from a private registry (username:password), overriding config")
+	deployCmd.Flags().String("health-type", "http", "Health check type (http, tcp, exec)")
 	deployCmd.Flags().String("health-endpoint", "/health", "Health check endpoint path")
+	deployCmd.Flags().String("health-command", "", "Command run inside the container for exec health checks (exit 0 = healthy)")
 	deployCmd.Flags().String("health-interval", "30s", "Health check interval")
 	deployCmd.Flags().String("health-timeout", "5s", "Health check timeout")
 	deployCmd.Flags().Int("health-retries", 3, "Health check retry count before restart")
+	deployCmd.Flags().String("health-start-period", "", "Time for the agent to start up during which failed health checks are not counted (e.g., 60s)")
+	deployCmd.Flags().Bool("health-use-image", false, "Use the image's built-in Docker HEALTHCHECK instead of the endpoint check")
+	deployCmd.Flags().String("readiness-type", "", "Readiness probe type (http, tcp, exec); traffic is held back until it passes")
+	deployCmd.Flags().String("readiness-endpoint", "", "Readiness probe endpoint path (default the health endpoint)")
+	deployCmd.Flags().String("readiness-command", "", "Command run inside the container for exec readiness probes (exit 0 = ready)")
+	deployCmd.Flags().String("readiness-interval", "5s", "Readiness probe interval")
 
 	logsCmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	logsCmd.Flags().String("tail", "", "Number of lines to show from the end of the logs (default all)")
+	logsCmd.Flags().String("since", "", "Show logs since a timestamp (e.g. 2024-01-01T00:00:00Z) or relative duration (e.g. 10m)")
+
+	execCmd.Flags().BoolP("interactive", "i", false, "Keep stdin attached to the command")
+	execCmd.Flags().BoolP("tty", "t", false, "Allocate a pseudo-TTY")
+	
+	scaleCmd.Flags().Int("replicas", 1, "Total number of instances, including the agent itself")
+	
+	for _, cmd := range []*cobra.Command{startAllCmd, stopAllCmd, removeAllCmd} {
+		cmd.Flags().StringSlice("filter", []string{}, "Only act on agents matching key=value (name, status, namespace, image, label=key=value; name and image accept globs such as worker-*)")
+	}
+	removeAllCmd.Flags().BoolP("yes", "y", false, "Don't ask for confirmation")
+	
+	listCmd.Flags().String("namespace", "", "Only list agents in this namespace")
+	listCmd.Flags().StringSliceP("filter", "f", []string{}, "Only list agents matching key=value (name, status, namespace, image, label=key=value)")
 	
 	metricsCmd.Flags().BoolP("history", "H", false, "Show metrics history")
 	metricsCmd.Flags().StringP("duration", "d", "1h", "History duration (e.g., 30m, 1h, 6h, 24h)")
+	topCmd.Flags().StringP("sort", "s", "cpu", "Sort by cpu, mem, net or name")
+	topCmd.Flags().Duration("interval", time.Second, "Refresh interval")
+	topCmd.Flags().StringP("namespace", "n", "", "Only show agents in this namespace")
+	
+	statsCmd.Flags().Bool("reset", false, "Clear the agent's request stats")
+	
+	eventsCmd.Flags().IntP("limit", "n", 0, "Only show the most recent events")
+	
+	waitCmd.Flags().String("for", "running", "State to wait for: running, healthy, stopped, paused or failed")
+	waitCmd.Flags().Duration("timeout", 60*time.Second, "Give up and exit non-zero after this long")
+	waitCmd.Flags().Duration("interval", time.Second, "Time between checks")
+	for _, cmd := range []*cobra.Command{startCmd, resumeCmd} {
+		cmd.Flags().Bool("wait", false, "Wait until the agent is running (and healthy, if it has a health check)")
+		cmd.Flags().Duration("wait-timeout", 60*time.Second, "How long --wait waits before exiting non-zero")
+	}
+	
+	inspectCmd.Flags().Bool("diff", false, "Show files added, changed or deleted in the container instead")
+	reconcileCmd.Flags().Bool("once", true, "Run a single sync pass; with --once=false keep running every --interval")
+	reconcileCmd.Flags().Duration("interval", 10*time.Second, "Time between sync passes when not running once")
 	
 	backupCreateCmd.Flags().StringP("name", "n", "", "Backup name (required)")
 	backupCreateCmd.Flags().StringP("description", "d", "", "Backup description")
 	backupCreateCmd.Flags().StringSliceP("agents", "a", []string{}, "Specific agents to backup (default: all)")
+	backupCreateCmd.Flags().Bool("include-volumes", false, "Archive the contents of each agent's read-write volumes")
 	backupCreateCmd.MarkFlagRequired("name")
 	
 	backupRestoreCmd.Flags().StringSliceP("agents", "a", []string{}, "Specific agents to restore (default: all)")
+	backupRestoreCmd.Flags().StringSlice("remap-volume", []string{}, "Restore a volume to a different host path (old:new, can be used multiple times)")
 	
 	backupCmd.AddCommand(backupCreateCmd)
 	backupCmd.AddCommand(backupListCmd)
 	backupCmd.AddCommand(backupRestoreCmd)
 	backupCmd.AddCommand(backupDeleteCmd)
 	backupCmd.AddCommand(backupExportCmd)
+	backupCmd.AddCommand(backupScheduleCmd)
 	
-	auditCmd.Flags().StringP("user", "u", "", "Filter by user ID")
-	auditCmd.Flags().StringP("action", "a", "", "Filter by action")
-	auditCmd.Flags().StringP("resource", "r", "", "Filter by resource type")
-	auditCmd.Flags().StringP("duration", "d", "24h", "Time duration to query")
+	auditCmd.PersistentFlags().StringP("user", "u", "", "Filter by user ID")
+	auditCmd.PersistentFlags().StringP("action", "a", "", "Filter by action")
+	auditCmd.PersistentFlags().StringP("resource", "r", "", "Filter by resource type")
+	auditCmd.PersistentFlags().StringP("duration", "d", "24h", "Time duration to query")
 	auditCmd.Flags().IntP("limit", "l", 100, "Maximum number of entries to show")
+	auditCmd.PersistentFlags().String("resource-id", "", "Filter by resource ID, e.g. an agent ID")
+	auditCmd.PersistentFlags().String("result", "", "Filter by result (success or failure)")
+	auditCmd.PersistentFlags().String("before", "", "Show entries before this RFC 3339 timestamp (page back)")
+	auditCmd.PersistentFlags().String("after", "", "Show entries after this RFC 3339 timestamp (page forward)")
+	auditExportCmd.Flags().String("format", "json", "Export format (json, csv)")
+	auditExportCmd.Flags().StringP("output", "o", "", "File to write (default stdout)")
+	auditCmd.AddCommand(auditExportCmd)
+	
+	secretsCreateCmd.Flags().String("value", "", "Secret value (prefer stdin so it stays out of shell history)")
+	secretsCmd.AddCommand(secretsCreateCmd)
+	secretsCmd.AddCommand(secretsListCmd)
+	secretsCmd.AddCommand(secretsDeleteCmd)
+	
+	tokensCreateCmd.Flags().String("user", "", "User the token's requests are attributed to (default: the token name)")
+	tokensCmd.AddCommand(tokensCreateCmd)
+	tokensCmd.AddCommand(tokensListCmd)
+	tokensCmd.AddCommand(tokensRevokeCmd)
+	tokensRotateCmd.Flags().Duration("grace", 0, "How long the old default token keeps working, e.g. 10m")
+	tokensCmd.AddCommand(tokensRotateCmd)
+	
+	configCmd.AddCommand(configViewCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configPathCmd)
 
 	rootCmd.AddCommand(serverCmd)
 	rootCmd.AddCommand(deployCmd)
@@ -270,21 +497,38 @@ func init() {
 	rootCmd.AddCommand(restartCmd)
 	rootCmd.AddCommand(pauseCmd)
 	rootCmd.AddCommand(resumeCmd)
+	rootCmd.AddCommand(waitCmd)
 	rootCmd.AddCommand(removeCmd)
+	rootCmd.AddCommand(scaleCmd)
+	rootCmd.AddCommand(startAllCmd)
+	rootCmd.AddCommand(stopAllCmd)
+	rootCmd.AddCommand(removeAllCmd)
 	rootCmd.AddCommand(logsCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(invokeCmd)
+	rootCmd.AddCommand(execCmd)
+	rootCmd.AddCommand(cpCmd)
+	requestsCmd.AddCommand(requestsDeadLetterCmd)
 	rootCmd.AddCommand(requestsCmd)
 	rootCmd.AddCommand(healthCmd)
 	rootCmd.AddCommand(metricsCmd)
+	rootCmd.AddCommand(topCmd)
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(eventsCmd)
+	rootCmd.AddCommand(inspectCmd)
+	rootCmd.AddCommand(reconcileCmd)
+	rootCmd.AddCommand(alertsCmd)
 	rootCmd.AddCommand(backupCmd)
 	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(secretsCmd)
+	rootCmd.AddCommand(tokensCmd)
+	rootCmd.AddCommand(configCmd)
 }
 
 func runServer() {
 	ctx := context.Background()
 
-	dockerClient, err := docker.NewClient(cfg.Docker.Host)
+	dockerClient, err := docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
 	if err != nil {
 		log.Fatalf("Failed to create Docker client: %v", err)
 	}
@@ -299,12 +543,31 @@ func runServer() {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 
+	store := newStore(redisClient)
+	defer store.Close()
+
 	storage := storage.NewStorage(redisClient)
-	agentMgr := agent.NewManager(dockerClient, redisClient, cfg.GetAgentConfigPath())
+	agentMgr := agent.NewManager(dockerClient, redisClient, store, cfg.GetAgentConfigPath(), cfg.Docker.OperationTimeout)
+	agentMgr.SetRegistryAuths(cfg.Registries)
+	agentMgr.SetAutoPull(cfg.Deploy.AutoPull)
+	agentMgr.SetAPIPort(cfg.Server.Port)
 	metricsCollector := metrics.NewCollector(dockerClient, storage)
+	metricsCollector.SetRetention(cfg.Metrics.RetentionDuration, cfg.Metrics.RawRetention)
 	
 	// Initialize logger
-	logger, err := logging.NewLogger(redisClient, "", true) // Console logging enabled
+	logLevels, err := logging.ParseLevels(cfg.Logging.Level, cfg.Logging.Modules)
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	logger, err := logging.NewLogger(redisClient, store, logging.Options{
+		Dir:         cfg.Logging.Dir,
+		NoFiles:     !cfg.Logging.File,
+		FileMaxSize: cfg.Logging.FileMaxSizeMB * 1024 * 1024,
+		MaxAge:      cfg.Logging.MaxAge,
+		MaxEntries:  cfg.Logging.MaxEntries,
+		Console:     true,
+		Levels:      logLevels,
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
@@ -313,33 +576,69 @@ func runServer() {
 	// Set global logger
 	logging.SetGlobalLogger(logger)
 	
+	auditSinks, err := logging.NewAuditSinks(cfg.Audit.Sinks)
+	if err != nil {
+		log.Fatalf("Failed to open audit sinks: %v", err)
+	}
+	logger.SetAuditSinks(auditSinks)
+	
 	logging.Info("server", "Agentainer server starting", map[string]interface{}{
 		"version": "1.0",
 		"host": cfg.Server.Host,
 		"port": cfg.Server.Port,
 	})
 
-	server := api.NewServer(cfg, agentMgr, storage, metricsCollector, redisClient, dockerClient)
+	secretStore, err := newSecretStore(redisClient)
+	if err != nil {
+		log.Fatalf("Failed to initialize secrets: %v", err)
+	}
+	agentMgr.SetSecretStore(secretStore)
+
+	server := api.NewServer(cfg, agentMgr, storage, store, metricsCollector, redisClient, dockerClient, secretStore)
+
+	// Bring agents back to their desired state after downtime, before the
+	// synchronizer overwrites their last known status
+	if cfg.Reconcile.OnStartup {
+		result, err := agentMgr.Reconcile(ctx, cfg.Reconcile.RestartAll)
+		if err != nil {
+			log.Printf("Startup reconciliation failed: %v", err)
+		} else {
+			log.Printf("Startup reconciliation checked %d agents: %d restarted, %d skipped, %d failed",
+				result.Checked, len(result.Restarted), len(result.Skipped), len(result.Failed))
+		}
+	}
 
 	// Start state synchronizer with more frequent updates
-	stateSynchronizer := sync.NewStateSynchronizer(dockerClient, redisClient, 10*time.Second) // Reduced from 30s to 10s
+	stateSynchronizer := sync.NewStateSynchronizer(dockerClient, redisClient, store, agentMgr, 10*time.Second) // Reduced from 30s to 10s
 	if err := stateSynchronizer.Start(ctx); err != nil {
 		log.Printf("Failed to start state synchronizer: %v", err)
 	} else {
 		defer stateSynchronizer.Stop()
+		server.SetStateSynchronizer(stateSynchronizer)
 		log.Println("State synchronizer started - agents will be automatically synced with Docker containers every 10 seconds")
 	}
 
 	// Start replay worker if request persistence is enabled
 	if cfg.Features.RequestPersistence {
-		requestMgr := requests.NewManager(redisClient)
-		replayWorker := requests.NewReplayWorker(requestMgr, redisClient)
+		requestMgr := requests.NewManager(redisClient, store)
+		replayWorker := requests.NewReplayWorker(requestMgr, redisClient, cfg.Replay.RateLimit)
 		go replayWorker.Start(ctx)
 		defer replayWorker.Stop()
 		
 		log.Println("Request persistence and replay enabled")
 	}
 
+	// Run scheduled backups if configured
+	if cfg.Backup.Schedule != "" {
+		scheduler, err := backup.NewScheduler(backup.NewManager(agentMgr, redisClient, ""), redisClient, cfg.Backup.Schedule, cfg.Backup.RetentionDays, cfg.Backup.IncludeVolumes)
+		if err != nil {
+			log.Printf("Scheduled backups disabled: %v", err)
+		} else {
+			go scheduler.Start(ctx)
+			log.Printf("Scheduled backups enabled (%s, keeping %d days)", cfg.Backup.Schedule, cfg.Backup.RetentionDays)
+		}
+	}
+
 	go func() {
 		if err := server.Start(); err != nil {
 			log.Fatalf("Server failed to start: %v", err)
@@ -351,18 +650,34 @@ func runServer() {
 	<-quit
 
 	fmt.Println("Shutting down server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Stop(shutdownCtx); err != nil {
+		log.Printf("Server shutdown: %v", err)
+	}
 	dockerClient.Close()
 	redisClient.Close()
 }
 
 func deployAgent(cmd *cobra.Command) {
 	configFile, _ := cmd.Flags().GetString("config")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	envVars, _ := cmd.Flags().GetStringSlice("env")
+	envFiles, _ := cmd.Flags().GetStringSlice("env-file")
+	
+	envMap, err := buildEnv(envFiles, envVars)
+	if err != nil {
+		log.Fatalf("Failed to load environment: %v", err)
+	}
 	
 	// Check if deploying from YAML config file
 	if configFile != "" {
-		deployFromYAML(configFile)
+		deployFromYAML(configFile, envMap, dryRun)
 		return
 	}
+	if dryRun {
+		log.Fatal("--dry-run requires --config")
+	}
 	
 	// Otherwise, deploy single agent from CLI flags
 	image, _ := cmd.Flags().GetString("image")
@@ -375,99 +690,137 @@ func deployAgent(cmd *cobra.Command) {
 	
 	// Check if image is actually a Dockerfile
 	var dockerClient *dockerclient.Client
-	if docker.IsDockerfile(image) {
+	builtImage := docker.IsDockerfile(image)
+	buildArgFlags, _ := cmd.Flags().GetStringArray("build-arg")
+	buildTarget, _ := cmd.Flags().GetString("target")
+	noCache, _ := cmd.Flags().GetBool("no-cache")
+	if !builtImage && (len(buildArgFlags) > 0 || buildTarget != "" || noCache) {
+		log.Fatal("--build-arg, --target and --no-cache only apply when --image is a Dockerfile")
+	}
+	if builtImage {
+		buildArgs, err := docker.ParseBuildArgs(buildArgFlags)
+		if err != nil {
+			log.Fatalf("Invalid build arg: %v", err)
+		}
+		buildOpts := docker.BuildOptions{BuildArgs: buildArgs, Target: buildTarget, NoCache: noCache}
+		
 		// Only create Docker client if we need to build an image
-		var err error
-		dockerClient, err = docker.NewClient(cfg.Docker.Host)
+		dockerClient, err = docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
 		if err != nil {
 			log.Fatalf("Failed to create Docker client: %v", err)
 		}
 		
-		builder := docker.NewImageBuilder(dockerClient)
+		builder := docker.NewImageBuilder(dockerClient, cfg.Registries)
 		fmt.Printf("Detected Dockerfile: %s\n", image)
 		
-		// Generate unique image name
-		generatedImageName := docker.GenerateImageName(name)
-		finalImageName, err := builder.PreventDuplicateImage(context.Background(), generatedImageName)
+		// Reuse the image from a previous build of the same context
+		sourceHash, err := docker.SourceHash(image, buildOpts)
 		if err != nil {
-			log.Fatalf("Failed to generate unique image name: %v", err)
+			log.Fatalf("Failed to read build context: %v", err)
+		}
+		buildOpts.SourceHash = sourceHash
+		var finalImageName string
+		if !noCache {
+			findCtx, cancelFind := docker.WithOperationTimeout(context.Background(), cfg.Docker.OperationTimeout)
+			finalImageName, err = builder.FindBuiltImage(findCtx, sourceHash)
+			cancelFind()
+			if err != nil {
+				log.Fatalf("Failed to look for a previous build: %v", docker.CheckTimeout(err, cfg.Docker.OperationTimeout))
+			}
 		}
 		
-		fmt.Printf("Building Docker image: %s\n", finalImageName)
-		
-		// Create progress channel for build output
-		progressChan := make(chan string, 100)
-		buildCtx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-		defer cancel()
-		
-		// Start build progress display
-		doneChan := make(chan bool)
-		go func() {
-			spinner := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
-			spinIdx := 0
-			lastMsg := ""
+		if finalImageName != "" {
+			fmt.Printf("✓ Build context unchanged, reusing image %s (use --no-cache to rebuild)\n", finalImageName)
+		} else {
+			// Generate unique image name
+			generatedImageName := docker.GenerateImageName(name)
+			nameCtx, cancelName := docker.WithOperationTimeout(context.Background(), cfg.Docker.OperationTimeout)
+			finalImageName, err = builder.PreventDuplicateImage(nameCtx, generatedImageName)
+			cancelName()
+			if err != nil {
+				log.Fatalf("Failed to generate unique image name: %v", docker.CheckTimeout(err, cfg.Docker.OperationTimeout))
+			}
 			
-			for {
-				select {
-				case msg, ok := <-progressChan:
-					if !ok {
-						doneChan <- true
-						return
-					}
-					// Clear previous line and print new message
-					if lastMsg != "" {
-						fmt.Printf("\r%-120s", " ") // Clear line with more space
-					}
-					
-					// Truncate long messages
-					displayMsg := msg
-					if len(msg) > 100 {
-						displayMsg = msg[:97] + "..."
-					}
-					
-					if strings.HasPrefix(msg, "Step ") || strings.HasPrefix(msg, "Successfully ") {
-						fmt.Printf("\r%s %s\n", spinner[spinIdx], displayMsg)
-						lastMsg = ""
-					} else {
-						fmt.Printf("\r%s %s", spinner[spinIdx], displayMsg)
-						lastMsg = displayMsg
-					}
-					spinIdx = (spinIdx + 1) % len(spinner)
-				case <-time.After(100 * time.Millisecond):
-					if lastMsg != "" {
-						fmt.Printf("\r%s %s", spinner[spinIdx], lastMsg)
-						spinIdx = (spinIdx + 1) % len(spinner)
-					}
-				}
+			fmt.Printf("Building Docker image: %s\n", finalImageName)
+			
+			// Create progress channel for build output
+			progressChan := make(chan string, 100)
+			buildCtx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			defer cancel()
+			
+			// Start build progress display
+			doneChan := displayProgress(progressChan)
+			
+			// Build the image
+			if err := builder.BuildImage(buildCtx, image, finalImageName, buildOpts, progressChan); err != nil {
+				<-doneChan
+				log.Fatalf("Failed to build Docker image: %v", err)
 			}
-		}()
-		
-		// Build the image
-		if err := builder.BuildImage(buildCtx, image, finalImageName, progressChan); err != nil {
+			
+			// Wait for progress display to finish
 			<-doneChan
-			log.Fatalf("Failed to build Docker image: %v", err)
+			fmt.Println() // New line after build
 		}
 		
-		// Wait for progress display to finish
-		<-doneChan
-		fmt.Println() // New line after build
-		
 		// Use the built image for deployment
 		image = finalImageName
-		fmt.Printf("Using built image: %s\n\n", image)
+		fmt.Printf("Using built image: %s\n", image)
+		sizeCtx, cancelSize := docker.WithOperationTimeout(context.Background(), cfg.Docker.OperationTimeout)
+		if size, err := builder.ImageSize(sizeCtx, image); err == nil {
+			fmt.Printf("Image size: %s\n", formatBytes(size))
+		}
+		cancelSize()
+		fmt.Println()
 	}
 	
-	envVars, _ := cmd.Flags().GetStringSlice("env")
 	cpuStr, _ := cmd.Flags().GetString("cpu")
 	memoryStr, _ := cmd.Flags().GetString("memory")
+	size, _ := cmd.Flags().GetString("size")
+	memorySwapStr, _ := cmd.Flags().GetString("memory-swap")
+	memoryReservationStr, _ := cmd.Flags().GetString("memory-reservation")
+	cpuShares, _ := cmd.Flags().GetInt64("cpu-shares")
+	pidsLimit, _ := cmd.Flags().GetInt64("pids-limit")
+	ulimitFlags, _ := cmd.Flags().GetStringSlice("ulimit")
+	gpus, _ := cmd.Flags().GetString("gpus")
+	networks, _ := cmd.Flags().GetStringSlice("network")
+	networkAliases, _ := cmd.Flags().GetStringSlice("network-alias")
+	injectRedis, _ := cmd.Flags().GetBool("inject-redis")
+	injectAPI, _ := cmd.Flags().GetBool("inject-api")
 	autoRestart, _ := cmd.Flags().GetBool("auto-restart")
+	maxRestarts, _ := cmd.Flags().GetInt("max-restarts")
+	replayRate, _ := cmd.Flags().GetFloat64("replay-rate")
+	rateLimit, _ := cmd.Flags().GetFloat64("rate-limit")
+	rateLimitBurst, _ := cmd.Flags().GetInt("rate-limit-burst")
+	proxyTimeout, _ := cmd.Flags().GetString("proxy-timeout")
+	proxyRetries, _ := cmd.Flags().GetInt("proxy-retries")
+	stopSignal, _ := cmd.Flags().GetString("stop-signal")
+	stopTimeout, _ := cmd.Flags().GetString("stop-timeout")
+	restartWindow, _ := cmd.Flags().GetString("restart-window")
 	token, _ := cmd.Flags().GetString("token")
 	portMappings, _ := cmd.Flags().GetStringSlice("port")
+	proxyPort, _ := cmd.Flags().GetInt("proxy-port")
+	routeFlags, _ := cmd.Flags().GetStringSlice("route")
 	volumeMappings, _ := cmd.Flags().GetStringSlice("volume")
+	sharedVolume, _ := cmd.Flags().GetBool("shared-volume")
+	allowArchMismatch, _ := cmd.Flags().GetBool("allow-arch-mismatch")
+	registryAuthFlag, _ := cmd.Flags().GetString("registry-auth")
+	pullPolicy, _ := cmd.Flags().GetString("pull")
+	pinDigest, _ := cmd.Flags().GetBool("pin-digest")
+	secretFlags, _ := cmd.Flags().GetStringSlice("secret")
+	healthType, _ := cmd.Flags().GetString("health-type")
 	healthEndpoint, _ := cmd.Flags().GetString("health-endpoint")
+	healthCommand, _ := cmd.Flags().GetString("health-command")
 	healthInterval, _ := cmd.Flags().GetString("health-interval")
 	healthTimeout, _ := cmd.Flags().GetString("health-timeout")
 	healthRetries, _ := cmd.Flags().GetInt("health-retries")
+	healthStartPeriod, _ := cmd.Flags().GetString("health-start-period")
+	healthUseImage, _ := cmd.Flags().GetBool("health-use-image")
+	readinessType, _ := cmd.Flags().GetString("readiness-type")
+	readinessEndpoint, _ := cmd.Flags().GetString("readiness-endpoint")
+	readinessCommand, _ := cmd.Flags().GetString("readiness-command")
+	readinessInterval, _ := cmd.Flags().GetString("readiness-interval")
+	namespace, _ := cmd.Flags().GetString("namespace")
+	labelFlags, _ := cmd.Flags().GetStringSlice("label")
 	
 	// Parse CPU and memory limits using the same functions as YAML
 	var cpuLimit, memoryLimit int64
@@ -485,21 +838,35 @@ func deployAgent(cmd *cobra.Command) {
 		}
 		memoryLimit = mem
 	}
+	memorySwap, err := config.ParseMemorySwap(memorySwapStr)
+	if err != nil {
+		log.Fatalf("Invalid memory swap limit: %v", err)
+	}
+	var memoryReservation int64
+	if memoryReservationStr != "" {
+		if memoryReservation, err = config.ParseMemory(memoryReservationStr); err != nil {
+			log.Fatalf("Invalid memory reservation: %v", err)
+		}
+	}
+	var ulimits []agent.Ulimit
+	for _, value := range ulimitFlags {
+		ulimit, err := agent.ParseUlimit(value)
+		if err != nil {
+			log.Fatalf("Invalid --ulimit: %v", err)
+		}
+		ulimits = append(ulimits, ulimit)
+	}
+	if _, err := agent.ParseGPUs(gpus); err != nil {
+		log.Fatalf("Invalid --gpus: %v", err)
+	}
+	if err := agent.ValidateNetworkAliases(networkAliases); err != nil {
+		log.Fatalf("Invalid --network-alias: %v", err)
+	}
 
 	if token == "" {
 		token = cfg.Security.DefaultToken
 	}
 
-	envMap := make(map[string]string)
-	for _, env := range envVars {
-		if len(env) > 0 {
-			parts := strings.SplitN(env, "=", 2)
-			if len(parts) == 2 {
-				envMap[parts[0]] = parts[1]
-			}
-		}
-	}
-
 	ports, err := parsePortMappings(portMappings)
 	if err != nil {
 		log.Fatalf("Failed to parse port mappings: %v", err)
@@ -510,30 +877,116 @@ func deployAgent(cmd *cobra.Command) {
 		log.Fatalf("Failed to parse volume mappings: %v", err)
 	}
 
+	routes, err := parseRoutes(routeFlags)
+	if err != nil {
+		log.Fatalf("Failed to parse routes: %v", err)
+	}
+
+	labels, err := agent.ParseLabels(labelFlags)
+	if err != nil {
+		log.Fatalf("Failed to parse labels: %v", err)
+	}
+
+	secretRefs, err := parseSecretRefs(secretFlags)
+	if err != nil {
+		log.Fatalf("Failed to parse secrets: %v", err)
+	}
+
+	var registryAuth *docker.RegistryAuth
+	if registryAuthFlag != "" {
+		username, password, ok := strings.Cut(registryAuthFlag, ":")
+		if !ok || username == "" {
+			log.Fatalf("Invalid registry auth: expected username:password")
+		}
+		registryAuth = &docker.RegistryAuth{Username: username, Password: password, Host: docker.RegistryHost(image)}
+	}
+
+	if err := agent.ValidatePullPolicy(pullPolicy); err != nil {
+		log.Fatalf("Invalid --pull: %v", err)
+	}
+	if !builtImage {
+		pullPolicy = pullImageLocally(image, pullPolicy, registryAuth)
+	}
+
 	// Create health check config
 	var healthCheck *agent.HealthCheckConfig
-	if healthEndpoint != "" {
+	if healthEndpoint != "" || healthUseImage || healthType != agent.HealthCheckHTTP {
 		healthCheck = &agent.HealthCheckConfig{
+			Type:     healthType,
 			Endpoint: healthEndpoint,
 			Interval: healthInterval,
 			Timeout:  healthTimeout,
 			Retries:  healthRetries,
+			StartPeriod: healthStartPeriod,
+			UseImageHealthcheck: healthUseImage,
+		}
+		if healthCommand != "" {
+			// Run through a shell so pipes and quoting work as typed
+			healthCheck.Command = []string{"sh", "-c", healthCommand}
+		}
+		if readinessType != "" || readinessEndpoint != "" || readinessCommand != "" {
+			healthCheck.Readiness = &agent.ReadinessProbe{
+				Type:     readinessType,
+				Endpoint: readinessEndpoint,
+				Interval: readinessInterval,
+			}
+			if readinessCommand != "" {
+				healthCheck.Readiness.Command = []string{"sh", "-c", readinessCommand}
+				if readinessType == "" {
+					healthCheck.Readiness.Type = agent.HealthCheckExec
+				}
+			}
+		}
+		if err := agent.ValidateHealthCheck(healthCheck); err != nil {
+			log.Fatalf("Invalid health check: %v", err)
 		}
 	}
 
 	// Create deployment request
 	deployReq := map[string]interface{}{
 		"name":         name,
+		"namespace":    namespace,
+		"labels":       labels,
 		"image":        image,
 		"env_vars":     envMap,
+		"secrets":      secretRefs,
 		"cpu_limit":    cpuLimit,
 		"memory_limit": memoryLimit,
+		"memory_swap":  memorySwap,
+		"memory_reservation": memoryReservation,
+		"cpu_shares":   cpuShares,
+		"pids_limit":   pidsLimit,
+		"ulimits":      ulimits,
+		"gpus":         gpus,
+		"networks":     networks,
+		"network_aliases": networkAliases,
+		"inject_redis": injectRedis,
+		"inject_api":   injectAPI,
+		"size":         size,
 		"auto_restart": autoRestart,
+		"max_restarts": maxRestarts,
+		"restart_window": restartWindow,
+		"replay_rate_limit": replayRate,
+		"rate_limit":   rateLimit,
+		"rate_limit_burst": rateLimitBurst,
+		"proxy_timeout": proxyTimeout,
+		"proxy_retries": proxyRetries,
+		"stop_signal":  stopSignal,
+		"stop_timeout": stopTimeout,
 		"token":        token,
 		"ports":        ports,
+		"proxy_port":   proxyPort,
+		"routes":       routes,
 		"volumes":      volumes,
+		"shared_volumes": sharedVolume,
+		"allow_arch_mismatch": allowArchMismatch,
+		"pull_policy":  pullPolicy,
+		"pin_digest":   pinDigest,
 		"health_check": healthCheck,
 	}
+	if registryAuth != nil {
+		deployReq["registry_auth"] = registryAuth
+	}
 
 	// Deploy via API
 	apiResp, err := makeAPIRequest("POST", "/agents", deployReq)
@@ -551,12 +1004,21 @@ func deployAgent(cmd *cobra.Command) {
 	fmt.Printf("Agent deployed successfully!\n")
 	fmt.Printf("ID: %s\n", agentData["id"])
 	fmt.Printf("Name: %s\n", agentData["name"])
+	fmt.Printf("Namespace: %s\n", agentData["namespace"])
 	fmt.Printf("Image: %s\n", agentData["image"])
+	if digest, ok := agentData["image_digest"].(string); ok && digest != "" {
+		pinned := ""
+		if pinDigest, _ := agentData["pin_digest"].(bool); pinDigest {
+			pinned = " (pinned)"
+		}
+		fmt.Printf("Digest: %s%s\n", digest, pinned)
+	}
 	fmt.Printf("Status: %s\n", agentData["status"])
 	
 	// In the new architecture, all access is through the proxy
 	fmt.Printf("\nAccess:\n")
 	fmt.Printf("  Proxy: http://localhost:%d/agent/%s/\n", cfg.Server.Port, agentData["id"])
+	fmt.Printf("         http://localhost:%d/ns/%s/agent/%s/\n", cfg.Server.Port, agentData["namespace"], agentData["name"])
 	fmt.Printf("  API:   http://localhost:%d/agents/%s\n", cfg.Server.Port, agentData["id"])
 	
 	// Display volume mappings if any
@@ -573,9 +1035,27 @@ func deployAgent(cmd *cobra.Command) {
 	}
 }
 
+// stopRequestTimeout bounds requests that stop agents, which wait out each
+// agent's stop timeout
+const stopRequestTimeout = 5 * time.Minute
+
+// apiToken is the token the CLI authenticates with: AGENTAINER_TOKEN if set,
+// so each user can use their own named token, otherwise the default token
+func apiToken() string {
+	if token := os.Getenv("AGENTAINER_TOKEN"); token != "" {
+		return token
+	}
+	return cfg.Security.DefaultToken
+}
+
 // Helper function to make API requests
 func makeAPIRequest(method, endpoint string, body interface{}) (*api.Response, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
+	return makeAPIRequestTimeout(method, endpoint, body, 10*time.Second)
+}
+
+// makeAPIRequestTimeout is makeAPIRequest for requests that may take longer
+func makeAPIRequestTimeout(method, endpoint string, body interface{}, timeout time.Duration) (*api.Response, error) {
+	client := &http.Client{Timeout: timeout}
 	
 	url := fmt.Sprintf("http://localhost:%d%s", cfg.Server.Port, endpoint)
 	
@@ -593,7 +1073,7 @@ func makeAPIRequest(method, endpoint string, body interface{}) (*api.Response, e
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	
-	req.Header.Set("Authorization", "Bearer "+cfg.Security.DefaultToken)
+	req.Header.Set("Authorization", "Bearer "+apiToken())
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
@@ -612,6 +1092,104 @@ func makeAPIRequest(method, endpoint string, body interface{}) (*api.Response, e
 	return &apiResp, nil
 }
 
+// waitAfter handles --wait on start and resume
+func waitAfter(cmd *cobra.Command, agentID string) {
+	wait, _ := cmd.Flags().GetBool("wait")
+	if !wait {
+		return
+	}
+	timeout, _ := cmd.Flags().GetDuration("wait-timeout")
+	
+	fmt.Printf("Waiting for agent %s to become healthy...\n", agentID)
+	if err := waitForAgent(agentID, "healthy", timeout, time.Second); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Agent %s is ready\n", agentID)
+}
+
+// waitForAgent polls an agent until it reaches the target state. "healthy"
+// means running with a passing health check; agents without a health check
+// or Docker HEALTHCHECK count as healthy once running. Waiting for running or
+// healthy stops early if the agent fails or is stopped.
+func waitForAgent(agentID, target string, timeout, interval time.Duration) error {
+	switch target {
+	case "running", "healthy", "stopped", "paused", "failed":
+	default:
+		return fmt.Errorf("invalid state %q (use running, healthy, stopped, paused or failed)", target)
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	
+	deadline := time.Now().Add(timeout)
+	status := "unknown"
+	for {
+		reached, current, err := checkAgentState(agentID, target)
+		if err != nil {
+			return err
+		}
+		if current != "" {
+			status = current
+		}
+		if reached {
+			return nil
+		}
+		if (target == "running" || target == "healthy") &&
+			(status == string(agent.StatusFailed) || status == string(agent.StatusStopped)) {
+			return fmt.Errorf("agent %s is %s, not waiting for it to be %s", agentID, status, target)
+		}
+		
+		if timeout > 0 && time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for agent %s to be %s (status: %s)", timeout, agentID, target, status)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// checkAgentState reports whether an agent has reached the target state,
+// along with its current status. Connection errors are treated as not yet
+// reached so that a restarting server does not end the wait.
+func checkAgentState(agentID, target string) (bool, string, error) {
+	apiResp, err := makeAPIRequest("GET", fmt.Sprintf("/agents/%s", agentID), nil)
+	if err != nil {
+		return false, "", nil
+	}
+	if !apiResp.Success {
+		return false, "", fmt.Errorf("failed to get agent: %s", apiResp.Message)
+	}
+	
+	data, err := json.Marshal(apiResp.Data)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	var agentObj api.AgentResponse
+	if err := json.Unmarshal(data, &agentObj); err != nil {
+		return false, "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	
+	status := string(agentObj.Status)
+	if target != "healthy" {
+		return status == target, status, nil
+	}
+	if agentObj.Status != agent.StatusRunning {
+		return false, status, nil
+	}
+	
+	if agentObj.HealthCheck != nil {
+		// Ignore the placeholder before the first check and any result left
+		// over from before the agent was last started
+		healthStatus := agentObj.Health
+		if healthStatus == nil || healthStatus.Message == "" {
+			return false, status, nil
+		}
+		return healthStatus.Healthy && healthStatus.LastCheck.After(agentObj.UpdatedAt), status, nil
+	}
+	if agentObj.ContainerHealth != "" {
+		return agentObj.ContainerHealth == "healthy", status, nil
+	}
+	return true, status, nil
+}
+
 func startAgent(agentID string) {
 	apiResp, err := makeAPIRequest("POST", fmt.Sprintf("/agents/%s/start", agentID), nil)
 	if err != nil {
@@ -626,7 +1204,7 @@ func startAgent(agentID string) {
 }
 
 func stopAgent(agentID string) {
-	apiResp, err := makeAPIRequest("POST", fmt.Sprintf("/agents/%s/stop", agentID), nil)
+	apiResp, err := makeAPIRequestTimeout("POST", fmt.Sprintf("/agents/%s/stop", agentID), nil, stopRequestTimeout)
 	if err != nil {
 		log.Fatalf("Failed to stop agent: %v", err)
 	}
@@ -639,7 +1217,7 @@ func stopAgent(agentID string) {
 }
 
 func restartAgent(agentID string) {
-	apiResp, err := makeAPIRequest("POST", fmt.Sprintf("/agents/%s/restart", agentID), nil)
+	apiResp, err := makeAPIRequestTimeout("POST", fmt.Sprintf("/agents/%s/restart", agentID), nil, stopRequestTimeout)
 	if err != nil {
 		log.Fatalf("Failed to restart agent: %v", err)
 	}
@@ -696,7 +1274,7 @@ func removeAgent(agentID string) {
 	fmt.Printf("Removing agent '%s' (ID: %s, Status: %s)\n", name, agentID, status)
 	
 	// Remove the agent
-	removeResp, err := makeAPIRequest("DELETE", fmt.Sprintf("/agents/%s", agentID), nil)
+	removeResp, err := makeAPIRequestTimeout("DELETE", fmt.Sprintf("/agents/%s", agentID), nil, stopRequestTimeout)
 	if err != nil {
 		log.Fatalf("Failed to remove agent: %v", err)
 	}
@@ -708,98 +1286,302 @@ func removeAgent(agentID string) {
 	fmt.Printf("Agent %s removed successfully\n", agentID)
 }
 
-func viewLogs(cmd *cobra.Command, agentID string) {
-	follow, _ := cmd.Flags().GetBool("follow")
-	
-	// Create HTTP client with longer timeout for streaming logs
-	client := &http.Client{Timeout: 5 * time.Minute}
-	
-	// Build URL with query parameter
-	url := fmt.Sprintf("http://localhost:%d/agents/%s/logs", cfg.Server.Port, agentID)
-	if follow {
-		url += "?follow=true"
-	}
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Fatalf("Failed to create request: %v", err)
-	}
-	
-	// Add auth header
-	req.Header.Set("Authorization", "Bearer "+cfg.Security.DefaultToken)
-	
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatalf("Failed to connect to server: %v\nMake sure the server is running with 'agentainer server'", err)
-	}
-	defer resp.Body.Close()
-	
-	// Check for error status
-	if resp.StatusCode != http.StatusOK {
-		var apiResp api.Response
-		if err := json.NewDecoder(resp.Body).Decode(&apiResp); err == nil {
-			log.Fatalf("Failed to get logs: %s", apiResp.Message)
-		} else {
-			log.Fatalf("Failed to get logs: HTTP %d", resp.StatusCode)
+// agentsEndpoint builds the agent list URL for a filter
+func agentsEndpoint(filter agent.ListFilter) string {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"namespace": filter.Namespace,
+		"name":      filter.Name,
+		"status":    string(filter.Status),
+		"image":     filter.Image,
+	} {
+		if value != "" {
+			query.Set(key, value)
 		}
 	}
-	
-	// Stream the logs
-	buf := make([]byte, 1024)
-	for {
-		n, err := resp.Body.Read(buf)
-		if n > 0 {
-			fmt.Print(string(buf[:n]))
-		}
-		if err != nil {
-			if err != io.EOF {
-				log.Printf("Error reading logs: %v", err)
-			}
-			break
-		}
+	for key, value := range filter.Labels {
+		query.Add("label", key+"="+value)
 	}
+	if len(query) == 0 {
+		return "/agents"
+	}
+	return "/agents?" + query.Encode()
 }
 
-func listAgents() {
-	apiResp, err := makeAPIRequest("GET", "/agents", nil)
+// bulkAction applies start, stop or remove to each matching agent in turn,
+// reporting each result and carrying on past failures
+func bulkAction(action string, filterFlags []string, confirmed bool) {
+	filter, err := agent.ParseListFilter(filterFlags)
+	if err != nil {
+		log.Fatalf("Invalid --filter: %v", err)
+	}
+
+	apiResp, err := makeAPIRequest("GET", agentsEndpoint(filter), nil)
 	if err != nil {
 		log.Fatalf("Failed to list agents: %v", err)
 	}
-	
 	if !apiResp.Success {
 		log.Fatalf("Failed to list agents: %s", apiResp.Message)
 	}
-	
-	// Convert response data to agents
-	agents, ok := apiResp.Data.([]interface{})
-	if !ok {
-		fmt.Println("No agents found")
-		return
+	data, err := json.Marshal(apiResp.Data)
+	if err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
 	}
-
-	if len(agents) == 0 {
-		fmt.Println("No agents found")
-		return
+	var agents []agent.Agent
+	if err := json.Unmarshal(data, &agents); err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
 	}
 
-	fmt.Printf("%-20s %-20s %-30s %-10s\n", "ID", "NAME", "IMAGE", "STATUS")
-	fmt.Println(strings.Repeat("-", 80))
-	
+	matched := make(map[string]bool, len(agents))
+	for _, a := range agents {
+		matched[a.ID] = true
+	}
+	var targets []agent.Agent
+	for _, a := range agents {
+		// Replicas are removed along with their parent
+		if action == agent.BatchRemove && a.ReplicaOf != "" && matched[a.ReplicaOf] {
+			continue
+		}
+		targets = append(targets, a)
+	}
+	if len(targets) == 0 {
+		fmt.Println("No agents match")
+		return
+	}
+
+	if !confirmed {
+		for _, a := range targets {
+			fmt.Printf("  %s (%s, %s)\n", a.Name, a.ID, a.Status)
+		}
+		fmt.Printf("%s%s %d agent(s)? [y/N] ", strings.ToUpper(action[:1]), action[1:], len(targets))
+		var answer string
+		fmt.Scanln(&answer)
+		if answer != "y" && answer != "Y" && answer != "yes" {
+			fmt.Println("Aborted")
+			return
+		}
+	}
+
+	succeeded, skipped, failed := 0, 0, 0
+	for _, a := range targets {
+		if (action == agent.BatchStart && a.Status == agent.StatusRunning) ||
+			(action == agent.BatchStop && a.Status == agent.StatusStopped) {
+			fmt.Printf("  - %s (%s): already %s\n", a.Name, a.ID, a.Status)
+			skipped++
+			continue
+		}
+
+		method, endpoint := "POST", fmt.Sprintf("/agents/%s/%s", a.ID, action)
+		if action == agent.BatchRemove {
+			method, endpoint = "DELETE", fmt.Sprintf("/agents/%s", a.ID)
+		}
+		resp, err := makeAPIRequestTimeout(method, endpoint, nil, stopRequestTimeout)
+		if err == nil && !resp.Success {
+			err = errors.New(resp.Message)
+		}
+		if err != nil {
+			fmt.Printf("  ✗ %s (%s): %v\n", a.Name, a.ID, err)
+			failed++
+			continue
+		}
+		fmt.Printf("  ✓ %s (%s)\n", a.Name, a.ID)
+		succeeded++
+	}
+
+	fmt.Printf("\n%s: %d succeeded, %d skipped, %d failed\n", action, succeeded, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func scaleAgent(agentID string, replicas int) {
+	apiResp, err := makeAPIRequest("POST", fmt.Sprintf("/agents/%s/scale", agentID), map[string]interface{}{
+		"replicas": replicas,
+	})
+	if err != nil {
+		log.Fatalf("Failed to scale agent: %v", err)
+	}
+	
+	if !apiResp.Success {
+		log.Fatalf("Failed to scale agent: %s", apiResp.Message)
+	}
+	
+	fmt.Println(apiResp.Message)
+	
+	result, ok := apiResp.Data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	
+	printIDs := func(label string, key string) {
+		ids, ok := result[key].([]interface{})
+		if !ok || len(ids) == 0 {
+			return
+		}
+		fmt.Printf("%s:\n", label)
+		for _, id := range ids {
+			fmt.Printf("  %s\n", id)
+		}
+	}
+	printIDs("Running", "running")
+	printIDs("Created", "created")
+	printIDs("Removed", "removed")
+	
+	if failed, ok := result["failed"].(map[string]interface{}); ok && len(failed) > 0 {
+		fmt.Println("Failed:")
+		for id, reason := range failed {
+			fmt.Printf("  %s: %s\n", id, reason)
+		}
+		os.Exit(1)
+	}
+}
+
+func viewLogs(cmd *cobra.Command, agentID string) {
+	follow, _ := cmd.Flags().GetBool("follow")
+	tail, _ := cmd.Flags().GetString("tail")
+	since, _ := cmd.Flags().GetString("since")
+	
+	// Create HTTP client with longer timeout for streaming logs
+	client := &http.Client{Timeout: 5 * time.Minute}
+	
+	// Build URL with query parameters
+	query := url.Values{}
+	if follow {
+		query.Set("follow", "true")
+	}
+	if tail != "" {
+		query.Set("tail", tail)
+	}
+	if since != "" {
+		query.Set("since", since)
+	}
+	logsURL := fmt.Sprintf("http://localhost:%d/agents/%s/logs", cfg.Server.Port, agentID)
+	if len(query) > 0 {
+		logsURL += "?" + query.Encode()
+	}
+	
+	req, err := http.NewRequest("GET", logsURL, nil)
+	if err != nil {
+		log.Fatalf("Failed to create request: %v", err)
+	}
+	
+	// Add auth header
+	req.Header.Set("Authorization", "Bearer "+apiToken())
+	
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to connect to server: %v\nMake sure the server is running with 'agentainer server'", err)
+	}
+	defer resp.Body.Close()
+	
+	// Check for error status
+	if resp.StatusCode != http.StatusOK {
+		var apiResp api.Response
+		if err := json.NewDecoder(resp.Body).Decode(&apiResp); err == nil {
+			log.Fatalf("Failed to get logs: %s", apiResp.Message)
+		} else {
+			log.Fatalf("Failed to get logs: HTTP %d", resp.StatusCode)
+		}
+	}
+	
+	// Stream the logs
+	buf := make([]byte, 1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			fmt.Print(string(buf[:n]))
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading logs: %v", err)
+			}
+			break
+		}
+	}
+}
+
+func listAgents(namespace string, filters []string) {
+	filter, err := agent.ParseListFilter(filters)
+	if err != nil {
+		log.Fatalf("Invalid --filter: %v", err)
+	}
+	if namespace != "" {
+		filter.Namespace = namespace
+	}
+	
+	apiResp, err := makeAPIRequest("GET", agentsEndpoint(filter), nil)
+	if err != nil {
+		log.Fatalf("Failed to list agents: %v", err)
+	}
+	
+	if !apiResp.Success {
+		log.Fatalf("Failed to list agents: %s", apiResp.Message)
+	}
+	
+	// Convert response data to agents
+	agents, ok := apiResp.Data.([]interface{})
+	if !ok {
+		fmt.Println("No agents found")
+		return
+	}
+
+	if len(agents) == 0 {
+		fmt.Println("No agents found")
+		return
+	}
+
+	fmt.Printf("%-20s %-15s %-20s %-30s %-10s %-10s\n", "ID", "NAMESPACE", "NAME", "IMAGE", "STATUS", "HEALTH")
+	fmt.Println(strings.Repeat("-", 107))
+	
 	for _, agentData := range agents {
 		agent := agentData.(map[string]interface{})
 		id := agent["id"].(string)
 		name := agent["name"].(string)
 		image := agent["image"].(string)
 		status := agent["status"].(string)
+		namespace, _ := agent["namespace"].(string)
 		
-		fmt.Printf("%-20s %-20s %-30s %-10s\n", id, name, image, status)
+		fmt.Printf("%-20s %-15s %-20s %-30s %-10s %-10s\n", id, namespace, name, image, status, healthColumn(agent))
 		if status == "running" {
 			fmt.Printf("  → Proxy:  http://localhost:%d/agent/%s/\n", cfg.Server.Port, id)
 			fmt.Printf("  → API:    http://localhost:%d/agents/%s\n", cfg.Server.Port, id)
+		} else if lastExit, ok := agent["last_exit"].(map[string]interface{}); ok {
+			fmt.Printf("  → Exit:   %s at %s\n", lastExit["reason"], lastExit["finished_at"])
 		}
 	}
 }
 
+// healthColumn summarises an agent's health check result for list output,
+// falling back to Docker's HEALTHCHECK status
+func healthColumn(agentData map[string]interface{}) string {
+	if health, ok := agentData["health"].(map[string]interface{}); ok {
+		if agentData["status"] != "running" {
+			return "-"
+		}
+		// Monitoring starts out healthy with no message until the first check
+		if message, _ := health["message"].(string); message == "" {
+			return "pending"
+		}
+		if healthy, _ := health["healthy"].(bool); healthy {
+			if ready, ok := health["ready"].(bool); ok && !ready {
+				return "healthy, not ready"
+			}
+			return "healthy"
+		}
+		if starting, _ := health["starting"].(bool); starting {
+			return "starting"
+		}
+		if failures, _ := health["failure_count"].(float64); failures > 0 {
+			return fmt.Sprintf("unhealthy (%d)", int(failures))
+		}
+		return "unhealthy"
+	}
+	if containerHealth, ok := agentData["container_health"].(string); ok && containerHealth != "" {
+		return containerHealth
+	}
+	return "-"
+}
+
 func invokeAgent(agentID string) {
 	// First check if agent exists and is running
 	getResp, err := makeAPIRequest("GET", fmt.Sprintf("/agents/%s", agentID), nil)
@@ -831,6 +1613,97 @@ func invokeAgent(agentID string) {
 	fmt.Printf("Agent %s invoked successfully\n", agentID)
 }
 
+// execAgent runs a command in the agent's container through the local
+// Docker daemon and returns the command's exit code
+func execAgent(agentID string, command []string, interactive, tty bool) int {
+	dockerClient, err := docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
+	if err != nil {
+		log.Fatalf("Failed to create Docker client: %v", err)
+	}
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", cfg.Redis.Host, cfg.Redis.Port),
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+
+	agentMgr := agent.NewManager(dockerClient, redisClient, newStore(redisClient), cfg.GetAgentConfigPath(), cfg.Docker.OperationTimeout)
+
+	streams := agent.ExecStreams{Stdout: os.Stdout, Stderr: os.Stderr}
+	if interactive {
+		streams.Stdin = os.Stdin
+	}
+
+	if tty {
+		fd, isTerminal := term.GetFdInfo(os.Stdin)
+		if !isTerminal {
+			log.Fatalf("--tty requires stdin to be a terminal")
+		}
+		if size, err := term.GetWinsize(fd); err == nil {
+			streams.ConsoleSize = &[2]uint{uint(size.Height), uint(size.Width)}
+		}
+		if interactive {
+			state, err := term.SetRawTerminal(fd)
+			if err != nil {
+				log.Fatalf("Failed to set terminal to raw mode: %v", err)
+			}
+			defer term.RestoreTerminal(fd, state)
+		}
+	}
+
+	exitCode, err := agentMgr.Exec(context.Background(), agentID, command, tty, streams)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to exec in agent: %v\n", err)
+		return 1
+	}
+	return exitCode
+}
+
+// splitCopyArg splits an <agent-id>:<path> argument; paths starting with
+// / or . are always local
+func splitCopyArg(arg string) (agentID, path string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+	if id, p, ok := strings.Cut(arg, ":"); ok && id != "" {
+		return id, p
+	}
+	return "", arg
+}
+
+// copyFiles copies between an agent's container and the local filesystem
+// through the local Docker daemon
+func copyFiles(src, dst string) {
+	srcAgent, srcPath := splitCopyArg(src)
+	dstAgent, dstPath := splitCopyArg(dst)
+	if (srcAgent == "") == (dstAgent == "") {
+		log.Fatalf("Exactly one of source and destination must be <agent-id>:<path>")
+	}
+
+	dockerClient, err := docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
+	if err != nil {
+		log.Fatalf("Failed to create Docker client: %v", err)
+	}
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", cfg.Redis.Host, cfg.Redis.Port),
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+
+	agentMgr := agent.NewManager(dockerClient, redisClient, newStore(redisClient), cfg.GetAgentConfigPath(), cfg.Docker.OperationTimeout)
+
+	ctx := context.Background()
+	if srcAgent != "" {
+		err = agentMgr.CopyFrom(ctx, srcAgent, srcPath, dstPath)
+	} else {
+		err = agentMgr.CopyTo(ctx, dstAgent, srcPath, dstPath)
+	}
+	if err != nil {
+		log.Fatalf("Failed to copy: %v", err)
+	}
+	fmt.Printf("✓ Copied %s to %s\n", src, dst)
+}
 
 var healthCmd = &cobra.Command{
 	Use:   "health [agent-id]",
@@ -861,6 +1734,94 @@ var metricsCmd = &cobra.Command{
 	},
 }
 
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show live resource usage of running agents",
+	Long:  "Show CPU, memory and network usage of all running agents, refreshed every second. Press c, m, n or a to sort by CPU, memory, network or name, and q to quit.",
+	Run: func(cmd *cobra.Command, args []string) {
+		sortBy, _ := cmd.Flags().GetString("sort")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		namespace, _ := cmd.Flags().GetString("namespace")
+		
+		if sortBy != "cpu" && sortBy != "mem" && sortBy != "net" && sortBy != "name" {
+			log.Fatalf("Invalid --sort: expected cpu, mem, net or name")
+		}
+		if interval <= 0 {
+			log.Fatalf("Invalid --interval: must be positive")
+		}
+		runTop(namespace, sortBy, interval)
+	},
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [agent-id]",
+	Short: "Show request counts, latency and error rate for an agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		reset, _ := cmd.Flags().GetBool("reset")
+		
+		if reset {
+			resetAgentStats(args[0])
+		} else {
+			viewAgentStats(args[0])
+		}
+	},
+}
+
+var eventsCmd = &cobra.Command{
+	Use:   "events [agent-id]",
+	Short: "Show the lifecycle history of an agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+		viewAgentEvents(args[0], limit)
+	},
+}
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [agent-id]",
+	Short: "Show the full container configuration of an agent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		diff, _ := cmd.Flags().GetBool("diff")
+		if diff {
+			viewAgentDiff(args[0])
+		} else {
+			inspectAgent(args[0])
+		}
+	},
+}
+
+var alertsCmd = &cobra.Command{
+	Use:   "alerts",
+	Short: "Show the alerts that are currently firing",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		viewAlerts()
+	},
+}
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Sync agent states with Docker and show what was corrected",
+	Run: func(cmd *cobra.Command, args []string) {
+		once, _ := cmd.Flags().GetBool("once")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		
+		if once {
+			reconcileAgents()
+			return
+		}
+		if interval <= 0 {
+			log.Fatal("--interval must be positive")
+		}
+		for {
+			reconcileAgents()
+			time.Sleep(interval)
+		}
+	},
+}
+
 var backupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "Backup and restore agent configurations",
@@ -874,7 +1835,9 @@ var backupCreateCmd = &cobra.Command{
 		description, _ := cmd.Flags().GetString("description")
 		agents, _ := cmd.Flags().GetStringSlice("agents")
 		
-		createBackup(name, description, agents)
+		includeVolumes, _ := cmd.Flags().GetBool("include-volumes")
+		
+		createBackup(name, description, agents, includeVolumes)
 	},
 }
 
@@ -892,7 +1855,12 @@ var backupRestoreCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		agents, _ := cmd.Flags().GetStringSlice("agents")
-		restoreBackup(args[0], agents)
+		remapFlags, _ := cmd.Flags().GetStringSlice("remap-volume")
+		remap, err := parseVolumeRemaps(remapFlags)
+		if err != nil {
+			log.Fatalf("Invalid --remap-volume: %v", err)
+		}
+		restoreBackup(args[0], agents, remap)
 	},
 }
 
@@ -905,6 +1873,14 @@ var backupDeleteCmd = &cobra.Command{
 	},
 }
 
+var backupScheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Show the automatic backup schedule and next run",
+	Run: func(cmd *cobra.Command, args []string) {
+		showBackupSchedule()
+	},
+}
+
 var backupExportCmd = &cobra.Command{
 	Use:   "export [backup-id] [output-file]",
 	Short: "Export backup as tar.gz file",
@@ -918,16 +1894,253 @@ var auditCmd = &cobra.Command{
 	Use:   "audit",
 	Short: "View audit logs",
 	Run: func(cmd *cobra.Command, args []string) {
-		user, _ := cmd.Flags().GetString("user")
-		action, _ := cmd.Flags().GetString("action")
-		resource, _ := cmd.Flags().GetString("resource")
-		duration, _ := cmd.Flags().GetString("duration")
-		limit, _ := cmd.Flags().GetInt("limit")
+		filter, duration := auditFilterFromFlags(cmd)
+		filter.Limit, _ = cmd.Flags().GetInt("limit")
+		
+		viewAuditLogs(filter, duration)
+	},
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export audit logs as JSON or CSV",
+	Long:  "Export every audit entry matching the filters. Entries are kept for logging.max_age (7 days by default); configure audit sinks in config.yaml to keep them longer.",
+	Run: func(cmd *cobra.Command, args []string) {
+		filter, duration := auditFilterFromFlags(cmd)
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
 		
-		viewAuditLogs(user, action, resource, duration, limit)
+		exportAuditLogs(filter, duration, format, output)
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "View and edit the Agentainer configuration",
+	Long: `Settings come from defaults, then config.yaml (the first found in ./,
+$HOME/.agentainer and /etc/agentainer), then AGENTAINER_* environment
+variables, each overriding the one before.`,
+}
+
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Print the effective configuration with secrets redacted",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		viewConfig()
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set key=value [key=value...]",
+	Short: "Set values in the config file",
+	Long: `Set values in the config file, e.g. agentainer config set server.port=9090.
+Keys use the dotted names shown by 'config view'. Values are checked before
+anything is written; restart the server to apply them.`,
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setConfig(args)
+	},
+}
+
+var configPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Show which config file is loaded",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if path := config.ConfigFileUsed(); path != "" {
+			fmt.Println(path)
+			return
+		}
+		fmt.Printf("No config file found (searched %s); defaults are in use\n", strings.Join(config.SearchPaths, ", "))
+		fmt.Printf("'agentainer config set' will create %s\n", config.DefaultConfigFile())
+	},
+}
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage encrypted secrets for agent environments",
+	Long: `Secrets are stored encrypted and injected into an agent's environment when
+its container is created. Reference them at deploy time with
+--secret ENV_VAR=secret-name; the value is never stored on the agent.`,
+}
+
+var secretsCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create or replace a secret (value is read from stdin unless --value is given)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		value, _ := cmd.Flags().GetString("value")
+		if !cmd.Flags().Changed("value") {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("Failed to read secret from stdin: %v", err)
+			}
+			value = strings.TrimSuffix(string(data), "\n")
+		}
+		createSecret(args[0], value)
 	},
 }
 
+var secretsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List secrets (values are not shown)",
+	Run: func(cmd *cobra.Command, args []string) {
+		listSecrets()
+	},
+}
+
+var secretsDeleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete a secret",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		deleteSecret(args[0])
+	},
+}
+
+var tokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Aliases: []string{"token"},
+	Short: "Manage named API tokens",
+	Long: `Named tokens let each user or integration authenticate with its own
+credential, attributed to its user in the audit log, and be revoked on its
+own. The default token from the config keeps working alongside them.
+Use a token from the CLI by setting AGENTAINER_TOKEN.`,
+}
+
+var tokensCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create a token (it is shown only once)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user, _ := cmd.Flags().GetString("user")
+		createToken(args[0], user)
+	},
+}
+
+var tokensListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tokens (values are not shown)",
+	Run: func(cmd *cobra.Command, args []string) {
+		listTokens()
+	},
+}
+
+var tokensRevokeCmd = &cobra.Command{
+	Use:   "revoke [id or name]",
+	Short: "Revoke a token so it can no longer be used",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		revokeToken(args[0])
+	},
+}
+
+var tokensRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the default token and save the new one to the config file",
+	Long: `Generates a new default token on the server, which rejects the old one
+from then on (after --grace, if given), and writes the new token to the
+config file so the CLI keeps working. Other clients using the default token
+must be updated with the printed value. Named tokens are not affected.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		grace, _ := cmd.Flags().GetDuration("grace")
+		rotateDefaultToken(grace)
+	},
+}
+
+// pullImageLocally pulls the image through the local Docker daemon so that
+// progress can be shown, and returns the pull policy to send with the deploy.
+// If the daemon is unreachable the server is left to pull the image.
+func pullImageLocally(image, policy string, auth *docker.RegistryAuth) string {
+	if policy == "" {
+		policy = agent.PullNever
+		if cfg.Deploy.AutoPull {
+			policy = agent.PullMissing
+		}
+	}
+	if policy == agent.PullNever {
+		return policy
+	}
+
+	dockerClient, err := docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
+	if err != nil {
+		return policy
+	}
+
+	if policy == agent.PullMissing {
+		inspectCtx, cancel := docker.WithOperationTimeout(context.Background(), cfg.Docker.OperationTimeout)
+		_, _, err := dockerClient.ImageInspectWithRaw(inspectCtx, image)
+		cancel()
+		if err == nil {
+			return policy
+		}
+	}
+
+	if auth == nil {
+		auth = docker.FindRegistryAuth(cfg.Registries, image)
+	}
+
+	fmt.Printf("Pulling image: %s\n", image)
+	progressChan := make(chan string, 100)
+	doneChan := displayProgress(progressChan)
+	err = docker.PullImage(context.Background(), dockerClient, image, auth, progressChan)
+	<-doneChan
+	fmt.Println()
+	if err != nil {
+		log.Fatalf("Failed to pull image: %v", err)
+	}
+
+	// The image is now local, so the server must not pull it again
+	return agent.PullMissing
+}
+
+// displayProgress shows progress messages on a single line with a spinner
+// until progressChan is closed, then signals the returned channel
+func displayProgress(progressChan <-chan string) <-chan bool {
+	doneChan := make(chan bool)
+	go func() {
+		spinner := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+		spinIdx := 0
+		lastMsg := ""
+		
+		for {
+			select {
+			case msg, ok := <-progressChan:
+				if !ok {
+					doneChan <- true
+					return
+				}
+				// Clear previous line and print new message
+				if lastMsg != "" {
+					fmt.Printf("\r%-120s", " ") // Clear line with more space
+				}
+				
+				// Truncate long messages
+				displayMsg := msg
+				if len(msg) > 100 {
+					displayMsg = msg[:97] + "..."
+				}
+				
+				if strings.HasPrefix(msg, "Step ") || strings.HasPrefix(msg, "Successfully ") {
+					fmt.Printf("\r%s %s\n", spinner[spinIdx], displayMsg)
+					lastMsg = ""
+				} else {
+					fmt.Printf("\r%s %s", spinner[spinIdx], displayMsg)
+					lastMsg = displayMsg
+				}
+				spinIdx = (spinIdx + 1) % len(spinner)
+			case <-time.After(100 * time.Millisecond):
+				if lastMsg != "" {
+					fmt.Printf("\r%s %s", spinner[spinIdx], lastMsg)
+					spinIdx = (spinIdx + 1) % len(spinner)
+				}
+			}
+		}
+	}()
+	return doneChan
+}
+
 func parsePortMappings(portMappings []string) ([]agent.PortMapping, error) {
 	var ports []agent.PortMapping
 	
@@ -989,26 +2202,87 @@ func parseVolumeMappings(volumeMappings []string) ([]agent.VolumeMapping, error)
 		if len(parts) == 3 && parts[2] == "ro" {
 			readOnly = true
 		}
-		
-		if hostPath == "" || containerPath == "" {
-			return nil, fmt.Errorf("invalid volume mapping: host and container paths cannot be empty")
+		
+		if hostPath == "" || containerPath == "" {
+			return nil, fmt.Errorf("invalid volume mapping: host and container paths cannot be empty")
+		}
+		
+		volumes = append(volumes, agent.VolumeMapping{
+			HostPath:      hostPath,
+			ContainerPath: containerPath,
+			ReadOnly:      readOnly,
+		})
+	}
+	
+	return volumes, nil
+}
+
+func parseRoutes(routeFlags []string) (map[string]int, error) {
+	routes := make(map[string]int)
+	
+	for _, route := range routeFlags {
+		if route == "" {
+			continue
+		}
+		
+		// Parse format: name=port
+		parts := strings.SplitN(route, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid route format: %s (expected name=port)", route)
+		}
+		
+		port, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in route %s: %w", route, err)
+		}
+		
+		routes[parts[0]] = port
+	}
+	
+	return routes, nil
+}
+
+// buildEnv merges env files in order and then --env values, so later files
+// override earlier ones and --env overrides them all. Host variables in
+// --env values are substituted like in YAML files.
+func buildEnv(envFiles, envVars []string) (map[string]string, error) {
+	envMap := make(map[string]string)
+	for _, path := range envFiles {
+		fileEnv, err := config.ParseEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range fileEnv {
+			envMap[key] = value
 		}
-		
-		volumes = append(volumes, agent.VolumeMapping{
-			HostPath:      hostPath,
-			ContainerPath: containerPath,
-			ReadOnly:      readOnly,
-		})
 	}
-	
-	return volumes, nil
+	for _, env := range envVars {
+		if len(env) > 0 {
+			parts := strings.SplitN(env, "=", 2)
+			if len(parts) == 2 {
+				envMap[parts[0]] = config.ExpandHostEnv(parts[1])
+			}
+		}
+	}
+	return envMap, nil
 }
 
-func deployFromYAML(configFile string) {
+// deployFromYAML deploys every agent in a deployment file. envOverrides from
+// --env-file and --env take precedence over each agent's env.
+func deployFromYAML(configFile string, envOverrides map[string]string, dryRun bool) {
 	// Load deployment configuration
 	deployConfig, err := config.LoadDeploymentConfig(configFile)
 	if err != nil {
-		log.Fatalf("Failed to load deployment config: %v", err)
+		fmt.Fprintln(os.Stderr, "Failed to load deployment config:")
+		for _, e := range splitErrors(err) {
+			fmt.Fprintf(os.Stderr, "  - %v\n", e)
+		}
+		os.Exit(1)
+	}
+
+	if dryRun {
+		previewDeployment(configFile, deployConfig, envOverrides)
+		return
 	}
 
 	fmt.Printf("Deploying agents from: %s\n", configFile)
@@ -1038,6 +2312,8 @@ func deployFromYAML(configFile string) {
 
 		// Deploy each replica
 		for _, agentConfig := range agentConfigs {
+			applyEnvOverrides(&agentConfig, envOverrides)
+
 			// Use default token if not specified
 			token := agentConfig.Token
 			if token == "" {
@@ -1046,18 +2322,53 @@ func deployFromYAML(configFile string) {
 
 			// Empty port mappings (not supported in new architecture)
 			var portMappings []agent.PortMapping
+			
+			// Agent-level namespace wins over the deployment-wide one
+			namespace := agentConfig.Namespace
+			if namespace == "" {
+				namespace = deployConfig.Metadata.Namespace
+			}
 
 			// Create deployment request
 			deployReq := map[string]interface{}{
 				"name":         agentConfig.Name,
+				"namespace":    namespace,
+				"labels":       agentConfig.Labels,
 				"image":        agentConfig.Image,
 				"env_vars":     agentConfig.EnvVars,
+				"secrets":      agentConfig.Secrets,
 				"cpu_limit":    agentConfig.CPULimit,
 				"memory_limit": agentConfig.MemoryLimit,
+				"memory_swap":  agentConfig.MemorySwap,
+				"memory_reservation": agentConfig.MemoryReservation,
+				"cpu_shares":   agentConfig.CPUShares,
+				"pids_limit":   agentConfig.PidsLimit,
+				"ulimits":      agentConfig.Ulimits,
+				"gpus":         agentConfig.GPUs,
+				"networks":     agentConfig.Networks,
+				"network_aliases": agentConfig.NetworkAliases,
+				"inject_redis": agentConfig.InjectRedis,
+				"inject_api":   agentConfig.InjectAPI,
+				"size":         agentConfig.Size,
 				"auto_restart": agentConfig.AutoRestart,
+				"max_restarts": agentConfig.MaxRestarts,
+				"restart_window": agentConfig.RestartWindow,
+				"replay_rate_limit": agentConfig.ReplayRateLimit,
+				"rate_limit":   agentConfig.RateLimit,
+				"rate_limit_burst": agentConfig.RateLimitBurst,
+				"proxy_timeout": agentConfig.ProxyTimeout,
+				"proxy_retries": agentConfig.ProxyRetries,
+				"stop_signal":  agentConfig.StopSignal,
+				"stop_timeout": agentConfig.StopTimeout,
 				"token":        token,
 				"ports":        portMappings,
+				"proxy_port":   agentConfig.ProxyPort,
+				"routes":       agentConfig.Routes,
 				"volumes":      agentConfig.Volumes,
+				"shared_volumes": agentConfig.SharedVolumes,
+				"allow_arch_mismatch": agentConfig.AllowArchMismatch,
+				"pull_policy":  agentConfig.PullPolicy,
+				"pin_digest":   agentConfig.PinDigest,
 				"health_check": agentConfig.HealthCheck,
 			}
 
@@ -1109,6 +2420,173 @@ func deployFromYAML(configFile string) {
 	}
 }
 
+// applyEnvOverrides sets --env-file and --env values on an agent from a deployment file
+func applyEnvOverrides(agentConfig *config.AgentConfig, envOverrides map[string]string) {
+	if len(envOverrides) == 0 {
+		return
+	}
+	env := make(map[string]string, len(agentConfig.EnvVars)+len(envOverrides))
+	for key, value := range agentConfig.EnvVars {
+		env[key] = value
+	}
+	for key, value := range envOverrides {
+		env[key] = value
+	}
+	agentConfig.EnvVars = env
+}
+
+// previewDeployment prints the agents a deployment file resolves to and runs
+// the checks the server would make, without deploying anything. All problems
+// are reported before exiting.
+func previewDeployment(configFile string, deployConfig *config.DeploymentConfig, envOverrides map[string]string) {
+	fmt.Printf("Dry run of: %s\n", configFile)
+	fmt.Printf("Deployment: %s\n", deployConfig.Metadata.Name)
+	fmt.Println(strings.Repeat("-", 80))
+
+	var problems []error
+	total := 0
+	for _, spec := range deployConfig.Spec.Agents {
+		agentConfigs, err := spec.ConvertToAgentConfigs()
+		if err != nil {
+			problems = append(problems, fmt.Errorf("agent[%s]: %w", spec.Name, err))
+			continue
+		}
+
+		for _, agentConfig := range agentConfigs {
+			applyEnvOverrides(&agentConfig, envOverrides)
+			fail := func(err error) {
+				problems = append(problems, fmt.Errorf("agent[%s]: %w", agentConfig.Name, err))
+			}
+
+			namespace := agentConfig.Namespace
+			if namespace == "" {
+				namespace = deployConfig.Metadata.Namespace
+			}
+			if namespace == "" {
+				namespace = agent.DefaultNamespace
+			}
+
+			// Explicit limits take precedence over the size preset, as on the server
+			cpuLimit, memoryLimit := agentConfig.CPULimit, agentConfig.MemoryLimit
+			if agentConfig.Size != "" {
+				sizeCPU, sizeMemory, err := cfg.SizeLimits(agentConfig.Size)
+				if err != nil {
+					fail(err)
+				}
+				if cpuLimit == 0 {
+					cpuLimit = sizeCPU
+				}
+				if memoryLimit == 0 {
+					memoryLimit = sizeMemory
+				}
+			}
+
+			if err := agent.ValidateResourceLimits(memoryLimit, agentConfig.MemorySwap, agentConfig.PidsLimit, agentConfig.Ulimits); err != nil {
+				fail(err)
+			}
+			if err := agent.ValidateReservations(memoryLimit, agentConfig.MemoryReservation, agentConfig.CPUShares); err != nil {
+				fail(err)
+			}
+			if _, err := agent.ParseRestartWindow(agentConfig.RestartWindow); err != nil {
+				fail(err)
+			}
+			if _, err := agent.ParseProxyTimeout(agentConfig.ProxyTimeout); err != nil {
+				fail(err)
+			}
+			if err := agent.ValidateProxyRetries(agentConfig.ProxyRetries); err != nil {
+				fail(err)
+			}
+			if _, err := agent.ParseStopSignal(agentConfig.StopSignal); err != nil {
+				fail(err)
+			}
+			if _, err := agent.ParseStopTimeout(agentConfig.StopTimeout); err != nil {
+				fail(err)
+			}
+			if agentConfig.HealthCheck != nil {
+				if err := agent.ValidateHealthCheck(agentConfig.HealthCheck); err != nil {
+					fail(err)
+				}
+			}
+			if _, err := agent.ResolveEnv(&agent.Agent{Name: agentConfig.Name, Namespace: namespace, EnvVars: agentConfig.EnvVars}); err != nil {
+				fail(err)
+			}
+
+			total++
+			fmt.Printf("\n%s (namespace: %s)\n", agentConfig.Name, namespace)
+			fmt.Printf("  Image:    %s\n", agentConfig.Image)
+			cpu, memory := "unlimited", "unlimited"
+			if cpuLimit > 0 {
+				cpu = fmt.Sprintf("%g cores", float64(cpuLimit)/1e9)
+			}
+			if memoryLimit > 0 {
+				memory = formatBytes(memoryLimit)
+			}
+			fmt.Printf("  CPU:      %s\n", cpu)
+			fmt.Printf("  Memory:   %s\n", memory)
+			if agentConfig.Size != "" {
+				fmt.Printf("  Size:     %s\n", agentConfig.Size)
+			}
+			if len(agentConfig.Labels) > 0 {
+				labels := make([]string, 0, len(agentConfig.Labels))
+				for key, value := range agentConfig.Labels {
+					labels = append(labels, key+"="+value)
+				}
+				sort.Strings(labels)
+				fmt.Printf("  Labels:   %s\n", strings.Join(labels, ", "))
+			}
+			if len(agentConfig.EnvVars) > 0 {
+				keys := make([]string, 0, len(agentConfig.EnvVars))
+				for key := range agentConfig.EnvVars {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				fmt.Printf("  Env:      %s\n", strings.Join(keys, ", "))
+			}
+			if len(agentConfig.Secrets) > 0 {
+				keys := make([]string, 0, len(agentConfig.Secrets))
+				for key := range agentConfig.Secrets {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				fmt.Printf("  Secrets:  %s\n", strings.Join(keys, ", "))
+			}
+			for _, volume := range agentConfig.Volumes {
+				mode := "rw"
+				if volume.ReadOnly {
+					mode = "ro"
+				}
+				fmt.Printf("  Volume:   %s -> %s (%s)\n", volume.HostPath, volume.ContainerPath, mode)
+			}
+			if agentConfig.HealthCheck != nil {
+				fmt.Printf("  Health:   %s %s every %s\n", agentConfig.HealthCheck.Type, agentConfig.HealthCheck.Endpoint, agentConfig.HealthCheck.Interval)
+			}
+			if agentConfig.AutoRestart {
+				fmt.Printf("  Restart:  auto (max %d)\n", agentConfig.MaxRestarts)
+			}
+		}
+	}
+
+	fmt.Println(strings.Repeat("-", 80))
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Found %d problem(s):\n", len(problems))
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  - %v\n", problem)
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("Dry run OK: %d agent(s) would be deployed\n", total)
+}
+
+// splitErrors returns the individual errors of a joined error, or err itself
+func splitErrors(err error) []error {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if joined, ok := e.(interface{ Unwrap() []error }); ok {
+			return joined.Unwrap()
+		}
+	}
+	return []error{err}
+}
+
 func viewRequests(agentID string) {
 	
 	// Create HTTP client
@@ -1122,7 +2600,7 @@ func viewRequests(agentID string) {
 	}
 	
 	// Add auth header
-	req.Header.Set("Authorization", "Bearer "+cfg.Security.DefaultToken)
+	req.Header.Set("Authorization", "Bearer "+apiToken())
 	
 	resp, err := client.Do(req)
 	if err != nil {
@@ -1153,25 +2631,303 @@ func viewRequests(agentID string) {
 		return
 	}
 	
-	if len(pendingReqs) == 0 {
-		fmt.Printf("No pending requests for agent %s\n", agentID)
-		return
+	if len(pendingReqs) == 0 {
+		fmt.Printf("No pending requests for agent %s\n", agentID)
+		return
+	}
+	
+	fmt.Printf("Pending requests for agent %s:\n", agentID)
+	fmt.Println(strings.Repeat("-", 80))
+	
+	for _, req := range pendingReqs {
+		r := req.(map[string]interface{})
+		fmt.Printf("ID: %s\n", r["id"])
+		fmt.Printf("Method: %s %s\n", r["method"], r["path"])
+		fmt.Printf("Status: %s\n", r["status"])
+		if priority, ok := r["priority"].(float64); ok && priority != 0 {
+			fmt.Printf("Priority: %d\n", int(priority))
+		}
+		fmt.Printf("Created: %s\n", r["created_at"])
+		if retries, ok := r["retry_count"].(float64); ok && retries > 0 {
+			fmt.Printf("Retries: %d/%d\n", int(retries), int(r["max_retries"].(float64)))
+		}
+		fmt.Println(strings.Repeat("-", 80))
+	}
+}
+
+func viewDeadLetterRequests(agentID string) {
+	apiResp, err := makeAPIRequest("GET", fmt.Sprintf("/agents/%s/requests/deadletter", agentID), nil)
+	if err != nil {
+		log.Fatalf("Failed to get dead-letter requests: %v", err)
+	}
+	
+	if !apiResp.Success {
+		log.Fatalf("API error: %s", apiResp.Message)
+	}
+	
+	data, ok := apiResp.Data.(map[string]interface{})
+	if !ok {
+		fmt.Println("Unexpected response format")
+		return
+	}
+	
+	deadLetter, _ := data["deadletter"].([]interface{})
+	if len(deadLetter) == 0 {
+		fmt.Printf("No dead-letter requests for agent %s\n", agentID)
+		return
+	}
+	
+	fmt.Printf("Dead-letter requests for agent %s:\n", agentID)
+	fmt.Println(strings.Repeat("-", 80))
+	
+	for _, req := range deadLetter {
+		r := req.(map[string]interface{})
+		fmt.Printf("ID: %s\n", r["id"])
+		fmt.Printf("Method: %s %s\n", r["method"], r["path"])
+		fmt.Printf("Created: %s\n", r["created_at"])
+		if retries, ok := r["retry_count"].(float64); ok {
+			fmt.Printf("Retries: %d/%d\n", int(retries), int(r["max_retries"].(float64)))
+		}
+		if errMsg, ok := r["error"].(string); ok && errMsg != "" {
+			fmt.Printf("Last error: %s\n", errMsg)
+		}
+		fmt.Println(strings.Repeat("-", 80))
+	}
+}
+
+func reconcileAgents() {
+	apiResp, err := makeAPIRequest("POST", "/system/reconcile", nil)
+	if err != nil {
+		log.Fatalf("Failed to reconcile: %v", err)
+	}
+	
+	if !apiResp.Success {
+		log.Fatalf("API error: %s", apiResp.Message)
+	}
+	
+	data, err := json.Marshal(apiResp.Data)
+	if err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	var result sync.SyncResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	
+	fmt.Printf("[%s] Checked %d agents\n", time.Now().Format("15:04:05"), result.Checked)
+	if len(result.Drift) == 0 && len(result.Updated) == 0 && len(result.Failed) == 0 {
+		fmt.Println("  All agents match their containers")
+		return
+	}
+	
+	for _, drift := range result.Drift {
+		fmt.Printf("  %s (%s): %s → %s, %s\n", drift.AgentName, drift.AgentID, drift.From, drift.To, drift.Message)
+	}
+	if others := len(result.Updated) - len(result.Drift); others > 0 {
+		fmt.Printf("  Updated container details of %d agents\n", others)
+	}
+	for agentID, msg := range result.Failed {
+		fmt.Printf("  ✗ %s: %s\n", agentID, msg)
+	}
+}
+
+func viewAgentEvents(agentID string, limit int) {
+	endpoint := fmt.Sprintf("/agents/%s/events", agentID)
+	if limit > 0 {
+		endpoint += fmt.Sprintf("?limit=%d", limit)
+	}
+	apiResp, err := makeAPIRequest("GET", endpoint, nil)
+	if err != nil {
+		log.Fatalf("Failed to get events: %v", err)
+	}
+	
+	if !apiResp.Success {
+		log.Fatalf("API error: %s", apiResp.Message)
+	}
+	
+	data, err := json.Marshal(apiResp.Data)
+	if err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	var events []agent.Event
+	if err := json.Unmarshal(data, &events); err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	
+	if len(events) == 0 {
+		fmt.Println("No events recorded")
+		return
+	}
+	
+	fmt.Printf("%-20s %-10s %-22s %-16s %s\n", "TIME", "EVENT", "STATUS", "USER", "MESSAGE")
+	for _, event := range events {
+		transition := string(event.To)
+		if event.From != "" && event.To != "" {
+			transition = fmt.Sprintf("%s -> %s", event.From, event.To)
+		} else if event.From != "" {
+			transition = string(event.From)
+		}
+		user := event.User
+		if len(user) > 16 {
+			user = user[:13] + "..."
+		}
+		fmt.Printf("%-20s %-10s %-22s %-16s %s\n",
+			event.Time.Local().Format("2006-01-02 15:04:05"), event.Type, transition, user, event.Message)
+	}
+}
+
+func inspectAgent(agentID string) {
+	apiResp, err := makeAPIRequest("GET", fmt.Sprintf("/agents/%s/inspect", agentID), nil)
+	if err != nil {
+		log.Fatalf("Failed to inspect agent: %v", err)
+	}
+	
+	if !apiResp.Success {
+		log.Fatalf("API error: %s", apiResp.Message)
+	}
+	
+	data, err := json.MarshalIndent(apiResp.Data, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to format response: %v", err)
+	}
+	fmt.Println(string(data))
+}
+
+func viewAgentDiff(agentID string) {
+	apiResp, err := makeAPIRequest("GET", fmt.Sprintf("/agents/%s/diff", agentID), nil)
+	if err != nil {
+		log.Fatalf("Failed to get container changes: %v", err)
+	}
+	
+	if !apiResp.Success {
+		log.Fatalf("API error: %s", apiResp.Message)
+	}
+	
+	data, err := json.Marshal(apiResp.Data)
+	if err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	var changes []agent.FileChange
+	if err := json.Unmarshal(data, &changes); err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+	
+	for _, change := range changes {
+		marker := "C"
+		switch change.Kind {
+		case "added":
+			marker = "A"
+		case "deleted":
+			marker = "D"
+		}
+		fmt.Printf("%s %s\n", marker, change.Path)
+	}
+}
+
+func viewAlerts() {
+	apiResp, err := makeAPIRequest("GET", "/alerts", nil)
+	if err != nil {
+		log.Fatalf("Failed to get alerts: %v", err)
+	}
+	
+	if !apiResp.Success {
+		log.Fatalf("API error: %s", apiResp.Message)
+	}
+	
+	data, err := json.Marshal(apiResp.Data)
+	if err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	var active []alerts.Alert
+	if err := json.Unmarshal(data, &active); err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	
+	if len(active) == 0 {
+		fmt.Println("No active alerts")
+		return
+	}
+	
+	fmt.Printf("%-24s %-20s %-16s %-18s %-20s\n", "RULE", "AGENT", "METRIC", "VALUE", "FIRING SINCE")
+	for _, alert := range active {
+		name := alert.AgentName
+		if name == "" {
+			name = alert.AgentID
+		}
+		fmt.Printf("%-24s %-20s %-16s %-18s %-20s\n",
+			alert.Rule, name, alert.Metric,
+			fmt.Sprintf("%.4g %s %g", alert.Value, alert.Comparator, alert.Threshold),
+			alert.FiredAt.Local().Format("2006-01-02 15:04:05"))
+	}
+}
+
+func viewAgentStats(agentID string) {
+	apiResp, err := makeAPIRequest("GET", fmt.Sprintf("/agents/%s/stats", agentID), nil)
+	if err != nil {
+		log.Fatalf("Failed to get stats: %v", err)
+	}
+	
+	if !apiResp.Success {
+		log.Fatalf("API error: %s", apiResp.Message)
+	}
+	
+	data, err := json.Marshal(apiResp.Data)
+	if err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	var stats requests.Stats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		log.Fatalf("Failed to parse response: %v", err)
+	}
+	
+	fmt.Printf("Request Stats for Agent %s:\n", agentID)
+	fmt.Println(strings.Repeat("=", 60))
+	if stats.Requests == 0 {
+		fmt.Println("No requests recorded yet")
+		return
+	}
+	
+	if stats.Since != nil {
+		fmt.Printf("Since:      %s\n", stats.Since.Format(time.RFC3339))
+	}
+	fmt.Printf("Requests:   %d\n", stats.Requests)
+	fmt.Printf("Errors:     %d (%.2f%%)\n", stats.Errors, stats.ErrorRate*100)
+	
+	fmt.Println("\nStatus Codes:")
+	classes := make([]string, 0, len(stats.StatusCodes))
+	for class := range stats.StatusCodes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Printf("  %-8s %d\n", class+":", stats.StatusCodes[class])
+	}
+	
+	fmt.Println("\nLatency:")
+	fmt.Printf("  Avg: %.1fms\n", stats.Latency.Avg)
+	fmt.Printf("  Percentiles over the last %d requests\n", stats.Latency.Samples)
+	fmt.Printf("  P50: %.1fms\n", stats.Latency.P50)
+	fmt.Printf("  P95: %.1fms\n", stats.Latency.P95)
+	fmt.Printf("  P99: %.1fms\n", stats.Latency.P99)
+	fmt.Printf("  Max: %.1fms\n", stats.Latency.Max)
+}
+
+func resetAgentStats(agentID string) {
+	apiResp, err := makeAPIRequest("DELETE", fmt.Sprintf("/agents/%s/stats", agentID), nil)
+	if err != nil {
+		log.Fatalf("Failed to reset stats: %v", err)
 	}
 	
-	fmt.Printf("Pending requests for agent %s:\n", agentID)
-	fmt.Println(strings.Repeat("-", 80))
-	
-	for _, req := range pendingReqs {
-		r := req.(map[string]interface{})
-		fmt.Printf("ID: %s\n", r["id"])
-		fmt.Printf("Method: %s %s\n", r["method"], r["path"])
-		fmt.Printf("Status: %s\n", r["status"])
-		fmt.Printf("Created: %s\n", r["created_at"])
-		if retries, ok := r["retry_count"].(float64); ok && retries > 0 {
-			fmt.Printf("Retries: %d/%d\n", int(retries), int(r["max_retries"].(float64)))
-		}
-		fmt.Println(strings.Repeat("-", 80))
+	if !apiResp.Success {
+		log.Fatalf("API error: %s", apiResp.Message)
 	}
+	
+	fmt.Printf("Request stats for agent %s reset\n", agentID)
 }
 
 func viewAgentHealth(agentID string) {
@@ -1186,7 +2942,7 @@ func viewAgentHealth(agentID string) {
 	}
 	
 	// Add auth header
-	req.Header.Set("Authorization", "Bearer "+cfg.Security.DefaultToken)
+	req.Header.Set("Authorization", "Bearer "+apiToken())
 	
 	resp, err := client.Do(req)
 	if err != nil {
@@ -1221,6 +2977,9 @@ func viewAgentHealth(agentID string) {
 	if msg, ok := data["message"].(string); ok && msg != "" {
 		fmt.Printf("Message: %s\n", msg)
 	}
+	if containerHealth, ok := data["container_health"].(string); ok && containerHealth != "" {
+		fmt.Printf("Container Health: %s\n", containerHealth)
+	}
 }
 
 func viewAllHealthStatuses() {
@@ -1235,7 +2994,7 @@ func viewAllHealthStatuses() {
 	}
 	
 	// Add auth header
-	req.Header.Set("Authorization", "Bearer "+cfg.Security.DefaultToken)
+	req.Header.Set("Authorization", "Bearer "+apiToken())
 	
 	resp, err := client.Do(req)
 	if err != nil {
@@ -1289,7 +3048,7 @@ func viewCurrentMetrics(agentID string) {
 	}
 	
 	// Add auth header
-	req.Header.Set("Authorization", "Bearer "+cfg.Security.DefaultToken)
+	req.Header.Set("Authorization", "Bearer "+apiToken())
 	
 	resp, err := client.Do(req)
 	if err != nil {
@@ -1368,7 +3127,7 @@ func viewMetricsHistory(agentID, duration string) {
 	}
 	
 	// Add auth header
-	req.Header.Set("Authorization", "Bearer "+cfg.Security.DefaultToken)
+	req.Header.Set("Authorization", "Bearer "+apiToken())
 	
 	resp, err := client.Do(req)
 	if err != nil {
@@ -1394,6 +3153,11 @@ func viewMetricsHistory(agentID, duration string) {
 	}
 	
 	fmt.Printf("Metrics History for Agent %s (Duration: %s):\n", agentID, data["duration"])
+	timeFormat := "15:04:05"
+	if data["resolution"] == "1h" {
+		fmt.Println("Showing hourly averages")
+		timeFormat = "01-02 15:04"
+	}
 	fmt.Println(strings.Repeat("=", 80))
 	
 	metrics, ok := data["metrics"].([]interface{})
@@ -1424,7 +3188,7 @@ func viewMetricsHistory(agentID, duration string) {
 		
 		// Format timestamp to show only time for readability
 		t, _ := time.Parse(time.RFC3339, timestamp)
-		timeStr := t.Format("15:04:05")
+		timeStr := t.Format(timeFormat)
 		
 		fmt.Printf("%-20s %-10.2f %-15s %-15s %-15s\n",
 			timeStr,
@@ -1436,6 +3200,175 @@ func viewMetricsHistory(agentID, duration string) {
 }
 
 // formatBytes converts bytes to human-readable format
+// topRow is one agent's line in agentainer top
+type topRow struct {
+	ID       string
+	Name     string
+	Metrics  *metrics.Metrics
+	// RxRate and TxRate are bytes per second between the last two samples
+	RxRate   float64
+	TxRate   float64
+}
+
+// runTop redraws the running agents' resource usage until q or Ctrl-C
+func runTop(namespace, sortBy string, interval time.Duration) {
+	keys := make(chan byte, 1)
+	if fd, isTerminal := term.GetFdInfo(os.Stdin); isTerminal {
+		state, err := term.SetRawTerminal(fd)
+		if err != nil {
+			log.Fatalf("Failed to set terminal to raw mode: %v", err)
+		}
+		defer term.RestoreTerminal(fd, state)
+		
+		go func() {
+			buf := make([]byte, 1)
+			for {
+				if _, err := os.Stdin.Read(buf); err != nil {
+					return
+				}
+				keys <- buf[0]
+			}
+		}()
+	}
+	
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	
+	// Hide the cursor while redrawing and show it again on exit
+	fmt.Print("\033[?25l")
+	defer fmt.Print("\033[?25h\r\n")
+	
+	previous := make(map[string]topRow)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for {
+		rows, err := fetchTopRows(namespace, previous)
+		previous = make(map[string]topRow, len(rows))
+		for _, row := range rows {
+			previous[row.ID] = row
+		}
+		renderTop(rows, sortBy, err)
+		
+		select {
+		case key := <-keys:
+			switch key {
+			case 'q', 'Q', 3: // 3 is Ctrl-C in raw mode
+				return
+			case 'c':
+				sortBy = "cpu"
+			case 'm':
+				sortBy = "mem"
+			case 'n':
+				sortBy = "net"
+			case 'a':
+				sortBy = "name"
+			}
+		case <-sigChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchTopRows gets the latest metrics of every running agent, working out
+// network rates against the previous refresh
+func fetchTopRows(namespace string, previous map[string]topRow) ([]topRow, error) {
+	apiResp, err := makeAPIRequest("GET", agentsEndpoint(agent.ListFilter{Namespace: namespace, Status: agent.StatusRunning}), nil)
+	if err != nil {
+		return nil, err
+	}
+	if !apiResp.Success {
+		return nil, fmt.Errorf("%s", apiResp.Message)
+	}
+	agents, _ := apiResp.Data.([]interface{})
+	
+	results := make(chan topRow, len(agents))
+	for _, a := range agents {
+		agentData, _ := a.(map[string]interface{})
+		id, _ := agentData["id"].(string)
+		name, _ := agentData["name"].(string)
+		go func(row topRow) {
+			if resp, err := makeAPIRequest("GET", fmt.Sprintf("/agents/%s/metrics", row.ID), nil); err == nil && resp.Success {
+				if data, err := json.Marshal(resp.Data); err == nil {
+					var m metrics.Metrics
+					if json.Unmarshal(data, &m) == nil {
+						row.Metrics = &m
+					}
+				}
+			}
+			results <- row
+		}(topRow{ID: id, Name: name})
+	}
+	
+	rows := make([]topRow, 0, len(agents))
+	for range agents {
+		row := <-results
+		if prev, ok := previous[row.ID]; ok && row.Metrics != nil && prev.Metrics != nil {
+			row.RxRate, row.TxRate = prev.RxRate, prev.TxRate
+			// Metrics are sampled less often than top refreshes
+			if elapsed := row.Metrics.Timestamp.Sub(prev.Metrics.Timestamp).Seconds(); elapsed > 0 {
+				row.RxRate = float64(row.Metrics.Network.RxBytes-prev.Metrics.Network.RxBytes) / elapsed
+				row.TxRate = float64(row.Metrics.Network.TxBytes-prev.Metrics.Network.TxBytes) / elapsed
+				if row.Metrics.Network.RxBytes < prev.Metrics.Network.RxBytes || row.Metrics.Network.TxBytes < prev.Metrics.Network.TxBytes {
+					// Counters reset when the container restarted
+					row.RxRate, row.TxRate = 0, 0
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func renderTop(rows []topRow, sortBy string, fetchErr error) {
+	value := func(row topRow) float64 {
+		if row.Metrics == nil {
+			return -1
+		}
+		switch sortBy {
+		case "mem":
+			return float64(row.Metrics.Memory.Usage)
+		case "net":
+			return row.RxRate + row.TxRate
+		}
+		return row.Metrics.CPU.UsagePercent
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if sortBy == "name" {
+			return rows[i].Name < rows[j].Name
+		}
+		return value(rows[i]) > value(rows[j])
+	})
+	
+	// Raw mode needs explicit carriage returns
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "agentainer top - %s - %d running agents, sorted by %s (c/m/n/a sort, q quit)\r\n\r\n",
+		time.Now().Format("15:04:05"), len(rows), sortBy)
+	if fetchErr != nil {
+		fmt.Fprintf(&b, "✗ Failed to get agents: %v\r\n", fetchErr)
+	}
+	fmt.Fprintf(&b, "%-20s %-20s %7s %21s %7s %12s %12s\r\n", "ID", "NAME", "CPU %", "MEM USAGE / LIMIT", "MEM %", "NET RX/s", "NET TX/s")
+	for _, row := range rows {
+		name := row.Name
+		if len(name) > 20 {
+			name = name[:17] + "..."
+		}
+		if row.Metrics == nil {
+			fmt.Fprintf(&b, "%-20s %-20s %7s %21s %7s %12s %12s\r\n", row.ID, name, "-", "-", "-", "-", "-")
+			continue
+		}
+		m := row.Metrics
+		memory := fmt.Sprintf("%s / %s", formatBytes(int64(m.Memory.Usage)), formatBytes(int64(m.Memory.Limit)))
+		fmt.Fprintf(&b, "%-20s %-20s %7.2f %21s %7.2f %12s %12s\r\n",
+			row.ID, name, m.CPU.UsagePercent, memory, m.Memory.UsagePercent,
+			formatBytes(int64(row.RxRate)), formatBytes(int64(row.TxRate)))
+	}
+	fmt.Print(b.String())
+}
+
 func formatBytes(bytes int64) string {
 	const unit = 1024
 	if bytes < unit {
@@ -1449,9 +3382,9 @@ func formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
-func createBackup(name, description string, agentIDs []string) {
+func createBackup(name, description string, agentIDs []string, includeVolumes bool) {
 	// Create backup manager
-	dockerClient, err := docker.NewClient(cfg.Docker.Host)
+	dockerClient, err := docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
 	if err != nil {
 		log.Fatalf("Failed to create Docker client: %v", err)
 	}
@@ -1462,11 +3395,11 @@ func createBackup(name, description string, agentIDs []string) {
 		DB:       cfg.Redis.DB,
 	})
 
-	agentMgr := agent.NewManager(dockerClient, redisClient, cfg.GetAgentConfigPath())
+	agentMgr := agent.NewManager(dockerClient, redisClient, newStore(redisClient), cfg.GetAgentConfigPath(), cfg.Docker.OperationTimeout)
 	backupMgr := backup.NewManager(agentMgr, redisClient, "")
 
 	// Create backup
-	b, err := backupMgr.CreateBackup(context.Background(), name, description, agentIDs)
+	b, err := backupMgr.CreateBackup(context.Background(), name, description, agentIDs, includeVolumes)
 	if err != nil {
 		log.Fatalf("Failed to create backup: %v", err)
 	}
@@ -1480,7 +3413,7 @@ func createBackup(name, description string, agentIDs []string) {
 
 func listBackups() {
 	// Create backup manager
-	dockerClient, err := docker.NewClient(cfg.Docker.Host)
+	dockerClient, err := docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
 	if err != nil {
 		log.Fatalf("Failed to create Docker client: %v", err)
 	}
@@ -1491,7 +3424,7 @@ func listBackups() {
 		DB:       cfg.Redis.DB,
 	})
 
-	agentMgr := agent.NewManager(dockerClient, redisClient, cfg.GetAgentConfigPath())
+	agentMgr := agent.NewManager(dockerClient, redisClient, newStore(redisClient), cfg.GetAgentConfigPath(), cfg.Docker.OperationTimeout)
 	backupMgr := backup.NewManager(agentMgr, redisClient, "")
 
 	// List backups
@@ -1517,9 +3450,69 @@ func listBackups() {
 	}
 }
 
-func restoreBackup(backupID string, agentIDs []string) {
+func showBackupSchedule() {
+	if cfg.Backup.Schedule == "" {
+		fmt.Println("Scheduled backups are disabled (set backup.schedule in config.yaml)")
+		return
+	}
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%d", cfg.Redis.Host, cfg.Redis.Port),
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	defer redisClient.Close()
+
+	scheduler, err := backup.NewScheduler(nil, redisClient, cfg.Backup.Schedule, cfg.Backup.RetentionDays, cfg.Backup.IncludeVolumes)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx := context.Background()
+	fmt.Printf("Schedule: %s\n", scheduler.Spec())
+	if cfg.Backup.RetentionDays > 0 {
+		fmt.Printf("Retention: %d days\n", cfg.Backup.RetentionDays)
+	} else {
+		fmt.Println("Retention: forever")
+	}
+
+	lastRun, err := scheduler.LastRun(ctx)
+	if err != nil {
+		log.Fatalf("Failed to get last run: %v", err)
+	}
+	if lastRun.IsZero() {
+		fmt.Println("Last run: never")
+	} else {
+		fmt.Printf("Last run: %s\n", lastRun.Format(time.RFC3339))
+	}
+
+	nextRun, err := scheduler.NextRun(ctx)
+	if err != nil {
+		log.Fatalf("Failed to get next run: %v", err)
+	}
+	fmt.Printf("Next run: %s\n", nextRun.Format(time.RFC3339))
+}
+
+// parseVolumeRemaps parses old:new host path pairs for backup restore
+func parseVolumeRemaps(remaps []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, remap := range remaps {
+		parts := strings.SplitN(remap, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("expected old:new, got '%s'", remap)
+		}
+		newPath, err := filepath.Abs(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid path '%s': %w", parts[1], err)
+		}
+		result[parts[0]] = newPath
+	}
+	return result, nil
+}
+
+func restoreBackup(backupID string, agentIDs []string, remap map[string]string) {
 	// Create backup manager
-	dockerClient, err := docker.NewClient(cfg.Docker.Host)
+	dockerClient, err := docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
 	if err != nil {
 		log.Fatalf("Failed to create Docker client: %v", err)
 	}
@@ -1530,11 +3523,19 @@ func restoreBackup(backupID string, agentIDs []string) {
 		DB:       cfg.Redis.DB,
 	})
 
-	agentMgr := agent.NewManager(dockerClient, redisClient, cfg.GetAgentConfigPath())
+	agentMgr := agent.NewManager(dockerClient, redisClient, newStore(redisClient), cfg.GetAgentConfigPath(), cfg.Docker.OperationTimeout)
+	agentMgr.SetRegistryAuths(cfg.Registries)
+	agentMgr.SetAutoPull(cfg.Deploy.AutoPull)
+	agentMgr.SetAPIPort(cfg.Server.Port)
+	secretStore, err := newSecretStore(redisClient)
+	if err != nil {
+		log.Fatalf("Failed to initialize secrets: %v", err)
+	}
+	agentMgr.SetSecretStore(secretStore)
 	backupMgr := backup.NewManager(agentMgr, redisClient, "")
 
 	// Restore backup
-	if err := backupMgr.RestoreBackup(context.Background(), backupID, agentIDs); err != nil {
+	if err := backupMgr.RestoreBackup(context.Background(), backupID, agentIDs, remap); err != nil {
 		log.Fatalf("Failed to restore backup: %v", err)
 	}
 
@@ -1543,7 +3544,7 @@ func restoreBackup(backupID string, agentIDs []string) {
 
 func deleteBackup(backupID string) {
 	// Create backup manager
-	dockerClient, err := docker.NewClient(cfg.Docker.Host)
+	dockerClient, err := docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
 	if err != nil {
 		log.Fatalf("Failed to create Docker client: %v", err)
 	}
@@ -1554,7 +3555,7 @@ func deleteBackup(backupID string) {
 		DB:       cfg.Redis.DB,
 	})
 
-	agentMgr := agent.NewManager(dockerClient, redisClient, cfg.GetAgentConfigPath())
+	agentMgr := agent.NewManager(dockerClient, redisClient, newStore(redisClient), cfg.GetAgentConfigPath(), cfg.Docker.OperationTimeout)
 	backupMgr := backup.NewManager(agentMgr, redisClient, "")
 
 	// Delete backup
@@ -1567,7 +3568,7 @@ func deleteBackup(backupID string) {
 
 func exportBackup(backupID, outputPath string) {
 	// Create backup manager
-	dockerClient, err := docker.NewClient(cfg.Docker.Host)
+	dockerClient, err := docker.NewClient(cfg.Docker.Host, cfg.Docker.OperationTimeout)
 	if err != nil {
 		log.Fatalf("Failed to create Docker client: %v", err)
 	}
@@ -1578,7 +3579,7 @@ func exportBackup(backupID, outputPath string) {
 		DB:       cfg.Redis.DB,
 	})
 
-	agentMgr := agent.NewManager(dockerClient, redisClient, cfg.GetAgentConfigPath())
+	agentMgr := agent.NewManager(dockerClient, redisClient, newStore(redisClient), cfg.GetAgentConfigPath(), cfg.Docker.OperationTimeout)
 	backupMgr := backup.NewManager(agentMgr, redisClient, "")
 
 	// Export backup
@@ -1589,14 +3590,48 @@ func exportBackup(backupID, outputPath string) {
 	fmt.Printf("Backup %s exported to %s\n", backupID, outputPath)
 }
 
-func viewAuditLogs(userID, action, resource, durationStr string, limit int) {
-	// Parse duration
+// auditFilterFromFlags builds an audit query from the audit command's
+// filter flags, returning the duration as given for display
+func auditFilterFromFlags(cmd *cobra.Command) (logging.AuditFilter, string) {
+	user, _ := cmd.Flags().GetString("user")
+	action, _ := cmd.Flags().GetString("action")
+	resource, _ := cmd.Flags().GetString("resource")
+	durationStr, _ := cmd.Flags().GetString("duration")
+	resourceID, _ := cmd.Flags().GetString("resource-id")
+	result, _ := cmd.Flags().GetString("result")
+	
 	duration, err := time.ParseDuration(durationStr)
 	if err != nil {
 		log.Fatalf("Invalid duration: %v", err)
 	}
+	if result != "" && result != "success" && result != "failure" {
+		log.Fatalf("Invalid --result: expected success or failure")
+	}
 	
-	// Create logger to access audit logs
+	filter := logging.AuditFilter{
+		Duration:   duration,
+		UserID:     user,
+		Action:     action,
+		Resource:   resource,
+		ResourceID: resourceID,
+		Result:     result,
+	}
+	for name, cursor := range map[string]*time.Time{"before": &filter.Before, "after": &filter.After} {
+		value, _ := cmd.Flags().GetString(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			log.Fatalf("Invalid --%s: expected an RFC 3339 timestamp", name)
+		}
+		*cursor = t
+	}
+	return filter, durationStr
+}
+
+// queryAuditLogs reads audit logs straight from the store
+func queryAuditLogs(filter logging.AuditFilter) []logging.AuditEntry {
 	redisClient := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%d", cfg.Redis.Host, cfg.Redis.Port),
 		Password: cfg.Redis.Password,
@@ -1604,33 +3639,39 @@ func viewAuditLogs(userID, action, resource, durationStr string, limit int) {
 	})
 	defer redisClient.Close()
 	
-	logger, err := logging.NewLogger(redisClient, "", false)
+	logger, err := logging.NewLogger(redisClient, newStore(redisClient), logging.Options{
+		NoFiles:    true,
+		MaxAge:     cfg.Logging.MaxAge,
+		MaxEntries: cfg.Logging.MaxEntries,
+	})
 	if err != nil {
 		log.Fatalf("Failed to create logger: %v", err)
 	}
 	defer logger.Close()
 	
-	// Get audit logs
-	filter := logging.AuditFilter{
-		Duration: duration,
-		UserID:   userID,
-		Action:   action,
-		Resource: resource,
-		Limit:    limit,
-	}
-	
 	logs, err := logger.GetAuditLogs(context.Background(), filter)
 	if err != nil {
 		log.Fatalf("Failed to get audit logs: %v", err)
 	}
-	
+	return logs
+}
+
+func viewAuditLogs(filter logging.AuditFilter, durationStr string) {
+	logs := queryAuditLogs(filter)
 	if len(logs) == 0 {
 		fmt.Println("No audit logs found matching the criteria")
 		return
 	}
 	
 	// Display logs
-	fmt.Printf("Audit Logs (Last %s):\n", durationStr)
+	switch {
+	case !filter.After.IsZero():
+		fmt.Printf("Audit Logs (After %s):\n", filter.After.Format(time.RFC3339))
+	case !filter.Before.IsZero():
+		fmt.Printf("Audit Logs (%s before %s):\n", durationStr, filter.Before.Format(time.RFC3339))
+	default:
+		fmt.Printf("Audit Logs (Last %s):\n", durationStr)
+	}
 	fmt.Printf("%-20s %-20s %-15s %-20s %-10s %-15s\n", "TIMESTAMP", "USER", "ACTION", "RESOURCE", "RESULT", "IP")
 	fmt.Println(strings.Repeat("-", 100))
 	
@@ -1654,4 +3695,355 @@ func viewAuditLogs(userID, action, resource, durationStr string, limit int) {
 			log.Result,
 			log.IP)
 	}
-}
\ No newline at end of file
+	
+	// A full page may have more entries beyond it
+	if len(logs) == filter.Limit {
+		fmt.Println()
+		if filter.After.IsZero() {
+			fmt.Printf("Older entries: --before %s\n", logs[0].Timestamp.Format(time.RFC3339Nano))
+		} else {
+			fmt.Printf("Newer entries: --after %s\n", logs[len(logs)-1].Timestamp.Format(time.RFC3339Nano))
+		}
+	}
+}
+
+// exportAuditLogs writes every matching audit entry as a JSON array or CSV
+func exportAuditLogs(filter logging.AuditFilter, durationStr, format, output string) {
+	if format != "json" && format != "csv" {
+		log.Fatalf("Invalid --format: expected json or csv")
+	}
+	
+	logs := queryAuditLogs(filter)
+	
+	out := os.Stdout
+	if output != "" {
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", output, err)
+		}
+		defer file.Close()
+		out = file
+	}
+	
+	var err error
+	if format == "csv" {
+		err = writeAuditCSV(out, logs)
+	} else {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(logs)
+	}
+	if err != nil {
+		log.Fatalf("Failed to export audit logs: %v", err)
+	}
+	
+	if output != "" {
+		fmt.Printf("✓ Exported %d audit entries (last %s) to %s\n", len(logs), durationStr, output)
+	}
+}
+
+func writeAuditCSV(out io.Writer, logs []logging.AuditEntry) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"timestamp", "user_id", "action", "resource", "resource_id", "result", "ip", "user_agent", "details"})
+	for _, entry := range logs {
+		details := ""
+		if len(entry.Details) > 0 {
+			data, _ := json.Marshal(entry.Details)
+			details = string(data)
+		}
+		w.Write([]string{
+			entry.Timestamp.Format(time.RFC3339Nano),
+			entry.UserID,
+			entry.Action,
+			entry.Resource,
+			entry.ResourceID,
+			entry.Result,
+			entry.IP,
+			entry.UserAgent,
+			details,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// newStore opens the configured storage backend, exiting if it can't
+func newStore(redisClient *redis.Client) storage.Store {
+	store, err := storage.NewStore(cfg.Storage.Backend, cfg.Storage.PostgresDSN, redisClient)
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", cfg.Storage.Backend, err)
+	}
+	return store
+}
+
+// newSecretStore opens the encrypted secret store with the configured master key
+func newSecretStore(redisClient *redis.Client) (*secrets.Store, error) {
+	key, err := secrets.LoadMasterKey(cfg.Security.SecretsKey, cfg.GetSecretsKeyPath())
+	if err != nil {
+		return nil, err
+	}
+	return secrets.NewStore(redisClient, key)
+}
+
+// parseSecretRefs parses ENV_VAR=secret-name references
+func parseSecretRefs(refs []string) (map[string]string, error) {
+	result := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		envName, secretName, ok := strings.Cut(ref, "=")
+		if !ok || envName == "" || secretName == "" {
+			return nil, fmt.Errorf("invalid secret reference '%s' (expected ENV_VAR=secret-name)", ref)
+		}
+		if err := secrets.ValidateName(secretName); err != nil {
+			return nil, err
+		}
+		result[envName] = secretName
+	}
+	return result, nil
+}
+
+func createSecret(name, value string) {
+	if err := secrets.ValidateName(name); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	resp, err := makeAPIRequest("POST", "/secrets", api.SecretRequest{Name: name, Value: value})
+	if err != nil {
+		log.Fatalf("Failed to save secret: %v", err)
+	}
+	if !resp.Success {
+		log.Fatalf("Failed to save secret: %s", resp.Message)
+	}
+
+	fmt.Printf("Secret %s saved\n", name)
+}
+
+func listSecrets() {
+	resp, err := makeAPIRequest("GET", "/secrets", nil)
+	if err != nil {
+		log.Fatalf("Failed to list secrets: %v", err)
+	}
+	if !resp.Success {
+		log.Fatalf("Failed to list secrets: %s", resp.Message)
+	}
+
+	list, _ := resp.Data.([]interface{})
+	if len(list) == 0 {
+		fmt.Println("No secrets found")
+		return
+	}
+
+	fmt.Printf("%-40s %-20s %-20s\n", "NAME", "CREATED", "UPDATED")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, item := range list {
+		secret, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := secret["name"].(string)
+		createdAt, _ := secret["created_at"].(string)
+		updatedAt, _ := secret["updated_at"].(string)
+		created, _ := time.Parse(time.RFC3339, createdAt)
+		updated, _ := time.Parse(time.RFC3339, updatedAt)
+		fmt.Printf("%-40s %-20s %-20s\n", name, created.Format("2006-01-02 15:04:05"), updated.Format("2006-01-02 15:04:05"))
+	}
+}
+
+func deleteSecret(name string) {
+	resp, err := makeAPIRequest("DELETE", fmt.Sprintf("/secrets/%s", url.PathEscape(name)), nil)
+	if err != nil {
+		log.Fatalf("Failed to delete secret: %v", err)
+	}
+	if !resp.Success {
+		log.Fatalf("Failed to delete secret: %s", resp.Message)
+	}
+
+	fmt.Printf("Secret %s deleted\n", name)
+}
+
+func createToken(name, user string) {
+	if err := tokens.ValidateName(name); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if user != "" {
+		if err := tokens.ValidateName(user); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	resp, err := makeAPIRequest("POST", "/tokens", api.TokenRequest{Name: name, User: user})
+	if err != nil {
+		log.Fatalf("Failed to create token: %v", err)
+	}
+	if !resp.Success {
+		log.Fatalf("Failed to create token: %s", resp.Message)
+	}
+
+	data, _ := resp.Data.(map[string]interface{})
+	id, _ := data["id"].(string)
+	value, _ := data["token"].(string)
+	owner, _ := data["user"].(string)
+	fmt.Printf("✓ Token %s created (ID: %s, user: %s)\n", name, id, owner)
+	fmt.Printf("\n  %s\n\n", value)
+	fmt.Println("Store it now; it cannot be shown again. Use it with:")
+	fmt.Println("  export AGENTAINER_TOKEN=<token>")
+}
+
+func listTokens() {
+	resp, err := makeAPIRequest("GET", "/tokens", nil)
+	if err != nil {
+		log.Fatalf("Failed to list tokens: %v", err)
+	}
+	if !resp.Success {
+		log.Fatalf("Failed to list tokens: %s", resp.Message)
+	}
+
+	list, _ := resp.Data.([]interface{})
+	if len(list) == 0 {
+		fmt.Println("No tokens found")
+		return
+	}
+
+	fmt.Printf("%-14s %-20s %-20s %-10s %-20s %-20s\n", "ID", "NAME", "USER", "STATUS", "CREATED", "LAST USED")
+	fmt.Println(strings.Repeat("-", 108))
+	for _, item := range list {
+		token, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := token["id"].(string)
+		name, _ := token["name"].(string)
+		user, _ := token["user"].(string)
+		status := "active"
+		if revoked, _ := token["revoked"].(bool); revoked {
+			status = "revoked"
+		}
+		createdAt, _ := token["created_at"].(string)
+		created, _ := time.Parse(time.RFC3339, createdAt)
+		lastUsed := "never"
+		if s, ok := token["last_used"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				lastUsed = t.Format("2006-01-02 15:04:05")
+			}
+		}
+		fmt.Printf("%-14s %-20s %-20s %-10s %-20s %-20s\n", id, name, user, status, created.Format("2006-01-02 15:04:05"), lastUsed)
+	}
+}
+
+func revokeToken(idOrName string) {
+	resp, err := makeAPIRequest("DELETE", fmt.Sprintf("/tokens/%s", url.PathEscape(idOrName)), nil)
+	if err != nil {
+		log.Fatalf("Failed to revoke token: %v", err)
+	}
+	if !resp.Success {
+		log.Fatalf("Failed to revoke token: %s", resp.Message)
+	}
+
+	fmt.Printf("✓ Token %s revoked\n", idOrName)
+}
+
+func rotateDefaultToken(grace time.Duration) {
+	if grace < 0 {
+		log.Fatalf("--grace must not be negative")
+	}
+	path := config.ConfigFileUsed()
+	if path == "" {
+		path = config.DefaultConfigFile()
+	}
+
+	resp, err := makeAPIRequest("POST", "/tokens/rotate", api.RotateRequest{Grace: grace.String()})
+	if err != nil {
+		log.Fatalf("Failed to rotate default token: %v", err)
+	}
+	if !resp.Success {
+		log.Fatalf("Failed to rotate default token: %s", resp.Message)
+	}
+	data, _ := resp.Data.(map[string]interface{})
+	value, _ := data["token"].(string)
+
+	fmt.Println("✓ Default token rotated")
+	if until, ok := data["grace_until"].(string); ok {
+		fmt.Printf("  The old token is accepted until %s\n", until)
+	} else {
+		fmt.Println("  The old token no longer works")
+	}
+
+	// The server has already switched, so print the token before anything
+	// that could fail
+	if err := config.SetValue(path, "security.default_token", value); err != nil {
+		fmt.Printf("✗ Failed to save the new token to %s: %v\n", path, err)
+		fmt.Printf("\n  %s\n\n", value)
+		fmt.Println("Set security.default_token to this value yourself; it cannot be shown again")
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Saved to %s\n", path)
+	fmt.Printf("\n  %s\n\n", value)
+	fmt.Println("Update any other clients that use the default token.")
+	if env, ok := config.EnvOverrides()["security.default_token"]; ok {
+		fmt.Printf("⚠️  %s overrides the config file; update it too\n", env)
+	}
+}
+
+// viewConfig prints the merged configuration, noting where it came from
+func viewConfig() {
+	source := config.ConfigFileUsed()
+	if source == "" {
+		source = "none (defaults)"
+	}
+	fmt.Printf("# Config file: %s\n", source)
+
+	overrides := config.EnvOverrides()
+	if len(overrides) > 0 {
+		keys := make([]string, 0, len(overrides))
+		for key := range overrides {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Println("# Set from the environment:")
+		for _, key := range keys {
+			fmt.Printf("#   %s (%s)\n", key, overrides[key])
+		}
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config.EffectiveSettings()); err != nil {
+		log.Fatalf("Failed to encode config: %v", err)
+	}
+}
+
+// setConfig validates every key=value before writing any of them
+func setConfig(pairs []string) {
+	path := config.ConfigFileUsed()
+	if path == "" {
+		path = config.DefaultConfigFile()
+	}
+
+	type setting struct{ key, value string }
+	settings := make([]setting, 0, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			log.Fatalf("Invalid setting '%s': expected key=value", pair)
+		}
+		settings = append(settings, setting{key, value})
+	}
+
+	for _, s := range settings {
+		if err := config.ValidateSetting(s.key, s.value); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	overrides := config.EnvOverrides()
+	for _, s := range settings {
+		if err := config.SetValue(path, s.key, s.value); err != nil {
+			log.Fatalf("Failed to set %s: %v", s.key, err)
+		}
+		fmt.Printf("✓ Set %s = %s\n", s.key, s.value)
+		if env, ok := overrides[strings.ToLower(s.key)]; ok {
+			fmt.Printf("  Note: %s is set in the environment and takes precedence\n", env)
+		}
+	}
+	fmt.Printf("Saved to %s; restart the server to apply\n", path)
+}
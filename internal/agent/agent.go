@@ -7,15 +7,25 @@ import (
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	timetypes "github.com/docker/docker/api/types/time"
 	"github.com/docker/docker/client"
 	"github.com/go-redis/redis/v8"
+	"github.com/agentainer/agentainer-lab/internal/logging"
+	"github.com/agentainer/agentainer-lab/internal/secrets"
+	"github.com/agentainer/agentainer-lab/internal/storage"
 	"github.com/agentainer/agentainer-lab/pkg/agentsync"
+	"github.com/agentainer/agentainer-lab/pkg/docker"
 )
 
 type Status string
@@ -29,6 +39,9 @@ const (
 	
 	// Network configuration
 	AgentainerNetworkName = "agentainer-network"
+	
+	// DefaultNamespace is used for agents deployed without a namespace
+	DefaultNamespace = "default"
 )
 
 func (s Status) MarshalBinary() ([]byte, error) {
@@ -43,17 +56,84 @@ func (s *Status) UnmarshalBinary(data []byte) error {
 type Agent struct {
 	ID           string            `json:"id"`
 	Name         string            `json:"name"`
+	Namespace    string            `json:"namespace"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	// ReplicaOf is the ID of the agent this one was scaled from, if any
+	ReplicaOf    string            `json:"replica_of,omitempty"`
 	Image        string            `json:"image"`
+	// ImageDigest is the registry digest the image resolved to at deploy
+	// (repo@sha256:...); empty for images that were never pushed
+	ImageDigest  string            `json:"image_digest,omitempty"`
+	// PinDigest creates containers from ImageDigest rather than the tag
+	PinDigest    bool              `json:"pin_digest,omitempty"`
 	ContainerID  string            `json:"container_id"`
 	Status       Status            `json:"status"`
+	// DesiredStatus is the state the user last asked for (running or stopped);
+	// startup reconciliation brings the agent back to it
+	DesiredStatus Status           `json:"desired_status,omitempty"`
+	// ContainerHealth mirrors Docker's native HEALTHCHECK status
+	// (starting, healthy or unhealthy); empty if the image defines none
+	ContainerHealth string         `json:"container_health,omitempty"`
+	// StopSignal is sent to stop the container; empty uses the image's STOPSIGNAL
+	StopSignal   string            `json:"stop_signal,omitempty"`
+	// StopTimeout is how long the agent has to exit before it is killed
+	// (e.g. 30s); empty means 10s
+	StopTimeout  string            `json:"stop_timeout,omitempty"`
+	// LastExit is how the container last exited, kept after it is removed
+	LastExit     *ExitState        `json:"last_exit,omitempty"`
 	EnvVars      map[string]string `json:"env_vars"`
+	// Secrets maps environment variable names to stored secret names; values
+	// are only resolved into the container environment
+	Secrets      map[string]string `json:"secrets,omitempty"`
 	CPULimit     int64             `json:"cpu_limit"`
 	MemoryLimit  int64             `json:"memory_limit"`
+	// MemorySwap is memory plus swap in bytes (-1 = unlimited swap, 0 = Docker's default)
+	MemorySwap   int64             `json:"memory_swap,omitempty"`
+	// MemoryReservation is a soft memory limit in bytes, enforced only when
+	// the host is under memory pressure
+	MemoryReservation int64        `json:"memory_reservation,omitempty"`
+	// CPUShares is the agent's relative CPU weight under contention (1024 = default)
+	CPUShares    int64             `json:"cpu_shares,omitempty"`
+	PidsLimit    int64             `json:"pids_limit,omitempty"`
+	Ulimits      []Ulimit          `json:"ulimits,omitempty"`
+	// GPUs requests NVIDIA GPUs: all, count=N or device IDs such as 0,1
+	GPUs         string            `json:"gpus,omitempty"`
 	AutoRestart  bool              `json:"auto_restart"`
+	MaxRestarts  int               `json:"max_restarts,omitempty"`
+	RestartWindow string           `json:"restart_window,omitempty"`
+	// ReplayRateLimit caps replays of queued requests in requests/sec
+	// (0 uses the server-wide default)
+	ReplayRateLimit float64        `json:"replay_rate_limit,omitempty"`
+	// RateLimit caps proxied requests in requests/sec (0 = unlimited);
+	// RateLimitBurst is the bucket size, defaulting to the rate rounded up
+	RateLimit    float64           `json:"rate_limit,omitempty"`
+	RateLimitBurst int             `json:"rate_limit_burst,omitempty"`
+	// ProxyTimeout bounds the wait for the agent's response headers (empty = no limit);
+	// ProxyRetries is how often idempotent requests are retried on 502/503 or connection errors
+	ProxyTimeout string            `json:"proxy_timeout,omitempty"`
+	ProxyRetries int               `json:"proxy_retries,omitempty"`
 	Token        string            `json:"token"`
 	Ports        []PortMapping     `json:"ports"`
+	// ProxyPort is the container port the proxy forwards to (DefaultProxyPort if unset)
+	ProxyPort    int               `json:"proxy_port,omitempty"`
+	// Routes maps a leading path segment under /agent/{id}/ to another container port
+	Routes       map[string]int    `json:"routes,omitempty"`
 	Volumes      []VolumeMapping   `json:"volumes"`
+	// SharedVolumes allows mounting host paths already used by other running agents
+	SharedVolumes bool             `json:"shared_volumes,omitempty"`
+	// AllowArchMismatch deploys images built for another CPU architecture
+	// (run under emulation, if the host has it)
+	AllowArchMismatch bool         `json:"allow_arch_mismatch,omitempty"`
 	HealthCheck  *HealthCheckConfig `json:"health_check,omitempty"`
+	// Networks are user-defined Docker networks joined in addition to
+	// agentainer-network; NetworkAliases are extra DNS names the agent is
+	// reachable by on every network it is on
+	Networks     []string          `json:"networks,omitempty"`
+	NetworkAliases []string        `json:"network_aliases,omitempty"`
+	// InjectRedis and InjectAPI add REDIS_HOST/REDIS_PORT and
+	// AGENTAINER_API_URL, plus AGENTAINER_AGENT_ID, to the environment
+	InjectRedis  bool              `json:"inject_redis,omitempty"`
+	InjectAPI    bool              `json:"inject_api,omitempty"`
 	CreatedAt    time.Time         `json:"created_at"`
 	UpdatedAt    time.Time         `json:"updated_at"`
 }
@@ -70,30 +150,197 @@ type VolumeMapping struct {
 	ReadOnly      bool   `json:"read_only"`
 }
 
+// Health check types
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckExec = "exec"
+)
+
 type HealthCheckConfig struct {
+	// Type is http (default), tcp or exec
+	Type     string `json:"type,omitempty"`
 	Endpoint string `json:"endpoint"`
+	// Command is run inside the container for exec checks; exit code 0 is healthy
+	Command  []string `json:"command,omitempty"`
 	Interval string `json:"interval"`
 	Timeout  string `json:"timeout,omitempty"`
 	Retries  int    `json:"retries,omitempty"`
+	// StartPeriod is how long after the agent starts failures don't count
+	// towards Retries, giving slow-starting agents time to boot (e.g. 60s)
+	StartPeriod string `json:"start_period,omitempty"`
+	// MaxRestarts caps health-triggered restarts within the agent's restart
+	// window; zero falls back to the agent's MaxRestarts
+	MaxRestarts int `json:"max_restarts,omitempty"`
+	// UseImageHealthcheck judges health by the image's own HEALTHCHECK
+	// instead of probing Endpoint
+	UseImageHealthcheck bool `json:"use_image_healthcheck,omitempty"`
+	// Readiness gates proxied traffic; the settings above are the liveness
+	// check, which restarts the agent when it keeps failing
+	Readiness *ReadinessProbe `json:"readiness,omitempty"`
+}
+
+// ReadinessProbe decides whether an agent is sent proxied traffic. Failing it
+// holds traffic back but never restarts the agent.
+type ReadinessProbe struct {
+	// Type is http (default), tcp or exec
+	Type     string   `json:"type,omitempty"`
+	Endpoint string   `json:"endpoint,omitempty"`
+	Command  []string `json:"command,omitempty"`
+	Interval string   `json:"interval,omitempty"`
+	Timeout  string   `json:"timeout,omitempty"`
+}
+
+// DeployOptions carries optional deployment settings that most agents leave unset
+type DeployOptions struct {
+	Namespace     string `json:"namespace,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	MaxRestarts   int    `json:"max_restarts,omitempty"`
+	RestartWindow string `json:"restart_window,omitempty"`
+	ProxyPort     int            `json:"proxy_port,omitempty"`
+	Routes        map[string]int `json:"routes,omitempty"`
+	ReplayRateLimit float64      `json:"replay_rate_limit,omitempty"`
+	SharedVolumes bool           `json:"shared_volumes,omitempty"`
+	AllowArchMismatch bool       `json:"allow_arch_mismatch,omitempty"`
+	RateLimit     float64        `json:"rate_limit,omitempty"`
+	RateLimitBurst int           `json:"rate_limit_burst,omitempty"`
+	ProxyTimeout  string         `json:"proxy_timeout,omitempty"`
+	ProxyRetries  int            `json:"proxy_retries,omitempty"`
+	MemorySwap    int64          `json:"memory_swap,omitempty"`
+	MemoryReservation int64      `json:"memory_reservation,omitempty"`
+	CPUShares     int64          `json:"cpu_shares,omitempty"`
+	PidsLimit     int64          `json:"pids_limit,omitempty"`
+	Ulimits       []Ulimit       `json:"ulimits,omitempty"`
+	GPUs          string         `json:"gpus,omitempty"`
+	Networks      []string       `json:"networks,omitempty"`
+	NetworkAliases []string      `json:"network_aliases,omitempty"`
+	InjectRedis   bool           `json:"inject_redis,omitempty"`
+	InjectAPI     bool           `json:"inject_api,omitempty"`
+	// RegistryAuth overrides the configured credentials for pulling the image;
+	// it is never stored
+	RegistryAuth  *docker.RegistryAuth `json:"-"`
+	// PullPolicy is always, missing or never; empty follows the server's auto-pull setting
+	PullPolicy    string         `json:"pull_policy,omitempty"`
+	StopSignal    string         `json:"stop_signal,omitempty"`
+	StopTimeout   string         `json:"stop_timeout,omitempty"`
+	// PinDigest creates the container from the image's resolved digest
+	PinDigest     bool           `json:"pin_digest,omitempty"`
+	// ImageDigest deploys this exact digest of the image and pins it; restores
+	// use it to recreate the image that was backed up
+	ImageDigest   string         `json:"image_digest,omitempty"`
+	Secrets       map[string]string `json:"secrets,omitempty"`
+}
+
+// ListFilter narrows the set of agents returned by FindAgents. Name and
+// Image are glob patterns such as worker-*.
+type ListFilter struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Status    Status `json:"status,omitempty"`
+	Image     string `json:"image,omitempty"`
+	// Labels selects agents that have every one of these labels
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// ParseListFilter parses key=value filters as given on the command line
+func ParseListFilter(filters []string) (ListFilter, error) {
+	var filter ListFilter
+	for _, f := range filters {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || value == "" {
+			return filter, fmt.Errorf("invalid filter %q: expected key=value", f)
+		}
+		switch key {
+		case "namespace":
+			filter.Namespace = value
+		case "name":
+			filter.Name = value
+		case "status":
+			filter.Status = Status(value)
+		case "image":
+			filter.Image = value
+		case "label":
+			labelKey, labelValue, ok := strings.Cut(value, "=")
+			if !ok {
+				return filter, fmt.Errorf("invalid label filter %q: expected label=key=value", f)
+			}
+			if filter.Labels == nil {
+				filter.Labels = make(map[string]string)
+			}
+			filter.Labels[labelKey] = labelValue
+		default:
+			return filter, fmt.Errorf("unknown filter %q (use namespace, name, status, image or label)", key)
+		}
+	}
+	return filter, filter.Validate()
+}
+
+// Validate checks the filter's glob patterns and status
+func (f ListFilter) Validate() error {
+	for _, pattern := range []string{f.Name, f.Image} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	switch f.Status {
+	case "", StatusCreated, StatusRunning, StatusStopped, StatusPaused, StatusFailed:
+		return nil
+	}
+	return fmt.Errorf("invalid status %q", f.Status)
+}
+
+// Matches reports whether an agent satisfies every set field of the filter
+func (f ListFilter) Matches(a *Agent) bool {
+	if f.Namespace != "" && a.Namespace != f.Namespace {
+		return false
+	}
+	if f.Status != "" && a.Status != f.Status {
+		return false
+	}
+	if f.Name != "" {
+		if ok, _ := path.Match(f.Name, a.Name); !ok {
+			return false
+		}
+	}
+	if f.Image != "" {
+		if ok, _ := path.Match(f.Image, a.Image); !ok {
+			return false
+		}
+	}
+	return a.matchLabels(f.Labels)
 }
 
 type Manager struct {
-	dockerClient *client.Client
-	redisClient  *redis.Client
-	configPath   string
-	quickSync    *agentsync.QuickSync
+	dockerClient     *client.Client
+	redisClient      *redis.Client
+	store            storage.Store
+	configPath       string
+	quickSync        *agentsync.QuickSync
+	operationTimeout time.Duration
+	registries       []docker.RegistryAuth
+	autoPull         bool
+	secrets          *secrets.Store
+	apiPort          int
+	
+	hooksMu          sync.RWMutex
+	stopHooks        []func(agentID string)
 }
 
-func NewManager(dockerClient *client.Client, redisClient *redis.Client, configPath string) *Manager {
+// NewManager creates an agent manager. Agent records are kept in store;
+// Redis holds everything else, such as restart history and events.
+func NewManager(dockerClient *client.Client, redisClient *redis.Client, store storage.Store, configPath string, operationTimeout time.Duration) *Manager {
 	m := &Manager{
-		dockerClient: dockerClient,
-		redisClient:  redisClient,
-		configPath:   configPath,
-		quickSync:    agentsync.NewQuickSync(dockerClient, redisClient),
+		dockerClient:     dockerClient,
+		redisClient:      redisClient,
+		store:            store,
+		configPath:       configPath,
+		quickSync:        agentsync.NewQuickSync(dockerClient, store, operationTimeout),
+		operationTimeout: operationTimeout,
 	}
 	
 	// Ensure the internal network exists
-	ctx := context.Background()
+	ctx, cancel := m.dockerCtx(context.Background())
+	defer cancel()
 	if err := m.ensureNetworkExists(ctx); err != nil {
 		log.Printf("Warning: Failed to create network: %v", err)
 	}
@@ -101,14 +348,123 @@ func NewManager(dockerClient *client.Client, redisClient *redis.Client, configPa
 	return m
 }
 
-func (m *Manager) Deploy(ctx context.Context, name, image string, envVars map[string]string, cpuLimit, memoryLimit int64, autoRestart bool, token string, ports []PortMapping, volumes []VolumeMapping, healthCheck *HealthCheckConfig) (*Agent, error) {
-	// Validate that the Docker image exists
-	_, _, err := m.dockerClient.ImageInspectWithRaw(ctx, image)
+// OnStop registers a hook that runs after an agent's container is stopped or
+// removed, e.g. to drop connections that would outlive the container
+func (m *Manager) OnStop(hook func(agentID string)) {
+	m.hooksMu.Lock()
+	defer m.hooksMu.Unlock()
+	m.stopHooks = append(m.stopHooks, hook)
+}
+
+func (m *Manager) notifyStopped(agentID string) {
+	m.hooksMu.RLock()
+	defer m.hooksMu.RUnlock()
+	for _, hook := range m.stopHooks {
+		hook(agentID)
+	}
+}
+
+// dockerCtx bounds a single Docker API call with the configured operation timeout
+func (m *Manager) dockerCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	return docker.WithOperationTimeout(ctx, m.operationTimeout)
+}
+
+// stopCtx is like dockerCtx but also allows for the container's stop grace period,
+// since ContainerStop blocks until the container has exited or been killed
+func (m *Manager) stopCtx(ctx context.Context, graceSeconds int) (context.Context, context.CancelFunc) {
+	timeout := m.operationTimeout
+	if timeout <= 0 {
+		timeout = docker.DefaultOperationTimeout
+	}
+	return context.WithTimeout(ctx, timeout+time.Duration(graceSeconds)*time.Second)
+}
+
+func (m *Manager) Deploy(ctx context.Context, name, image string, envVars map[string]string, cpuLimit, memoryLimit int64, autoRestart bool, token string, ports []PortMapping, volumes []VolumeMapping, healthCheck *HealthCheckConfig, opts DeployOptions) (*Agent, error) {
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	if err := ValidateNamespace(namespace); err != nil {
+		return nil, err
+	}
+	if err := ValidateLabels(opts.Labels); err != nil {
+		return nil, err
+	}
+	if opts.MaxRestarts < 0 {
+		return nil, fmt.Errorf("max restarts must not be negative")
+	}
+	if _, err := ParseRestartWindow(opts.RestartWindow); err != nil {
+		return nil, err
+	}
+	if err := ValidateProxyPorts(opts.ProxyPort, opts.Routes); err != nil {
+		return nil, err
+	}
+	if opts.ReplayRateLimit < 0 {
+		return nil, fmt.Errorf("replay rate limit must not be negative")
+	}
+	if opts.RateLimit < 0 || opts.RateLimitBurst < 0 {
+		return nil, fmt.Errorf("rate limit and burst must not be negative")
+	}
+	if _, err := ParseProxyTimeout(opts.ProxyTimeout); err != nil {
+		return nil, err
+	}
+	if err := ValidateProxyRetries(opts.ProxyRetries); err != nil {
+		return nil, err
+	}
+	stopSignal, err := ParseStopSignal(opts.StopSignal)
 	if err != nil {
-		if client.IsErrNotFound(err) {
-			return nil, fmt.Errorf("docker image '%s' not found. Please build or pull the image first", image)
+		return nil, err
+	}
+	if _, err := ParseStopTimeout(opts.StopTimeout); err != nil {
+		return nil, err
+	}
+	if err := ValidateResourceLimits(memoryLimit, opts.MemorySwap, opts.PidsLimit, opts.Ulimits); err != nil {
+		return nil, err
+	}
+	if err := ValidateReservations(memoryLimit, opts.MemoryReservation, opts.CPUShares); err != nil {
+		return nil, err
+	}
+	if err := ValidateHealthCheck(healthCheck); err != nil {
+		return nil, err
+	}
+	if err := m.checkGPUs(ctx, opts.GPUs); err != nil {
+		return nil, err
+	}
+	if err := ValidateNetworkAliases(opts.NetworkAliases); err != nil {
+		return nil, err
+	}
+	if err := m.validateNetworks(ctx, opts.Networks); err != nil {
+		return nil, err
+	}
+	if err := ValidatePullPolicy(opts.PullPolicy); err != nil {
+		return nil, err
+	}
+	if err := m.validateSecretRefs(ctx, opts.Secrets, envVars); err != nil {
+		return nil, err
+	}
+	
+	// Validate that the Docker image exists, pulling it if the policy allows
+	imageRef := image
+	if opts.ImageDigest != "" {
+		if !strings.Contains(opts.ImageDigest, "@") {
+			return nil, fmt.Errorf("invalid image digest '%s' (expected repository@sha256:...)", opts.ImageDigest)
 		}
-		return nil, fmt.Errorf("failed to inspect docker image: %w", err)
+		imageRef = opts.ImageDigest
+	}
+	imageInfo, err := m.ensureImage(ctx, imageRef, opts.PullPolicy, opts.RegistryAuth)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkImageArchitecture(ctx, image, imageInfo.Architecture, opts.AllowArchMismatch); err != nil {
+		return nil, err
+	}
+	digest := opts.ImageDigest
+	if digest == "" {
+		digest = imageDigest(image, imageInfo)
+	}
+	pinDigest := opts.PinDigest || opts.ImageDigest != ""
+	if pinDigest && digest == "" {
+		return nil, fmt.Errorf("image '%s' has no registry digest to pin (it was built or tagged locally); push it to a registry or deploy without --pin-digest", image)
 	}
 	
 	id := generateID()
@@ -120,23 +476,62 @@ func (m *Manager) Deploy(ctx context.Context, name, image string, envVars map[st
 	agent := &Agent{
 		ID:          id,
 		Name:        name,
+		Namespace:   namespace,
+		Labels:      opts.Labels,
 		Image:       image,
+		ImageDigest: digest,
+		PinDigest:   pinDigest,
 		Status:      StatusCreated,
 		EnvVars:     envVars,
+		Secrets:     opts.Secrets,
 		CPULimit:    cpuLimit,
 		MemoryLimit: memoryLimit,
+		MemorySwap:  opts.MemorySwap,
+		MemoryReservation: opts.MemoryReservation,
+		CPUShares:   opts.CPUShares,
+		PidsLimit:   opts.PidsLimit,
+		Ulimits:     opts.Ulimits,
+		GPUs:        opts.GPUs,
 		AutoRestart: autoRestart,
+		MaxRestarts: opts.MaxRestarts,
+		RestartWindow: opts.RestartWindow,
+		ReplayRateLimit: opts.ReplayRateLimit,
+		RateLimit:   opts.RateLimit,
+		RateLimitBurst: opts.RateLimitBurst,
+		ProxyTimeout: opts.ProxyTimeout,
+		ProxyRetries: opts.ProxyRetries,
+		StopSignal:  stopSignal,
+		StopTimeout: opts.StopTimeout,
 		Token:       token,
 		Ports:       []PortMapping{}, // No longer exposing ports
+		ProxyPort:   opts.ProxyPort,
+		Routes:      opts.Routes,
 		Volumes:     volumes,
+		SharedVolumes: opts.SharedVolumes,
+		AllowArchMismatch: opts.AllowArchMismatch,
 		HealthCheck: healthCheck,
+		Networks:    opts.Networks,
+		NetworkAliases: opts.NetworkAliases,
+		InjectRedis: opts.InjectRedis,
+		InjectAPI:   opts.InjectAPI,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
+	
+	// Reject undefined or circular env references up front rather than at start
+	if _, err := ResolveEnv(agent); err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
+	}
+	
+	// Two agents writing to the same host path can corrupt each other's data
+	if err := m.checkVolumeConflicts(agent); err != nil {
+		return nil, err
+	}
 
 	if err := m.saveAgent(agent); err != nil {
 		return nil, fmt.Errorf("failed to save agent: %w", err)
 	}
+	m.recordEvent(ctx, agent.ID, EventDeployed, "", StatusCreated, "image "+image)
 
 	return agent, nil
 }
@@ -150,10 +545,21 @@ func (m *Manager) Start(ctx context.Context, agentID string) error {
 	if agent.Status == StatusRunning {
 		return fmt.Errorf("agent is already running")
 	}
+	
+	if err := m.checkVolumeConflicts(agent); err != nil {
+		return err
+	}
+	
+	// Starting a failed agent by hand gives it a fresh restart budget
+	if agent.Status == StatusFailed {
+		m.resetRestarts(ctx, agent)
+	}
 
 	if agent.ContainerID != "" {
-		if err := m.dockerClient.ContainerStart(ctx, agent.ContainerID, types.ContainerStartOptions{}); err != nil {
-			return fmt.Errorf("failed to start existing container: %w", err)
+		startCtx, cancel := m.dockerCtx(ctx)
+		defer cancel()
+		if err := m.dockerClient.ContainerStart(startCtx, agent.ContainerID, types.ContainerStartOptions{}); err != nil {
+			return fmt.Errorf("failed to start existing container: %w", docker.CheckTimeout(err, m.operationTimeout))
 		}
 	} else {
 		containerID, err := m.createContainer(ctx, agent)
@@ -163,12 +569,15 @@ func (m *Manager) Start(ctx context.Context, agentID string) error {
 		agent.ContainerID = containerID
 	}
 
+	previous := agent.Status
 	agent.Status = StatusRunning
+	agent.DesiredStatus = StatusRunning
 	agent.UpdatedAt = time.Now()
 	
 	if err := m.saveAgent(agent); err != nil {
 		return fmt.Errorf("failed to save agent: %w", err)
 	}
+	m.recordEvent(ctx, agentID, EventStarted, previous, StatusRunning, "")
 	
 	// Trigger immediate sync to ensure consistency
 	go func() {
@@ -191,18 +600,25 @@ func (m *Manager) Stop(ctx context.Context, agentID string) error {
 	}
 
 	if agent.ContainerID != "" {
-		timeout := 10
-		if err := m.dockerClient.ContainerStop(ctx, agent.ContainerID, container.StopOptions{Timeout: &timeout}); err != nil {
-			return fmt.Errorf("failed to stop container: %w", err)
+		timeout := agent.stopTimeoutSeconds()
+		stopCtx, cancel := m.stopCtx(ctx, timeout)
+		defer cancel()
+		if err := m.dockerClient.ContainerStop(stopCtx, agent.ContainerID, container.StopOptions{Timeout: &timeout}); err != nil {
+			return fmt.Errorf("failed to stop container: %w", docker.CheckTimeout(err, m.operationTimeout))
 		}
 	}
 
+	previous := agent.Status
 	agent.Status = StatusStopped
+	agent.DesiredStatus = StatusStopped
 	agent.UpdatedAt = time.Now()
 	
 	if err := m.saveAgent(agent); err != nil {
 		return fmt.Errorf("failed to save agent: %w", err)
 	}
+	m.recordEvent(ctx, agentID, EventStopped, previous, StatusStopped, "")
+	
+	m.notifyStopped(agentID)
 	
 	// Trigger immediate sync to ensure consistency
 	go func() {
@@ -231,8 +647,10 @@ func (m *Manager) Pause(ctx context.Context, agentID string) error {
 		return fmt.Errorf("agent is not running")
 	}
 
-	if err := m.dockerClient.ContainerPause(ctx, agent.ContainerID); err != nil {
-		return fmt.Errorf("failed to pause container: %w", err)
+	pauseCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	if err := m.dockerClient.ContainerPause(pauseCtx, agent.ContainerID); err != nil {
+		return fmt.Errorf("failed to pause container: %w", docker.CheckTimeout(err, m.operationTimeout))
 	}
 
 	agent.Status = StatusPaused
@@ -241,6 +659,7 @@ func (m *Manager) Pause(ctx context.Context, agentID string) error {
 	if err := m.saveAgent(agent); err != nil {
 		return fmt.Errorf("failed to save agent: %w", err)
 	}
+	m.recordEvent(ctx, agentID, EventPaused, StatusRunning, StatusPaused, "")
 	
 	// Trigger immediate sync to ensure consistency
 	go func() {
@@ -264,15 +683,22 @@ func (m *Manager) Resume(ctx context.Context, agentID string) error {
 	
 	case StatusPaused:
 		// Unpause the container
-		if err := m.dockerClient.ContainerUnpause(ctx, agent.ContainerID); err != nil {
-			return fmt.Errorf("failed to resume paused container: %w", err)
+		unpauseCtx, cancel := m.dockerCtx(ctx)
+		defer cancel()
+		if err := m.dockerClient.ContainerUnpause(unpauseCtx, agent.ContainerID); err != nil {
+			return fmt.Errorf("failed to resume paused container: %w", docker.CheckTimeout(err, m.operationTimeout))
 		}
 	
 	case StatusStopped, StatusFailed, StatusCreated:
+		if agent.Status == StatusFailed {
+			m.resetRestarts(ctx, agent)
+		}
 		// Rehydrate from saved state - restart the container
 		if agent.ContainerID != "" {
 			// Try to start existing container
-			if err := m.dockerClient.ContainerStart(ctx, agent.ContainerID, types.ContainerStartOptions{}); err != nil {
+			startCtx, cancel := m.dockerCtx(ctx)
+			defer cancel()
+			if err := m.dockerClient.ContainerStart(startCtx, agent.ContainerID, types.ContainerStartOptions{}); err != nil {
 				// If start fails, create a new container with same configuration
 				containerID, createErr := m.createContainer(ctx, agent)
 				if createErr != nil {
@@ -293,12 +719,15 @@ func (m *Manager) Resume(ctx context.Context, agentID string) error {
 		return fmt.Errorf("cannot resume agent in status: %s", agent.Status)
 	}
 
+	previous := agent.Status
 	agent.Status = StatusRunning
+	agent.DesiredStatus = StatusRunning
 	agent.UpdatedAt = time.Now()
 	
 	if err := m.saveAgent(agent); err != nil {
 		return fmt.Errorf("failed to save agent: %w", err)
 	}
+	m.recordEvent(ctx, agentID, EventResumed, previous, StatusRunning, "")
 	
 	// Trigger immediate sync to ensure consistency
 	go func() {
@@ -315,12 +744,21 @@ func (m *Manager) Remove(ctx context.Context, agentID string) error {
 	if err != nil {
 		return err
 	}
+	
+	// Replicas go with their parent; a removed replica leaves its parent's set
+	if agent.ReplicaOf == "" {
+		m.removeReplicas(ctx, agentID)
+	} else if err := m.redisClient.SRem(ctx, replicasKey(agent.ReplicaOf), agentID).Err(); err != nil {
+		log.Printf("Warning: failed to untrack replica %s: %v", agentID, err)
+	}
 
 	// Stop the container if it's running
 	if agent.Status == StatusRunning || agent.Status == StatusPaused {
 		if agent.ContainerID != "" {
-			timeout := 10
-			if err := m.dockerClient.ContainerStop(ctx, agent.ContainerID, container.StopOptions{Timeout: &timeout}); err != nil {
+			timeout := agent.stopTimeoutSeconds()
+			stopCtx, cancel := m.stopCtx(ctx, timeout)
+			defer cancel()
+			if err := m.dockerClient.ContainerStop(stopCtx, agent.ContainerID, container.StopOptions{Timeout: &timeout}); err != nil {
 				// Log but don't fail if stop fails - we still want to clean up
 				log.Printf("Warning: failed to stop container %s: %v", agent.ContainerID, err)
 			}
@@ -329,36 +767,48 @@ func (m *Manager) Remove(ctx context.Context, agentID string) error {
 
 	// Remove the container from Docker
 	if agent.ContainerID != "" {
-		if err := m.dockerClient.ContainerRemove(ctx, agent.ContainerID, types.ContainerRemoveOptions{Force: true}); err != nil {
+		removeCtx, cancel := m.dockerCtx(ctx)
+		defer cancel()
+		if err := m.dockerClient.ContainerRemove(removeCtx, agent.ContainerID, types.ContainerRemoveOptions{Force: true}); err != nil {
 			// Log but don't fail if remove fails - container might already be gone
 			log.Printf("Warning: failed to remove container %s: %v", agent.ContainerID, err)
 		}
 	}
 
+	m.notifyStopped(agentID)
+
 	// Remove agent from storage
 	if err := m.removeAgentFromStorage(agentID); err != nil {
 		return fmt.Errorf("failed to remove agent from storage: %w", err)
 	}
-
-	// Remove agent from Redis cache
-	if err := m.redisClient.Del(ctx, fmt.Sprintf("agent:%s", agentID)).Err(); err != nil {
-		// Log but don't fail if Redis deletion fails
-		log.Printf("Warning: failed to remove agent from cache: %v", err)
-	}
 	
-	// Clean up any request queues for this agent
+	// Keep the history for a while so it can explain what happened
+	m.recordEvent(ctx, agentID, EventRemoved, agent.Status, "", "")
+	if err := m.redisClient.Expire(ctx, eventsKey(agentID), removedEventsTTL).Err(); err != nil {
+		log.Printf("Warning: failed to expire events of agent %s: %v", agentID, err)
+	}
+	m.expireLogs(ctx, agentID)
+
+	// Clean up any request queues, request stats and the rate limit bucket
+	// for this agent
 	requestKeys := []string{
 		fmt.Sprintf("agent:%s:requests:pending", agentID),
 		fmt.Sprintf("agent:%s:requests:completed", agentID),
 		fmt.Sprintf("agent:%s:requests:failed", agentID),
+		fmt.Sprintf("agent:%s:requests:deadletter", agentID),
+		fmt.Sprintf("agent:%s:requests:seq", agentID),
+		fmt.Sprintf("agent:%s:stats", agentID),
+		fmt.Sprintf("agent:%s:stats:latency", agentID),
+		fmt.Sprintf("agent:%s:ratelimit", agentID),
 	}
 	for _, key := range requestKeys {
 		if err := m.redisClient.Del(ctx, key).Err(); err != nil {
-			log.Printf("Warning: failed to remove request queue %s: %v", key, err)
+			log.Printf("Warning: failed to remove %s: %v", key, err)
 		}
 	}
 	
-	// Also clean up any individual request data
+	// Also clean up any individual request data; other storage backends
+	// let the records expire
 	iter := m.redisClient.Scan(ctx, 0, fmt.Sprintf("request:%s:*", agentID), 0).Iterator()
 	for iter.Next(ctx) {
 		if err := m.redisClient.Del(ctx, iter.Val()).Err(); err != nil {
@@ -372,26 +822,50 @@ func (m *Manager) Remove(ctx context.Context, agentID string) error {
 func (m *Manager) GetAgent(agentID string) (*Agent, error) {
 	ctx := context.Background()
 	
-	// Get agent from Redis
-	key := fmt.Sprintf("agent:%s", agentID)
-	data, err := m.redisClient.Get(ctx, key).Result()
-	if err == redis.Nil {
+	data, err := m.store.Get(ctx, storage.CollectionAgents, agentID)
+	if err == storage.ErrNotFound {
 		return nil, fmt.Errorf("agent not found")
 	} else if err != nil {
 		return nil, fmt.Errorf("failed to get agent: %w", err)
 	}
 	
 	var agent Agent
-	if err := json.Unmarshal([]byte(data), &agent); err != nil {
+	if err := json.Unmarshal(data, &agent); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal agent: %w", err)
 	}
+	agent.applyDefaults()
 	
 	return &agent, nil
 }
 
+// GetAgentByName resolves an agent by its name within a namespace
+func (m *Manager) GetAgentByName(namespace, name string) (*Agent, error) {
+	agents, err := m.loadAgents()
+	if err != nil {
+		return nil, err
+	}
+	
+	var found *Agent
+	for i := range agents {
+		if agents[i].Namespace != namespace || agents[i].Name != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("agent name '%s' is ambiguous in namespace '%s'", name, namespace)
+		}
+		found = &agents[i]
+	}
+	
+	if found == nil {
+		return nil, fmt.Errorf("agent not found")
+	}
+	return found, nil
+}
+
 func (m *Manager) ListAgents(token string) ([]Agent, error) {
 	// Quick sync all agents before listing to ensure fresh data
-	ctx := context.Background()
+	ctx, cancel := m.dockerCtx(context.Background())
+	defer cancel()
 	if err := m.quickSync.SyncAll(ctx); err != nil {
 		// Log but don't fail - still return what we have
 		log.Printf("Warning: Failed to sync before list: %v", err)
@@ -408,29 +882,103 @@ func (m *Manager) ListAgents(token string) ([]Agent, error) {
 	return allAgents, nil
 }
 
-func (m *Manager) GetLogs(ctx context.Context, agentID string, follow bool) (io.ReadCloser, error) {
+// FindAgents lists agents matching the given filter
+func (m *Manager) FindAgents(filter ListFilter) ([]Agent, error) {
+	allAgents, err := m.ListAgents("")
+	if err != nil {
+		return nil, err
+	}
+	
+	agents := make([]Agent, 0, len(allAgents))
+	for i := range allAgents {
+		if filter.Matches(&allAgents[i]) {
+			agents = append(agents, allAgents[i])
+		}
+	}
+	return agents, nil
+}
+
+// LogOptions selects which container logs GetLogs returns
+type LogOptions struct {
+	Follow bool
+	Tail   string // number of lines from the end, or "all"
+	Since  string // RFC3339 timestamp, Unix timestamp or relative duration such as "10m"
+}
+
+// ValidateLogOptions checks the tail and since filters
+func ValidateLogOptions(opts LogOptions) error {
+	if opts.Tail != "" && opts.Tail != "all" {
+		if n, err := strconv.Atoi(opts.Tail); err != nil || n < 0 {
+			return fmt.Errorf("tail must be a non-negative number of lines or \"all\"")
+		}
+	}
+	if opts.Since != "" {
+		if _, err := timetypes.GetTimestamp(opts.Since, time.Now()); err != nil {
+			return fmt.Errorf("invalid since %q: use an RFC3339 timestamp or a duration such as 10m", opts.Since)
+		}
+	}
+	return nil
+}
+
+// GetLogs returns the agent's multiplexed container log stream
+func (m *Manager) GetLogs(ctx context.Context, agentID string, opts LogOptions) (io.ReadCloser, error) {
+	if err := ValidateLogOptions(opts); err != nil {
+		return nil, err
+	}
+
+	// Persisted logs outlive the container and, for a while, the agent
 	agent, err := m.GetAgent(agentID)
 	if err != nil {
+		if logs, ok := m.persistedLogs(ctx, agentID, opts); ok {
+			return logs, nil
+		}
 		return nil, err
 	}
 
 	if agent.ContainerID == "" {
+		if logs, ok := m.persistedLogs(ctx, agentID, opts); ok {
+			return logs, nil
+		}
 		return nil, fmt.Errorf("container not found")
 	}
 
 	options := types.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
-		Follow:     follow,
+		Follow:     opts.Follow,
 		Timestamps: true,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
 	}
 
-	return m.dockerClient.ContainerLogs(ctx, agent.ContainerID, options)
+	logs, err := m.dockerClient.ContainerLogs(ctx, agent.ContainerID, options)
+	if client.IsErrNotFound(err) {
+		if persisted, ok := m.persistedLogs(ctx, agentID, opts); ok {
+			return persisted, nil
+		}
+	}
+	return logs, err
 }
 
 func (m *Manager) createContainer(ctx context.Context, agent *Agent) (string, error) {
-	env := make([]string, 0, len(agent.EnvVars))
-	for key, value := range agent.EnvVars {
+	// Interpolate ${VAR} references at start so derived values track their inputs
+	resolvedEnv, err := ResolveEnv(agent)
+	if err != nil {
+		return "", err
+	}
+	secretEnv, err := m.resolveSecrets(ctx, agent)
+	if err != nil {
+		return "", err
+	}
+	injected := m.injectedEnv(agent)
+	env := make([]string, 0, len(resolvedEnv)+len(secretEnv)+len(injected))
+	for key, value := range resolvedEnv {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	for key, value := range injected {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	for key, value := range secretEnv {
 		env = append(env, fmt.Sprintf("%s=%s", key, value))
 	}
 
@@ -469,39 +1017,50 @@ func (m *Manager) createContainer(ctx context.Context, agent *Agent) (string, er
 	}
 
 	config := &container.Config{
-		Image:        agent.Image,
+		Image:        agent.ContainerImage(),
 		Env:          env,
-		Labels: map[string]string{
-			"agentainer.id":   agent.ID,
-			"agentainer.name": agent.Name,
-		},
+		Labels:       agent.containerLabels(),
 		Hostname: agent.ID, // Use agent ID as hostname for easy identification
+		StopSignal:   agent.StopSignal,
 	}
+	// Also applies when Docker itself stops the container, e.g. on daemon shutdown
+	stopTimeout := agent.stopTimeoutSeconds()
+	config.StopTimeout = &stopTimeout
 
 	hostConfig := &container.HostConfig{
-		RestartPolicy: container.RestartPolicy{
-			Name: "no",
-		},
-		Resources: container.Resources{
-			Memory:   agent.MemoryLimit,
-			NanoCPUs: agent.CPULimit,
-		},
+		RestartPolicy: agent.restartPolicy(),
+		Resources:    agent.containerResources(),
 		Mounts:       mounts,
 		NetworkMode: container.NetworkMode(AgentainerNetworkName),
 	}
-
-	if agent.AutoRestart {
-		hostConfig.RestartPolicy.Name = "always"
+	if len(injected) > 0 {
+		// Docker Desktop resolves this itself; on Linux it needs mapping
+		hostConfig.ExtraHosts = []string{dockerHostName + ":host-gateway"}
 	}
-	
 
-	resp, err := m.dockerClient.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+
+	createCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			AgentainerNetworkName: agent.endpointSettings(),
+		},
+	}
+	resp, err := m.dockerClient.ContainerCreate(createCtx, config, hostConfig, networkingConfig, nil, "")
 	if err != nil {
+		return "", docker.CheckTimeout(err, m.operationTimeout)
+	}
+	
+	// Docker only attaches one network at create time
+	if err := m.connectNetworks(ctx, agent, resp.ID); err != nil {
+		m.dockerClient.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
 		return "", err
 	}
 
-	if err := m.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return "", err
+	startCtx, cancelStart := m.dockerCtx(ctx)
+	defer cancelStart()
+	if err := m.dockerClient.ContainerStart(startCtx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", docker.CheckTimeout(err, m.operationTimeout)
 	}
 
 	return resp.ID, nil
@@ -510,47 +1069,30 @@ func (m *Manager) createContainer(ctx context.Context, agent *Agent) (string, er
 func (m *Manager) saveAgent(agent *Agent) error {
 	ctx := context.Background()
 	
-	// Save agent to Redis as primary storage
 	data, err := json.Marshal(agent)
 	if err != nil {
 		return fmt.Errorf("failed to marshal agent: %w", err)
 	}
 	
-	key := fmt.Sprintf("agent:%s", agent.ID)
-	if err := m.redisClient.Set(ctx, key, data, 0).Err(); err != nil {
-		return fmt.Errorf("failed to save agent to Redis: %w", err)
-	}
-	
-	// Also save to agents list for efficient listing
-	if err := m.redisClient.SAdd(ctx, "agents:list", agent.ID).Err(); err != nil {
-		return fmt.Errorf("failed to add agent to list: %w", err)
+	if err := m.store.Put(ctx, storage.CollectionAgents, agent.ID, data, 0); err != nil {
+		return fmt.Errorf("failed to save agent: %w", err)
 	}
 	
 	return nil
 }
 
 func (m *Manager) removeAgentFromStorage(agentID string) error {
-	// Remove agent from Redis storage
 	ctx := context.Background()
-	key := fmt.Sprintf("agent:%s", agentID)
 	
 	// Check if agent exists first
-	exists, err := m.redisClient.Exists(ctx, key).Result()
-	if err != nil {
-		return fmt.Errorf("failed to check agent existence: %w", err)
-	}
-	if exists == 0 {
+	if _, err := m.store.Get(ctx, storage.CollectionAgents, agentID); err == storage.ErrNotFound {
 		return fmt.Errorf("agent not found in storage")
+	} else if err != nil {
+		return fmt.Errorf("failed to check agent existence: %w", err)
 	}
 	
-	// Delete the agent
-	if err := m.redisClient.Del(ctx, key).Err(); err != nil {
-		return fmt.Errorf("failed to delete agent from Redis: %w", err)
-	}
-	
-	// Remove from agents list
-	if err := m.redisClient.SRem(ctx, "agents:list", agentID).Err(); err != nil {
-		return fmt.Errorf("failed to remove agent from list: %w", err)
+	if err := m.store.Delete(ctx, storage.CollectionAgents, agentID); err != nil {
+		return fmt.Errorf("failed to delete agent: %w", err)
 	}
 	
 	return nil
@@ -559,31 +1101,21 @@ func (m *Manager) removeAgentFromStorage(agentID string) error {
 func (m *Manager) loadAgents() ([]Agent, error) {
 	ctx := context.Background()
 	
-	// Get all agent IDs from Redis set
-	agentIDs, err := m.redisClient.SMembers(ctx, "agents:list").Result()
+	records, err := m.store.List(ctx, storage.CollectionAgents)
 	if err != nil {
-		log.Printf("ERROR: Failed to get agent list from Redis: %v", err)
+		logging.Errorf("agent", "Failed to get agent list: %v", err)
 		return nil, fmt.Errorf("failed to get agent list: %w", err)
 	}
 	
-	log.Printf("DEBUG: Found %d agent IDs in Redis: %v", len(agentIDs), agentIDs)
+	logging.Debugf("agent", "Found %d agents in storage", len(records))
 	
-	agents := make([]Agent, 0, len(agentIDs))
-	for _, id := range agentIDs {
-		key := fmt.Sprintf("agent:%s", id)
-		data, err := m.redisClient.Get(ctx, key).Result()
-		if err == redis.Nil {
-			// Agent in list but not found, clean up
-			m.redisClient.SRem(ctx, "agents:list", id)
-			continue
-		} else if err != nil {
-			return nil, fmt.Errorf("failed to get agent %s: %w", id, err)
-		}
-		
+	agents := make([]Agent, 0, len(records))
+	for id, data := range records {
 		var agent Agent
-		if err := json.Unmarshal([]byte(data), &agent); err != nil {
+		if err := json.Unmarshal(data, &agent); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal agent %s: %w", id, err)
 		}
+		agent.applyDefaults()
 		
 		agents = append(agents, agent)
 	}
@@ -591,6 +1123,67 @@ func (m *Manager) loadAgents() ([]Agent, error) {
 	return agents, nil
 }
 
+// applyDefaults fills fields that agents saved by older versions may lack
+func (a *Agent) applyDefaults() {
+	if a.Namespace == "" {
+		a.Namespace = DefaultNamespace
+	}
+}
+
+// ValidateHealthCheck checks that a health check's type and settings agree
+func ValidateHealthCheck(hc *HealthCheckConfig) error {
+	if hc == nil {
+		return nil
+	}
+	switch hc.Type {
+	case "", HealthCheckHTTP, HealthCheckTCP:
+	case HealthCheckExec:
+		if len(hc.Command) == 0 {
+			return fmt.Errorf("exec health check requires a command")
+		}
+	default:
+		return fmt.Errorf("invalid health check type '%s' (expected http, tcp or exec)", hc.Type)
+	}
+	if hc.StartPeriod != "" {
+		if d, err := time.ParseDuration(hc.StartPeriod); err != nil || d < 0 {
+			return fmt.Errorf("invalid health check start period '%s'", hc.StartPeriod)
+		}
+	}
+	if probe := hc.Readiness; probe != nil {
+		switch probe.Type {
+		case "", HealthCheckHTTP, HealthCheckTCP:
+		case HealthCheckExec:
+			if len(probe.Command) == 0 {
+				return fmt.Errorf("exec readiness probe requires a command")
+			}
+		default:
+			return fmt.Errorf("invalid readiness probe type '%s' (expected http, tcp or exec)", probe.Type)
+		}
+		for _, value := range []string{probe.Interval, probe.Timeout} {
+			if value == "" {
+				continue
+			}
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				return fmt.Errorf("invalid readiness probe duration '%s'", value)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateNamespace checks that a namespace is usable in proxy paths
+func ValidateNamespace(namespace string) error {
+	if len(namespace) > 63 {
+		return fmt.Errorf("namespace too long (max 63 characters)")
+	}
+	for _, c := range namespace {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+			return fmt.Errorf("invalid namespace '%s': use lowercase letters, digits and '-'", namespace)
+		}
+	}
+	return nil
+}
+
 func generateID() string {
 	return fmt.Sprintf("agent-%d", time.Now().UnixNano())
 }
@@ -599,7 +1192,7 @@ func (m *Manager) ensureNetworkExists(ctx context.Context) error {
 	// Check if network already exists
 	networks, err := m.dockerClient.NetworkList(ctx, types.NetworkListOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to list networks: %w", err)
+		return fmt.Errorf("failed to list networks: %w", docker.CheckTimeout(err, m.operationTimeout))
 	}
 	
 	for _, net := range networks {
@@ -620,7 +1213,7 @@ func (m *Manager) ensureNetworkExists(ctx context.Context) error {
 	})
 	
 	if err != nil {
-		return fmt.Errorf("failed to create network: %w", err)
+		return fmt.Errorf("failed to create network: %w", docker.CheckTimeout(err, m.operationTimeout))
 	}
 	
 	log.Printf("Created Agentainer network: %s", AgentainerNetworkName)
@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/agentainer/agentainer-lab/pkg/docker"
+)
+
+// checkImageArchitecture rejects an image built for a different CPU
+// architecture than the Docker host. Such images either fail with an exec
+// format error at start or run slowly under emulation. With allow set the
+// mismatch is only logged.
+func (m *Manager) checkImageArchitecture(ctx context.Context, image, imageArch string, allow bool) error {
+	if imageArch == "" {
+		return nil
+	}
+
+	versionCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	version, err := m.dockerClient.ServerVersion(versionCtx)
+	if err != nil {
+		return fmt.Errorf("failed to get docker host architecture: %w", docker.CheckTimeout(err, m.operationTimeout))
+	}
+	if version.Arch == "" || version.Arch == imageArch {
+		return nil
+	}
+
+	if allow {
+		log.Printf("Warning: image %s is built for %s but the Docker host is %s; it will only run under emulation",
+			image, imageArch, version.Arch)
+		return nil
+	}
+	return fmt.Errorf("image %s is built for %s but the Docker host is %s; rebuild it for %s (e.g. docker buildx build --platform linux/%s) or deploy with --allow-arch-mismatch to run it under emulation",
+		image, imageArch, version.Arch, version.Arch, version.Arch)
+}
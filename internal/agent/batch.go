@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+)
+
+// Batch actions
+const (
+	BatchStart   = "start"
+	BatchStop    = "stop"
+	BatchRestart = "restart"
+	BatchRemove  = "remove"
+)
+
+// BatchResult reports the outcome of a Batch call per agent
+type BatchResult struct {
+	Action    string            `json:"action"`
+	Matched   int               `json:"matched"`
+	Succeeded []string          `json:"succeeded"`
+	// Skipped agents were already in the requested state
+	Skipped   []string          `json:"skipped"`
+	Failed    map[string]string `json:"failed"`
+}
+
+// ValidateBatchAction checks that an action is supported by Batch
+func ValidateBatchAction(action string) error {
+	switch action {
+	case BatchStart, BatchStop, BatchRestart, BatchRemove:
+		return nil
+	}
+	return fmt.Errorf("invalid action %q (use start, stop, restart or remove)", action)
+}
+
+// Batch applies an action to every agent matching the filter. A failure on
+// one agent is recorded and the rest are still processed. Replicas whose
+// parent also matches are left to the parent when removing.
+func (m *Manager) Batch(ctx context.Context, action string, filter ListFilter) (*BatchResult, error) {
+	if err := ValidateBatchAction(action); err != nil {
+		return nil, err
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	agents, err := m.FindAgents(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+
+	matched := make(map[string]bool, len(agents))
+	for _, agent := range agents {
+		matched[agent.ID] = true
+	}
+
+	result := &BatchResult{
+		Action:    action,
+		Matched:   len(agents),
+		Succeeded: []string{},
+		Skipped:   []string{},
+		Failed:    make(map[string]string),
+	}
+	for _, agent := range agents {
+		if action == BatchRemove && agent.ReplicaOf != "" && matched[agent.ReplicaOf] {
+			continue
+		}
+		if (action == BatchStart && agent.Status == StatusRunning) ||
+			(action == BatchStop && agent.Status == StatusStopped) {
+			result.Skipped = append(result.Skipped, agent.ID)
+			continue
+		}
+
+		var err error
+		switch action {
+		case BatchStart:
+			err = m.Start(ctx, agent.ID)
+		case BatchStop:
+			err = m.Stop(ctx, agent.ID)
+		case BatchRestart:
+			err = m.Restart(ctx, agent.ID)
+		case BatchRemove:
+			err = m.Remove(ctx, agent.ID)
+		}
+		if err != nil {
+			result.Failed[agent.ID] = err.Error()
+			continue
+		}
+		result.Succeeded = append(result.Succeeded, agent.ID)
+	}
+	return result, nil
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/archive"
+)
+
+// containerFor returns the container ID of an agent for copying files,
+// which works whether or not the container is running
+func (m *Manager) containerFor(agentID string) (string, error) {
+	agent, err := m.GetAgent(agentID)
+	if err != nil {
+		return "", err
+	}
+	if agent.ContainerID == "" {
+		return "", fmt.Errorf("agent has no container")
+	}
+	return agent.ContainerID, nil
+}
+
+// CopyTo copies a local file or directory into the agent's container. As
+// with cp, copying onto an existing directory places the source inside it.
+// File modes are preserved.
+func (m *Manager) CopyTo(ctx context.Context, agentID, srcLocal, dstContainer string) error {
+	containerID, err := m.containerFor(agentID)
+	if err != nil {
+		return err
+	}
+
+	srcInfo, err := archive.CopyInfoSourcePath(srcLocal, false)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", srcLocal, err)
+	}
+	srcArchive, err := archive.TarResource(srcInfo)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", srcLocal, err)
+	}
+	defer srcArchive.Close()
+
+	dstInfo := archive.CopyInfo{Path: dstContainer}
+	if stat, err := m.dockerClient.ContainerStatPath(ctx, containerID, dstContainer); err == nil {
+		dstInfo.Exists = true
+		dstInfo.IsDir = stat.Mode.IsDir()
+	}
+
+	dstDir, content, err := archive.PrepareArchiveCopy(srcArchive, srcInfo, dstInfo)
+	if err != nil {
+		return fmt.Errorf("failed to prepare copy: %w", err)
+	}
+	defer content.Close()
+
+	options := types.CopyToContainerOptions{AllowOverwriteDirWithFile: false}
+	if err := m.dockerClient.CopyToContainer(ctx, containerID, dstDir, content, options); err != nil {
+		return fmt.Errorf("failed to copy to container: %w", err)
+	}
+	return nil
+}
+
+// CopyFrom copies a file or directory out of the agent's container to a
+// local path. File modes are preserved.
+func (m *Manager) CopyFrom(ctx context.Context, agentID, srcContainer, dstLocal string) error {
+	containerID, err := m.containerFor(agentID)
+	if err != nil {
+		return err
+	}
+
+	content, stat, err := m.dockerClient.CopyFromContainer(ctx, containerID, srcContainer)
+	if err != nil {
+		return fmt.Errorf("failed to copy from container: %w", err)
+	}
+	defer content.Close()
+
+	srcInfo := archive.CopyInfo{
+		Path:   srcContainer,
+		Exists: true,
+		IsDir:  stat.Mode.IsDir(),
+	}
+	if err := archive.CopyTo(content, srcInfo, dstLocal); err != nil {
+		return fmt.Errorf("failed to write %s: %w", dstLocal, err)
+	}
+	return nil
+}
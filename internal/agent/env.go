@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// builtinEnv returns the Agentainer-provided variables that an agent's env
+// values may reference. They are only used for interpolation; an agent's own
+// env map always takes precedence over them.
+func builtinEnv(agent *Agent) map[string]string {
+	return map[string]string{
+		"AGENTAINER_AGENT_ID":   agent.ID,
+		"AGENTAINER_AGENT_NAME": agent.Name,
+		"AGENTAINER_NAMESPACE":  agent.Namespace,
+	}
+}
+
+// ResolveEnv expands ${VAR} and $VAR references in the agent's env values.
+// References resolve against the agent's own env map first, then the
+// Agentainer-provided variables. Use $$ for a literal dollar sign.
+func ResolveEnv(agent *Agent) (map[string]string, error) {
+	r := &envResolver{
+		raw:       agent.EnvVars,
+		builtins:  builtinEnv(agent),
+		resolved:  make(map[string]string, len(agent.EnvVars)),
+		resolving: make(map[string]bool),
+	}
+
+	// Resolve in a stable order so errors are deterministic
+	keys := make([]string, 0, len(agent.EnvVars))
+	for key := range agent.EnvVars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, err := r.resolve(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.resolved, nil
+}
+
+type envResolver struct {
+	raw       map[string]string
+	builtins  map[string]string
+	resolved  map[string]string
+	resolving map[string]bool
+}
+
+func (r *envResolver) resolve(key string) (string, error) {
+	if value, ok := r.resolved[key]; ok {
+		return value, nil
+	}
+	if r.resolving[key] {
+		return "", fmt.Errorf("env var %s: circular reference", key)
+	}
+
+	r.resolving[key] = true
+	defer delete(r.resolving, key)
+
+	value, err := r.expand(key, r.raw[key])
+	if err != nil {
+		return "", err
+	}
+
+	r.resolved[key] = value
+	return value, nil
+}
+
+func (r *envResolver) lookup(owner, name string) (string, error) {
+	if _, ok := r.raw[name]; ok {
+		return r.resolve(name)
+	}
+	if value, ok := r.builtins[name]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("env var %s references undefined variable %s", owner, name)
+}
+
+func (r *envResolver) expand(owner, value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 >= len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+
+		next := value[i+1]
+		switch {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("env var %s: unterminated ${ in value", owner)
+			}
+			name := value[i+2 : i+2+end]
+			if !isEnvName(name) {
+				return "", fmt.Errorf("env var %s: invalid variable name %q", owner, name)
+			}
+			resolved, err := r.lookup(owner, name)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(resolved)
+			i += end + 2
+		case isEnvNameStart(next):
+			j := i + 1
+			for j < len(value) && isEnvNameChar(value[j]) {
+				j++
+			}
+			resolved, err := r.lookup(owner, value[i+1:j])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(resolved)
+			i = j - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+func isEnvName(name string) bool {
+	if name == "" || !isEnvNameStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isEnvNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isEnvNameChar(c byte) bool {
+	return isEnvNameStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Event types recorded in an agent's history
+const (
+	EventDeployed = "deployed"
+	EventStarted  = "started"
+	EventStopped  = "stopped"
+	EventPaused   = "paused"
+	EventResumed  = "resumed"
+	EventRemoved  = "removed"
+	EventFailed   = "failed"
+	// EventDrift is recorded when the state synchronizer corrects an agent's
+	// status to match its container
+	EventDrift    = "drift"
+)
+
+const (
+	// maxEvents is how many events are kept per agent
+	maxEvents = 1000
+	// removedEventsTTL is how long the history of a removed agent is kept
+	removedEventsTTL = 7 * 24 * time.Hour
+)
+
+// Event is one entry in an agent's lifecycle history
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	From    Status    `json:"from,omitempty"`
+	To      Status    `json:"to,omitempty"`
+	User    string    `json:"user"`
+	Message string    `json:"message,omitempty"`
+}
+
+type userKey struct{}
+
+// WithUser returns a context that attributes lifecycle events to user
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the user set by WithUser, or "system"
+func UserFromContext(ctx context.Context) string {
+	if user, ok := ctx.Value(userKey{}).(string); ok && user != "" {
+		return user
+	}
+	return "system"
+}
+
+func eventsKey(agentID string) string {
+	return fmt.Sprintf("agent:%s:events", agentID)
+}
+
+// RecordEvent appends an event to the agent's history. Time and User are
+// filled in from the clock and the context when not set.
+func (m *Manager) RecordEvent(ctx context.Context, agentID string, event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.User == "" {
+		event.User = UserFromContext(ctx)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	key := eventsKey(agentID)
+	pipe := m.redisClient.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, -maxEvents, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record event: %w", err)
+	}
+	return nil
+}
+
+// recordEvent is RecordEvent for lifecycle operations, which should not fail
+// because their history could not be written
+func (m *Manager) recordEvent(ctx context.Context, agentID, eventType string, from, to Status, message string) {
+	event := Event{Type: eventType, From: from, To: to, Message: message}
+	if err := m.RecordEvent(ctx, agentID, event); err != nil {
+		log.Printf("Failed to record %s event for agent %s: %v", eventType, agentID, err)
+	}
+}
+
+// GetEvents returns an agent's events, oldest first. A limit above zero
+// returns only the most recent events.
+func (m *Manager) GetEvents(ctx context.Context, agentID string, limit int) ([]Event, error) {
+	start := int64(0)
+	if limit > 0 {
+		start = int64(-limit)
+	}
+	entries, err := m.redisClient.LRange(ctx, eventsKey(agentID), start, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get events: %w", err)
+	}
+
+	events := make([]Event, 0, len(entries))
+	for _, entry := range entries {
+		var event Event
+		if err := json.Unmarshal([]byte(entry), &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/agentainer/agentainer-lab/pkg/docker"
+)
+
+// maxExecOutput caps how much command output is kept in an ExecResult
+const maxExecOutput = 64 << 10
+
+// ExecResult is the outcome of running a command inside an agent's container
+type ExecResult struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// ExecStreams connects a command run by Exec to the caller. Stdin is only
+// attached when set. With a TTY all output is written to Stdout.
+type ExecStreams struct {
+	Stdin       io.Reader
+	Stdout      io.Writer
+	Stderr      io.Writer
+	ConsoleSize *[2]uint // initial [height, width] of the TTY
+}
+
+// Exec runs a command inside a running agent's container, streams its output
+// and returns its exit code once it finishes. The caller's context bounds
+// how long the command may run.
+func (m *Manager) Exec(ctx context.Context, agentID string, cmd []string, tty bool, streams ExecStreams) (int, error) {
+	if len(cmd) == 0 {
+		return 0, fmt.Errorf("command is required")
+	}
+	if streams.Stdout == nil {
+		streams.Stdout = io.Discard
+	}
+	if streams.Stderr == nil {
+		streams.Stderr = io.Discard
+	}
+
+	agent, err := m.GetAgent(agentID)
+	if err != nil {
+		return 0, err
+	}
+	if agent.Status != StatusRunning || agent.ContainerID == "" {
+		return 0, fmt.Errorf("agent is not running")
+	}
+
+	config := types.ExecConfig{
+		Cmd:          cmd,
+		Tty:          tty,
+		AttachStdin:  streams.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	if tty {
+		config.ConsoleSize = streams.ConsoleSize
+	}
+
+	createCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	created, err := m.dockerClient.ContainerExecCreate(createCtx, agent.ContainerID, config)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create exec: %w", docker.CheckTimeout(err, m.operationTimeout))
+	}
+
+	attach, err := m.dockerClient.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{Tty: tty, ConsoleSize: config.ConsoleSize})
+	if err != nil {
+		return 0, fmt.Errorf("failed to start exec: %w", err)
+	}
+	defer attach.Close()
+
+	if streams.Stdin != nil {
+		go func() {
+			io.Copy(attach.Conn, streams.Stdin)
+			attach.CloseWrite()
+		}()
+	}
+
+	// Without a TTY output is multiplexed; either copy returns once the command exits
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if tty {
+			_, err = io.Copy(streams.Stdout, attach.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(streams.Stdout, streams.Stderr, attach.Reader)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return 0, fmt.Errorf("failed to read exec output: %w", err)
+		}
+	case <-ctx.Done():
+		return 0, fmt.Errorf("command did not finish: %w", ctx.Err())
+	}
+
+	inspectCtx, cancelInspect := m.dockerCtx(ctx)
+	defer cancelInspect()
+	inspect, err := m.dockerClient.ContainerExecInspect(inspectCtx, created.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect exec: %w", docker.CheckTimeout(err, m.operationTimeout))
+	}
+	return inspect.ExitCode, nil
+}
+
+// RunCommand runs a command without a TTY or stdin and collects its output
+func (m *Manager) RunCommand(ctx context.Context, agentID string, cmd []string) (*ExecResult, error) {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := m.Exec(ctx, agentID, cmd, false, ExecStreams{
+		Stdout: &limitedWriter{w: &stdout, n: maxExecOutput},
+		Stderr: &limitedWriter{w: &stderr, n: maxExecOutput},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExecResult{
+		ExitCode: exitCode,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+	}, nil
+}
+
+// limitedWriter keeps at most n bytes and silently discards the rest
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		keep := p
+		if len(keep) > l.n {
+			keep = keep[:l.n]
+		}
+		written, err := l.w.Write(keep)
+		l.n -= written
+		if err != nil {
+			return written, err
+		}
+	}
+	return len(p), nil
+}
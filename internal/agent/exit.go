@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ExitState records how an agent's container last exited
+type ExitState struct {
+	ExitCode   int       `json:"exit_code"`
+	OOMKilled  bool      `json:"oom_killed,omitempty"`
+	// Error is Docker's error for the container, e.g. a failed start
+	Error      string    `json:"error,omitempty"`
+	Reason     string    `json:"reason"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// ContainerExit returns the last exit recorded in a container's state, or
+// nil if the container has never exited
+func ContainerExit(state *types.ContainerState) *ExitState {
+	if state == nil {
+		return nil
+	}
+	finishedAt, err := time.Parse(time.RFC3339Nano, state.FinishedAt)
+	if err != nil || finishedAt.IsZero() {
+		return nil
+	}
+	return &ExitState{
+		ExitCode:   state.ExitCode,
+		OOMKilled:  state.OOMKilled,
+		Error:      state.Error,
+		Reason:     exitReason(state.ExitCode, state.OOMKilled),
+		FinishedAt: finishedAt,
+	}
+}
+
+// exitReason describes an exit code. Codes above 128 mean the process was
+// killed by signal code-128, e.g. 139 for a segfault.
+func exitReason(code int, oomKilled bool) string {
+	switch {
+	case oomKilled:
+		return fmt.Sprintf("OOM killed (exit %d)", code)
+	case code == 0:
+		return "exited normally"
+	case code > 128 && code < 128+65:
+		return fmt.Sprintf("killed by signal %d: %s (exit %d)", code-128, syscall.Signal(code-128), code)
+	}
+	return fmt.Sprintf("exited with code %d", code)
+}
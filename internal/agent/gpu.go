@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// nvidiaGPUDir lists one entry per NVIDIA GPU when the driver is loaded
+const nvidiaGPUDir = "/proc/driver/nvidia/gpus"
+
+// ParseGPUs parses a --gpus value in Docker's forms: "all", "count=N", or a
+// comma-separated list of device indexes or UUIDs such as "0,1" (optionally
+// prefixed with "device="). An empty value requests no GPUs.
+func ParseGPUs(value string) (*container.DeviceRequest, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	request := &container.DeviceRequest{
+		Driver:       "nvidia",
+		Capabilities: [][]string{{"gpu"}},
+	}
+	switch {
+	case value == "all":
+		request.Count = -1
+	case strings.HasPrefix(value, "count="):
+		n, err := strconv.Atoi(strings.TrimPrefix(value, "count="))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid gpus %q: count must be a positive number", value)
+		}
+		request.Count = n
+	default:
+		for _, id := range strings.Split(strings.TrimPrefix(value, "device="), ",") {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				return nil, fmt.Errorf("invalid gpus %q: use all, count=N or a list of device IDs such as 0,1", value)
+			}
+			request.DeviceIDs = append(request.DeviceIDs, id)
+		}
+	}
+	return request, nil
+}
+
+// checkGPUs makes sure the GPUs requested exist on the Docker host. Only a
+// local Docker host can be checked; remote hosts are left to Docker.
+func (m *Manager) checkGPUs(ctx context.Context, value string) error {
+	request, err := ParseGPUs(value)
+	if err != nil || request == nil {
+		return err
+	}
+	host := m.dockerClient.DaemonHost()
+	if !strings.HasPrefix(host, "unix://") && !strings.HasPrefix(host, "npipe://") {
+		return nil
+	}
+
+	entries, err := os.ReadDir(nvidiaGPUDir)
+	if err != nil || len(entries) == 0 {
+		return fmt.Errorf("no NVIDIA GPUs found on this host (is the NVIDIA driver installed?)")
+	}
+	available := len(entries)
+
+	if request.Count > available {
+		return fmt.Errorf("%d GPUs requested but only %d available", request.Count, available)
+	}
+	for _, id := range request.DeviceIDs {
+		// UUIDs (GPU-...) are left to Docker
+		if index, err := strconv.Atoi(id); err == nil && (index < 0 || index >= available) {
+			return fmt.Errorf("GPU %d does not exist (this host has GPUs 0-%d)", index, available-1)
+		}
+	}
+	return nil
+}
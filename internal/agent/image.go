@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/agentainer/agentainer-lab/pkg/docker"
+)
+
+// Image pull policies
+const (
+	PullAlways  = "always"
+	PullMissing = "missing"
+	PullNever   = "never"
+)
+
+// ValidatePullPolicy checks that a pull policy is one of the known values
+func ValidatePullPolicy(policy string) error {
+	switch policy {
+	case "", PullAlways, PullMissing, PullNever:
+		return nil
+	}
+	return fmt.Errorf("invalid pull policy '%s' (must be always, missing or never)", policy)
+}
+
+// SetAutoPull sets whether images missing locally are pulled on deploy when
+// no pull policy is given
+func (m *Manager) SetAutoPull(enabled bool) {
+	m.autoPull = enabled
+}
+
+// SetRegistryAuths sets the credentials used to pull images from private
+// registries during deploy
+func (m *Manager) SetRegistryAuths(auths []docker.RegistryAuth) {
+	m.registries = auths
+}
+
+// ensureImage pulls an image according to the pull policy and inspects it.
+// An explicit auth takes precedence over the configured registries.
+func (m *Manager) ensureImage(ctx context.Context, image, policy string, auth *docker.RegistryAuth) (types.ImageInspect, error) {
+	if policy == "" {
+		policy = PullNever
+		if m.autoPull {
+			policy = PullMissing
+		}
+	}
+
+	if policy != PullAlways {
+		info, err := m.inspectImage(ctx, image)
+		if err == nil || !client.IsErrNotFound(err) {
+			return info, err
+		}
+		if policy == PullNever {
+			return types.ImageInspect{}, fmt.Errorf("docker image '%s' not found. Please build or pull the image first, or deploy with --pull=missing", image)
+		}
+	}
+
+	if auth == nil {
+		auth = docker.FindRegistryAuth(m.registries, image)
+	}
+
+	log.Printf("Pulling image %s from %s", image, docker.RegistryHost(image))
+	if err := docker.PullImage(ctx, m.dockerClient, image, auth, nil); err != nil {
+		return types.ImageInspect{}, err
+	}
+
+	info, err := m.inspectImage(ctx, image)
+	if client.IsErrNotFound(err) {
+		// The daemon can report success for a pull that left no matching tag,
+		// e.g. when no variant of the image exists for the host platform
+		return types.ImageInspect{}, fmt.Errorf("pulled image '%s' but it still cannot be found locally; check the tag and that it is published for this platform", image)
+	}
+	return info, err
+}
+
+func (m *Manager) inspectImage(ctx context.Context, image string) (types.ImageInspect, error) {
+	inspectCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	info, _, err := m.dockerClient.ImageInspectWithRaw(inspectCtx, image)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return info, err
+		}
+		return info, fmt.Errorf("failed to inspect docker image: %w", docker.CheckTimeout(err, m.operationTimeout))
+	}
+	return info, nil
+}
+
+// imageDigest returns the registry digest (repo@sha256:...) an image was
+// resolved to, preferring one from the image's own repository. Images that
+// were built locally and never pushed have none.
+func imageDigest(image string, info types.ImageInspect) string {
+	repo := imageRepository(image)
+	for _, digest := range info.RepoDigests {
+		if strings.HasPrefix(digest, repo+"@") {
+			return digest
+		}
+	}
+	if len(info.RepoDigests) > 0 {
+		return info.RepoDigests[0]
+	}
+	return ""
+}
+
+// imageRepository strips the tag and digest from an image reference
+func imageRepository(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// ContainerImage is the image reference containers are created from: the
+// recorded digest for pinned agents, the tag otherwise
+func (a *Agent) ContainerImage() string {
+	if a.PinDigest && a.ImageDigest != "" {
+		return a.ImageDigest
+	}
+	return a.Image
+}
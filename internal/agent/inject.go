@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// dockerHostName is how containers reach the host. Docker Desktop defines
+// it; on Linux it is mapped to the bridge gateway with host-gateway.
+const dockerHostName = "host.docker.internal"
+
+// SetAPIPort sets the API server port that agents deployed with InjectAPI
+// are told to use
+func (m *Manager) SetAPIPort(port int) {
+	m.apiPort = port
+}
+
+// containerHost rewrites an address the server uses into one reachable from
+// an agent. Loopback addresses and single-label names, such as a Compose
+// service, only resolve on the server's side, so they are reached through
+// the host's published port instead.
+func containerHost(host string) string {
+	if host == "" || host == "localhost" || host == "0.0.0.0" {
+		return dockerHostName
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return dockerHostName
+		}
+		return host
+	}
+	if !strings.Contains(host, ".") {
+		return dockerHostName
+	}
+	return host
+}
+
+// injectedEnv returns the connection settings requested with InjectRedis and
+// InjectAPI. Variables the agent sets itself are left alone.
+func (m *Manager) injectedEnv(agent *Agent) map[string]string {
+	if !agent.InjectRedis && !agent.InjectAPI {
+		return nil
+	}
+
+	env := map[string]string{"AGENTAINER_AGENT_ID": agent.ID}
+	if agent.InjectRedis {
+		host, port, err := net.SplitHostPort(m.redisClient.Options().Addr)
+		if err == nil {
+			env["REDIS_HOST"] = containerHost(host)
+			env["REDIS_PORT"] = port
+		}
+	}
+	if agent.InjectAPI && m.apiPort > 0 {
+		env["AGENTAINER_API_URL"] = fmt.Sprintf("http://%s:%d", dockerHostName, m.apiPort)
+	}
+
+	for key := range env {
+		if _, ok := agent.EnvVars[key]; ok {
+			delete(env, key)
+		}
+	}
+	return env
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// redactedValue replaces secret values in inspect output
+const redactedValue = "[redacted]"
+
+// FileChange is a path added, changed or deleted in an agent's container
+// relative to its image
+type FileChange struct {
+	Kind string `json:"kind"` // added, changed or deleted
+	Path string `json:"path"`
+}
+
+// Inspect returns Docker's full description of the agent's container.
+// Environment variables filled from secrets are redacted.
+func (m *Manager) Inspect(ctx context.Context, agentID string) (*types.ContainerJSON, error) {
+	agent, err := m.GetAgent(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if agent.ContainerID == "" {
+		return nil, fmt.Errorf("container not found")
+	}
+
+	inspectCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	info, err := m.dockerClient.ContainerInspect(inspectCtx, agent.ContainerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	if info.Config != nil && len(agent.Secrets) > 0 {
+		env := make([]string, len(info.Config.Env))
+		for i, entry := range info.Config.Env {
+			name, _, _ := strings.Cut(entry, "=")
+			if _, ok := agent.Secrets[name]; ok {
+				entry = name + "=" + redactedValue
+			}
+			env[i] = entry
+		}
+		info.Config.Env = env
+	}
+	return &info, nil
+}
+
+// Diff returns the paths changed in the agent's container filesystem since
+// it was created from its image
+func (m *Manager) Diff(ctx context.Context, agentID string) ([]FileChange, error) {
+	agent, err := m.GetAgent(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if agent.ContainerID == "" {
+		return nil, fmt.Errorf("container not found")
+	}
+
+	diffCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	changes, err := m.dockerClient.ContainerDiff(diffCtx, agent.ContainerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container changes: %w", err)
+	}
+
+	result := make([]FileChange, 0, len(changes))
+	for _, change := range changes {
+		kind := "changed"
+		switch change.Kind {
+		case container.ChangeAdd:
+			kind = "added"
+		case container.ChangeDelete:
+			kind = "deleted"
+		}
+		result = append(result, FileChange{Kind: kind, Path: change.Path})
+	}
+	return result, nil
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// reservedLabelPrefix marks the Docker labels Agentainer sets itself
+const reservedLabelPrefix = "agentainer."
+
+// ValidateLabels checks user-defined agent labels. Keys use letters, digits,
+// '-', '_', '.' and '/', and may not use the reserved agentainer. prefix.
+func ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if key == "" || len(key) > 63 {
+			return fmt.Errorf("invalid label key '%s'", key)
+		}
+		for _, c := range key {
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') &&
+				c != '-' && c != '_' && c != '.' && c != '/' {
+				return fmt.Errorf("invalid label key '%s': use letters, digits, '-', '_', '.' and '/'", key)
+			}
+		}
+		if strings.HasPrefix(key, reservedLabelPrefix) {
+			return fmt.Errorf("label key '%s' uses the reserved prefix '%s'", key, reservedLabelPrefix)
+		}
+		if len(value) > 255 {
+			return fmt.Errorf("label '%s' value too long (max 255 characters)", key)
+		}
+	}
+	return nil
+}
+
+// ParseLabels parses key=value labels as given on the command line
+func ParseLabels(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(values))
+	for _, value := range values {
+		key, v, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", value)
+		}
+		labels[key] = v
+	}
+	return labels, ValidateLabels(labels)
+}
+
+// matchLabels reports whether an agent has every label in the selector
+func (a *Agent) matchLabels(selector map[string]string) bool {
+	for key, value := range selector {
+		if actual, ok := a.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// containerLabels returns the agent's labels plus the ones Agentainer uses
+// to find its containers
+func (a *Agent) containerLabels() map[string]string {
+	labels := make(map[string]string, len(a.Labels)+3)
+	for key, value := range a.Labels {
+		labels[key] = value
+	}
+	labels["agentainer.id"] = a.ID
+	labels["agentainer.name"] = a.Name
+	labels["agentainer.namespace"] = a.Namespace
+	return labels
+}
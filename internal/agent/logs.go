@@ -0,0 +1,283 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const (
+	// logsScanInterval is how often running agents are checked for
+	// containers whose logs are not being persisted yet
+	logsScanInterval = 5 * time.Second
+	// logsFlushInterval bounds how long tailed lines wait to be written
+	logsFlushInterval = time.Second
+)
+
+// PersistLogs copies the container logs of every running agent into Redis
+// until ctx is cancelled, keeping at most maxBytes per agent, so that the
+// logs can still be read once the container is gone
+func (m *Manager) PersistLogs(ctx context.Context, maxBytes int64) error {
+	// Tailers are keyed by container, as a restart may replace it
+	tailing := make(map[string]bool)
+	done := make(chan string)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(logsScanInterval)
+	defer ticker.Stop()
+
+	for {
+		agents, err := m.loadAgents()
+		if err != nil {
+			log.Printf("Failed to list agents for log persistence: %v", err)
+		}
+		for _, agent := range agents {
+			if agent.Status != StatusRunning || agent.ContainerID == "" || tailing[agent.ContainerID] {
+				continue
+			}
+			tailing[agent.ContainerID] = true
+			wg.Add(1)
+			go func(agentID, containerID string) {
+				defer wg.Done()
+				if err := m.tailLogs(ctx, agentID, containerID, maxBytes); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to persist logs of agent %s: %v", agentID, err)
+				}
+				select {
+				case done <- containerID:
+				case <-ctx.Done():
+				}
+			}(agent.ID, agent.ContainerID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case containerID := <-done:
+			delete(tailing, containerID)
+		case <-ticker.C:
+		}
+	}
+}
+
+// tailLogs follows a container's logs into Redis until the container stops,
+// resuming after the last line already stored
+func (m *Manager) tailLogs(ctx context.Context, agentID, containerID string, maxBytes int64) error {
+	var after time.Time
+	last, err := m.redisClient.LIndex(ctx, logsKey(agentID), -1).Result()
+	if err == nil {
+		after, _ = logEntryTime(last)
+	}
+
+	options := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+	}
+	if !after.IsZero() {
+		options.Since = fmt.Sprintf("%d.%09d", after.Unix(), after.Nanosecond())
+	}
+	stream, err := m.dockerClient.ContainerLogs(ctx, containerID, options)
+	if err != nil {
+		return fmt.Errorf("failed to get container logs: %w", err)
+	}
+	defer stream.Close()
+
+	sink := &logSink{m: m, agentID: agentID, maxBytes: maxBytes, after: after}
+	stop := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		ticker := time.NewTicker(logsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sink.flush()
+			case <-stop:
+				sink.flush()
+				return
+			}
+		}
+	}()
+
+	stdout := &logLineWriter{sink: sink, stream: stdcopy.Stdout}
+	stderr := &logLineWriter{sink: sink, stream: stdcopy.Stderr}
+	_, err = stdcopy.StdCopy(stdout, stderr, stream)
+	stdout.finish()
+	stderr.finish()
+	close(stop)
+	<-flushed
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read container logs: %w", err)
+	}
+	return nil
+}
+
+// logSink buffers log lines for one agent and writes them to Redis in
+// batches. Entries are the stream number followed by Docker's timestamped
+// line, e.g. "1" + "2026-01-01T12:00:00.000000000Z listening on :8000".
+type logSink struct {
+	m        *Manager
+	agentID  string
+	maxBytes int64
+	// after skips lines already stored, as Docker's since is inclusive
+	after    time.Time
+
+	mu       sync.Mutex
+	pending  []interface{}
+}
+
+func (s *logSink) add(stream stdcopy.StdType, line string) {
+	entry := strconv.Itoa(int(stream)) + line
+	if t, ok := logEntryTime(entry); ok && !s.after.IsZero() && !t.After(s.after) {
+		return
+	}
+	s.mu.Lock()
+	s.pending = append(s.pending, entry)
+	s.mu.Unlock()
+}
+
+func (s *logSink) flush() {
+	s.mu.Lock()
+	entries := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	var size int64
+	for _, entry := range entries {
+		size += int64(len(entry.(string)))
+	}
+	ctx := context.Background()
+	pipe := s.m.redisClient.Pipeline()
+	pipe.RPush(ctx, logsKey(s.agentID), entries...)
+	total := pipe.IncrBy(ctx, logsSizeKey(s.agentID), size)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to persist logs of agent %s: %v", s.agentID, err)
+		return
+	}
+
+	// Drop the oldest lines to stay within the cap
+	for excess := total.Val() - s.maxBytes; s.maxBytes > 0 && excess > 0; {
+		oldest, err := s.m.redisClient.LPop(ctx, logsKey(s.agentID)).Result()
+		if err != nil {
+			break
+		}
+		excess -= int64(len(oldest))
+		s.m.redisClient.DecrBy(ctx, logsSizeKey(s.agentID), int64(len(oldest)))
+	}
+}
+
+// logLineWriter splits one demultiplexed stream into lines
+type logLineWriter struct {
+	sink    *logSink
+	stream  stdcopy.StdType
+	partial []byte
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	data := append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		w.sink.add(w.stream, string(data[:i]))
+		data = data[i+1:]
+	}
+	w.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// finish keeps a last line that had no newline
+func (w *logLineWriter) finish() {
+	if len(w.partial) > 0 {
+		w.sink.add(w.stream, string(w.partial))
+		w.partial = nil
+	}
+}
+
+// persistedLogs returns an agent's persisted logs as a multiplexed stream
+// like Docker's, or false if there are none
+func (m *Manager) persistedLogs(ctx context.Context, agentID string, opts LogOptions) (io.ReadCloser, bool) {
+	entries, err := m.redisClient.LRange(ctx, logsKey(agentID), 0, -1).Result()
+	if err != nil || len(entries) == 0 {
+		return nil, false
+	}
+
+	if opts.Since != "" {
+		since, _ := timetypes.GetTimestamp(opts.Since, time.Now())
+		seconds, nanos, err := timetypes.ParseTimestamps(since, 0)
+		if err == nil {
+			cutoff := time.Unix(seconds, nanos)
+			kept := entries[:0]
+			for _, entry := range entries {
+				if t, ok := logEntryTime(entry); !ok || !t.Before(cutoff) {
+					kept = append(kept, entry)
+				}
+			}
+			entries = kept
+		}
+	}
+	if opts.Tail != "" && opts.Tail != "all" {
+		if n, err := strconv.Atoi(opts.Tail); err == nil && n < len(entries) {
+			entries = entries[len(entries)-n:]
+		}
+	}
+
+	var buf bytes.Buffer
+	stdout := stdcopy.NewStdWriter(&buf, stdcopy.Stdout)
+	stderr := stdcopy.NewStdWriter(&buf, stdcopy.Stderr)
+	for _, entry := range entries {
+		if len(entry) == 0 {
+			continue
+		}
+		out := stdout
+		if entry[0] == '0'+byte(stdcopy.Stderr) {
+			out = stderr
+		}
+		out.Write([]byte(entry[1:] + "\n"))
+	}
+	return io.NopCloser(&buf), true
+}
+
+// expireLogs keeps a removed agent's persisted logs for as long as its events
+func (m *Manager) expireLogs(ctx context.Context, agentID string) {
+	for _, key := range []string{logsKey(agentID), logsSizeKey(agentID)} {
+		if err := m.redisClient.Expire(ctx, key, removedEventsTTL).Err(); err != nil {
+			log.Printf("Warning: failed to expire logs of agent %s: %v", agentID, err)
+		}
+	}
+}
+
+// logEntryTime reads the Docker timestamp at the start of a stored entry
+func logEntryTime(entry string) (time.Time, bool) {
+	if len(entry) < 2 {
+		return time.Time{}, false
+	}
+	stamp, _, _ := strings.Cut(entry[1:], " ")
+	t, err := time.Parse(time.RFC3339Nano, stamp)
+	return t, err == nil
+}
+
+func logsKey(agentID string) string {
+	return fmt.Sprintf("agent:%s:logs", agentID)
+}
+
+func logsSizeKey(agentID string) string {
+	return fmt.Sprintf("agent:%s:logs:bytes", agentID)
+}
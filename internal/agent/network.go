@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/agentainer/agentainer-lab/pkg/docker"
+)
+
+// ValidateNetworkAliases checks that aliases are usable as DNS names
+func ValidateNetworkAliases(aliases []string) error {
+	seen := make(map[string]bool, len(aliases))
+	for _, alias := range aliases {
+		if alias == "" || len(alias) > 63 {
+			return fmt.Errorf("invalid network alias '%s': must be 1-63 characters", alias)
+		}
+		for i, c := range alias {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && !(c == '-' && i > 0 && i < len(alias)-1) {
+				return fmt.Errorf("invalid network alias '%s': use lowercase letters, digits and inner '-'", alias)
+			}
+		}
+		if seen[alias] {
+			return fmt.Errorf("network alias '%s' is given more than once", alias)
+		}
+		seen[alias] = true
+	}
+	return nil
+}
+
+// validateNetworks checks that the additional networks exist and are
+// user-defined, since Docker's built-in networks do not support aliases
+func (m *Manager) validateNetworks(ctx context.Context, networks []string) error {
+	seen := make(map[string]bool, len(networks))
+	for _, name := range networks {
+		switch name {
+		case "":
+			return fmt.Errorf("network name is required")
+		case AgentainerNetworkName:
+			return fmt.Errorf("agents are always attached to %s", AgentainerNetworkName)
+		case "bridge", "host", "none":
+			return fmt.Errorf("network '%s' is not supported; use a user-defined network", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("network '%s' is given more than once", name)
+		}
+		seen[name] = true
+
+		inspectCtx, cancel := m.dockerCtx(ctx)
+		_, err := m.dockerClient.NetworkInspect(inspectCtx, name, types.NetworkInspectOptions{})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("network '%s' not found: %w", name, docker.CheckTimeout(err, m.operationTimeout))
+		}
+	}
+	return nil
+}
+
+// endpointSettings returns the agent's settings on a network. The agent ID
+// is always an alias so the proxy can reach it by ID.
+func (a *Agent) endpointSettings() *network.EndpointSettings {
+	aliases := append([]string{a.ID}, a.NetworkAliases...)
+	return &network.EndpointSettings{Aliases: aliases}
+}
+
+// connectNetworks attaches a created container to the agent's additional networks
+func (m *Manager) connectNetworks(ctx context.Context, agent *Agent, containerID string) error {
+	for _, name := range agent.Networks {
+		connectCtx, cancel := m.dockerCtx(ctx)
+		err := m.dockerClient.NetworkConnect(connectCtx, name, containerID, agent.endpointSettings())
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to connect to network %s: %w", name, docker.CheckTimeout(err, m.operationTimeout))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultProxyPort is the container port the proxy targets when an agent
+// doesn't configure one
+const DefaultProxyPort = 8000
+
+// ProxyTarget returns the container port and path a proxied request should be
+// forwarded to. If the first path segment names one of the agent's routes, the
+// route's port is used and the segment is stripped from the path.
+func (a *Agent) ProxyTarget(path string) (int, string) {
+	port := a.ProxyPort
+	if port == 0 {
+		port = DefaultProxyPort
+	}
+
+	if len(a.Routes) == 0 {
+		return port, path
+	}
+
+	trimmed := strings.TrimPrefix(path, "/")
+	segment := trimmed
+	rest := "/"
+	if i := strings.IndexByte(trimmed, '/'); i >= 0 {
+		segment = trimmed[:i]
+		rest = trimmed[i:]
+	}
+
+	if routePort, ok := a.Routes[segment]; ok {
+		return routePort, rest
+	}
+	return port, path
+}
+
+// ValidateProxyPorts checks the proxy port and named routes of an agent
+func ValidateProxyPorts(proxyPort int, routes map[string]int) error {
+	if proxyPort < 0 || proxyPort > 65535 {
+		return fmt.Errorf("invalid proxy port %d", proxyPort)
+	}
+	for name, port := range routes {
+		if name == "" || len(name) > 63 {
+			return fmt.Errorf("invalid route name '%s'", name)
+		}
+		for _, c := range name {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+				return fmt.Errorf("invalid route name '%s': use lowercase letters, digits, '-' and '_'", name)
+			}
+		}
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %d for route '%s'", port, name)
+		}
+	}
+	return nil
+}
+
+// MaxProxyRetries caps how many times a proxied request is retried
+const MaxProxyRetries = 10
+
+// ParseProxyTimeout parses how long the proxy waits for an agent's response
+// headers. An empty timeout means no limit.
+func ParseProxyTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid proxy timeout %q: %w", timeout, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("proxy timeout must be positive")
+	}
+	return d, nil
+}
+
+// ValidateProxyRetries checks the number of proxy retries of an agent
+func ValidateProxyRetries(retries int) error {
+	if retries < 0 || retries > MaxProxyRetries {
+		return fmt.Errorf("proxy retries must be between 0 and %d", MaxProxyRetries)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/agentainer/agentainer-lab/pkg/docker"
+)
+
+// ReconcileResult summarises a reconciliation pass
+type ReconcileResult struct {
+	Checked   int               `json:"checked"`
+	Restarted []string          `json:"restarted"`
+	Skipped   []string          `json:"skipped"`
+	Failed    map[string]string `json:"failed"`
+}
+
+// Reconcile brings agents whose desired state is running back up if their
+// container is gone or not running, e.g. because it died while the server
+// was down. Only auto-restart agents are restarted unless restartAll is set.
+// Agents in StatusFailed have exhausted their restart budget and are left alone.
+func (m *Manager) Reconcile(ctx context.Context, restartAll bool) (*ReconcileResult, error) {
+	agents, err := m.loadAgents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load agents: %w", err)
+	}
+
+	result := &ReconcileResult{
+		Restarted: []string{},
+		Skipped:   []string{},
+		Failed:    make(map[string]string),
+	}
+	for i := range agents {
+		agent := &agents[i]
+		if agent.desiredStatus() != StatusRunning || agent.Status == StatusFailed {
+			continue
+		}
+		result.Checked++
+
+		running, exists, err := m.containerRunning(ctx, agent.ContainerID)
+		if err != nil {
+			result.Failed[agent.ID] = err.Error()
+			continue
+		}
+		if running {
+			continue
+		}
+
+		if !agent.AutoRestart && !restartAll {
+			log.Printf("Agent %s (%s) should be running but its container is down; not restarting without auto-restart", agent.Name, agent.ID)
+			result.Skipped = append(result.Skipped, agent.ID)
+			continue
+		}
+
+		if exceeded, err := m.RecordRestart(ctx, agent.ID, "startup reconciliation", agent.MaxRestarts); err != nil || exceeded {
+			if err == nil {
+				err = fmt.Errorf("restart limit exceeded")
+			}
+			result.Failed[agent.ID] = err.Error()
+			continue
+		}
+
+		// Start refuses agents it believes are running, and a missing
+		// container has to be recreated
+		agent.Status = StatusStopped
+		if !exists {
+			agent.ContainerID = ""
+		}
+		agent.UpdatedAt = time.Now()
+		if err := m.saveAgent(agent); err != nil {
+			result.Failed[agent.ID] = err.Error()
+			continue
+		}
+
+		if err := m.Start(ctx, agent.ID); err != nil {
+			log.Printf("Failed to restart agent %s (%s) during reconciliation: %v", agent.Name, agent.ID, err)
+			result.Failed[agent.ID] = err.Error()
+			continue
+		}
+		log.Printf("Restarted agent %s (%s) to match its desired state", agent.Name, agent.ID)
+		result.Restarted = append(result.Restarted, agent.ID)
+	}
+
+	return result, nil
+}
+
+// desiredStatus falls back to the last known status for agents saved before
+// desired state was tracked
+func (a *Agent) desiredStatus() Status {
+	if a.DesiredStatus != "" {
+		return a.DesiredStatus
+	}
+	if a.Status == StatusRunning {
+		return StatusRunning
+	}
+	return StatusStopped
+}
+
+// containerRunning reports whether a container is running and whether it exists at all
+func (m *Manager) containerRunning(ctx context.Context, containerID string) (running, exists bool, err error) {
+	if containerID == "" {
+		return false, false, nil
+	}
+
+	inspectCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	info, err := m.dockerClient.ContainerInspect(inspectCtx, containerID)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("failed to inspect container: %w", docker.CheckTimeout(err, m.operationTimeout))
+	}
+	return info.State.Running, true, nil
+}
@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// ScaleResult reports the outcome of a Scale call. Replica creation is not
+// atomic, so some replicas may come up while others fail to start.
+type ScaleResult struct {
+	AgentID  string            `json:"agent_id"`
+	Replicas int               `json:"replicas"`
+	Running  []string          `json:"running"`
+	Created  []string          `json:"created,omitempty"`
+	Removed  []string          `json:"removed,omitempty"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+func replicasKey(agentID string) string {
+	return fmt.Sprintf("agent:%s:replicas", agentID)
+}
+
+// Scale sets the total number of instances of an agent, counting the agent
+// itself. Sibling replicas share its image, env, volumes and settings and are
+// started if the agent is running.
+func (m *Manager) Scale(ctx context.Context, agentID string, count int) (*ScaleResult, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("replica count must be at least 1")
+	}
+
+	parent, err := m.GetAgent(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ReplicaOf != "" {
+		return nil, fmt.Errorf("agent %s is a replica of %s; scale the parent instead", agentID, parent.ReplicaOf)
+	}
+
+	replicaIDs, err := m.replicaIDs(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ScaleResult{
+		AgentID: agentID,
+		Failed:  make(map[string]string),
+	}
+
+	desired := count - 1
+	for len(replicaIDs) > desired {
+		// Remove the newest replicas first
+		id := replicaIDs[len(replicaIDs)-1]
+		replicaIDs = replicaIDs[:len(replicaIDs)-1]
+		if err := m.Remove(ctx, id); err != nil {
+			result.Failed[id] = fmt.Sprintf("failed to remove: %v", err)
+			continue
+		}
+		result.Removed = append(result.Removed, id)
+	}
+
+	for i := len(replicaIDs); i < desired; i++ {
+		replica, err := m.createReplica(ctx, parent)
+		if err != nil {
+			result.Failed[fmt.Sprintf("replica-%d", i+1)] = err.Error()
+			continue
+		}
+		result.Created = append(result.Created, replica.ID)
+
+		if parent.Status == StatusRunning {
+			if err := m.Start(ctx, replica.ID); err != nil {
+				result.Failed[replica.ID] = fmt.Sprintf("failed to start: %v", err)
+			}
+		}
+	}
+
+	instances, err := m.Instances(agentID)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		if instance.Status == StatusRunning {
+			result.Running = append(result.Running, instance.ID)
+		}
+	}
+	result.Replicas = len(instances)
+
+	if len(result.Failed) == 0 {
+		result.Failed = nil
+	}
+	return result, nil
+}
+
+// Instances returns the agent followed by its replicas
+func (m *Manager) Instances(agentID string) ([]*Agent, error) {
+	parent, err := m.GetAgent(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaIDs, err := m.replicaIDs(context.Background(), agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []*Agent{parent}
+	for _, id := range replicaIDs {
+		replica, err := m.GetAgent(id)
+		if err != nil {
+			log.Printf("Warning: replica %s of agent %s not found: %v", id, agentID, err)
+			continue
+		}
+		instances = append(instances, replica)
+	}
+	return instances, nil
+}
+
+// replicaIDs returns the replica IDs of an agent, oldest first
+func (m *Manager) replicaIDs(ctx context.Context, agentID string) ([]string, error) {
+	ids, err := m.redisClient.SMembers(ctx, replicasKey(agentID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get replicas: %w", err)
+	}
+	// IDs embed their creation time, so sorting orders them by age
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *Manager) createReplica(ctx context.Context, parent *Agent) (*Agent, error) {
+	replica := *parent
+	replica.ID = generateID()
+	replica.Name = fmt.Sprintf("%s-%s", parent.Name, replica.ID[len(replica.ID)-6:])
+	replica.ReplicaOf = parent.ID
+	replica.ContainerID = ""
+	replica.ContainerHealth = ""
+	replica.Status = StatusCreated
+	replica.DesiredStatus = ""
+	replica.CreatedAt = time.Now()
+	replica.UpdatedAt = time.Now()
+
+	if err := m.saveAgent(&replica); err != nil {
+		return nil, fmt.Errorf("failed to save replica: %w", err)
+	}
+	if err := m.redisClient.SAdd(ctx, replicasKey(parent.ID), replica.ID).Err(); err != nil {
+		return nil, fmt.Errorf("failed to track replica: %w", err)
+	}
+	return &replica, nil
+}
+
+// removeReplicas removes all replicas of an agent along with their tracking set
+func (m *Manager) removeReplicas(ctx context.Context, agentID string) {
+	replicaIDs, err := m.replicaIDs(ctx, agentID)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	for _, id := range replicaIDs {
+		if err := m.Remove(ctx, id); err != nil {
+			log.Printf("Warning: failed to remove replica %s: %v", id, err)
+		}
+	}
+	if err := m.redisClient.Del(ctx, replicasKey(agentID)).Err(); err != nil {
+		log.Printf("Warning: failed to remove replica set for agent %s: %v", agentID, err)
+	}
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// Ulimit is a resource limit applied to the agent's processes
+type Ulimit struct {
+	Name string `json:"name"`
+	Soft int64  `json:"soft"`
+	Hard int64  `json:"hard"`
+}
+
+// ParseUlimit parses a ulimit in Docker's name=soft[:hard] form, e.g. nofile=1024:2048
+func ParseUlimit(value string) (Ulimit, error) {
+	parsed, err := units.ParseUlimit(value)
+	if err != nil {
+		return Ulimit{}, fmt.Errorf("invalid ulimit %q: %w", value, err)
+	}
+	return Ulimit{Name: parsed.Name, Soft: parsed.Soft, Hard: parsed.Hard}, nil
+}
+
+// ValidateResourceLimits checks the limits that go beyond CPU and memory.
+// A memory swap of -1 allows unlimited swap; any swap setting needs a memory limit.
+func ValidateResourceLimits(memoryLimit, memorySwap, pidsLimit int64, ulimits []Ulimit) error {
+	if pidsLimit < 0 {
+		return fmt.Errorf("pids limit must not be negative")
+	}
+	if memorySwap != 0 {
+		if memoryLimit <= 0 {
+			return fmt.Errorf("memory swap requires a memory limit")
+		}
+		if memorySwap != -1 && memorySwap < memoryLimit {
+			return fmt.Errorf("memory swap (%d) must be at least the memory limit (%d)", memorySwap, memoryLimit)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, ulimit := range ulimits {
+		if _, err := units.ParseUlimit(fmt.Sprintf("%s=%d:%d", ulimit.Name, ulimit.Soft, ulimit.Hard)); err != nil {
+			return fmt.Errorf("invalid ulimit %s: %w", ulimit.Name, err)
+		}
+		if seen[ulimit.Name] {
+			return fmt.Errorf("ulimit %s is set more than once", ulimit.Name)
+		}
+		seen[ulimit.Name] = true
+	}
+	return nil
+}
+
+// minMemoryReservation is the smallest memory reservation Docker accepts
+const minMemoryReservation = 6 * 1024 * 1024
+
+// maxCPUShares is the largest CPU weight the kernel accepts
+const maxCPUShares = 262144
+
+// ValidateReservations checks the soft limits. A memory reservation is only
+// enforced when the host is short on memory and must not exceed the hard
+// limit; CPU shares are a relative weight (1024 is the default).
+func ValidateReservations(memoryLimit, memoryReservation, cpuShares int64) error {
+	if memoryReservation < 0 {
+		return fmt.Errorf("memory reservation must not be negative")
+	}
+	if memoryReservation > 0 && memoryReservation < minMemoryReservation {
+		return fmt.Errorf("memory reservation must be at least 6MB")
+	}
+	if memoryLimit > 0 && memoryReservation > memoryLimit {
+		return fmt.Errorf("memory reservation (%d) must not exceed the memory limit (%d)", memoryReservation, memoryLimit)
+	}
+	if cpuShares < 0 {
+		return fmt.Errorf("cpu shares must not be negative")
+	}
+	if cpuShares > 0 && (cpuShares < 2 || cpuShares > maxCPUShares) {
+		return fmt.Errorf("cpu shares must be between 2 and %d", maxCPUShares)
+	}
+	return nil
+}
+
+// containerResources maps the agent's limits onto Docker's
+func (a *Agent) containerResources() container.Resources {
+	resources := container.Resources{
+		Memory:     a.MemoryLimit,
+		MemorySwap: a.MemorySwap,
+		MemoryReservation: a.MemoryReservation,
+		NanoCPUs:   a.CPULimit,
+		CPUShares:  a.CPUShares,
+	}
+	if a.PidsLimit > 0 {
+		pidsLimit := a.PidsLimit
+		resources.PidsLimit = &pidsLimit
+	}
+	// Validated at deploy
+	if request, _ := ParseGPUs(a.GPUs); request != nil {
+		resources.DeviceRequests = []container.DeviceRequest{*request}
+	}
+	for _, ulimit := range a.Ulimits {
+		resources.Ulimits = append(resources.Ulimits, &units.Ulimit{
+			Name: ulimit.Name,
+			Soft: ulimit.Soft,
+			Hard: ulimit.Hard,
+		})
+	}
+	return resources
+}
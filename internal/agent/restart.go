@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/go-redis/redis/v8"
+	"github.com/agentainer/agentainer-lab/internal/logging"
+	"github.com/agentainer/agentainer-lab/pkg/docker"
+)
+
+// DefaultRestartWindow is used when an agent sets MaxRestarts without a RestartWindow
+const DefaultRestartWindow = 10 * time.Minute
+
+func restartHistoryKey(agentID string) string {
+	return fmt.Sprintf("agent:%s:restarts", agentID)
+}
+
+func restartCountKey(agentID string) string {
+	return fmt.Sprintf("agent:%s:restart_count", agentID)
+}
+
+// ParseRestartWindow parses a restart window, falling back to DefaultRestartWindow when empty
+func ParseRestartWindow(window string) (time.Duration, error) {
+	if window == "" {
+		return DefaultRestartWindow, nil
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil {
+		return 0, fmt.Errorf("invalid restart window %q: %w", window, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("restart window must be positive")
+	}
+	return d, nil
+}
+
+// RecordRestart records a restart of the agent and reports whether more than
+// maxRestarts restarts have happened within the agent's restart window. When
+// the limit is exceeded the agent is stopped and moved to StatusFailed.
+// A maxRestarts of zero means unlimited.
+func (m *Manager) RecordRestart(ctx context.Context, agentID, reason string, maxRestarts int) (bool, error) {
+	agent, err := m.GetAgent(agentID)
+	if err != nil {
+		return false, err
+	}
+
+	window, err := ParseRestartWindow(agent.RestartWindow)
+	if err != nil {
+		window = DefaultRestartWindow
+	}
+
+	now := time.Now()
+	key := restartHistoryKey(agentID)
+	pipe := m.redisClient.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixNano()), Member: now.UnixNano()})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to record restart: %w", err)
+	}
+
+	if maxRestarts <= 0 || int(count.Val()) <= maxRestarts {
+		return false, nil
+	}
+
+	if err := m.markFailed(ctx, agent, int(count.Val()), maxRestarts, window, reason); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// ObserveContainerEvent updates restart tracking from a Docker container event.
+// Docker increments a container's RestartCount each time its restart policy
+// restarts it, so new restarts are detected by comparing against the last
+// count seen for the agent.
+func (m *Manager) ObserveContainerEvent(ctx context.Context, agentID, action string) error {
+	if action != "start" && action != "die" {
+		return nil
+	}
+
+	agent, err := m.GetAgent(agentID)
+	if err != nil {
+		return err
+	}
+	if !agent.AutoRestart || agent.MaxRestarts <= 0 || agent.ContainerID == "" || agent.Status == StatusFailed {
+		return nil
+	}
+
+	inspectCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	info, err := m.dockerClient.ContainerInspect(inspectCtx, agent.ContainerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", docker.CheckTimeout(err, m.operationTimeout))
+	}
+
+	last, err := m.redisClient.Get(ctx, restartCountKey(agentID)).Int()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to get restart count: %w", err)
+	}
+
+	// A manual start resets Docker's counter
+	if info.RestartCount < last {
+		last = 0
+	}
+
+	for i := last; i < info.RestartCount; i++ {
+		exceeded, err := m.RecordRestart(ctx, agentID, "container exited", agent.MaxRestarts)
+		if err != nil {
+			return err
+		}
+		if exceeded {
+			return nil
+		}
+	}
+
+	if err := m.redisClient.Set(ctx, restartCountKey(agentID), info.RestartCount, 0).Err(); err != nil {
+		return fmt.Errorf("failed to save restart count: %w", err)
+	}
+
+	// Docker stops retrying once the on-failure retry count is used up
+	if action == "die" && info.State != nil && !info.State.Running &&
+		info.State.ExitCode != 0 && info.RestartCount >= agent.MaxRestarts {
+		window, _ := ParseRestartWindow(agent.RestartWindow)
+		return m.markFailed(ctx, agent, info.RestartCount, agent.MaxRestarts, window, "restart policy exhausted")
+	}
+
+	return nil
+}
+
+// restartPolicy is the Docker restart policy for the agent's container
+func (a *Agent) restartPolicy() container.RestartPolicy {
+	if !a.AutoRestart {
+		return container.RestartPolicy{Name: "no"}
+	}
+	if a.MaxRestarts > 0 {
+		// Let Docker give up after MaxRestarts; the manager enforces the window
+		return container.RestartPolicy{Name: "on-failure", MaximumRetryCount: a.MaxRestarts}
+	}
+	return container.RestartPolicy{Name: "always"}
+}
+
+// clearRestartHistory forgets recorded restarts, e.g. when a failed agent is started again
+func (m *Manager) clearRestartHistory(ctx context.Context, agentID string) {
+	if err := m.redisClient.Del(ctx, restartHistoryKey(agentID), restartCountKey(agentID)).Err(); err != nil {
+		log.Printf("Failed to clear restart history for agent %s: %v", agentID, err)
+	}
+}
+
+// resetRestarts gives a failed agent a fresh restart budget and restores the
+// restart policy markFailed disabled on its container
+func (m *Manager) resetRestarts(ctx context.Context, agent *Agent) {
+	m.clearRestartHistory(ctx, agent.ID)
+	if agent.ContainerID == "" {
+		return
+	}
+
+	updateCtx, cancel := m.dockerCtx(ctx)
+	defer cancel()
+	if _, err := m.dockerClient.ContainerUpdate(updateCtx, agent.ContainerID, container.UpdateConfig{
+		RestartPolicy: agent.restartPolicy(),
+	}); err != nil {
+		log.Printf("Failed to restore restart policy for agent %s: %v", agent.ID, err)
+	}
+}
+
+// markFailed stops a crash-looping agent and moves it to StatusFailed
+func (m *Manager) markFailed(ctx context.Context, agent *Agent, restarts, maxRestarts int, window time.Duration, reason string) error {
+	if agent.ContainerID != "" {
+		// Keep Docker from restarting the container behind our back
+		updateCtx, cancel := m.dockerCtx(ctx)
+		_, err := m.dockerClient.ContainerUpdate(updateCtx, agent.ContainerID, container.UpdateConfig{
+			RestartPolicy: container.RestartPolicy{Name: "no"},
+		})
+		cancel()
+		if err != nil {
+			log.Printf("Failed to disable restart policy for agent %s: %v", agent.ID, err)
+		}
+
+		timeout := agent.stopTimeoutSeconds()
+		stopCtx, cancelStop := m.stopCtx(ctx, timeout)
+		if err := m.dockerClient.ContainerStop(stopCtx, agent.ContainerID, container.StopOptions{Timeout: &timeout}); err != nil {
+			log.Printf("Failed to stop agent %s after restart limit: %v", agent.ID, err)
+		}
+		cancelStop()
+	}
+
+	previous := agent.Status
+	agent.Status = StatusFailed
+	agent.UpdatedAt = time.Now()
+	if err := m.saveAgent(agent); err != nil {
+		return fmt.Errorf("failed to save agent: %w", err)
+	}
+	m.recordEvent(ctx, agent.ID, EventFailed, previous, StatusFailed,
+		fmt.Sprintf("%s: %d restarts within %s (max %d)", reason, restarts, window, maxRestarts))
+	m.notifyStopped(agent.ID)
+
+	m.redisClient.Publish(ctx, fmt.Sprintf("agent:status:%s", agent.ID), string(StatusFailed))
+
+	logging.Error("agent", "Agent exceeded restart limit", map[string]interface{}{
+		"agent_id":     agent.ID,
+		"restarts":     restarts,
+		"max_restarts": maxRestarts,
+		"window":       window.String(),
+		"reason":       reason,
+	})
+
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     "system",
+		Action:     "restart_limit_exceeded",
+		Resource:   "agent",
+		ResourceID: agent.ID,
+		Result:     "failure",
+		Details: map[string]interface{}{
+			"name":         agent.Name,
+			"restarts":     restarts,
+			"max_restarts": maxRestarts,
+			"window":       window.String(),
+			"reason":       reason,
+		},
+	})
+
+	return nil
+}
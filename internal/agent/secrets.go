@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/agentainer/agentainer-lab/internal/secrets"
+)
+
+// SetSecretStore sets the store that secret references are resolved from
+// when an agent's container is created
+func (m *Manager) SetSecretStore(store *secrets.Store) {
+	m.secrets = store
+}
+
+// validateSecretRefs checks that every referenced secret exists and that no
+// environment variable is set both directly and from a secret
+func (m *Manager) validateSecretRefs(ctx context.Context, refs map[string]string, envVars map[string]string) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	if m.secrets == nil {
+		return fmt.Errorf("secrets are not available on this server")
+	}
+
+	for envName, secretName := range refs {
+		if envName == "" {
+			return fmt.Errorf("secret reference for '%s' has no environment variable name", secretName)
+		}
+		if _, ok := envVars[envName]; ok {
+			return fmt.Errorf("environment variable %s is set both directly and from a secret", envName)
+		}
+		exists, err := m.secrets.Exists(ctx, secretName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("secret '%s' referenced by %s does not exist", secretName, envName)
+		}
+	}
+	return nil
+}
+
+// resolveSecrets decrypts an agent's secret references for its container
+// environment. The values are never written back to the agent.
+func (m *Manager) resolveSecrets(ctx context.Context, agent *Agent) (map[string]string, error) {
+	if len(agent.Secrets) == 0 {
+		return nil, nil
+	}
+	if m.secrets == nil {
+		return nil, fmt.Errorf("agent references secrets but no secret store is configured")
+	}
+	return m.secrets.Resolve(ctx, agent.Secrets)
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStopTimeout is how long an agent has to exit after its stop signal
+// before it is killed
+const DefaultStopTimeout = 10 * time.Second
+
+// stopSignals are the signal names an agent can be stopped with
+var stopSignals = map[string]bool{
+	"SIGHUP":  true,
+	"SIGINT":  true,
+	"SIGQUIT": true,
+	"SIGKILL": true,
+	"SIGUSR1": true,
+	"SIGUSR2": true,
+	"SIGTERM": true,
+	"SIGWINCH": true,
+	"SIGPWR":  true,
+}
+
+// ParseStopSignal normalizes a stop signal such as INT, SIGINT or 2. An
+// empty signal keeps the image's STOPSIGNAL, which is SIGTERM by default.
+func ParseStopSignal(signal string) (string, error) {
+	if signal == "" {
+		return "", nil
+	}
+	if n, err := strconv.Atoi(signal); err == nil {
+		if n < 1 || n > 64 {
+			return "", fmt.Errorf("invalid stop signal %d (must be between 1 and 64)", n)
+		}
+		return signal, nil
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if !stopSignals[name] {
+		return "", fmt.Errorf("invalid stop signal %q (e.g. SIGTERM, SIGINT or SIGQUIT)", signal)
+	}
+	return name, nil
+}
+
+// ParseStopTimeout parses how long an agent has to exit after its stop
+// signal. An empty timeout means DefaultStopTimeout.
+func ParseStopTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return DefaultStopTimeout, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid stop timeout %q: %w", timeout, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("stop timeout must not be negative")
+	}
+	return d, nil
+}
+
+// stopTimeoutSeconds is the agent's stop timeout in whole seconds, as Docker
+// expects it
+func (a *Agent) stopTimeoutSeconds() int {
+	// Validated at deploy
+	d, err := ParseStopTimeout(a.StopTimeout)
+	if err != nil {
+		d = DefaultStopTimeout
+	}
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// checkVolumeConflicts rejects mounting a host path that a running agent
+// already mounts, unless both mounts are read-only. Agents deployed
+// with SharedVolumes only get a warning. Replicas of the same agent are
+// expected to share their volumes and are never treated as conflicting.
+func (m *Manager) checkVolumeConflicts(candidate *Agent) error {
+	if len(candidate.Volumes) == 0 {
+		return nil
+	}
+
+	agents, err := m.loadAgents()
+	if err != nil {
+		return fmt.Errorf("failed to check volume usage: %w", err)
+	}
+
+	family := candidate.replicaFamily()
+	for i := range agents {
+		other := &agents[i]
+		if other.ID == candidate.ID || other.Status != StatusRunning || other.replicaFamily() == family {
+			continue
+		}
+
+		for _, volume := range candidate.Volumes {
+			for _, otherVolume := range other.Volumes {
+				if volume.ReadOnly && otherVolume.ReadOnly {
+					continue
+				}
+				if !pathsOverlap(volume.HostPath, otherVolume.HostPath) {
+					continue
+				}
+
+				if candidate.SharedVolumes {
+					log.Printf("Warning: volume %s of agent %s is also mounted by running agent %s (%s)",
+						volume.HostPath, candidate.Name, other.Name, other.ID)
+					continue
+				}
+				return fmt.Errorf("volume %s overlaps %s, which is already mounted by running agent %s (%s); deploy with shared volumes enabled to allow this",
+					volume.HostPath, otherVolume.HostPath, other.Name, other.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// replicaFamily identifies an agent and all of its replicas
+func (a *Agent) replicaFamily() string {
+	if a.ReplicaOf != "" {
+		return a.ReplicaOf
+	}
+	return a.ID
+}
+
+// pathsOverlap reports whether two host paths are the same or one contains the other
+func pathsOverlap(a, b string) bool {
+	a, b = normalizeHostPath(a), normalizeHostPath(b)
+	if a == b {
+		return true
+	}
+	return strings.HasPrefix(a, withTrailingSeparator(b)) || strings.HasPrefix(b, withTrailingSeparator(a))
+}
+
+func normalizeHostPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return filepath.Clean(path)
+}
+
+func withTrailingSeparator(path string) string {
+	if strings.HasSuffix(path, string(filepath.Separator)) {
+		return path
+	}
+	return path + string(filepath.Separator)
+}
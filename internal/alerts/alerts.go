@@ -0,0 +1,272 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/agentainer/agentainer-lab/internal/agent"
+	"github.com/agentainer/agentainer-lab/internal/config"
+	"github.com/agentainer/agentainer-lab/internal/health"
+	"github.com/agentainer/agentainer-lab/pkg/metrics"
+)
+
+// Metrics an alert rule can watch
+const (
+	MetricCPUPercent     = "cpu_percent"
+	MetricMemoryPercent  = "memory_percent"
+	MetricMemoryBytes    = "memory_bytes"
+	MetricHealthFailures = "health_failures"
+)
+
+// Alert states
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// defaultInterval is how often rules are evaluated when no interval is set
+const defaultInterval = 30 * time.Second
+
+// Alert is a rule that is breached by an agent
+type Alert struct {
+	Rule       string     `json:"rule"`
+	AgentID    string     `json:"agent_id"`
+	AgentName  string     `json:"agent_name"`
+	Metric     string     `json:"metric"`
+	Comparator string     `json:"comparator"`
+	Threshold  float64    `json:"threshold"`
+	// Value is the metric's value when the alert was last evaluated
+	Value      float64    `json:"value"`
+	State      string     `json:"state"`
+	// Since is when the rule was first breached
+	Since      time.Time  `json:"since"`
+	FiredAt    time.Time  `json:"fired_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Manager evaluates alert rules against the metrics collector and health
+// monitor and notifies when alerts fire and resolve. An alert is notified
+// once when it fires and once when it resolves.
+type Manager struct {
+	rules            []config.AlertRule
+	interval         time.Duration
+	agentMgr         *agent.Manager
+	metricsCollector *metrics.Collector
+	healthMonitor    *health.Monitor
+	notifier         *notifier
+	
+	mu      sync.Mutex
+	// breaches tracks every rule and agent pair currently over its
+	// threshold, keyed by rule name and agent ID; pending ones have a zero
+	// FiredAt
+	breaches map[string]*Alert
+}
+
+// NewManager validates the configured rules
+func NewManager(cfg config.AlertsConfig, agentMgr *agent.Manager, metricsCollector *metrics.Collector, healthMonitor *health.Monitor) (*Manager, error) {
+	rules, err := ValidateRules(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	
+	return &Manager{
+		rules:            rules,
+		interval:         interval,
+		agentMgr:         agentMgr,
+		metricsCollector: metricsCollector,
+		healthMonitor:    healthMonitor,
+		notifier:         newNotifier(cfg.WebhookURL, cfg.SlackURL),
+		breaches:         make(map[string]*Alert),
+	}, nil
+}
+
+// ValidateRules checks alert rules and names the unnamed ones after their
+// condition
+func ValidateRules(rules []config.AlertRule) ([]config.AlertRule, error) {
+	validated := make([]config.AlertRule, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		switch rule.Metric {
+		case MetricCPUPercent, MetricMemoryPercent, MetricMemoryBytes, MetricHealthFailures:
+		default:
+			return nil, fmt.Errorf("alert rule %d: unknown metric '%s' (use cpu_percent, memory_percent, memory_bytes or health_failures)", i+1, rule.Metric)
+		}
+		if _, err := compare(rule.Comparator, 0, 0); err != nil {
+			return nil, fmt.Errorf("alert rule %d: %w", i+1, err)
+		}
+		if rule.Duration < 0 {
+			return nil, fmt.Errorf("alert rule %d: duration must not be negative", i+1)
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("%s %s %g", rule.Metric, rule.Comparator, rule.Threshold)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %d: duplicate name '%s'", i+1, rule.Name)
+		}
+		names[rule.Name] = true
+		validated = append(validated, rule)
+	}
+	return validated, nil
+}
+
+// Rules returns the rules being evaluated
+func (m *Manager) Rules() []config.AlertRule {
+	return m.rules
+}
+
+// Start evaluates the rules every interval until ctx is cancelled
+func (m *Manager) Start(ctx context.Context) error {
+	if len(m.rules) == 0 {
+		<-ctx.Done()
+		return nil
+	}
+	log.Printf("Evaluating %d alert rules every %s", len(m.rules), m.interval)
+	
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ticker.C:
+			m.Evaluate(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Active returns the firing alerts, oldest first
+func (m *Manager) Active() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	active := make([]Alert, 0, len(m.breaches))
+	for _, alert := range m.breaches {
+		if alert.State == StateFiring {
+			active = append(active, *alert)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].FiredAt.Before(active[j].FiredAt) })
+	return active
+}
+
+// Evaluate checks every rule against the running agents once, firing
+// alerts whose rule has been breached for its duration and resolving those
+// that are no longer breached
+func (m *Manager) Evaluate(ctx context.Context) {
+	agents, err := m.agentMgr.ListAgents("")
+	if err != nil {
+		log.Printf("Failed to list agents for alerting: %v", err)
+		return
+	}
+	
+	now := time.Now()
+	breached := make(map[string]bool)
+	var notify []Alert
+	
+	m.mu.Lock()
+	for _, rule := range m.rules {
+		filter := agent.ListFilter{Status: agent.StatusRunning, Labels: rule.Labels}
+		for i := range agents {
+			agentObj := &agents[i]
+			if !filter.Matches(agentObj) {
+				continue
+			}
+			value, ok := m.value(rule.Metric, agentObj.ID)
+			if !ok {
+				continue
+			}
+			if over, _ := compare(rule.Comparator, value, rule.Threshold); !over {
+				continue
+			}
+			
+			key := rule.Name + "/" + agentObj.ID
+			breached[key] = true
+			alert, ok := m.breaches[key]
+			if !ok {
+				alert = &Alert{
+					Rule:       rule.Name,
+					AgentID:    agentObj.ID,
+					AgentName:  agentObj.Name,
+					Metric:     rule.Metric,
+					Comparator: rule.Comparator,
+					Threshold:  rule.Threshold,
+					Since:      now,
+				}
+				m.breaches[key] = alert
+			}
+			alert.Value = value
+			if alert.State != StateFiring && now.Sub(alert.Since) >= rule.Duration {
+				alert.State = StateFiring
+				alert.FiredAt = now
+				notify = append(notify, *alert)
+			}
+		}
+	}
+	
+	for key, alert := range m.breaches {
+		if breached[key] {
+			continue
+		}
+		delete(m.breaches, key)
+		if alert.State == StateFiring {
+			alert.State = StateResolved
+			alert.ResolvedAt = &now
+			notify = append(notify, *alert)
+		}
+	}
+	m.mu.Unlock()
+	
+	for _, alert := range notify {
+		log.Printf("Alert %s: %s for agent %s (%s = %g)", alert.State, alert.Rule, alert.AgentID, alert.Metric, alert.Value)
+		m.notifier.send(ctx, alert)
+	}
+}
+
+// value returns the current value of a metric for an agent, or false when
+// there is no data for it yet
+func (m *Manager) value(metric, agentID string) (float64, bool) {
+	if metric == MetricHealthFailures {
+		status, err := m.healthMonitor.GetStatus(agentID)
+		if err != nil {
+			return 0, false
+		}
+		return float64(status.FailureCount), true
+	}
+	
+	current, err := m.metricsCollector.GetMetrics(agentID)
+	if err != nil {
+		return 0, false
+	}
+	switch metric {
+	case MetricCPUPercent:
+		return current.CPU.UsagePercent, true
+	case MetricMemoryPercent:
+		return current.Memory.UsagePercent, true
+	case MetricMemoryBytes:
+		return float64(current.Memory.Usage), true
+	}
+	return 0, false
+}
+
+func compare(comparator string, value, threshold float64) (bool, error) {
+	switch comparator {
+	case ">":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	}
+	return false, fmt.Errorf("unknown comparator '%s' (use >, >=, < or <=)", comparator)
+}
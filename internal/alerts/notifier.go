@@ -0,0 +1,75 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// notifier posts alerts to the configured webhook and Slack URLs
+type notifier struct {
+	webhookURL string
+	slackURL   string
+	client     *http.Client
+}
+
+func newNotifier(webhookURL, slackURL string) *notifier {
+	return &notifier{
+		webhookURL: webhookURL,
+		slackURL:   slackURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// send delivers an alert to every configured destination. Failures are
+// logged; the alert stays active either way.
+func (n *notifier) send(ctx context.Context, alert Alert) {
+	if n.webhookURL != "" {
+		if err := n.post(ctx, n.webhookURL, alert); err != nil {
+			log.Printf("Failed to send alert to webhook: %v", err)
+		}
+	}
+	if n.slackURL != "" {
+		message := map[string]string{"text": slackText(alert)}
+		if err := n.post(ctx, n.slackURL, message); err != nil {
+			log.Printf("Failed to send alert to Slack: %v", err)
+		}
+	}
+}
+
+func (n *notifier) post(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func slackText(alert Alert) string {
+	name := alert.AgentName
+	if name == "" {
+		name = alert.AgentID
+	}
+	if alert.State == StateResolved {
+		return fmt.Sprintf("[RESOLVED] %s on agent %s", alert.Rule, name)
+	}
+	return fmt.Sprintf("[FIRING] %s on agent %s: %s is %g (%s %g)", alert.Rule, name, alert.Metric, alert.Value, alert.Comparator, alert.Threshold)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/agentainer/agentainer-lab/internal/logging"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditPage is one page of audit entries, oldest first. NextBefore and
+// NextAfter are the cursors for the neighbouring pages and are only set
+// when this page is full.
+type AuditPage struct {
+	Entries    []logging.AuditEntry `json:"entries"`
+	NextBefore string               `json:"next_before,omitempty"`
+	NextAfter  string               `json:"next_after,omitempty"`
+}
+
+func (s *Server) getAuditLogsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := logging.AuditFilter{
+		UserID:     query.Get("user"),
+		Action:     query.Get("action"),
+		Resource:   query.Get("resource"),
+		ResourceID: query.Get("resource_id"),
+		Result:     query.Get("result"),
+		Duration:   24 * time.Hour,
+		Limit:      defaultAuditLimit,
+	}
+
+	if filter.Result != "" && filter.Result != "success" && filter.Result != "failure" {
+		s.sendError(w, http.StatusBadRequest, "Invalid result (expected success or failure)")
+		return
+	}
+	if durationStr := query.Get("duration"); durationStr != "" {
+		d, err := time.ParseDuration(durationStr)
+		if err != nil || d <= 0 {
+			s.sendError(w, http.StatusBadRequest, "Invalid duration")
+			return
+		}
+		filter.Duration = d
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			s.sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit (1-%d)", maxAuditLimit))
+			return
+		}
+		filter.Limit = n
+	}
+	for name, cursor := range map[string]*time.Time{"before": &filter.Before, "after": &filter.After} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s: expected an RFC 3339 timestamp", name))
+			return
+		}
+		*cursor = t
+	}
+
+	entries, err := logging.GetAuditLogs(r.Context(), filter)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get audit logs: %v", err))
+		return
+	}
+
+	page := AuditPage{Entries: entries}
+	if len(entries) == filter.Limit {
+		if filter.After.IsZero() {
+			page.NextBefore = entries[0].Timestamp.Format(time.RFC3339Nano)
+		} else {
+			page.NextAfter = entries[len(entries)-1].Timestamp.Format(time.RFC3339Nano)
+		}
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: fmt.Sprintf("Found %d audit entries", len(entries)),
+		Data:    page,
+	})
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// flushWriter flushes after every write so followed logs reach the client
+// as they are produced
+type flushWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return n, err
+}
+
+// sseLogWriter frames each complete log line as a Server-Sent Event named
+// after its stream. Writers for stdout and stderr share a mutex so events
+// are never interleaved.
+type sseLogWriter struct {
+	mu      *sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	event   string
+	partial []byte
+}
+
+func (s *sseLogWriter) Write(p []byte) (int, error) {
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(s.partial[:i]), "\r")
+		s.partial = s.partial[i+1:]
+		if err := s.send(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close sends any trailing line that had no newline
+func (s *sseLogWriter) Close() error {
+	if len(s.partial) == 0 {
+		return nil
+	}
+	line := string(s.partial)
+	s.partial = nil
+	return s.send(line)
+}
+
+func (s *sseLogWriter) send(line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", s.event, line); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/agentainer/agentainer-lab/internal/agent"
+	"github.com/agentainer/agentainer-lab/pkg/metrics"
+)
+
+// promGauge describes one per-agent gauge in the Prometheus exposition
+type promGauge struct {
+	name  string
+	help  string
+	value func(m *metrics.Metrics) float64
+}
+
+var agentGauges = []promGauge{
+	{"agentainer_agent_cpu_usage_percent", "CPU usage of the agent container in percent.",
+		func(m *metrics.Metrics) float64 { return m.CPU.UsagePercent }},
+	{"agentainer_agent_memory_usage_bytes", "Memory used by the agent container.",
+		func(m *metrics.Metrics) float64 { return float64(m.Memory.Usage) }},
+	{"agentainer_agent_memory_limit_bytes", "Memory limit of the agent container.",
+		func(m *metrics.Metrics) float64 { return float64(m.Memory.Limit) }},
+	{"agentainer_agent_network_receive_bytes", "Bytes received by the agent container since it started.",
+		func(m *metrics.Metrics) float64 { return float64(m.Network.RxBytes) }},
+	{"agentainer_agent_network_transmit_bytes", "Bytes sent by the agent container since it started.",
+		func(m *metrics.Metrics) float64 { return float64(m.Network.TxBytes) }},
+	{"agentainer_agent_disk_read_bytes", "Bytes read from disk by the agent container since it started.",
+		func(m *metrics.Metrics) float64 { return float64(m.Disk.ReadBytes) }},
+	{"agentainer_agent_disk_write_bytes", "Bytes written to disk by the agent container since it started.",
+		func(m *metrics.Metrics) float64 { return float64(m.Disk.WriteBytes) }},
+}
+
+// prometheusMetricsHandler exports agent metrics in the Prometheus text format
+func (s *Server) prometheusMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	agents, err := s.agentMgr.ListAgents("")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to list agents: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+
+	// Agent counts by status
+	counts := make(map[agent.Status]int)
+	for _, a := range agents {
+		counts[a.Status]++
+	}
+	writePromHeader(&buf, "agentainer_agents", "Number of agents by status.")
+	for _, status := range []agent.Status{agent.StatusCreated, agent.StatusRunning, agent.StatusStopped, agent.StatusPaused, agent.StatusFailed} {
+		fmt.Fprintf(&buf, "agentainer_agents{status=\"%s\"} %d\n", status, counts[status])
+	}
+
+	writePromHeader(&buf, "agentainer_agent_up", "Whether the agent is running (1) or not (0).")
+	for _, a := range agents {
+		up := 0
+		if a.Status == agent.StatusRunning {
+			up = 1
+		}
+		fmt.Fprintf(&buf, "agentainer_agent_up{%s} %d\n", promAgentLabels(&a), up)
+	}
+
+	// Resource gauges for agents that have metrics
+	type sample struct {
+		labels  string
+		metrics *metrics.Metrics
+	}
+	var samples []sample
+	for i := range agents {
+		m, err := s.metricsCollector.GetMetrics(agents[i].ID)
+		if err != nil || m == nil {
+			continue
+		}
+		samples = append(samples, sample{promAgentLabels(&agents[i]), m})
+	}
+
+	for _, gauge := range agentGauges {
+		writePromHeader(&buf, gauge.name, gauge.help)
+		for _, sm := range samples {
+			fmt.Fprintf(&buf, "%s{%s} %g\n", gauge.name, sm.labels, gauge.value(sm.metrics))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func writePromHeader(buf *bytes.Buffer, name, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+func promAgentLabels(a *agent.Agent) string {
+	return fmt.Sprintf("agent_id=\"%s\",agent_name=\"%s\",namespace=\"%s\"",
+		promEscape(a.ID), promEscape(a.Name), promEscape(a.Namespace))
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promEscape(value string) string {
+	return promLabelEscaper.Replace(value)
+}
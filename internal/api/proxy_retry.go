@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	proxyRetryBaseDelay = 100 * time.Millisecond
+	proxyRetryMaxDelay  = 2 * time.Second
+)
+
+// errProxyTimeout is returned when an agent doesn't send its response
+// headers within its proxy timeout
+var errProxyTimeout = errors.New("agent did not respond within the proxy timeout")
+
+// retryTransport applies an agent's proxy timeout and retries idempotent
+// requests that fail to connect or get a 502/503 from the agent.
+// The timeout only bounds the wait for response headers, so streamed
+// responses aren't cut off once they have started.
+type retryTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+	retries int
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retries := t.retries
+	if !retryable(req) {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.roundTrip(req)
+		if attempt >= retries || !shouldRetry(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		if resp != nil {
+			// Drain so the connection can be reused for the next attempt
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+
+		delay := proxyRetryBaseDelay << attempt
+		if delay > proxyRetryMaxDelay {
+			delay = proxyRetryMaxDelay
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// roundTrip makes one attempt, cancelling it if the response headers don't
+// arrive within the timeout
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(t.timeout, func() { cancel(errProxyTimeout) })
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		timer.Stop()
+		if context.Cause(ctx) == errProxyTimeout {
+			err = fmt.Errorf("%w (%s)", errProxyTimeout, t.timeout)
+		}
+		cancel(nil)
+		return nil, err
+	}
+	if !timer.Stop() && context.Cause(ctx) == errProxyTimeout {
+		resp.Body.Close()
+		cancel(nil)
+		return nil, fmt.Errorf("%w (%s)", errProxyTimeout, t.timeout)
+	}
+
+	// Upgraded connections need their body to stay an io.ReadWriteCloser; their
+	// context is released when the handler returns
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return resp, nil
+	}
+
+	// The body is read after RoundTrip returns, so the context lives until it is closed
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: func() { cancel(nil) }}
+	return resp, nil
+}
+
+// retryable reports whether a request can safely be sent again
+func retryable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// shouldRetry reports whether an attempt failed in a way another attempt may fix
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		// A request that timed out already waited as long as it is allowed to
+		return !errors.Is(err, errProxyTimeout)
+	}
+	return resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// proxyErrorHandler reports timed out requests as 504 rather than the
+// reverse proxy's default 502
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("http: proxy error: %v", err)
+	if errors.Is(err, errProxyTimeout) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/agentainer/agentainer-lab/internal/requests"
+)
+
+// proxyTimer records when a proxied request reaches each stage so the
+// response can report how much latency came from Agentainer and how much
+// from the agent
+type proxyTimer struct {
+	received time.Time
+
+	mu           sync.Mutex
+	forwarded    time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+// trace attaches the timer to an outgoing request
+func (p *proxyTimer) trace(req *http.Request) *http.Request {
+	p.mu.Lock()
+	p.forwarded = time.Now()
+	p.mu.Unlock()
+
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) { p.mark(&p.connectStart) },
+		ConnectDone:  func(network, addr string, err error) { p.mark(&p.connectDone) },
+		WroteRequest: func(httptrace.WroteRequestInfo) { p.mark(&p.wroteRequest) },
+		GotFirstResponseByte: func() { p.mark(&p.firstByte) },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (p *proxyTimer) mark(t *time.Time) {
+	p.mu.Lock()
+	*t = time.Now()
+	p.mu.Unlock()
+}
+
+// timing summarises the request up to the point its response headers arrived
+func (p *proxyTimer) timing() *requests.Timing {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	timing := &requests.Timing{
+		Proxy: millis(p.forwarded.Sub(p.received)),
+		Total: millis(now.Sub(p.received)),
+	}
+	// A reused pooled connection has no connect phase
+	if !p.connectStart.IsZero() && !p.connectDone.IsZero() {
+		timing.Connect = millis(p.connectDone.Sub(p.connectStart))
+	}
+	if !p.firstByte.IsZero() {
+		sent := p.wroteRequest
+		if sent.IsZero() {
+			sent = p.forwarded
+		}
+		timing.Agent = millis(p.firstByte.Sub(sent))
+	}
+	return timing
+}
+
+// serverTimingHeader formats timing as a Server-Timing header value
+func serverTimingHeader(t *requests.Timing) string {
+	return fmt.Sprintf("proxy;dur=%.1f;desc=\"Agentainer\", connect;dur=%.1f, agent;dur=%.1f;desc=\"Time to first byte\", total;dur=%.1f",
+		t.Proxy, t.Connect, t.Agent, t.Total)
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
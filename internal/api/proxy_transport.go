@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/agentainer/agentainer-lab/internal/config"
+)
+
+// proxyTransports keeps a pooled http.Transport per agent so that idle
+// connections to an agent can be dropped when it stops or is removed.
+// A recreated container gets a new IP, and reusing a connection to the
+// old one fails.
+type proxyTransports struct {
+	config config.ProxyConfig
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func newProxyTransports(cfg config.ProxyConfig) *proxyTransports {
+	return &proxyTransports{
+		config:     cfg,
+		transports: make(map[string]*http.Transport),
+	}
+}
+
+// get returns the transport used to reach an agent, creating it on first use
+func (p *proxyTransports) get(agentID string) *http.Transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if transport, ok := p.transports[agentID]; ok {
+		return transport
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: p.config.KeepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          p.config.MaxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   p.config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       p.config.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	p.transports[agentID] = transport
+	return transport
+}
+
+// closeIdle drops pooled connections to an agent and forgets its transport
+func (p *proxyTransports) closeIdle(agentID string) {
+	p.mu.Lock()
+	transport, ok := p.transports[agentID]
+	delete(p.transports, agentID)
+	p.mu.Unlock()
+
+	if ok {
+		transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript refills an agent's bucket for the time since it was last
+// used and takes one token if available. It returns {allowed, wait_ms}.
+// Running it in Redis keeps the limit shared across server instances.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, wait}
+`)
+
+// rateLimiter enforces per-agent request rates in the proxy
+type rateLimiter struct {
+	redisClient *redis.Client
+}
+
+func newRateLimiter(redisClient *redis.Client) *rateLimiter {
+	return &rateLimiter{redisClient: redisClient}
+}
+
+// allow takes a token from the agent's bucket. When the bucket is empty it
+// returns how long until the next token is available.
+func (l *rateLimiter) allow(ctx context.Context, agentID string, rate float64, burst int) (bool, time.Duration, error) {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+
+	key := fmt.Sprintf("agent:%s:ratelimit", agentID)
+	result, err := tokenBucketScript.Run(ctx, l.redisClient, []string{key}, rate, burst, time.Now().UnixMilli()).Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit result: %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	waitMs, _ := result[1].(int64)
+	return allowed == 1, time.Duration(waitMs) * time.Millisecond, nil
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds for the Retry-After header
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
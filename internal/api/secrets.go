@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/agentainer/agentainer-lab/internal/logging"
+	"github.com/agentainer/agentainer-lab/internal/secrets"
+)
+
+// maxSecretSize bounds a single secret value
+const maxSecretSize = 64 << 10
+
+// SecretRequest creates or replaces a secret
+type SecretRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (s *Server) listSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := s.secretStore.List(r.Context())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list secrets: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: fmt.Sprintf("Found %d secrets", len(list)),
+		Data:    list,
+	})
+}
+
+func (s *Server) setSecretHandler(w http.ResponseWriter, r *http.Request) {
+	var req SecretRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSecretSize+1024)).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := secrets.ValidateName(req.Name); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Value) > maxSecretSize {
+		s.sendError(w, http.StatusBadRequest, fmt.Sprintf("Secret value too large (max %d bytes)", maxSecretSize))
+		return
+	}
+
+	secret, err := s.secretStore.Set(r.Context(), req.Name, req.Value)
+	
+	// The value is never logged
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     s.getUserID(r),
+		Action:     "set_secret",
+		Resource:   "secret",
+		ResourceID: req.Name,
+		Result:     result,
+		IP:         s.getClientIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+	
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save secret: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Secret saved",
+		Data:    secret,
+	})
+}
+
+func (s *Server) deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	err := s.secretStore.Delete(r.Context(), name)
+	
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     s.getUserID(r),
+		Action:     "delete_secret",
+		Resource:   "secret",
+		ResourceID: name,
+		Result:     result,
+		IP:         s.getClientIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+	
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, secrets.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		s.sendError(w, status, fmt.Sprintf("Failed to delete secret: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Secret deleted",
+	})
+}
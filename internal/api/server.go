@@ -4,23 +4,34 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/agentainer/agentainer-lab/internal/agent"
+	"github.com/agentainer/agentainer-lab/internal/alerts"
 	"github.com/agentainer/agentainer-lab/internal/config"
 	"github.com/agentainer/agentainer-lab/internal/health"
 	"github.com/agentainer/agentainer-lab/internal/logging"
 	"github.com/agentainer/agentainer-lab/internal/requests"
+	"github.com/agentainer/agentainer-lab/internal/secrets"
 	"github.com/agentainer/agentainer-lab/internal/storage"
+	"github.com/agentainer/agentainer-lab/internal/supervisor"
+	"github.com/agentainer/agentainer-lab/internal/tokens"
+	statesync "github.com/agentainer/agentainer-lab/internal/sync"
+	"github.com/agentainer/agentainer-lab/pkg/docker"
 	"github.com/agentainer/agentainer-lab/pkg/metrics"
 )
 
@@ -30,39 +41,134 @@ type Server struct {
 	storage          *storage.Storage
 	metricsCollector *metrics.Collector
 	requestMgr       *requests.Manager
+	secretStore      *secrets.Store
+	tokenStore       *tokens.Store
 	healthMonitor    *health.Monitor
 	dockerClient     *client.Client
+	supervisor       *supervisor.Supervisor
+	proxyTransports  *proxyTransports
+	rateLimiter      *rateLimiter
+	httpServer       *http.Server
+	stateSync        *statesync.StateSynchronizer
+	alerts           *alerts.Manager
+	
+	// background is cancelled by Stop to end the supervised loops
+	background       context.Context
+	stopBackground   context.CancelFunc
+	stopOnce         sync.Once
+	
+	// replicaCursors holds a *uint64 per agent for round-robin proxying
+	replicaCursors   sync.Map
 }
 
 type DeployRequest struct {
 	Name        string                 `json:"name"`
+	Namespace   string                 `json:"namespace,omitempty"`
+	Labels      map[string]string      `json:"labels,omitempty"`
 	Image       string                 `json:"image"`
 	EnvVars     map[string]string      `json:"env_vars"`
 	CPULimit    int64                  `json:"cpu_limit"`
 	MemoryLimit int64                  `json:"memory_limit"`
+	MemorySwap  int64                  `json:"memory_swap,omitempty"`
+	MemoryReservation int64            `json:"memory_reservation,omitempty"`
+	CPUShares   int64                  `json:"cpu_shares,omitempty"`
+	PidsLimit   int64                  `json:"pids_limit,omitempty"`
+	GPUs        string                 `json:"gpus,omitempty"`
+	Networks    []string               `json:"networks,omitempty"`
+	NetworkAliases []string            `json:"network_aliases,omitempty"`
+	InjectRedis bool                   `json:"inject_redis,omitempty"`
+	InjectAPI   bool                   `json:"inject_api,omitempty"`
+	Ulimits     []agent.Ulimit         `json:"ulimits,omitempty"`
+	// Size names a resource preset; explicit CPU and memory limits take precedence
+	Size        string                 `json:"size,omitempty"`
 	AutoRestart bool                   `json:"auto_restart"`
+	MaxRestarts int                    `json:"max_restarts,omitempty"`
+	RestartWindow string               `json:"restart_window,omitempty"`
+	ReplayRateLimit float64            `json:"replay_rate_limit,omitempty"`
 	Token       string                 `json:"token"`
 	Ports       []agent.PortMapping    `json:"ports"`
+	ProxyPort   int                    `json:"proxy_port,omitempty"`
+	Routes      map[string]int         `json:"routes,omitempty"`
 	Volumes     []agent.VolumeMapping  `json:"volumes"`
+	SharedVolumes bool                 `json:"shared_volumes,omitempty"`
+	AllowArchMismatch bool             `json:"allow_arch_mismatch,omitempty"`
+	RateLimit   float64                `json:"rate_limit,omitempty"`
+	RateLimitBurst int                 `json:"rate_limit_burst,omitempty"`
+	ProxyTimeout string                `json:"proxy_timeout,omitempty"`
+	ProxyRetries int                   `json:"proxy_retries,omitempty"`
+	StopSignal  string                 `json:"stop_signal,omitempty"`
+	StopTimeout string                 `json:"stop_timeout,omitempty"`
+	// RegistryAuth overrides the configured credentials for pulling Image;
+	// Host defaults to the image's registry
+	RegistryAuth *docker.RegistryAuth  `json:"registry_auth,omitempty"`
+	PullPolicy  string                 `json:"pull_policy,omitempty"`
+	// PinDigest creates the container from the digest the image resolves to
+	PinDigest   bool                   `json:"pin_digest,omitempty"`
+	// Secrets maps environment variable names to stored secret names
+	Secrets     map[string]string      `json:"secrets,omitempty"`
 	HealthCheck *agent.HealthCheckConfig `json:"health_check,omitempty"`
 }
 
+// BuildEvent is a single line of the newline-delimited JSON stream
+// returned by POST /agents/build
+type BuildEvent struct {
+	Type    string       `json:"type"` // progress, error or complete
+	Message string       `json:"message,omitempty"`
+	Image   string       `json:"image,omitempty"`
+	// Size is the built image's size in bytes, set on complete events
+	Size    int64        `json:"size,omitempty"`
+	Agent   *agent.Agent `json:"agent,omitempty"`
+}
+
+// maxBuildContextSize caps the size of an uploaded build context
+const maxBuildContextSize = 512 << 20
+
 type Response struct {
 	Success bool        `json:"success"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
 }
 
-func NewServer(config *config.Config, agentMgr *agent.Manager, storage *storage.Storage, metricsCollector *metrics.Collector, redisClient *redis.Client, dockerClient *client.Client) *Server {
-	return &Server{
+func NewServer(config *config.Config, agentMgr *agent.Manager, storage *storage.Storage, store storage.Store, metricsCollector *metrics.Collector, redisClient *redis.Client, dockerClient *client.Client, secretStore *secrets.Store) *Server {
+	s := &Server{
 		config:           config,
 		agentMgr:         agentMgr,
 		storage:          storage,
 		metricsCollector: metricsCollector,
-		requestMgr:       requests.NewManager(redisClient),
+		requestMgr:       requests.NewManager(redisClient, store),
+		secretStore:      secretStore,
+		tokenStore:       tokens.NewStore(redisClient),
 		healthMonitor:    health.NewMonitor(agentMgr, redisClient),
 		dockerClient:     dockerClient,
+		supervisor:       supervisor.New(),
+		proxyTransports:  newProxyTransports(config.Proxy),
+		rateLimiter:      newRateLimiter(redisClient),
+		httpServer:       &http.Server{},
+	}
+	s.background, s.stopBackground = context.WithCancel(context.Background())
+	
+	if size, err := config.ReplayMaxBodySize(); err != nil {
+		log.Printf("%v; storing bodies up to %d bytes", err, requests.DefaultMaxBodySize)
+	} else {
+		s.requestMgr.SetMaxBodySize(size)
 	}
+	
+	alertMgr, err := alerts.NewManager(config.Alerts, agentMgr, metricsCollector, s.healthMonitor)
+	if err != nil {
+		log.Printf("Alerting disabled: %v", err)
+	} else {
+		s.alerts = alertMgr
+	}
+	
+	// Don't reuse pooled connections to a container that is gone
+	agentMgr.OnStop(s.proxyTransports.closeIdle)
+	
+	return s
+}
+
+// SetStateSynchronizer enables the drift and reconcile endpoints
+func (s *Server) SetStateSynchronizer(stateSync *statesync.StateSynchronizer) {
+	s.stateSync = stateSync
 }
 
 func (s *Server) Start() error {
@@ -73,9 +179,18 @@ func (s *Server) Start() error {
 	
 	// Public endpoints (no auth required)
 	r.HandleFunc("/health", s.healthHandler).Methods("GET")
+	r.HandleFunc("/readyz", s.readyHandler).Methods("GET")
+	
+	// Prometheus scrape endpoint, optionally without auth
+	var metricsHandler http.Handler = http.HandlerFunc(s.prometheusMetricsHandler)
+	if s.config.Metrics.RequireAuth {
+		metricsHandler = s.authMiddleware(metricsHandler)
+	}
+	r.Handle("/metrics", metricsHandler).Methods("GET")
 	
 	// Proxy routes - catch-all for agent requests (no auth required)
 	r.PathPrefix("/agent/{id}/").HandlerFunc(s.proxyToAgentHandler)
+	r.PathPrefix("/ns/{namespace}/agent/{name}/").HandlerFunc(s.proxyToNamedAgentHandler)
 	
 	// Protected API endpoints - create a subrouter with auth middleware
 	api := r.PathPrefix("/").Subrouter()
@@ -83,20 +198,30 @@ func (s *Server) Start() error {
 	
 	api.HandleFunc("/agents", s.deployAgentHandler).Methods("POST")
 	api.HandleFunc("/agents", s.listAgentsHandler).Methods("GET")
+	api.HandleFunc("/agents/build", s.buildAgentHandler).Methods("POST")
+	api.HandleFunc("/agents/batch", s.batchAgentsHandler).Methods("POST")
 	api.HandleFunc("/agents/{id}", s.getAgentHandler).Methods("GET")
 	api.HandleFunc("/agents/{id}/start", s.startAgentHandler).Methods("POST")
 	api.HandleFunc("/agents/{id}/stop", s.stopAgentHandler).Methods("POST")
 	api.HandleFunc("/agents/{id}/restart", s.restartAgentHandler).Methods("POST")
 	api.HandleFunc("/agents/{id}/pause", s.pauseAgentHandler).Methods("POST")
 	api.HandleFunc("/agents/{id}/resume", s.resumeAgentHandler).Methods("POST")
+	api.HandleFunc("/agents/{id}/scale", s.scaleAgentHandler).Methods("POST")
 	api.HandleFunc("/agents/{id}", s.removeAgentHandler).Methods("DELETE")
 	api.HandleFunc("/agents/{id}/logs", s.getLogsHandler).Methods("GET")
 	api.HandleFunc("/agents/{id}/invoke", s.invokeAgentHandler).Methods("POST")
 	api.HandleFunc("/agents/{id}/metrics", s.getMetricsHandler).Methods("GET")
+	api.HandleFunc("/agents/{id}/stats", s.getStatsHandler).Methods("GET")
+	api.HandleFunc("/agents/{id}/stats", s.resetStatsHandler).Methods("DELETE")
+	api.HandleFunc("/agents/{id}/events", s.getEventsHandler).Methods("GET")
+	api.HandleFunc("/agents/{id}/inspect", s.inspectAgentHandler).Methods("GET")
+	api.HandleFunc("/agents/{id}/diff", s.diffAgentHandler).Methods("GET")
 	
 	// Request management endpoints
 	api.HandleFunc("/agents/{id}/requests", s.getAgentRequestsHandler).Methods("GET")
+	api.HandleFunc("/agents/{id}/requests/deadletter", s.getDeadLetterRequestsHandler).Methods("GET")
 	api.HandleFunc("/agents/{id}/requests/{reqId}", s.getRequestHandler).Methods("GET")
+	api.HandleFunc("/agents/{id}/requests/{reqId}/requeue", s.requeueRequestHandler).Methods("POST")
 	api.HandleFunc("/agents/{id}/requests/{reqId}/replay", s.replayRequestHandler).Methods("POST")
 	
 	// Health monitoring endpoints
@@ -105,6 +230,24 @@ func (s *Server) Start() error {
 	
 	// Metrics endpoints
 	api.HandleFunc("/agents/{id}/metrics/history", s.getMetricsHistoryHandler).Methods("GET")
+	
+	// Secrets endpoints
+	api.HandleFunc("/secrets", s.listSecretsHandler).Methods("GET")
+	api.HandleFunc("/secrets", s.setSecretHandler).Methods("POST")
+	api.HandleFunc("/secrets/{name}", s.deleteSecretHandler).Methods("DELETE")
+	
+	// API token endpoints
+	api.HandleFunc("/tokens", s.listTokensHandler).Methods("GET")
+	api.HandleFunc("/tokens", s.createTokenHandler).Methods("POST")
+	api.HandleFunc("/tokens/rotate", s.rotateDefaultTokenHandler).Methods("POST")
+	api.HandleFunc("/tokens/{id}", s.revokeTokenHandler).Methods("DELETE")
+	
+	// State synchronization endpoints
+	api.HandleFunc("/system/drift", s.getDriftHandler).Methods("GET")
+	api.HandleFunc("/system/reconcile", s.reconcileHandler).Methods("POST")
+	api.HandleFunc("/system/logs", s.getLogUsageHandler).Methods("GET")
+	api.HandleFunc("/alerts", s.getAlertsHandler).Methods("GET")
+	api.HandleFunc("/audit", s.getAuditLogsHandler).Methods("GET")
 
 	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.Port)
 	
@@ -120,21 +263,49 @@ func (s *Server) Start() error {
 	fmt.Println("🚨 ================================================")
 	fmt.Printf("Server starting on %s\n", addr)
 	
-	// Start health monitoring
-	go func() {
-		if err := s.healthMonitor.Start(context.Background()); err != nil {
-			fmt.Printf("Failed to start health monitor: %v\n", err)
+	// Start health monitoring and metrics collection; both are restarted
+	// with backoff if they fail or panic
+	s.supervisor.Go(s.background, "health_monitor", s.healthMonitor.Start)
+	s.supervisor.Go(s.background, "metrics_collector", s.metricsCollector.Start)
+	s.supervisor.Go(s.background, "metrics_compactor", s.metricsCollector.RunCompactor)
+	if s.alerts != nil {
+		s.supervisor.Go(s.background, "alerts", s.alerts.Start)
+	}
+	if s.config.AgentLogs.Persist {
+		maxSize, err := s.config.AgentLogsMaxSize()
+		if err != nil {
+			return err
 		}
-	}()
+		s.supervisor.Go(s.background, "log_persister", func(ctx context.Context) error {
+			return s.agentMgr.PersistLogs(ctx, maxSize)
+		})
+	}
 	
-	// Start metrics collection
-	go func() {
-		if err := s.metricsCollector.Start(context.Background()); err != nil {
-			fmt.Printf("Failed to start metrics collector: %v\n", err)
+	s.httpServer.Addr = addr
+	s.httpServer.Handler = r
+	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Stop stops accepting connections and waits for in-flight requests,
+// including proxied ones, to finish or for ctx to expire. Health monitoring
+// and metrics collection are stopped as well.
+func (s *Server) Stop(ctx context.Context) error {
+	var err error
+	s.stopOnce.Do(func() {
+		err = s.httpServer.Shutdown(ctx)
+		if err != nil {
+			err = fmt.Errorf("failed to drain requests: %w", err)
 		}
-	}()
-	
-	return http.ListenAndServe(addr, r)
+
+		s.stopBackground()
+		s.healthMonitor.Stop()
+		s.metricsCollector.Stop()
+		s.supervisor.Wait()
+	})
+	return err
 }
 
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -147,6 +318,26 @@ func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// readyHandler reports whether the server's background tasks are alive
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := s.supervisor.Statuses()
+	
+	if !s.supervisor.Healthy() {
+		s.sendResponse(w, http.StatusServiceUnavailable, Response{
+			Success: false,
+			Message: "One or more background tasks are not running",
+			Data:    statuses,
+		})
+		return
+	}
+	
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Service is ready",
+		Data:    statuses,
+	})
+}
+
 func (s *Server) deployAgentHandler(w http.ResponseWriter, r *http.Request) {
 	var req DeployRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -181,8 +372,62 @@ func (s *Server) deployAgentHandler(w http.ResponseWriter, r *http.Request) {
 	if req.Token == "" {
 		req.Token = s.config.Security.DefaultToken
 	}
+	
+	if req.Namespace == "" {
+		req.Namespace = s.config.Deploy.DefaultNamespace
+	}
+	
+	if req.Size != "" {
+		cpuLimit, memoryLimit, err := s.config.SizeLimits(req.Size)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.CPULimit == 0 {
+			req.CPULimit = cpuLimit
+		}
+		if req.MemoryLimit == 0 {
+			req.MemoryLimit = memoryLimit
+		}
+	}
+
+	if req.RegistryAuth != nil && req.RegistryAuth.Host == "" {
+		req.RegistryAuth.Host = docker.RegistryHost(req.Image)
+	}
+
+	opts := agent.DeployOptions{
+		Namespace:     req.Namespace,
+		Labels:        req.Labels,
+		MaxRestarts:   req.MaxRestarts,
+		RestartWindow: req.RestartWindow,
+		ProxyPort:     req.ProxyPort,
+		Routes:        req.Routes,
+		ReplayRateLimit: req.ReplayRateLimit,
+		SharedVolumes: req.SharedVolumes,
+		AllowArchMismatch: req.AllowArchMismatch,
+		RateLimit:     req.RateLimit,
+		RateLimitBurst: req.RateLimitBurst,
+		ProxyTimeout:  req.ProxyTimeout,
+		ProxyRetries:  req.ProxyRetries,
+		StopSignal:    req.StopSignal,
+		StopTimeout:   req.StopTimeout,
+		MemorySwap:    req.MemorySwap,
+		MemoryReservation: req.MemoryReservation,
+		CPUShares:     req.CPUShares,
+		PidsLimit:     req.PidsLimit,
+		Ulimits:       req.Ulimits,
+		GPUs:          req.GPUs,
+		Networks:      req.Networks,
+		NetworkAliases: req.NetworkAliases,
+		InjectRedis:   req.InjectRedis,
+		InjectAPI:     req.InjectAPI,
+		RegistryAuth:  req.RegistryAuth,
+		PullPolicy:    req.PullPolicy,
+		PinDigest:     req.PinDigest,
+		Secrets:       req.Secrets,
+	}
 
-	agent, err := s.agentMgr.Deploy(r.Context(), req.Name, req.Image, req.EnvVars, req.CPULimit, req.MemoryLimit, req.AutoRestart, req.Token, req.Ports, req.Volumes, req.HealthCheck)
+	agent, err := s.agentMgr.Deploy(r.Context(), req.Name, req.Image, req.EnvVars, req.CPULimit, req.MemoryLimit, req.AutoRestart, req.Token, req.Ports, req.Volumes, req.HealthCheck, opts)
 	if err != nil {
 		// Log error
 		logging.Error("api", "Failed to deploy agent", map[string]interface{}{
@@ -211,6 +456,7 @@ func (s *Server) deployAgentHandler(w http.ResponseWriter, r *http.Request) {
 	logging.Info("api", "Agent deployed successfully", map[string]interface{}{
 		"agent_id": agent.ID,
 		"name": agent.Name,
+		"namespace": agent.Namespace,
 		"image": agent.Image,
 	})
 	
@@ -221,7 +467,7 @@ func (s *Server) deployAgentHandler(w http.ResponseWriter, r *http.Request) {
 		Resource:   "agent",
 		ResourceID: agent.ID,
 		Result:     "success",
-		Details:    map[string]interface{}{"name": agent.Name, "image": agent.Image},
+		Details:    map[string]interface{}{"name": agent.Name, "image": agent.Image, "image_digest": agent.ImageDigest},
 		IP:         s.getClientIP(r),
 		UserAgent:  r.UserAgent(),
 	})
@@ -233,21 +479,266 @@ func (s *Server) deployAgentHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// buildAgentHandler builds an image from an uploaded tar build context,
+// streaming build output back to the client and optionally deploying the result
+func (s *Server) buildAgentHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		s.sendError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+	if len(name) > 64 {
+		s.sendError(w, http.StatusBadRequest, "Agent name too long (max 64 characters)")
+		return
+	}
+	
+	deploy := query.Get("deploy") == "true"
+	// Bare KEY args would read the server's environment, so values are required
+	for _, arg := range query["build_arg"] {
+		if !strings.Contains(arg, "=") {
+			s.sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid build arg '%s' (expected KEY=VALUE)", arg))
+			return
+		}
+	}
+	buildArgs, err := docker.ParseBuildArgs(query["build_arg"])
+	if err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	buildOpts := docker.BuildOptions{
+		BuildArgs: buildArgs,
+		Target:    query.Get("target"),
+		NoCache:   query.Get("no_cache") == "true",
+	}
+	namespace := query.Get("namespace")
+	if namespace == "" {
+		namespace = s.config.Deploy.DefaultNamespace
+	}
+	
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.sendError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+	
+	builder := docker.NewImageBuilder(s.dockerClient, s.config.Registries)
+	nameCtx, cancelName := docker.WithOperationTimeout(r.Context(), s.config.Docker.OperationTimeout)
+	imageName, err := builder.PreventDuplicateImage(nameCtx, docker.GenerateImageName(name))
+	cancelName()
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to generate image name: %v", docker.CheckTimeout(err, s.config.Docker.OperationTimeout)))
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	
+	encoder := json.NewEncoder(w)
+	send := func(event BuildEvent) {
+		encoder.Encode(event)
+		flusher.Flush()
+	}
+	
+	buildCtx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+	
+	progressChan := make(chan string, 100)
+	errChan := make(chan error, 1)
+	body := http.MaxBytesReader(w, r.Body, maxBuildContextSize)
+	go func() {
+		errChan <- builder.BuildFromContext(buildCtx, body, query.Get("dockerfile"), imageName, buildOpts, progressChan)
+	}()
+	
+	for msg := range progressChan {
+		send(BuildEvent{Type: "progress", Message: msg})
+	}
+	
+	if err := <-errChan; err != nil {
+		logging.Error("api", "Failed to build image", map[string]interface{}{
+			"name": name,
+			"image": imageName,
+			"error": err.Error(),
+		})
+		
+		logging.AuditLog(logging.AuditEntry{
+			UserID:     s.getUserID(r),
+			Action:     "build_image",
+			Resource:   "image",
+			ResourceID: imageName,
+			Result:     "failure",
+			Details:    map[string]interface{}{"name": name, "error": err.Error()},
+			IP:         s.getClientIP(r),
+			UserAgent:  r.UserAgent(),
+		})
+		
+		send(BuildEvent{Type: "error", Message: err.Error(), Image: imageName})
+		return
+	}
+	
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     s.getUserID(r),
+		Action:     "build_image",
+		Resource:   "image",
+		ResourceID: imageName,
+		Result:     "success",
+		Details:    map[string]interface{}{"name": name, "target": buildOpts.Target, "no_cache": buildOpts.NoCache},
+		IP:         s.getClientIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+	
+	sizeCtx, cancelSize := docker.WithOperationTimeout(r.Context(), s.config.Docker.OperationTimeout)
+	imageSize, _ := builder.ImageSize(sizeCtx, imageName)
+	cancelSize()
+	
+	if !deploy {
+		send(BuildEvent{Type: "complete", Message: "Image built successfully", Image: imageName, Size: imageSize})
+		return
+	}
+	
+	opts := agent.DeployOptions{
+		Namespace: namespace,
+	}
+	autoRestart := query.Get("auto_restart") == "true"
+	
+	deployed, err := s.agentMgr.Deploy(r.Context(), name, imageName, nil, 0, 0, autoRestart, s.config.Security.DefaultToken, nil, nil, nil, opts)
+	if err != nil {
+		logging.Error("api", "Failed to deploy built image", map[string]interface{}{
+			"name": name,
+			"image": imageName,
+			"error": err.Error(),
+		})
+		send(BuildEvent{Type: "error", Message: fmt.Sprintf("Failed to deploy agent: %v", err), Image: imageName})
+		return
+	}
+	
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     s.getUserID(r),
+		Action:     "deploy_agent",
+		Resource:   "agent",
+		ResourceID: deployed.ID,
+		Result:     "success",
+		Details:    map[string]interface{}{"name": deployed.Name, "image": deployed.Image},
+		IP:         s.getClientIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+	
+	send(BuildEvent{Type: "complete", Message: "Image built and agent deployed successfully", Image: imageName, Size: imageSize, Agent: deployed})
+}
+
 func (s *Server) listAgentsHandler(w http.ResponseWriter, r *http.Request) {
-	// API lists all agents regardless of token (same as CLI)
-	agents, err := s.agentMgr.ListAgents("")
+	// API lists all agents regardless of token (same as CLI),
+	// optionally narrowed by namespace, name, status, image or labels
+	query := r.URL.Query()
+	filter := agent.ListFilter{
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("name"),
+		Status:    agent.Status(query.Get("status")),
+		Image:     query.Get("image"),
+	}
+	for _, selector := range query["label"] {
+		key, value, ok := strings.Cut(selector, "=")
+		if !ok {
+			s.sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid label selector %q: expected key=value", selector))
+			return
+		}
+		if filter.Labels == nil {
+			filter.Labels = make(map[string]string)
+		}
+		filter.Labels[key] = value
+	}
+	if err := filter.Validate(); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	agents, err := s.agentMgr.FindAgents(filter)
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list agents: %v", err))
 		return
 	}
 
+	responses := make([]AgentResponse, 0, len(agents))
+	for _, a := range agents {
+		responses = append(responses, s.agentResponse(a))
+	}
+
 	s.sendResponse(w, http.StatusOK, Response{
 		Success: true,
 		Message: "Agents retrieved successfully",
-		Data:    agents,
+		Data:    responses,
 	})
 }
 
+// AgentResponse is an agent together with its latest health check result
+type AgentResponse struct {
+	agent.Agent
+	// Health is omitted for agents the health monitor is not checking
+	Health *health.HealthStatus `json:"health,omitempty"`
+}
+
+func (s *Server) agentResponse(a agent.Agent) AgentResponse {
+	response := AgentResponse{Agent: a}
+	if status, err := s.healthMonitor.GetStatus(a.ID); err == nil {
+		response.Health = status
+	}
+	return response
+}
+
+// BatchRequest applies an action to every agent matching a filter
+type BatchRequest struct {
+	Action string           `json:"action"`
+	Filter agent.ListFilter `json:"filter"`
+}
+
+func (s *Server) batchAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := agent.ValidateBatchAction(req.Action); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := req.Filter.Validate(); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := s.agentMgr.Batch(r.Context(), req.Action, req.Filter)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to %s agents: %v", req.Action, err))
+		return
+	}
+	if req.Action == agent.BatchRemove {
+		for _, id := range result.Succeeded {
+			if err := s.requestMgr.ResetStats(r.Context(), id); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+	}
+
+	logging.AuditLog(logging.AuditEntry{
+		UserID:   s.getUserID(r),
+		Action:   "batch_" + req.Action,
+		Resource: "agent",
+		Result:   "success",
+		Details: map[string]interface{}{
+			"filter":    req.Filter,
+			"succeeded": result.Succeeded,
+			"failed":    result.Failed,
+		},
+		IP:        s.getClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: fmt.Sprintf("%s: %d succeeded, %d skipped, %d failed", req.Action, len(result.Succeeded), len(result.Skipped), len(result.Failed)),
+		Data:    result,
+	})
+}
 
 func (s *Server) getAgentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -262,7 +753,7 @@ func (s *Server) getAgentHandler(w http.ResponseWriter, r *http.Request) {
 	s.sendResponse(w, http.StatusOK, Response{
 		Success: true,
 		Message: "Agent retrieved successfully",
-		Data:    agent,
+		Data:    s.agentResponse(*agent),
 	})
 }
 
@@ -289,6 +780,7 @@ func (s *Server) startAgentHandler(w http.ResponseWriter, r *http.Request) {
 			Interval: parseDuration(agent.HealthCheck.Interval, 30*time.Second),
 			Timeout:  parseDuration(agent.HealthCheck.Timeout, 5*time.Second),
 			Retries:  agent.HealthCheck.Retries,
+			StartPeriod: parseDuration(agent.HealthCheck.StartPeriod, 0),
 		}
 		s.healthMonitor.StartMonitoring(agentID, config)
 	}
@@ -359,6 +851,58 @@ func (s *Server) resumeAgentHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ScaleRequest sets the total number of instances of an agent
+type ScaleRequest struct {
+	Replicas int `json:"replicas"`
+}
+
+func (s *Server) scaleAgentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["id"]
+	
+	var req ScaleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	
+	// Keep scaling within reason for a single host
+	if req.Replicas < 1 || req.Replicas > 50 {
+		s.sendError(w, http.StatusBadRequest, "Replicas must be between 1 and 50")
+		return
+	}
+	
+	result, err := s.agentMgr.Scale(r.Context(), agentID, req.Replicas)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to scale agent: %v", err))
+		return
+	}
+	
+	outcome := "success"
+	message := fmt.Sprintf("Agent scaled to %d replicas", result.Replicas)
+	if len(result.Failed) > 0 {
+		outcome = "partial"
+		message = fmt.Sprintf("Agent partially scaled: %d of %d replicas running", len(result.Running), req.Replicas)
+	}
+	
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     s.getUserID(r),
+		Action:     "scale_agent",
+		Resource:   "agent",
+		ResourceID: agentID,
+		Result:     outcome,
+		Details:    map[string]interface{}{"replicas": req.Replicas, "failed": len(result.Failed)},
+		IP:         s.getClientIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+	
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: message,
+		Data:    result,
+	})
+}
+
 func (s *Server) removeAgentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	agentID := vars["id"]
@@ -374,6 +918,9 @@ func (s *Server) removeAgentHandler(w http.ResponseWriter, r *http.Request) {
 		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to remove agent: %v", err))
 		return
 	}
+	if err := s.requestMgr.ResetStats(r.Context(), agentID); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
 
 	s.sendResponse(w, http.StatusOK, Response{
 		Success: true,
@@ -389,19 +936,52 @@ func (s *Server) getLogsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	agentID := vars["id"]
 
-	follow := r.URL.Query().Get("follow") == "true"
+	query := r.URL.Query()
+	opts := agent.LogOptions{
+		Follow: query.Get("follow") == "true",
+		Tail:   query.Get("tail"),
+		Since:  query.Get("since"),
+	}
+	if err := agent.ValidateLogOptions(opts); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	format := query.Get("format")
+	if format != "" && format != "text" && format != "sse" {
+		s.sendError(w, http.StatusBadRequest, "format must be text or sse")
+		return
+	}
 
-	logs, err := s.agentMgr.GetLogs(r.Context(), agentID, follow)
+	logs, err := s.agentMgr.GetLogs(r.Context(), agentID, opts)
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get logs: %v", err))
 		return
 	}
 	defer logs.Close()
 
+	flusher, _ := w.(http.Flusher)
+	if format == "sse" {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		var mu sync.Mutex
+		stdout := &sseLogWriter{mu: &mu, w: w, flusher: flusher, event: "stdout"}
+		stderr := &sseLogWriter{mu: &mu, w: w, flusher: flusher, event: "stderr"}
+		stdcopy.StdCopy(stdout, stderr, logs)
+		stdout.Close()
+		stderr.Close()
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 	
-	io.Copy(w, logs)
+	// Docker multiplexes stdout and stderr; strip the frame headers
+	out := &flushWriter{w: w, flusher: flusher}
+	stdcopy.StdCopy(out, out, logs)
 }
 
 func (s *Server) invokeAgentHandler(w http.ResponseWriter, r *http.Request) {
@@ -446,6 +1026,218 @@ func (s *Server) getMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) getStatsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["id"]
+
+	if _, err := s.agentMgr.GetAgent(agentID); err != nil {
+		s.sendError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+
+	stats, err := s.requestMgr.GetStats(r.Context(), agentID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get stats: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Request stats retrieved successfully",
+		Data:    stats,
+	})
+}
+
+func (s *Server) getEventsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["id"]
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 0 {
+			s.sendError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	events, err := s.agentMgr.GetEvents(r.Context(), agentID, limit)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get events: %v", err))
+		return
+	}
+
+	// Removed agents keep their history for a while
+	if len(events) == 0 {
+		if _, err := s.agentMgr.GetAgent(agentID); err != nil {
+			s.sendError(w, http.StatusNotFound, "Agent not found")
+			return
+		}
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Agent events retrieved successfully",
+		Data:    events,
+	})
+}
+
+func (s *Server) inspectAgentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["id"]
+
+	if _, err := s.agentMgr.GetAgent(agentID); err != nil {
+		s.sendError(w, http.StatusNotFound, fmt.Sprintf("Agent not found: %v", err))
+		return
+	}
+
+	info, err := s.agentMgr.Inspect(r.Context(), agentID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to inspect agent: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Container inspected successfully",
+		Data:    info,
+	})
+}
+
+func (s *Server) diffAgentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["id"]
+
+	if _, err := s.agentMgr.GetAgent(agentID); err != nil {
+		s.sendError(w, http.StatusNotFound, fmt.Sprintf("Agent not found: %v", err))
+		return
+	}
+
+	changes, err := s.agentMgr.Diff(r.Context(), agentID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get container changes: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: fmt.Sprintf("%d paths changed", len(changes)),
+		Data:    changes,
+	})
+}
+
+func (s *Server) getDriftHandler(w http.ResponseWriter, r *http.Request) {
+	if s.stateSync == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "State synchronizer is not running")
+		return
+	}
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 0 {
+			s.sendError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	drift, err := s.stateSync.RecentDrift(r.Context(), limit)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get drift: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "State drift retrieved successfully",
+		Data:    drift,
+	})
+}
+
+func (s *Server) getAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "Alerting is disabled; check the alerts configuration")
+		return
+	}
+
+	active := s.alerts.Active()
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: fmt.Sprintf("%d active alerts (%d rules)", len(active), len(s.alerts.Rules())),
+		Data:    active,
+	})
+}
+
+func (s *Server) getLogUsageHandler(w http.ResponseWriter, r *http.Request) {
+	usage, err := logging.StorageUsage(r.Context())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get log usage: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Log storage usage retrieved successfully",
+		Data:    usage,
+	})
+}
+
+func (s *Server) reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if s.stateSync == nil {
+		s.sendError(w, http.StatusServiceUnavailable, "State synchronizer is not running")
+		return
+	}
+
+	result, err := s.stateSync.SyncNow(r.Context())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to reconcile: %v", err))
+		return
+	}
+
+	logging.AuditLog(logging.AuditEntry{
+		UserID:   s.getUserID(r),
+		Action:   "reconcile",
+		Resource: "system",
+		Result:   "success",
+		Details: map[string]interface{}{
+			"checked": result.Checked,
+			"updated": len(result.Updated),
+			"drift":   len(result.Drift),
+			"failed":  len(result.Failed),
+		},
+		IP:        s.getClientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Reconciliation completed",
+		Data:    result,
+	})
+}
+
+func (s *Server) resetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["id"]
+
+	if _, err := s.agentMgr.GetAgent(agentID); err != nil {
+		s.sendError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+
+	if err := s.requestMgr.ResetStats(r.Context(), agentID); err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to reset stats: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Request stats reset successfully",
+	})
+}
+
 func (s *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/health" || strings.HasPrefix(r.URL.Path, "/web/") {
@@ -467,12 +1259,19 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		if token != s.config.Security.DefaultToken {
+		user, err := s.authenticate(r.Context(), token)
+		if err != nil {
+			s.sendError(w, http.StatusServiceUnavailable, fmt.Sprintf("Failed to check authorization token: %v", err))
+			return
+		}
+		if user == "" {
 			s.sendError(w, http.StatusUnauthorized, "Invalid authorization token")
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), "authToken", token)
+		ctx = context.WithValue(ctx, identityKey{}, user)
+		ctx = agent.WithUser(ctx, user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -491,6 +1290,7 @@ func (s *Server) sendResponse(w http.ResponseWriter, statusCode int, response Re
 }
 
 func (s *Server) proxyToAgentHandler(w http.ResponseWriter, r *http.Request) {
+	timer := &proxyTimer{received: time.Now()}
 	vars := mux.Vars(r)
 	agentID := vars["id"]
 	
@@ -503,9 +1303,33 @@ func (s *Server) proxyToAgentHandler(w http.ResponseWriter, r *http.Request) {
 	
 	// Store request if persistence is enabled (for both running and stopped agents)
 	var requestID string
-	isReplay := r.Header.Get("X-Agentainer-Replay") == "true"
+	var queued bool
+	// Anyone can send the replay header, so only the replay worker's token
+	// counts; agents still see "true" on replays but never the token
+	isReplay := requests.IsReplay(r)
+	r.Header.Del(requests.ReplayHeader)
+	if isReplay {
+		r.Header.Set(requests.ReplayHeader, "true")
+	}
+	
+	// Rate-limited requests are rejected before they are persisted; replays
+	// are paced by the replay worker instead
+	if agentObj.RateLimit > 0 && !isReplay {
+		allowed, wait, err := s.rateLimiter.allow(r.Context(), agentID, agentObj.RateLimit, agentObj.RateLimitBurst)
+		if err != nil {
+			// Fail open so a Redis hiccup doesn't take the agent offline
+			fmt.Printf("Warning: %v\n", err)
+		} else if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+			s.sendError(w, http.StatusTooManyRequests, fmt.Sprintf("Rate limit exceeded for agent (%g requests/sec)", agentObj.RateLimit))
+			return
+		}
+	}
+	
+	// Upgraded connections such as WebSockets can't be queued or replayed
+	isUpgrade := r.Header.Get("Upgrade") != ""
 	
-	if s.config.Features.RequestPersistence && !isReplay {
+	if s.config.Features.RequestPersistence && !isReplay && !isUpgrade {
 		ctx := r.Context()
 		storedReq, err := s.requestMgr.StoreRequest(ctx, agentID, r)
 		if err != nil {
@@ -513,6 +1337,7 @@ func (s *Server) proxyToAgentHandler(w http.ResponseWriter, r *http.Request) {
 			fmt.Printf("Warning: Failed to store request: %v\n", err)
 		} else {
 			requestID = storedReq.ID
+			queued = !storedReq.BodyTooLarge
 			// Add request ID to headers for tracking
 			r.Header.Set("X-Agentainer-Request-ID", requestID)
 		}
@@ -521,13 +1346,18 @@ func (s *Server) proxyToAgentHandler(w http.ResponseWriter, r *http.Request) {
 		requestID = r.Header.Get("X-Agentainer-Request-ID")
 	}
 	
-	// Check if agent is running
-	if agentObj.Status != agent.StatusRunning {
-		if s.config.Features.RequestPersistence && requestID != "" {
+	// Spread requests across the agent and any ready replicas
+	target, notReady := s.pickInstance(agentObj)
+	if target == nil {
+		if queued {
 			// We already stored the request above
+			message := "Agent is not running. Request queued for replay when agent starts."
+			if notReady {
+				message = "Agent is not ready. Request queued for replay when agent is ready."
+			}
 			s.sendResponse(w, http.StatusAccepted, Response{
 				Success: true,
-				Message: "Agent is not running. Request queued for replay when agent starts.",
+				Message: message,
 				Data: map[string]string{
 					"request_id": requestID,
 					"status":     "pending",
@@ -536,58 +1366,163 @@ func (s *Server) proxyToAgentHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		
-		s.sendError(w, http.StatusServiceUnavailable, "Agent is not running")
+		message := "Agent is not running"
+		if notReady {
+			w.Header().Set("Retry-After", "5")
+			message = "Agent is not ready"
+		}
+		if requestID != "" && !isReplay {
+			// Stored, but too large to queue for replay
+			s.requestMgr.MarkRequestFailed(r.Context(), agentID, requestID, errors.New(message))
+			message += "; request body too large to queue for replay"
+		}
+		s.sendError(w, http.StatusServiceUnavailable, message)
 		return
 	}
 	
+	// Modify the request path to remove the /agent/{id} prefix
+	path := strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/agent/%s", agentID))
+	if path == "" {
+		path = "/"
+	}
+	
 	// In the new architecture, we connect to the agent using its hostname
-	// on the internal network. The agent ID is used as the hostname.
-	// Default agent port is 8000.
-	targetURL, err := url.Parse(fmt.Sprintf("http://%s:8000", agentObj.ID))
+	// on the internal network. The agent ID is used as the hostname and the
+	// port comes from the agent's proxy port or a named route.
+	port, path := target.ProxyTarget(path)
+	targetURL, err := url.Parse(fmt.Sprintf("http://%s:%d", target.ID, port))
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "Failed to parse target URL")
 		return
 	}
+	r.URL.Path = path
+	r.URL.RawPath = ""
 	
-	// Modify the request path to remove the /agent/{id} prefix
-	originalPath := r.URL.Path
-	r.URL.Path = strings.TrimPrefix(originalPath, fmt.Sprintf("/agent/%s", agentID))
-	if r.URL.Path == "" {
-		r.URL.Path = "/"
-	}
+	// The agent's proxy timeout and retries apply to each forwarded request,
+	// not to the interception around it
+	proxyTimeout, _ := agent.ParseProxyTimeout(agentObj.ProxyTimeout)
 	
 	// Create custom transport to intercept response
 	transport := &interceptTransport{
-		base:       http.DefaultTransport,
+		base: &retryTransport{
+			base:    s.proxyTransports.get(target.ID),
+			timeout: proxyTimeout,
+			retries: agentObj.ProxyRetries,
+		},
 		requestMgr: s.requestMgr,
 		agentID:    agentID,
 		requestID:  requestID,
+		replayable: queued || isReplay,
+		timer:      timer,
 	}
 	
 	// Create reverse proxy with custom transport
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 	proxy.Transport = transport
+	proxy.ErrorHandler = proxyErrorHandler
 	
 	// Forward the request
 	proxy.ServeHTTP(w, r)
 }
 
+// pickInstance chooses a running instance of the agent that passes its
+// readiness probe, rotating through its replicas. It returns nil if there is
+// none; notReady reports that instances are running but none is ready yet.
+// Replicas only serve while the agent itself is running, so stopping or
+// pausing the agent queues its traffic even if replicas are still up.
+func (s *Server) pickInstance(agentObj *agent.Agent) (target *agent.Agent, notReady bool) {
+	if agentObj.Status != agent.StatusRunning {
+		return nil, false
+	}
+
+	instances, err := s.agentMgr.Instances(agentObj.ID)
+	if err != nil {
+		instances = []*agent.Agent{agentObj}
+	}
+	
+	var ready []*agent.Agent
+	for _, instance := range instances {
+		if instance.Status != agent.StatusRunning {
+			continue
+		}
+		if !s.healthMonitor.IsReady(instance) {
+			notReady = true
+			continue
+		}
+		ready = append(ready, instance)
+	}
+	
+	switch len(ready) {
+	case 0:
+		return nil, notReady
+	case 1:
+		return ready[0], false
+	}
+	
+	cursor, _ := s.replicaCursors.LoadOrStore(agentObj.ID, new(uint64))
+	next := atomic.AddUint64(cursor.(*uint64), 1)
+	return ready[next%uint64(len(ready))], false
+}
+
+// proxyToNamedAgentHandler resolves /ns/{namespace}/agent/{name}/ to the
+// agent's ID and forwards through the regular proxy path
+func (s *Server) proxyToNamedAgentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	namespace := vars["namespace"]
+	name := vars["name"]
+	
+	agentObj, err := s.agentMgr.GetAgentByName(namespace, name)
+	if err != nil {
+		s.sendError(w, http.StatusNotFound, fmt.Sprintf("Agent not found: %v", err))
+		return
+	}
+	
+	// Rewrite to the canonical proxy path so persistence and replay
+	// see the same request shape as /agent/{id}/
+	prefix := fmt.Sprintf("/ns/%s/agent/%s", namespace, name)
+	r.URL.Path = fmt.Sprintf("/agent/%s%s", agentObj.ID, strings.TrimPrefix(r.URL.Path, prefix))
+	r.URL.RawPath = ""
+	r = mux.SetURLVars(r, map[string]string{"id": agentObj.ID})
+	
+	s.proxyToAgentHandler(w, r)
+}
+
 // interceptTransport wraps http.RoundTripper to capture responses
 type interceptTransport struct {
 	base       http.RoundTripper
 	requestMgr *requests.Manager
 	agentID    string
 	requestID  string
+	// replayable is false for requests whose body was too large to store
+	replayable bool
+	timer      *proxyTimer
 }
 
 func (t *interceptTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// Forward the request
-	resp, err := t.base.RoundTrip(req)
+	resp, err := t.base.RoundTrip(t.timer.trace(req))
+	
+	// Report where the time went so agent latency can be told apart from ours
+	var timing *requests.Timing
+	if resp != nil && err == nil {
+		timing = t.timer.timing()
+		resp.Header.Add("Server-Timing", serverTimingHeader(timing))
+	}
+	
+	// Count every forwarded request, persisted or not, for the agent's stats
+	status, latency := 0, time.Since(t.timer.received)
+	if timing != nil {
+		status = resp.StatusCode
+		latency = time.Duration(timing.Total * float64(time.Millisecond))
+	}
+	if statsErr := t.requestMgr.RecordStats(context.Background(), t.agentID, status, latency); statsErr != nil {
+		fmt.Printf("Warning: %v\n", statsErr)
+	}
 	
 	// Handle successful response
 	if t.requestID != "" && resp != nil && err == nil {
 		ctx := context.Background()
-		if storeErr := t.requestMgr.StoreResponse(ctx, t.agentID, t.requestID, resp); storeErr != nil {
+		if storeErr := t.requestMgr.StoreResponse(ctx, t.agentID, t.requestID, resp, timing); storeErr != nil {
 			// Log but don't fail
 			fmt.Printf("Warning: Failed to store response: %v\n", storeErr)
 		}
@@ -597,9 +1532,9 @@ func (t *interceptTransport) RoundTrip(req *http.Request) (*http.Response, error
 	if t.requestID != "" && err != nil {
 		ctx := context.Background()
 		// Check if this is a connection error (agent likely crashed)
-		if strings.Contains(err.Error(), "connection refused") || 
+		if t.replayable && (strings.Contains(err.Error(), "connection refused") || 
 		   strings.Contains(err.Error(), "no such host") ||
-		   strings.Contains(err.Error(), "dial tcp") {
+		   strings.Contains(err.Error(), "dial tcp")) {
 			fmt.Printf("Agent %s appears to have crashed during request %s: %v\n", 
 				t.agentID, t.requestID, err)
 			// The request remains in pending state and will be retried when agent restarts
@@ -652,21 +1587,86 @@ func (s *Server) getAgentRequestsHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-func (s *Server) getRequestHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getDeadLetterRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["id"]
+	
+	// Verify agent exists
+	if _, err := s.agentMgr.GetAgent(agentID); err != nil {
+		s.sendError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+	
+	deadLetter, err := s.requestMgr.GetDeadLetterRequests(r.Context(), agentID)
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to get requests: %v", err))
+		return
+	}
+	
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Dead-letter requests retrieved successfully",
+		Data: map[string]interface{}{
+			"agent_id":   agentID,
+			"deadletter": deadLetter,
+			"count":      len(deadLetter),
+		},
+	})
+}
+
+func (s *Server) requeueRequestHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	agentID := vars["id"]
 	requestID := vars["reqId"]
 	
-	// Get request from storage
-	key := fmt.Sprintf("agent:%s:requests:%s", agentID, requestID)
-	data, err := s.storage.Get(r.Context(), key)
+	if _, err := s.agentMgr.GetAgent(agentID); err != nil {
+		s.sendError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+	
+	request, err := s.requestMgr.Requeue(r.Context(), agentID, requestID)
+	
+	result := "success"
 	if err != nil {
-		s.sendError(w, http.StatusNotFound, "Request not found")
+		result = "failure"
+	}
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     s.getUserID(r),
+		Action:     "requeue_request",
+		Resource:   "request",
+		ResourceID: requestID,
+		Result:     result,
+		Details:    map[string]interface{}{"agent_id": agentID},
+		IP:         s.getClientIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+	
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, requests.ErrNotDeadLettered) {
+			status = http.StatusNotFound
+		}
+		s.sendError(w, status, fmt.Sprintf("Failed to requeue request: %v", err))
 		return
 	}
 	
-	var request requests.Request
-	if err := json.Unmarshal([]byte(data), &request); err != nil {
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Request requeued for replay",
+		Data:    request,
+	})
+}
+
+func (s *Server) getRequestHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["id"]
+	requestID := vars["reqId"]
+	
+	request, err := s.requestMgr.GetRequest(r.Context(), agentID, requestID)
+	if errors.Is(err, storage.ErrNotFound) {
+		s.sendError(w, http.StatusNotFound, "Request not found")
+		return
+	} else if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "Failed to parse request")
 		return
 	}
@@ -683,19 +1683,18 @@ func (s *Server) replayRequestHandler(w http.ResponseWriter, r *http.Request) {
 	agentID := vars["id"]
 	requestID := vars["reqId"]
 	
-	// Get request from storage
-	key := fmt.Sprintf("agent:%s:requests:%s", agentID, requestID)
-	data, err := s.storage.Get(r.Context(), key)
-	if err != nil {
+	storedReq, err := s.requestMgr.GetRequest(r.Context(), agentID, requestID)
+	if errors.Is(err, storage.ErrNotFound) {
 		s.sendError(w, http.StatusNotFound, "Request not found")
 		return
-	}
-	
-	var storedReq requests.Request
-	if err := json.Unmarshal([]byte(data), &storedReq); err != nil {
+	} else if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "Failed to parse request")
 		return
 	}
+	if storedReq.BodyTooLarge {
+		s.sendError(w, http.StatusConflict, requests.ErrTooLargeToReplay.Error())
+		return
+	}
 	
 	// Check if agent is running
 	agent, err := s.agentMgr.GetAgent(agentID)
@@ -709,8 +1708,13 @@ func (s *Server) replayRequestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Recreate the HTTP request
-	targetURL := fmt.Sprintf("http://%s:8000%s", agentID, storedReq.Path)
+	// Recreate the HTTP request against the same port the proxy would use
+	path := strings.TrimPrefix(storedReq.Path, fmt.Sprintf("/agent/%s", agentID))
+	if path == "" {
+		path = "/"
+	}
+	port, path := agent.ProxyTarget(path)
+	targetURL := fmt.Sprintf("http://%s:%d%s", agentID, port, path)
 	httpReq, err := http.NewRequest(storedReq.Method, targetURL, bytes.NewReader(storedReq.Body))
 	if err != nil {
 		s.sendError(w, http.StatusInternalServerError, "Failed to create request")
@@ -736,7 +1740,7 @@ func (s *Server) replayRequestHandler(w http.ResponseWriter, r *http.Request) {
 	
 	// Store the new response
 	ctx := r.Context()
-	if err := s.requestMgr.StoreResponse(ctx, agentID, requestID, resp); err != nil {
+	if err := s.requestMgr.StoreResponse(ctx, agentID, requestID, resp, nil); err != nil {
 		fmt.Printf("Warning: Failed to store replay response: %v\n", err)
 	}
 	
@@ -790,9 +1794,13 @@ func (s *Server) getMetricsHistoryHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 	
-	// Limit to 24 hours max
-	if duration > 24*time.Hour {
-		duration = 24 * time.Hour
+	// Nothing older than the retention is kept
+	if duration > s.metricsCollector.Retention() {
+		duration = s.metricsCollector.Retention()
+	}
+	resolution := "raw"
+	if s.metricsCollector.Downsampled(duration) {
+		resolution = "1h"
 	}
 	
 	history, err := s.metricsCollector.GetMetricsHistory(agentID, duration)
@@ -805,9 +1813,10 @@ func (s *Server) getMetricsHistoryHandler(w http.ResponseWriter, r *http.Request
 		Success: true,
 		Message: "Metrics history retrieved successfully",
 		Data: map[string]interface{}{
-			"agent_id": agentID,
-			"duration": duration.String(),
-			"metrics":  history,
+			"agent_id":   agentID,
+			"duration":   duration.String(),
+			"resolution": resolution,
+			"metrics":    history,
 		},
 	})
 }
@@ -824,13 +1833,10 @@ func parseDuration(s string, defaultDur time.Duration) time.Duration {
 	return dur
 }
 
-// getUserID extracts user ID from the request (from token)
+// getUserID returns the identity authMiddleware resolved for the request
 func (s *Server) getUserID(r *http.Request) string {
-	// In a real implementation, you'd decode the JWT token
-	// For now, just use the token as user ID
-	auth := r.Header.Get("Authorization")
-	if strings.HasPrefix(auth, "Bearer ") {
-		return strings.TrimPrefix(auth, "Bearer ")
+	if user, ok := r.Context().Value(identityKey{}).(string); ok && user != "" {
+		return user
 	}
 	return "anonymous"
 }
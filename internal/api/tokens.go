@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/agentainer/agentainer-lab/internal/logging"
+	"github.com/agentainer/agentainer-lab/internal/tokens"
+)
+
+// defaultTokenUser is the identity of requests made with the default token
+const defaultTokenUser = "default"
+
+// identityKey holds the user authMiddleware resolved for a request
+type identityKey struct{}
+
+// TokenRequest creates a named API token
+type TokenRequest struct {
+	Name string `json:"name"`
+	// User is who the token's requests are attributed to; defaults to Name
+	User string `json:"user,omitempty"`
+}
+
+// CreatedToken is returned once when a token is created; Token is the only
+// copy of its value
+type CreatedToken struct {
+	tokens.Token
+	Value string `json:"token"`
+}
+
+// RotateRequest rotates the default token
+type RotateRequest struct {
+	// Grace is how long the replaced token keeps working, e.g. 10m
+	Grace string `json:"grace,omitempty"`
+}
+
+// RotatedToken is the new default token
+type RotatedToken struct {
+	Value      string    `json:"token"`
+	RotatedAt  time.Time `json:"rotated_at"`
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+}
+
+// authenticate returns the user a token belongs to, or "" if it is not valid
+func (s *Server) authenticate(ctx context.Context, value string) (string, error) {
+	isDefault, err := s.tokenStore.CheckDefault(ctx, value, s.config.Security.DefaultToken)
+	if err != nil {
+		return "", err
+	}
+	if isDefault {
+		return defaultTokenUser, nil
+	}
+
+	token, err := s.tokenStore.Authenticate(ctx, value)
+	if errors.Is(err, tokens.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return token.User, nil
+}
+
+func (s *Server) listTokensHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := s.tokenStore.List(r.Context())
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to list tokens: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: fmt.Sprintf("Found %d tokens", len(list)),
+		Data:    list,
+	})
+}
+
+func (s *Server) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := tokens.ValidateName(req.Name); err != nil {
+		s.sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.User != "" {
+		if err := tokens.ValidateName(req.User); err != nil {
+			s.sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	token, value, err := s.tokenStore.Create(r.Context(), req.Name, req.User, s.getUserID(r))
+
+	// The token value is never logged
+	result := "success"
+	resourceID := req.Name
+	if err != nil {
+		result = "failure"
+	} else {
+		resourceID = token.ID
+	}
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     s.getUserID(r),
+		Action:     "create_token",
+		Resource:   "token",
+		ResourceID: resourceID,
+		Details: map[string]interface{}{
+			"name": req.Name,
+			"user": req.User,
+		},
+		Result:     result,
+		IP:         s.getClientIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create token: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusCreated, Response{
+		Success: true,
+		Message: "Token created; store it now, it cannot be shown again",
+		Data:    CreatedToken{Token: *token, Value: value},
+	})
+}
+
+func (s *Server) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	token, err := s.tokenStore.Revoke(r.Context(), id)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	} else {
+		id = token.ID
+	}
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     s.getUserID(r),
+		Action:     "revoke_token",
+		Resource:   "token",
+		ResourceID: id,
+		Result:     result,
+		IP:         s.getClientIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, tokens.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		s.sendError(w, status, fmt.Sprintf("Failed to revoke token: %v", err))
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Token revoked",
+		Data:    token,
+	})
+}
+
+func (s *Server) rotateDefaultTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req RotateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.sendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	var grace time.Duration
+	if req.Grace != "" {
+		var err error
+		grace, err = time.ParseDuration(req.Grace)
+		if err != nil || grace < 0 {
+			s.sendError(w, http.StatusBadRequest, fmt.Sprintf("Invalid grace period '%s'", req.Grace))
+			return
+		}
+	}
+
+	value, err := s.tokenStore.RotateDefault(r.Context(), s.config.Security.DefaultToken, grace)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	logging.AuditLog(logging.AuditEntry{
+		UserID:     s.getUserID(r),
+		Action:     "rotate_default_token",
+		Resource:   "token",
+		ResourceID: defaultTokenUser,
+		Details: map[string]interface{}{
+			"grace": grace.String(),
+		},
+		Result:     result,
+		IP:         s.getClientIP(r),
+		UserAgent:  r.UserAgent(),
+	})
+
+	if err != nil {
+		s.sendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to rotate default token: %v", err))
+		return
+	}
+
+	rotated := RotatedToken{Value: value, RotatedAt: time.Now()}
+	if grace > 0 {
+		until := rotated.RotatedAt.Add(grace)
+		rotated.GraceUntil = &until
+	}
+	s.sendResponse(w, http.StatusOK, Response{
+		Success: true,
+		Message: "Default token rotated; store it now, it cannot be shown again",
+		Data:    rotated,
+	})
+}
@@ -25,12 +25,16 @@ type Backup struct {
 	CreatedAt   time.Time         `json:"created_at"`
 	Agents      []BackupAgent     `json:"agents"`
 	Version     string            `json:"version"`
+	// Scheduled backups are created by the Scheduler and subject to retention
+	Scheduled   bool              `json:"scheduled,omitempty"`
+	// IncludesVolumes is set when the contents of read-write volumes were archived
+	IncludesVolumes bool          `json:"includes_volumes,omitempty"`
 }
 
 // BackupAgent represents an agent in the backup
 type BackupAgent struct {
 	Agent       *agent.Agent      `json:"agent"`
-	VolumeData  map[string]string `json:"volume_data"` // path -> base64 encoded tar.gz
+	VolumeData  map[string]string `json:"volume_data"` // host path -> volume archive path
 }
 
 // Manager handles backup and restore operations
@@ -58,8 +62,14 @@ func NewManager(agentMgr *agent.Manager, redisClient *redis.Client, backupDir st
 	}
 }
 
-// CreateBackup creates a backup of specified agents (or all if empty)
-func (m *Manager) CreateBackup(ctx context.Context, name, description string, agentIDs []string) (*Backup, error) {
+// CreateBackup creates a backup of specified agents (or all if empty). With
+// includeVolumes the contents of each agent's read-write volumes are archived
+// too; read-only volumes are skipped since the agent can't have changed them.
+func (m *Manager) CreateBackup(ctx context.Context, name, description string, agentIDs []string, includeVolumes bool) (*Backup, error) {
+	return m.createBackup(ctx, name, description, agentIDs, includeVolumes, false)
+}
+
+func (m *Manager) createBackup(ctx context.Context, name, description string, agentIDs []string, includeVolumes, scheduled bool) (*Backup, error) {
 	backup := &Backup{
 		ID:          fmt.Sprintf("backup-%d", time.Now().Unix()),
 		Name:        name,
@@ -67,6 +77,8 @@ func (m *Manager) CreateBackup(ctx context.Context, name, description string, ag
 		CreatedAt:   time.Now(),
 		Version:     "1.0",
 		Agents:      []BackupAgent{},
+		Scheduled:   scheduled,
+		IncludesVolumes: includeVolumes,
 	}
 	
 	// Get agents to backup
@@ -98,15 +110,20 @@ func (m *Manager) CreateBackup(ctx context.Context, name, description string, ag
 			VolumeData: make(map[string]string),
 		}
 		
-		// Backup volume data if agent has volumes
-		if len(a.Volumes) > 0 {
+		// Backup volume data if requested
+		if includeVolumes {
 			for _, vol := range a.Volumes {
-				data, err := m.backupVolume(vol.HostPath)
+				if vol.ReadOnly {
+					continue
+				}
+				archivePath, err := m.backupVolume(backup.ID, vol.HostPath)
 				if err != nil {
 					log.Printf("Warning: Failed to backup volume %s: %v", vol.HostPath, err)
 					continue
 				}
-				backupAgent.VolumeData[vol.HostPath] = data
+				if archivePath != "" {
+					backupAgent.VolumeData[vol.HostPath] = archivePath
+				}
 			}
 		}
 		
@@ -129,8 +146,10 @@ func (m *Manager) CreateBackup(ctx context.Context, name, description string, ag
 	return backup, nil
 }
 
-// RestoreBackup restores agents from a backup
-func (m *Manager) RestoreBackup(ctx context.Context, backupID string, agentIDs []string) error {
+// RestoreBackup restores agents from a backup. remap moves volumes to new host
+// paths (old path -> new path); both the extracted data and the restored
+// agent's mounts use the new path.
+func (m *Manager) RestoreBackup(ctx context.Context, backupID string, agentIDs []string, remap map[string]string) error {
 	// Load backup
 	backup, err := m.LoadBackup(backupID)
 	if err != nil {
@@ -156,12 +175,19 @@ func (m *Manager) RestoreBackup(ctx context.Context, backupID string, agentIDs [
 	restoredCount := 0
 	for _, ba := range agentsToRestore {
 		// Restore volume data first
-		for path, data := range ba.VolumeData {
-			if err := m.restoreVolume(path, data); err != nil {
-				log.Printf("Warning: Failed to restore volume %s: %v", path, err)
+		for path, archivePath := range ba.VolumeData {
+			target := remapPath(path, remap)
+			if err := m.restoreVolume(target, archivePath); err != nil {
+				log.Printf("Warning: Failed to restore volume %s: %v", target, err)
 			}
 		}
 		
+		volumes := make([]agent.VolumeMapping, len(ba.Agent.Volumes))
+		for i, vol := range ba.Agent.Volumes {
+			vol.HostPath = remapPath(vol.HostPath, remap)
+			volumes[i] = vol
+		}
+		
 		// Deploy the agent
 		_, err := m.agentMgr.Deploy(
 			ctx,
@@ -173,8 +199,38 @@ func (m *Manager) RestoreBackup(ctx context.Context, backupID string, agentIDs [
 			ba.Agent.AutoRestart,
 			ba.Agent.Token,
 			ba.Agent.Ports,
-			ba.Agent.Volumes,
+			volumes,
 			ba.Agent.HealthCheck,
+			agent.DeployOptions{
+				Namespace:     ba.Agent.Namespace,
+				Labels:        ba.Agent.Labels,
+				MaxRestarts:   ba.Agent.MaxRestarts,
+				RestartWindow: ba.Agent.RestartWindow,
+				ProxyPort:     ba.Agent.ProxyPort,
+				Routes:        ba.Agent.Routes,
+				ReplayRateLimit: ba.Agent.ReplayRateLimit,
+				RateLimit:     ba.Agent.RateLimit,
+				RateLimitBurst: ba.Agent.RateLimitBurst,
+				ProxyTimeout:  ba.Agent.ProxyTimeout,
+				ProxyRetries:  ba.Agent.ProxyRetries,
+				StopSignal:    ba.Agent.StopSignal,
+				StopTimeout:   ba.Agent.StopTimeout,
+				MemorySwap:    ba.Agent.MemorySwap,
+				MemoryReservation: ba.Agent.MemoryReservation,
+				CPUShares:     ba.Agent.CPUShares,
+				PidsLimit:     ba.Agent.PidsLimit,
+				Ulimits:       ba.Agent.Ulimits,
+				GPUs:          ba.Agent.GPUs,
+				Networks:      ba.Agent.Networks,
+				NetworkAliases: ba.Agent.NetworkAliases,
+				InjectRedis:   ba.Agent.InjectRedis,
+				InjectAPI:     ba.Agent.InjectAPI,
+				SharedVolumes: ba.Agent.SharedVolumes,
+				AllowArchMismatch: ba.Agent.AllowArchMismatch,
+				// Recreate the exact image that was backed up
+				ImageDigest:   ba.Agent.ImageDigest,
+				Secrets:       ba.Agent.Secrets,
+			},
 		)
 		
 		if err != nil {
@@ -190,6 +246,14 @@ func (m *Manager) RestoreBackup(ctx context.Context, backupID string, agentIDs [
 	return nil
 }
 
+// remapPath returns the new location for a volume host path, if remapped
+func remapPath(path string, remap map[string]string) string {
+	if newPath, ok := remap[path]; ok {
+		return newPath
+	}
+	return path
+}
+
 // ListBackups returns all available backups
 func (m *Manager) ListBackups() ([]*Backup, error) {
 	files, err := os.ReadDir(m.backupDir)
@@ -237,28 +301,66 @@ func (m *Manager) DeleteBackup(backupID string) error {
 	return os.Remove(backupFile)
 }
 
-// backupVolume creates a tar.gz of a directory and returns base64 encoded data
-func (m *Manager) backupVolume(path string) (string, error) {
-	// Skip if path doesn't exist
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return "", nil
+// PruneScheduledBackups deletes scheduled backups, including their volume
+// archives, created before cutoff. Backups created by hand are never pruned.
+func (m *Manager) PruneScheduledBackups(cutoff time.Time) ([]string, error) {
+	backups, err := m.ListBackups()
+	if err != nil {
+		return nil, err
+	}
+	
+	pruned := []string{}
+	for _, b := range backups {
+		if !b.Scheduled || !b.CreatedAt.Before(cutoff) {
+			continue
+		}
+		
+		for _, ba := range b.Agents {
+			for _, archive := range ba.VolumeData {
+				if err := os.Remove(archive); err != nil && !os.IsNotExist(err) {
+					log.Printf("Warning: Failed to remove volume archive %s: %v", archive, err)
+				}
+			}
+		}
+		os.Remove(filepath.Join(m.backupDir, "volumes", b.ID))
+		if err := m.DeleteBackup(b.ID); err != nil {
+			log.Printf("Warning: Failed to delete backup %s: %v", b.ID, err)
+			continue
+		}
+		pruned = append(pruned, b.ID)
 	}
 	
-	// Create temporary file for tar.gz
-	tmpFile, err := os.CreateTemp("", "volume-*.tar.gz")
+	return pruned, nil
+}
+
+// backupVolume streams a tar.gz of a directory into the backup directory and
+// returns the archive's path. Nothing is buffered in memory, so large
+// volumes are fine.
+func (m *Manager) backupVolume(backupID, path string) (string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		// Skip if path doesn't exist
+		return "", nil
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+		return "", fmt.Errorf("failed to stat volume: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
 	
-	// Create gzip writer
-	gw := gzip.NewWriter(tmpFile)
-	defer gw.Close()
+	archivePath := filepath.Join(m.backupDir, "volumes", backupID, fmt.Sprintf("%d.tar.gz", time.Now().UnixNano()))
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create volume backup directory: %w", err)
+	}
 	
-	// Create tar writer
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create volume backup: %w", err)
+	}
+	
+	gw := gzip.NewWriter(archive)
 	tw := tar.NewWriter(gw)
-	defer tw.Close()
 	
 	// Walk directory and add files to tar
 	err = filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
@@ -266,8 +368,14 @@ func (m *Manager) backupVolume(path string) (string, error) {
 			return err
 		}
 		
-		// Create tar header
-		header, err := tar.FileInfoHeader(fi, fi.Name())
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		
+		header, err := tar.FileInfoHeader(fi, link)
 		if err != nil {
 			return err
 		}
@@ -277,79 +385,68 @@ func (m *Manager) backupVolume(path string) (string, error) {
 		if err != nil {
 			return err
 		}
-		header.Name = relPath
+		header.Name = filepath.ToSlash(relPath)
 		
-		// Write header
 		if err := tw.WriteHeader(header); err != nil {
 			return err
 		}
 		
-		// Write file content if not a directory
-		if !fi.IsDir() {
-			data, err := os.Open(file)
-			if err != nil {
-				return err
-			}
-			defer data.Close()
-			
-			if _, err := io.Copy(tw, data); err != nil {
-				return err
-			}
+		if !fi.Mode().IsRegular() {
+			return nil
 		}
 		
-		return nil
+		data, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer data.Close()
+		
+		_, err = io.Copy(tw, data)
+		return err
 	})
 	
-	if err != nil {
-		return "", fmt.Errorf("failed to create tar: %w", err)
-	}
-	
 	// Close writers to flush data
-	tw.Close()
-	gw.Close()
-	tmpFile.Close()
-	
-	// Read and encode file
-	data, err := os.ReadFile(tmpFile.Name())
-	if err != nil {
-		return "", fmt.Errorf("failed to read tar file: %w", err)
+	if closeErr := tw.Close(); err == nil {
+		err = closeErr
 	}
-	
-	// For simplicity, we'll store the path to the temp file instead of base64
-	// In production, you'd want to use proper storage
-	backupPath := filepath.Join(m.backupDir, "volumes", fmt.Sprintf("%d.tar.gz", time.Now().UnixNano()))
-	os.MkdirAll(filepath.Dir(backupPath), 0755)
-	
-	if err := os.WriteFile(backupPath, data, 0644); err != nil {
-		return "", fmt.Errorf("failed to save volume backup: %w", err)
+	if closeErr := gw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return "", fmt.Errorf("failed to create tar: %w", err)
 	}
 	
-	return backupPath, nil
+	return archivePath, nil
 }
 
-// restoreVolume restores a volume from backup
+// restoreVolume extracts a volume archive into path
 func (m *Manager) restoreVolume(path, backupPath string) error {
 	if backupPath == "" {
 		return nil
 	}
 	
-	// Read backup file
-	data, err := os.ReadFile(backupPath)
+	archive, err := os.Open(backupPath)
 	if err != nil {
-		return fmt.Errorf("failed to read backup file: %w", err)
+		return fmt.Errorf("failed to open backup file: %w", err)
 	}
+	defer archive.Close()
 	
-	// Create gzip reader
-	gr, err := gzip.NewReader(strings.NewReader(string(data)))
+	gr, err := gzip.NewReader(archive)
 	if err != nil {
 		return fmt.Errorf("failed to create gzip reader: %w", err)
 	}
 	defer gr.Close()
 	
-	// Create tar reader
-	tr := tar.NewReader(gr)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create volume directory: %w", err)
+	}
 	
 	// Extract files
+	tr := tar.NewReader(gr)
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
@@ -359,35 +456,44 @@ func (m *Manager) restoreVolume(path, backupPath string) error {
 			return fmt.Errorf("failed to read tar header: %w", err)
 		}
 		
-		// Construct full path
-		target := filepath.Join(path, header.Name)
+		// Refuse entries that would land outside the volume
+		target := filepath.Join(path, filepath.FromSlash(header.Name))
+		if target != filepath.Clean(path) && !strings.HasPrefix(target, filepath.Clean(path)+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
 		
-		// Create directory if needed
-		if header.Typeflag == tar.TypeDir {
+		switch header.Typeflag {
+		case tar.TypeDir:
 			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
 				return fmt.Errorf("failed to create directory: %w", err)
 			}
-			continue
-		}
-		
-		// Create parent directory
-		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-			return fmt.Errorf("failed to create parent directory: %w", err)
-		}
-		
-		// Create file
-		file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(header.Mode))
-		if err != nil {
-			return fmt.Errorf("failed to create file: %w", err)
-		}
-		
-		// Copy file content
-		if _, err := io.Copy(file, tr); err != nil {
-			file.Close()
-			return fmt.Errorf("failed to extract file: %w", err)
+			
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create parent directory: %w", err)
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return fmt.Errorf("failed to create symlink: %w", err)
+			}
+			
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create parent directory: %w", err)
+			}
+			
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return fmt.Errorf("failed to create file: %w", err)
+			}
+			if _, err := io.Copy(file, tr); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to extract file: %w", err)
+			}
+			if err := file.Close(); err != nil {
+				return fmt.Errorf("failed to extract file: %w", err)
+			}
 		}
-		
-		file.Close()
 	}
 	
 	return nil
@@ -432,25 +538,40 @@ func (m *Manager) ExportBackup(backupID, outputPath string) error {
 				continue
 			}
 			
-			// Read volume backup
-			data, err := os.ReadFile(backupPath)
-			if err != nil {
-				log.Printf("Warning: Failed to read volume backup %s: %v", backupPath, err)
-				continue
-			}
-			
-			// Add to tar
-			header := &tar.Header{
-				Name: fmt.Sprintf("volumes/%s-%s.tar.gz", ba.Agent.Name, filepath.Base(path)),
-				Size: int64(len(data)),
-				Mode: 0644,
+			// Stream the volume archive into the export
+			if err := addFileToTar(tw, backupPath, fmt.Sprintf("volumes/%s-%s.tar.gz", ba.Agent.Name, filepath.Base(path))); err != nil {
+				log.Printf("Warning: Failed to export volume backup %s: %v", backupPath, err)
 			}
-			tw.WriteHeader(header)
-			tw.Write(data)
 		}
 	}
 	
 	log.Printf("Exported backup %s to %s", backupID, outputPath)
 	
 	return nil
-}
\ No newline at end of file
+}
+
+// addFileToTar copies a file into a tar stream under name
+func addFileToTar(tw *tar.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	
+	header := &tar.Header{
+		Name:    name,
+		Size:    info.Size(),
+		Mode:    0644,
+		ModTime: info.ModTime(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
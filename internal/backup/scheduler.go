@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/robfig/cron/v3"
+)
+
+// lastRunKey records when the scheduler last created a backup so that a run
+// missed while the server was down is made up on the next start
+const lastRunKey = "backup:schedule:last_run"
+
+// Scheduler creates backups on a cron schedule and prunes scheduled backups
+// older than the retention window
+type Scheduler struct {
+	manager     *Manager
+	redisClient *redis.Client
+	spec        string
+	schedule    cron.Schedule
+	retention   time.Duration
+	includeVolumes bool
+}
+
+// NewScheduler parses a standard five-field cron spec. A retentionDays of
+// zero keeps scheduled backups forever.
+func NewScheduler(manager *Manager, redisClient *redis.Client, spec string, retentionDays int, includeVolumes bool) (*Scheduler, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup schedule %q: %w", spec, err)
+	}
+	if retentionDays < 0 {
+		return nil, fmt.Errorf("backup retention days must not be negative")
+	}
+
+	return &Scheduler{
+		manager:     manager,
+		redisClient: redisClient,
+		spec:        spec,
+		schedule:    schedule,
+		retention:   time.Duration(retentionDays) * 24 * time.Hour,
+		includeVolumes: includeVolumes,
+	}, nil
+}
+
+// Start runs scheduled backups until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) error {
+	lastRun, err := s.LastRun(ctx)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if !lastRun.IsZero() && s.schedule.Next(lastRun).Before(time.Now()) {
+		log.Printf("Scheduled backup missed since %s, running now", lastRun.Format(time.RFC3339))
+		s.run(ctx)
+	}
+
+	for {
+		next := s.schedule.Next(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+			s.run(ctx)
+		}
+	}
+}
+
+// LastRun returns when the last scheduled backup ran, or the zero time if never
+func (s *Scheduler) LastRun(ctx context.Context) (time.Time, error) {
+	value, err := s.redisClient.Get(ctx, lastRunKey).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last backup run: %w", err)
+	}
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid last backup run %q: %w", value, err)
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// NextRun returns when the next scheduled backup will run. A run that was
+// missed is due immediately.
+func (s *Scheduler) NextRun(ctx context.Context) (time.Time, error) {
+	lastRun, err := s.LastRun(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !lastRun.IsZero() {
+		if missed := s.schedule.Next(lastRun); missed.Before(time.Now()) {
+			return time.Now(), nil
+		}
+	}
+	return s.schedule.Next(time.Now()), nil
+}
+
+// Spec returns the cron expression the scheduler runs on
+func (s *Scheduler) Spec() string {
+	return s.spec
+}
+
+// Retention returns how long scheduled backups are kept (0 = forever)
+func (s *Scheduler) Retention() time.Duration {
+	return s.retention
+}
+
+// run creates one scheduled backup and prunes expired ones
+func (s *Scheduler) run(ctx context.Context) {
+	now := time.Now()
+	name := fmt.Sprintf("scheduled-%s", now.Format("20060102-1504"))
+	if _, err := s.manager.createBackup(ctx, name, fmt.Sprintf("Scheduled backup (%s)", s.spec), nil, s.includeVolumes, true); err != nil {
+		log.Printf("Scheduled backup failed: %v", err)
+	}
+
+	// Record the attempt even on failure so a broken backup doesn't rerun on every start
+	if err := s.redisClient.Set(ctx, lastRunKey, now.Unix(), 0).Err(); err != nil {
+		log.Printf("Warning: failed to record backup run: %v", err)
+	}
+
+	if s.retention > 0 {
+		pruned, err := s.manager.PruneScheduledBackups(now.Add(-s.retention))
+		if err != nil {
+			log.Printf("Warning: failed to prune backups: %v", err)
+		}
+		if len(pruned) > 0 {
+			log.Printf("Pruned %d scheduled backups older than %s", len(pruned), s.retention)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/agentainer/agentainer-lab/internal/agent"
 	"gopkg.in/yaml.v3"
@@ -22,6 +24,7 @@ type DeploymentConfig struct {
 // DeploymentMetadata contains deployment metadata
 type DeploymentMetadata struct {
 	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
 	Description string            `yaml:"description,omitempty"`
 	Labels      map[string]string `yaml:"labels,omitempty"`
 }
@@ -34,15 +37,44 @@ type DeploymentSpec struct {
 // AgentSpec defines a single agent configuration
 type AgentSpec struct {
 	Name         string                 `yaml:"name"`
+	Namespace    string                 `yaml:"namespace,omitempty"`
+	Labels       map[string]string      `yaml:"labels,omitempty"`
 	Image        string                 `yaml:"image"`
 	Replicas     int                    `yaml:"replicas,omitempty"`
 	Env          map[string]string      `yaml:"env,omitempty"`
+	// EnvFile is a .env file relative to the deployment file; env overrides it
+	EnvFile      string                 `yaml:"envFile,omitempty"`
+	// Secrets maps environment variable names to stored secret names
+	Secrets      map[string]string      `yaml:"secrets,omitempty"`
 	Resources    ResourceSpec           `yaml:"resources,omitempty"`
 	Volumes      []VolumeSpec           `yaml:"volumes,omitempty"`
+	SharedVolumes bool                  `yaml:"sharedVolumes,omitempty"`
+	AllowArchMismatch bool              `yaml:"allowArchMismatch,omitempty"`
+	PullPolicy   string                 `yaml:"pullPolicy,omitempty"`
+	PinDigest    bool                   `yaml:"pinDigest,omitempty"`
 	HealthCheck  *HealthCheckSpec       `yaml:"healthCheck,omitempty"`
 	Persistence  *PersistenceSpec       `yaml:"persistence,omitempty"`
 	AutoRestart  bool                   `yaml:"autoRestart,omitempty"`
+	MaxRestarts  int                    `yaml:"maxRestarts,omitempty"`
+	RestartWindow string                `yaml:"restartWindow,omitempty"`
+	ReplayRateLimit float64             `yaml:"replayRateLimit,omitempty"`
+	RateLimit    float64                `yaml:"rateLimit,omitempty"`
+	RateLimitBurst int                  `yaml:"rateLimitBurst,omitempty"`
+	ProxyTimeout string                 `yaml:"proxyTimeout,omitempty"`
+	ProxyRetries int                    `yaml:"proxyRetries,omitempty"`
+	StopSignal   string                 `yaml:"stopSignal,omitempty"`
+	StopTimeout  string                 `yaml:"stopTimeout,omitempty"`
 	Token        string                 `yaml:"token,omitempty"`
+	ProxyPort    int                    `yaml:"proxyPort,omitempty"`
+	Routes       map[string]int         `yaml:"routes,omitempty"`
+	// Networks are additional user-defined Docker networks to join;
+	// NetworkAliases are DNS names other agents can reach this one by
+	Networks     []string               `yaml:"networks,omitempty"`
+	NetworkAliases []string             `yaml:"networkAliases,omitempty"`
+	// InjectRedis and InjectAPI pass the agent how to reach Agentainer's
+	// Redis and API through its environment
+	InjectRedis  bool                   `yaml:"injectRedis,omitempty"`
+	InjectAPI    bool                   `yaml:"injectApi,omitempty"`
 	Dependencies []string               `yaml:"dependencies,omitempty"`
 }
 
@@ -50,6 +82,13 @@ type AgentSpec struct {
 type ResourceSpec struct {
 	Memory string `yaml:"memory,omitempty"` // e.g., "512Mi", "2Gi"
 	CPU    string `yaml:"cpu,omitempty"`    // e.g., "500m", "2"
+	Size   string `yaml:"size,omitempty"`   // named preset from config; cpu/memory override it
+	MemorySwap string   `yaml:"memorySwap,omitempty"` // memory plus swap, e.g. "1Gi"; "-1" for unlimited
+	MemoryReservation string `yaml:"memoryReservation,omitempty"` // soft memory limit, e.g. "256Mi"
+	CPUShares  int64    `yaml:"cpuShares,omitempty"`  // relative CPU weight, 1024 = default
+	PidsLimit  int64    `yaml:"pidsLimit,omitempty"`
+	Ulimits    []string `yaml:"ulimits,omitempty"`    // e.g. "nofile=1024:2048"
+	GPUs       string   `yaml:"gpus,omitempty"`       // "all", "count=N" or device IDs such as "0,1"
 }
 
 // VolumeSpec defines volume mounting
@@ -61,10 +100,25 @@ type VolumeSpec struct {
 
 // HealthCheckSpec defines health check configuration
 type HealthCheckSpec struct {
+	Type     string `yaml:"type,omitempty"` // http (default), tcp or exec
 	Endpoint string `yaml:"endpoint"`
+	Command  []string `yaml:"command,omitempty"`
 	Interval string `yaml:"interval"`
 	Timeout  string `yaml:"timeout,omitempty"`
 	Retries  int    `yaml:"retries,omitempty"`
+	StartPeriod string `yaml:"startPeriod,omitempty"` // failures within it of starting don't count, e.g. "60s"
+	UseImageHealthcheck bool `yaml:"useImageHealthcheck,omitempty"`
+	// Readiness gates proxied traffic without restarting the agent
+	Readiness *ReadinessSpec `yaml:"readiness,omitempty"`
+}
+
+// ReadinessSpec defines a readiness probe
+type ReadinessSpec struct {
+	Type     string   `yaml:"type,omitempty"` // http (default), tcp or exec
+	Endpoint string   `yaml:"endpoint,omitempty"`
+	Command  []string `yaml:"command,omitempty"`
+	Interval string   `yaml:"interval,omitempty"`
+	Timeout  string   `yaml:"timeout,omitempty"`
 }
 
 // PersistenceSpec defines persistence configuration
@@ -93,8 +147,9 @@ func LoadDeploymentConfig(filename string) (*DeploymentConfig, error) {
 		return nil, fmt.Errorf("failed to read deployment file: %w", err)
 	}
 
-	// Expand environment variables in file content
-	content := os.ExpandEnv(string(data))
+	// Expand host environment variables in file content. Unset variables are
+	// left as ${VAR} so they can still reference the agent's own env at start.
+	content := ExpandHostEnv(string(data))
 
 	// Parse YAML
 	var config DeploymentConfig
@@ -102,6 +157,31 @@ func LoadDeploymentConfig(filename string) (*DeploymentConfig, error) {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
+	// Merge env files, resolved relative to the deployment file; values set
+	// in env take precedence
+	for i := range config.Spec.Agents {
+		spec := &config.Spec.Agents[i]
+		if spec.EnvFile == "" {
+			continue
+		}
+		envPath := spec.EnvFile
+		if !filepath.IsAbs(envPath) {
+			envPath = filepath.Join(filepath.Dir(filename), envPath)
+		}
+		fileEnv, err := ParseEnvFile(envPath)
+		if err != nil {
+			return nil, fmt.Errorf("agent[%s]: %w", spec.Name, err)
+		}
+		if spec.Env == nil {
+			spec.Env = make(map[string]string)
+		}
+		for key, value := range fileEnv {
+			if _, ok := spec.Env[key]; !ok {
+				spec.Env[key] = value
+			}
+		}
+	}
+
 	// Validate
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid deployment config: %w", err)
@@ -110,54 +190,239 @@ func LoadDeploymentConfig(filename string) (*DeploymentConfig, error) {
 	return &config, nil
 }
 
-// Validate checks if the deployment configuration is valid
+// Validate checks if the deployment configuration is valid. Every problem
+// found is reported, joined into one error.
 func (d *DeploymentConfig) Validate() error {
+	var errs []error
 	if d.APIVersion == "" {
-		return fmt.Errorf("apiVersion is required")
+		errs = append(errs, fmt.Errorf("apiVersion is required"))
 	}
 	if d.Kind != "AgentDeployment" {
-		return fmt.Errorf("kind must be 'AgentDeployment', got '%s'", d.Kind)
+		errs = append(errs, fmt.Errorf("kind must be 'AgentDeployment', got '%s'", d.Kind))
 	}
 	if d.Metadata.Name == "" {
-		return fmt.Errorf("metadata.name is required")
+		errs = append(errs, fmt.Errorf("metadata.name is required"))
+	}
+	if d.Metadata.Namespace != "" {
+		if err := validateNamespace(d.Metadata.Namespace); err != nil {
+			errs = append(errs, fmt.Errorf("metadata.namespace: %w", err))
+		}
 	}
 	if len(d.Spec.Agents) == 0 {
-		return fmt.Errorf("at least one agent must be specified")
+		errs = append(errs, fmt.Errorf("at least one agent must be specified"))
 	}
 
 	// Validate each agent
 	agentNames := make(map[string]bool)
 	for i, agent := range d.Spec.Agents {
 		if agent.Name == "" {
-			return fmt.Errorf("agent[%d]: name is required", i)
+			errs = append(errs, fmt.Errorf("agent[%d]: name is required", i))
 		}
 		if agent.Image == "" {
-			return fmt.Errorf("agent[%d]: image is required", i)
+			errs = append(errs, fmt.Errorf("agent[%d]: image is required", i))
 		}
 		if agentNames[agent.Name] {
-			return fmt.Errorf("duplicate agent name: %s", agent.Name)
+			errs = append(errs, fmt.Errorf("duplicate agent name: %s", agent.Name))
 		}
 		agentNames[agent.Name] = true
 
+		// Validate resources and volumes
+		if _, err := ParseCPU(agent.Resources.CPU); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: invalid cpu: %w", agent.Name, err))
+		}
+		if _, err := ParseMemory(agent.Resources.Memory); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: invalid memory: %w", agent.Name, err))
+		}
+		for j, volume := range agent.Volumes {
+			if volume.Host == "" || volume.Container == "" {
+				errs = append(errs, fmt.Errorf("agent[%s]: volumes[%d]: host and container paths are required", agent.Name, j))
+			} else if !strings.HasPrefix(volume.Container, "/") {
+				errs = append(errs, fmt.Errorf("agent[%s]: volumes[%d]: container path %s must be absolute", agent.Name, j, volume.Container))
+			}
+		}
+		
+		if agent.Namespace != "" {
+			if err := validateNamespace(agent.Namespace); err != nil {
+				errs = append(errs, fmt.Errorf("agent[%s]: %w", agent.Name, err))
+			}
+		}
+		if err := validateLabels(agent.Labels); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: %w", agent.Name, err))
+		}
+
 		// Validate replicas
 		if agent.Replicas < 0 {
-			return fmt.Errorf("agent[%s]: replicas cannot be negative", agent.Name)
+			errs = append(errs, fmt.Errorf("agent[%s]: replicas cannot be negative", agent.Name))
 		}
 		if agent.Replicas == 0 {
 			agent.Replicas = 1 // Default to 1
 		}
 
+		// Validate proxy port and routes
+		if err := validateProxyPorts(agent.ProxyPort, agent.Routes); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: %w", agent.Name, err))
+		}
+
+		// Validate health check type
+		if agent.HealthCheck != nil {
+			if err := validateHealthCheck(agent.HealthCheck); err != nil {
+				errs = append(errs, fmt.Errorf("agent[%s]: %w", agent.Name, err))
+			}
+		}
+
+		if err := validatePullPolicy(agent.PullPolicy); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: %w", agent.Name, err))
+		}
+
+		// Validate restart limits
+		if agent.MaxRestarts < 0 {
+			errs = append(errs, fmt.Errorf("agent[%s]: maxRestarts cannot be negative", agent.Name))
+		}
+		if agent.ReplayRateLimit < 0 {
+			errs = append(errs, fmt.Errorf("agent[%s]: replayRateLimit cannot be negative", agent.Name))
+		}
+		if agent.RateLimit < 0 || agent.RateLimitBurst < 0 {
+			errs = append(errs, fmt.Errorf("agent[%s]: rateLimit and rateLimitBurst cannot be negative", agent.Name))
+		}
+		if agent.RestartWindow != "" {
+			if _, err := time.ParseDuration(agent.RestartWindow); err != nil {
+				errs = append(errs, fmt.Errorf("agent[%s]: invalid restartWindow: %w", agent.Name, err))
+			}
+		}
+		if agent.ProxyTimeout != "" {
+			if _, err := time.ParseDuration(agent.ProxyTimeout); err != nil {
+				errs = append(errs, fmt.Errorf("agent[%s]: invalid proxyTimeout: %w", agent.Name, err))
+			}
+		}
+		if agent.ProxyRetries < 0 {
+			errs = append(errs, fmt.Errorf("agent[%s]: proxyRetries cannot be negative", agent.Name))
+		}
+		if err := validateStop(agent.StopSignal, agent.StopTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: %w", agent.Name, err))
+		}
+
+		// Validate pids, swap and ulimits
+		if agent.Resources.PidsLimit < 0 {
+			errs = append(errs, fmt.Errorf("agent[%s]: pidsLimit cannot be negative", agent.Name))
+		}
+		if _, err := ParseMemorySwap(agent.Resources.MemorySwap); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: invalid memorySwap: %w", agent.Name, err))
+		}
+		if agent.Resources.MemoryReservation != "" {
+			if _, err := ParseMemory(agent.Resources.MemoryReservation); err != nil {
+				errs = append(errs, fmt.Errorf("agent[%s]: invalid memoryReservation: %w", agent.Name, err))
+			}
+		}
+		if agent.Resources.CPUShares < 0 {
+			errs = append(errs, fmt.Errorf("agent[%s]: cpuShares cannot be negative", agent.Name))
+		}
+		if err := validateUlimits(agent.Resources.Ulimits); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: %w", agent.Name, err))
+		}
+		if err := validateGPUs(agent.Resources.GPUs); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: %w", agent.Name, err))
+		}
+		if err := validateNetworkAliases(agent.NetworkAliases); err != nil {
+			errs = append(errs, fmt.Errorf("agent[%s]: %w", agent.Name, err))
+		}
+
 		// Validate dependencies
 		for _, dep := range agent.Dependencies {
 			if !agentNames[dep] {
-				return fmt.Errorf("agent[%s]: dependency '%s' not found", agent.Name, dep)
+				errs = append(errs, fmt.Errorf("agent[%s]: dependency '%s' not found", agent.Name, dep))
 			}
 		}
 	}
 
+	return errors.Join(errs...)
+}
+
+// validateProxyPorts wraps agent.ValidateProxyPorts for use where the agent
+// package name is shadowed
+func validateProxyPorts(proxyPort int, routes map[string]int) error {
+	return agent.ValidateProxyPorts(proxyPort, routes)
+}
+
+// validateHealthCheck wraps agent.ValidateHealthCheck for use where the agent
+// package name is shadowed
+func validateHealthCheck(spec *HealthCheckSpec) error {
+	return agent.ValidateHealthCheck(spec.config())
+}
+
+// config converts the spec to the agent package's health check config
+func (h *HealthCheckSpec) config() *agent.HealthCheckConfig {
+	config := &agent.HealthCheckConfig{
+		Type:     h.Type,
+		Endpoint: h.Endpoint,
+		Command:  h.Command,
+		Interval: h.Interval,
+		Timeout:  h.Timeout,
+		Retries:  h.Retries,
+		StartPeriod: h.StartPeriod,
+		UseImageHealthcheck: h.UseImageHealthcheck,
+	}
+	if h.Readiness != nil {
+		config.Readiness = &agent.ReadinessProbe{
+			Type:     h.Readiness.Type,
+			Endpoint: h.Readiness.Endpoint,
+			Command:  h.Readiness.Command,
+			Interval: h.Readiness.Interval,
+			Timeout:  h.Readiness.Timeout,
+		}
+	}
+	return config
+}
+
+// validatePullPolicy wraps agent.ValidatePullPolicy for use where the agent
+// package name is shadowed
+func validatePullPolicy(policy string) error {
+	return agent.ValidatePullPolicy(policy)
+}
+
+// validateUlimits parses each ulimit where the agent package name is shadowed
+func validateUlimits(ulimits []string) error {
+	for _, ulimit := range ulimits {
+		if _, err := agent.ParseUlimit(ulimit); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// validateGPUs parses the gpus value where the agent package name is shadowed
+func validateGPUs(gpus string) error {
+	_, err := agent.ParseGPUs(gpus)
+	return err
+}
+
+// validateNetworkAliases wraps agent.ValidateNetworkAliases for use where
+// the agent package name is shadowed
+func validateNetworkAliases(aliases []string) error {
+	return agent.ValidateNetworkAliases(aliases)
+}
+
+// validateStop checks the stop signal and timeout where the agent package
+// name is shadowed
+func validateStop(signal, timeout string) error {
+	if _, err := agent.ParseStopSignal(signal); err != nil {
+		return err
+	}
+	_, err := agent.ParseStopTimeout(timeout)
+	return err
+}
+
+// validateLabels wraps agent.ValidateLabels for use where the agent package
+// name is shadowed
+func validateLabels(labels map[string]string) error {
+	return agent.ValidateLabels(labels)
+}
+
+// validateNamespace wraps agent.ValidateNamespace for use where the agent
+// package name is shadowed by loop variables
+func validateNamespace(namespace string) error {
+	return agent.ValidateNamespace(namespace)
+}
+
 // ConvertToAgentConfigs converts AgentSpec to agent configurations
 func (a *AgentSpec) ConvertToAgentConfigs() ([]AgentConfig, error) {
 	configs := []AgentConfig{}
@@ -189,6 +454,24 @@ func (a *AgentSpec) ConvertToAgentConfigs() ([]AgentConfig, error) {
 			}
 			memLimit = mem
 		}
+		memSwap, err := ParseMemorySwap(a.Resources.MemorySwap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory swap: %w", err)
+		}
+		var memReservation int64
+		if a.Resources.MemoryReservation != "" {
+			if memReservation, err = ParseMemory(a.Resources.MemoryReservation); err != nil {
+				return nil, fmt.Errorf("invalid memory reservation: %w", err)
+			}
+		}
+		var ulimits []agent.Ulimit
+		for _, value := range a.Resources.Ulimits {
+			ulimit, err := agent.ParseUlimit(value)
+			if err != nil {
+				return nil, err
+			}
+			ulimits = append(ulimits, ulimit)
+		}
 
 		// Convert volumes
 		volumes := []agent.VolumeMapping{}
@@ -203,23 +486,47 @@ func (a *AgentSpec) ConvertToAgentConfigs() ([]AgentConfig, error) {
 		// Convert health check if specified
 		var healthCheck *agent.HealthCheckConfig
 		if a.HealthCheck != nil {
-			healthCheck = &agent.HealthCheckConfig{
-				Endpoint: a.HealthCheck.Endpoint,
-				Interval: a.HealthCheck.Interval,
-				Timeout:  a.HealthCheck.Timeout,
-				Retries:  a.HealthCheck.Retries,
-			}
+			healthCheck = a.HealthCheck.config()
 		}
 
 		config := AgentConfig{
 			Name:        name,
+			Namespace:   a.Namespace,
+			Labels:      a.Labels,
 			Image:       a.Image,
 			EnvVars:     a.Env,
+			Secrets:     a.Secrets,
 			CPULimit:    cpuLimit,
 			MemoryLimit: memLimit,
 			AutoRestart: a.AutoRestart,
+			MaxRestarts: a.MaxRestarts,
+			RestartWindow: a.RestartWindow,
+			ReplayRateLimit: a.ReplayRateLimit,
+			RateLimit:   a.RateLimit,
+			RateLimitBurst: a.RateLimitBurst,
+			ProxyTimeout: a.ProxyTimeout,
+			ProxyRetries: a.ProxyRetries,
+			StopSignal:  a.StopSignal,
+			StopTimeout: a.StopTimeout,
+			MemorySwap:  memSwap,
+			MemoryReservation: memReservation,
+			CPUShares:   a.Resources.CPUShares,
+			PidsLimit:   a.Resources.PidsLimit,
+			Ulimits:     ulimits,
+			GPUs:        a.Resources.GPUs,
+			Networks:    a.Networks,
+			NetworkAliases: a.NetworkAliases,
+			InjectRedis: a.InjectRedis,
+			InjectAPI:   a.InjectAPI,
+			Size:        a.Resources.Size,
 			Token:       a.Token,
+			ProxyPort:   a.ProxyPort,
+			Routes:      a.Routes,
 			Volumes:     volumes,
+			SharedVolumes: a.SharedVolumes,
+			AllowArchMismatch: a.AllowArchMismatch,
+			PullPolicy:  a.PullPolicy,
+			PinDigest:   a.PinDigest,
 			HealthCheck: healthCheck,
 		}
 
@@ -232,13 +539,42 @@ func (a *AgentSpec) ConvertToAgentConfigs() ([]AgentConfig, error) {
 // AgentConfig represents a single agent configuration
 type AgentConfig struct {
 	Name        string
+	Namespace   string
+	Labels      map[string]string
 	Image       string
 	EnvVars     map[string]string
+	Secrets     map[string]string
 	CPULimit    int64
 	MemoryLimit int64
 	AutoRestart bool
+	MaxRestarts int
+	RestartWindow string
+	ReplayRateLimit float64
+	RateLimit   float64
+	RateLimitBurst int
+	ProxyTimeout string
+	ProxyRetries int
+	StopSignal  string
+	StopTimeout string
+	MemorySwap  int64
+	MemoryReservation int64
+	CPUShares   int64
+	PidsLimit   int64
+	Ulimits     []agent.Ulimit
+	GPUs        string
+	Networks    []string
+	NetworkAliases []string
+	InjectRedis bool
+	InjectAPI   bool
+	Size        string
 	Token       string
+	ProxyPort   int
+	Routes      map[string]int
 	Volumes     []agent.VolumeMapping
+	SharedVolumes bool
+	AllowArchMismatch bool
+	PullPolicy  string
+	PinDigest   bool
 	HealthCheck *agent.HealthCheckConfig
 }
 
@@ -334,4 +670,13 @@ func ParseMemory(mem string) (int64, error) {
 		return 0, fmt.Errorf("invalid memory value: %s (use formats like 512M, 2G, 1.5G)", mem)
 	}
 	return bytes, nil
-}
\ No newline at end of file
+}
+
+// ParseMemorySwap parses a memory-plus-swap limit like ParseMemory, also
+// accepting "-1" for unlimited swap
+func ParseMemorySwap(swap string) (int64, error) {
+	if strings.TrimSpace(swap) == "-1" {
+		return -1, nil
+	}
+	return ParseMemory(swap)
+}
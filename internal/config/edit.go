@@ -0,0 +1,379 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agentainer/agentainer-lab/internal/agent"
+	"github.com/agentainer/agentainer-lab/internal/logging"
+	"github.com/agentainer/agentainer-lab/internal/storage"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+const redactedValue = "[redacted]"
+
+// secretKeys are settings whose values are never shown; any key named
+// password is redacted as well
+var secretKeys = map[string]bool{
+	"redis.password":        true,
+	"storage.postgres_dsn":  true,
+	"security.default_token": true,
+	"security.secrets_key":  true,
+	"alerts.slack_url":      true,
+	"alerts.webhook_url":    true,
+}
+
+// keyValidators check values on set with the same parsers used at deploy
+var keyValidators = map[string]func(string) error{
+	"deploy.default_namespace": agent.ValidateNamespace,
+	"logging.level": func(value string) error {
+		_, err := logging.ParseLevel(value)
+		return err
+	},
+	"storage.backend": func(value string) error {
+		if value != storage.BackendRedis && value != storage.BackendPostgres {
+			return fmt.Errorf("unknown storage backend '%s' (use redis or postgres)", value)
+		}
+		return nil
+	},
+	"replay.max_body_size": validateSize,
+	"agent_logs.max_size":  validateSize,
+	"server.port": validatePort,
+	"redis.port":  validatePort,
+}
+
+func validateSize(value string) error {
+	_, err := ParseMemory(value)
+	return err
+}
+
+func validatePort(value string) error {
+	port, _ := strconv.Atoi(value)
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	return nil
+}
+
+// ConfigFileUsed returns the config file that was loaded, or "" if none was
+// found and only defaults and the environment apply
+func ConfigFileUsed() string {
+	return viper.ConfigFileUsed()
+}
+
+// DefaultConfigFile is where config set writes when no config file exists
+func DefaultConfigFile() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".agentainer", "config.yaml")
+}
+
+// EnvOverrides maps the config keys set through the environment to the
+// variables that set them
+func EnvOverrides() map[string]string {
+	overrides := make(map[string]string)
+	for _, binding := range envBindings() {
+		if _, ok := overrides[binding.Key]; ok {
+			continue
+		}
+		if _, ok := os.LookupEnv(binding.Env); ok {
+			overrides[binding.Key] = binding.Env
+		}
+	}
+	return overrides
+}
+
+// EffectiveSettings returns the loaded configuration, merged from defaults,
+// the config file and the environment, with secrets redacted
+func EffectiveSettings() map[string]interface{} {
+	settings := viper.AllSettings()
+	redactSettings("", settings)
+	return settings
+}
+
+func redactSettings(prefix string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			if secretKeys[path] || key == "password" {
+				if s, ok := child.(string); !ok || s != "" {
+					v[key] = redactedValue
+				}
+				continue
+			}
+			redactSettings(path, child)
+		}
+	case []interface{}:
+		for _, item := range v {
+			redactSettings(prefix, item)
+		}
+	}
+}
+
+// ValidateSetting checks value for key without writing it
+func ValidateSetting(key, value string) error {
+	_, err := settingNode(strings.ToLower(key), value)
+	return err
+}
+
+// SetValue validates value for key and writes it to the config file at
+// path, creating the file if needed and keeping its comments
+func SetValue(path, key, value string) error {
+	// Viper matches keys case-insensitively and stores them lowercased
+	key = strings.ToLower(key)
+	node, err := settingNode(key, value)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if len(data) > 0 {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	// Rewrite an existing value in place so the file keeps its layout;
+	// re-encoding drops blank lines and comment alignment
+	var out bytes.Buffer
+	if existing := findNode(doc.Content[0], strings.Split(key, ".")); existing != nil {
+		if replaced, ok := replaceScalar(data, existing, node); ok {
+			out.Write(replaced)
+		}
+	}
+	if out.Len() == 0 {
+		if err := setNode(doc.Content[0], strings.Split(key, "."), node); err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&doc); err != nil {
+			return fmt.Errorf("failed to encode config file: %w", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(path, out.Bytes(), mode); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// settingNode parses value according to the type of key's field in Config
+func settingNode(key, value string) (*yaml.Node, error) {
+	fieldType, err := settingType(key)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value, Tag: "!!str"}
+	switch {
+	case fieldType == reflect.TypeOf(time.Duration(0)):
+		if _, err := time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: expected a duration such as 30s: %w", key, err)
+		}
+	case fieldType.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: expected true or false", key)
+		}
+		node.Tag, node.Value = "!!bool", strconv.FormatBool(b)
+	case fieldType.Kind() >= reflect.Int && fieldType.Kind() <= reflect.Int64:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s: expected a whole number", key)
+		}
+		node.Tag = "!!int"
+	case fieldType.Kind() == reflect.Float64:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s: expected a number", key)
+		}
+		node.Tag = "!!float"
+	case fieldType.Kind() != reflect.String:
+		return nil, fmt.Errorf("%s is a %s; edit the config file directly", key, fieldType.Kind())
+	}
+
+	validate := keyValidators[key]
+	switch {
+	case strings.HasPrefix(key, "logging.modules."):
+		validate = keyValidators["logging.level"]
+	case strings.HasPrefix(key, "sizes.") && strings.HasSuffix(key, ".cpu"):
+		validate = func(v string) error { _, err := ParseCPU(v); return err }
+	case strings.HasPrefix(key, "sizes.") && strings.HasSuffix(key, ".memory"):
+		validate = func(v string) error { _, err := ParseMemory(v); return err }
+	}
+	if validate != nil {
+		if err := validate(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	return node, nil
+}
+
+// settingType finds the type of the Config field a dotted key refers to.
+// Map fields such as sizes take the map key as the next part of the key.
+func settingType(key string) (reflect.Type, error) {
+	t := reflect.TypeOf(Config{})
+	for _, part := range strings.Split(key, ".") {
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := structField(t, part)
+			if !ok {
+				return nil, fmt.Errorf("unknown config key '%s'", key)
+			}
+			t = field.Type
+		case reflect.Map:
+			if part == "" {
+				return nil, fmt.Errorf("unknown config key '%s'", key)
+			}
+			t = t.Elem()
+		default:
+			return nil, fmt.Errorf("unknown config key '%s'", key)
+		}
+	}
+	if t.Kind() == reflect.Struct || t.Kind() == reflect.Map {
+		return nil, fmt.Errorf("%s is a section; set one of its keys instead", key)
+	}
+	return t, nil
+}
+
+func structField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("mapstructure") == name {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// setNode sets path under a YAML mapping, adding mappings as needed and
+// keeping the comments of a value it replaces
+func setNode(mapping *yaml.Node, path []string, value *yaml.Node) error {
+	if mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file is not a YAML mapping")
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != path[0] {
+			continue
+		}
+		existing := mapping.Content[i+1]
+		if len(path) == 1 {
+			value.LineComment = existing.LineComment
+			mapping.Content[i+1] = value
+			return nil
+		}
+		if existing.Kind != yaml.MappingNode {
+			*existing = yaml.Node{Kind: yaml.MappingNode}
+		}
+		return setNode(existing, path[1:], value)
+	}
+
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}
+	if len(path) == 1 {
+		mapping.Content = append(mapping.Content, keyNode, value)
+		return nil
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	mapping.Content = append(mapping.Content, keyNode, child)
+	return setNode(child, path[1:], value)
+}
+
+// findNode returns the value at path under a YAML mapping, or nil
+func findNode(mapping *yaml.Node, path []string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return mapping.Content[i+1]
+		}
+		return findNode(mapping.Content[i+1], path[1:])
+	}
+	return nil
+}
+
+// replaceScalar swaps the text of a single-line scalar in data for value,
+// leaving everything else, including a trailing comment, as it was
+func replaceScalar(data []byte, old, value *yaml.Node) ([]byte, bool) {
+	if old.Kind != yaml.ScalarNode || (old.Style != 0 && old.Style != yaml.DoubleQuotedStyle && old.Style != yaml.SingleQuotedStyle) {
+		return nil, false
+	}
+	encoded, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	text := strings.TrimSuffix(string(encoded), "\n")
+	if strings.Contains(text, "\n") {
+		return nil, false
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if old.Line < 1 || old.Line > len(lines) {
+		return nil, false
+	}
+	line := []rune(lines[old.Line-1])
+	start := old.Column - 1
+	if start < 0 || start >= len(line) {
+		return nil, false
+	}
+
+	end := -1
+	switch old.Style {
+	case yaml.DoubleQuotedStyle:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				end = i + 1
+				break
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\'' {
+				if i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					continue
+				}
+				end = i + 1
+				break
+			}
+		}
+	default:
+		rest := string(line[start:])
+		if i := strings.Index(rest, " #"); i >= 0 {
+			rest = rest[:i]
+		}
+		end = start + len([]rune(strings.TrimRight(rest, " \t\r\n")))
+	}
+	if end < 0 {
+		return nil, false
+	}
+
+	lines[old.Line-1] = string(line[:start]) + text + string(line[end:])
+	return []byte(strings.Join(lines, "")), true
+}
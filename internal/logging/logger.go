@@ -8,10 +8,12 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/agentainer/agentainer-lab/internal/storage"
 )
 
 // LogLevel represents the severity of a log entry
@@ -25,6 +27,67 @@ const (
 	LevelFatal LogLevel = "FATAL"
 )
 
+// levelRank orders levels from least to most severe
+var levelRank = map[LogLevel]int{
+	LevelDebug: 0,
+	LevelInfo:  1,
+	LevelWarn:  2,
+	LevelError: 3,
+	LevelFatal: 4,
+}
+
+// Levels sets the least severe level that is logged, overall and per
+// component. The zero value logs INFO and above.
+type Levels struct {
+	Default LogLevel
+	Modules map[string]LogLevel
+}
+
+// ParseLevel parses a level name such as "warn", in any case
+func ParseLevel(name string) (LogLevel, error) {
+	level := LogLevel(strings.ToUpper(name))
+	if level == "WARNING" {
+		level = LevelWarn
+	}
+	if _, ok := levelRank[level]; !ok {
+		return "", fmt.Errorf("invalid log level '%s' (use debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
+// ParseLevels parses a default level and per-component overrides as given
+// in the logging section of config.yaml
+func ParseLevels(level string, modules map[string]string) (Levels, error) {
+	levels := Levels{Default: LevelInfo, Modules: make(map[string]LogLevel, len(modules))}
+	if level != "" {
+		parsed, err := ParseLevel(level)
+		if err != nil {
+			return Levels{}, err
+		}
+		levels.Default = parsed
+	}
+	for module, name := range modules {
+		parsed, err := ParseLevel(name)
+		if err != nil {
+			return Levels{}, fmt.Errorf("module %s: %w", module, err)
+		}
+		levels.Modules[module] = parsed
+	}
+	return levels, nil
+}
+
+// Enabled reports whether entries at level from component are logged
+func (lv Levels) Enabled(component string, level LogLevel) bool {
+	min, ok := lv.Modules[component]
+	if !ok {
+		min = lv.Default
+	}
+	if min == "" {
+		min = LevelInfo
+	}
+	return levelRank[level] >= levelRank[min]
+}
+
 // LogEntry represents a structured log entry
 type LogEntry struct {
 	Timestamp   time.Time              `json:"timestamp"`
@@ -52,54 +115,105 @@ type AuditEntry struct {
 	UserAgent   string                 `json:"user_agent,omitempty"`
 }
 
+const (
+	// DefaultMaxAge is how long entries are kept in the store by default
+	DefaultMaxAge = 7 * 24 * time.Hour
+	// DefaultFileMaxSize is the size at which log files are rotated by default
+	DefaultFileMaxSize = 100 * 1024 * 1024
+	// auditScanSpan is the first slice of the audit log read by a query;
+	// each further slice doubles, so a limited query stops reading once it
+	// has enough entries
+	auditScanSpan = time.Hour
+)
+
+// Options configures a Logger. The zero value writes log files to
+// ~/.agentainer/logs and keeps 7 days of entries in the store.
+type Options struct {
+	// Dir holds agentainer.log and audit.log; empty uses ~/.agentainer/logs
+	Dir         string
+	// NoFiles turns file output off
+	NoFiles     bool
+	// FileMaxSize rotates a log file once it grows past this many bytes
+	FileMaxSize int64
+	// MaxAge caps how long entries are kept in the store. MaxEntries caps
+	// the application log only, so audit entries are never dropped early;
+	// zero means no cap.
+	MaxAge      time.Duration
+	MaxEntries  int64
+	// Console echoes entries to stdout
+	Console     bool
+	Levels      Levels
+}
+
 // Logger manages structured logging
 type Logger struct {
 	mu          sync.RWMutex
 	redisClient *redis.Client
+	store       storage.Store
+	// logFile and auditFile are nil when file output is off
 	logFile     *os.File
 	auditFile   *os.File
 	logDir      string
 	maxSize     int64
 	maxAge      time.Duration
+	maxEntries  int64
 	console     bool
+	levels      Levels
+	stopChan    chan struct{}
+	// sinks receive a copy of each audit entry through sinkQueue
+	sinks       []AuditSink
+	sinkQueue   chan AuditEntry
+	sinksDone   chan struct{}
 }
 
-// NewLogger creates a new logger instance
-func NewLogger(redisClient *redis.Client, logDir string, console bool) (*Logger, error) {
-	if logDir == "" {
-		homeDir, _ := os.UserHomeDir()
-		logDir = filepath.Join(homeDir, ".agentainer", "logs")
+// NewLogger creates a new logger instance. Entries are kept in store for
+// querying; Redis carries the live stream.
+func NewLogger(redisClient *redis.Client, store storage.Store, opts Options) (*Logger, error) {
+	logger := &Logger{
+		redisClient: redisClient,
+		store:       store,
+		logDir:      opts.Dir,
+		maxSize:     opts.FileMaxSize,
+		maxAge:      opts.MaxAge,
+		maxEntries:  opts.MaxEntries,
+		console:     opts.Console,
+		levels:      opts.Levels,
+		stopChan:    make(chan struct{}),
 	}
-	
-	// Create log directory
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	if logger.maxSize <= 0 {
+		logger.maxSize = DefaultFileMaxSize
 	}
-	
-	// Open log files
-	logFile, err := openLogFile(filepath.Join(logDir, "agentainer.log"))
-	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %w", err)
+	if logger.maxAge <= 0 {
+		logger.maxAge = DefaultMaxAge
 	}
-	
-	auditFile, err := openLogFile(filepath.Join(logDir, "audit.log"))
-	if err != nil {
-		logFile.Close()
-		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	if logger.logDir == "" {
+		homeDir, _ := os.UserHomeDir()
+		logger.logDir = filepath.Join(homeDir, ".agentainer", "logs")
 	}
 	
-	logger := &Logger{
-		redisClient: redisClient,
-		logFile:     logFile,
-		auditFile:   auditFile,
-		logDir:      logDir,
-		maxSize:     100 * 1024 * 1024, // 100MB
-		maxAge:      7 * 24 * time.Hour, // 7 days
-		console:     console,
+	if !opts.NoFiles {
+		// Create log directory
+		if err := os.MkdirAll(logger.logDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %w", err)
+		}
+		
+		// Open log files
+		logFile, err := openLogFile(filepath.Join(logger.logDir, "agentainer.log"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		
+		auditFile, err := openLogFile(filepath.Join(logger.logDir, "audit.log"))
+		if err != nil {
+			logFile.Close()
+			return nil, fmt.Errorf("failed to open audit file: %w", err)
+		}
+		logger.logFile = logFile
+		logger.auditFile = auditFile
 	}
 	
-	// Start log rotation
-	go logger.rotateLoop()
+	// Start log rotation and store trimming
+	go logger.maintainLoop()
 	
 	return logger, nil
 }
@@ -109,25 +223,34 @@ func (l *Logger) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	
+	select {
+	case <-l.stopChan:
+	default:
+		close(l.stopChan)
+	}
 	if l.logFile != nil {
 		l.logFile.Close()
 	}
 	if l.auditFile != nil {
 		l.auditFile.Close()
 	}
+	l.closeSinks()
 	
 	return nil
 }
 
-// Log writes a log entry
+// Log writes a log entry unless its level is filtered out
 func (l *Logger) Log(entry LogEntry) {
+	if entry.Level != LevelFatal && !l.levels.Enabled(entry.Component, entry.Level) {
+		return
+	}
 	entry.Timestamp = time.Now()
 	
 	// Write to file
-	l.writeToFile(l.logFile, entry)
+	l.writeToFile(false, entry)
 	
-	// Write to Redis for real-time access
-	l.writeToRedis("logs", entry)
+	// Write to the store for querying
+	l.writeToStore(storage.LogApp, entry.Timestamp, entry)
 	
 	// Write to console if enabled
 	if l.console {
@@ -140,10 +263,13 @@ func (l *Logger) Audit(entry AuditEntry) {
 	entry.Timestamp = time.Now()
 	
 	// Write to file
-	l.writeToFile(l.auditFile, entry)
+	l.writeToFile(true, entry)
+	
+	// Write to the store for querying
+	l.writeToStore(storage.LogAudit, entry.Timestamp, entry)
 	
-	// Write to Redis for real-time access
-	l.writeToRedis("audit", entry)
+	// Copy to external sinks
+	l.sendToSinks(entry)
 }
 
 // Debug logs a debug message
@@ -197,19 +323,12 @@ func (l *Logger) Fatal(component, message string, details map[string]interface{}
 	os.Exit(1)
 }
 
-// GetLogs retrieves logs from Redis
+// GetLogs retrieves logs from the store
 func (l *Logger) GetLogs(ctx context.Context, filter LogFilter) ([]LogEntry, error) {
-	key := "logs:entries"
-	
-	// Get logs from Redis sorted set
 	endTime := time.Now()
 	startTime := endTime.Add(-filter.Duration)
 	
-	results, err := l.redisClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{
-		Min: fmt.Sprintf("%d", startTime.Unix()),
-		Max: fmt.Sprintf("%d", endTime.Unix()),
-	}).Result()
-	
+	results, err := l.store.Range(ctx, storage.LogApp, startTime, endTime)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get logs: %w", err)
 	}
@@ -217,7 +336,7 @@ func (l *Logger) GetLogs(ctx context.Context, filter LogFilter) ([]LogEntry, err
 	logs := make([]LogEntry, 0, len(results))
 	for _, result := range results {
 		var entry LogEntry
-		if err := json.Unmarshal([]byte(result), &entry); err != nil {
+		if err := json.Unmarshal(result, &entry); err != nil {
 			continue
 		}
 		
@@ -243,19 +362,72 @@ func (l *Logger) GetLogs(ctx context.Context, filter LogFilter) ([]LogEntry, err
 	return logs, nil
 }
 
-// GetAuditLogs retrieves audit logs
+// GetAuditLogs retrieves audit logs, oldest first. Without an After cursor
+// it returns the most recent matching entries within Duration (of Before,
+// if set); with one it returns the oldest matching entries after it.
 func (l *Logger) GetAuditLogs(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
-	key := "audit:entries"
+	end := time.Now()
+	if !filter.Before.IsZero() && filter.Before.Before(end) {
+		end = filter.Before
+	}
+	start := end.Add(-l.maxAge)
+	if !filter.After.IsZero() {
+		if filter.After.After(start) {
+			start = filter.After
+		}
+	} else if filter.Duration > 0 && filter.Duration < l.maxAge {
+		start = end.Add(-filter.Duration)
+	}
 	
-	// Get logs from Redis sorted set
-	endTime := time.Now()
-	startTime := endTime.Add(-filter.Duration)
+	// Paging forward from After reads the log oldest first
+	forward := !filter.After.IsZero()
 	
-	results, err := l.redisClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{
-		Min: fmt.Sprintf("%d", startTime.Unix()),
-		Max: fmt.Sprintf("%d", endTime.Unix()),
-	}).Result()
+	var audits []AuditEntry
+	span := auditScanSpan
+	for lo, hi := start, end; lo.Before(hi); span *= 2 {
+		from, to := lo, hi
+		if forward {
+			if next := lo.Add(span); next.Before(hi) {
+				to = next
+			}
+			lo = to
+		} else {
+			if next := hi.Add(-span); next.After(lo) {
+				from = next
+			}
+			hi = from
+		}
+		
+		entries, err := l.auditRange(ctx, filter, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if forward {
+			audits = append(audits, entries...)
+		} else {
+			audits = append(entries, audits...)
+		}
+		if filter.Limit > 0 && len(audits) >= filter.Limit {
+			break
+		}
+	}
+	
+	// Apply limit
+	if filter.Limit > 0 && len(audits) > filter.Limit {
+		if forward {
+			audits = audits[:filter.Limit]
+		} else {
+			audits = audits[len(audits)-filter.Limit:]
+		}
+	}
 	
+	return audits, nil
+}
+
+// auditRange returns the matching audit entries timestamped after from and
+// up to to, oldest first
+func (l *Logger) auditRange(ctx context.Context, filter AuditFilter, from, to time.Time) ([]AuditEntry, error) {
+	results, err := l.store.Range(ctx, storage.LogAudit, from, to)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get audit logs: %w", err)
 	}
@@ -263,7 +435,16 @@ func (l *Logger) GetAuditLogs(ctx context.Context, filter AuditFilter) ([]AuditE
 	audits := make([]AuditEntry, 0, len(results))
 	for _, result := range results {
 		var entry AuditEntry
-		if err := json.Unmarshal([]byte(result), &entry); err != nil {
+		if err := json.Unmarshal(result, &entry); err != nil {
+			continue
+		}
+		
+		// The store works in whole seconds; keep exactly the entries in
+		// this slice, and none at the Before cursor itself
+		if !entry.Timestamp.After(from) || entry.Timestamp.After(to) {
+			continue
+		}
+		if !filter.Before.IsZero() && !entry.Timestamp.Before(filter.Before) {
 			continue
 		}
 		
@@ -277,15 +458,15 @@ func (l *Logger) GetAuditLogs(ctx context.Context, filter AuditFilter) ([]AuditE
 		if filter.Resource != "" && entry.Resource != filter.Resource {
 			continue
 		}
+		if filter.ResourceID != "" && entry.ResourceID != filter.ResourceID {
+			continue
+		}
+		if filter.Result != "" && entry.Result != filter.Result {
+			continue
+		}
 		
 		audits = append(audits, entry)
 	}
-	
-	// Apply limit
-	if filter.Limit > 0 && len(audits) > filter.Limit {
-		audits = audits[len(audits)-filter.Limit:]
-	}
-	
 	return audits, nil
 }
 
@@ -304,48 +485,51 @@ type AuditFilter struct {
 	UserID   string
 	Action   string
 	Resource string
+	ResourceID string
+	// Result is success or failure
+	Result   string
+	// Before and After are exclusive paging cursors; pass the timestamp of
+	// the first entry of a page as Before to get the page before it. Duration
+	// is ignored when After is set.
+	Before   time.Time
+	After    time.Time
 	Limit    int
 }
 
-func (l *Logger) writeToFile(file *os.File, entry interface{}) {
+// writeToFile appends an entry to agentainer.log or audit.log, rotating the
+// file once it outgrows maxSize
+func (l *Logger) writeToFile(audit bool, entry interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	
+	file, basename := l.logFile, "agentainer.log"
+	if audit {
+		file, basename = l.auditFile, "audit.log"
+	}
+	if file == nil {
+		return
+	}
+	
 	data, err := json.Marshal(entry)
 	if err != nil {
 		return
 	}
 	
-	file.Write(data)
-	file.Write([]byte("\n"))
+	file.Write(append(data, '\n'))
+	
+	if info, err := file.Stat(); err == nil && info.Size() > l.maxSize {
+		l.rotateFile(file, basename)
+	}
 }
 
-func (l *Logger) writeToRedis(prefix string, entry interface{}) {
-	ctx := context.Background()
+// writeToStore appends an entry to the store; the maintenance loop trims it
+func (l *Logger) writeToStore(log string, timestamp time.Time, entry interface{}) {
 	data, err := json.Marshal(entry)
 	if err != nil {
 		return
 	}
 	
-	// Get timestamp
-	var timestamp time.Time
-	switch e := entry.(type) {
-	case LogEntry:
-		timestamp = e.Timestamp
-	case AuditEntry:
-		timestamp = e.Timestamp
-	}
-	
-	// Store in sorted set for time-based queries
-	key := fmt.Sprintf("%s:entries", prefix)
-	l.redisClient.ZAdd(ctx, key, &redis.Z{
-		Score:  float64(timestamp.Unix()),
-		Member: string(data),
-	})
-	
-	// Expire old entries (keep 7 days)
-	cutoff := time.Now().Add(-7 * 24 * time.Hour).Unix()
-	l.redisClient.ZRemRangeByScore(ctx, key, "0", fmt.Sprintf("%d", cutoff))
+	l.store.Append(context.Background(), log, timestamp, data)
 }
 
 func (l *Logger) writeToConsole(entry LogEntry) {
@@ -372,36 +556,6 @@ func (l *Logger) writeToConsole(entry LogEntry) {
 	)
 }
 
-func (l *Logger) rotateLoop() {
-	ticker := time.NewTicker(1 * time.Hour)
-	defer ticker.Stop()
-	
-	for range ticker.C {
-		l.rotate()
-	}
-}
-
-func (l *Logger) rotate() {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	
-	// Check file sizes
-	logInfo, _ := l.logFile.Stat()
-	auditInfo, _ := l.auditFile.Stat()
-	
-	// Rotate log file if needed
-	if logInfo != nil && logInfo.Size() > l.maxSize {
-		l.rotateFile(l.logFile, "agentainer.log")
-	}
-	
-	// Rotate audit file if needed
-	if auditInfo != nil && auditInfo.Size() > l.maxSize {
-		l.rotateFile(l.auditFile, "audit.log")
-	}
-	
-	// Clean up old files
-	l.cleanupOldFiles()
-}
 
 func (l *Logger) rotateFile(file *os.File, basename string) {
 	// Close current file
@@ -528,6 +682,34 @@ func Error(component, message string, details map[string]interface{}) {
 	}
 }
 
+// GetAuditLogs retrieves audit logs using the global logger
+func GetAuditLogs(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	if globalLogger == nil {
+		return nil, fmt.Errorf("logging is not initialized")
+	}
+	return globalLogger.GetAuditLogs(ctx, filter)
+}
+
+// Debugf logs a formatted debug message using the global logger
+func Debugf(component, format string, args ...interface{}) {
+	Debug(component, fmt.Sprintf(format, args...), nil)
+}
+
+// Infof logs a formatted info message using the global logger
+func Infof(component, format string, args ...interface{}) {
+	Info(component, fmt.Sprintf(format, args...), nil)
+}
+
+// Warnf logs a formatted warning using the global logger
+func Warnf(component, format string, args ...interface{}) {
+	Warn(component, fmt.Sprintf(format, args...), nil)
+}
+
+// Errorf logs a formatted error message using the global logger
+func Errorf(component, format string, args ...interface{}) {
+	Error(component, fmt.Sprintf(format, args...), nil)
+}
+
 // AuditLog logs an audit entry using the global logger
 func AuditLog(entry AuditEntry) {
 	if globalLogger != nil {
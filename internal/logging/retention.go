@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/agentainer/agentainer-lab/internal/storage"
+)
+
+const (
+	// trimInterval is how often the logs in the store are trimmed
+	trimInterval = time.Minute
+	// cleanupInterval is how often rotated log files past maxAge are removed
+	cleanupInterval = time.Hour
+)
+
+// Usage is the storage taken up by the logs
+type Usage struct {
+	App        storage.LogUsage `json:"app"`
+	Audit      storage.LogUsage `json:"audit"`
+	// FileBytes is the size of the log files, rotated ones included
+	FileBytes  int64            `json:"file_bytes"`
+	MaxAge     string           `json:"max_age"`
+	MaxEntries int64            `json:"max_entries,omitempty"`
+}
+
+// maintainLoop trims the store and removes old log files until the
+// logger is closed
+func (l *Logger) maintainLoop() {
+	ticker := time.NewTicker(trimInterval)
+	defer ticker.Stop()
+
+	l.trimStore(context.Background())
+	lastCleanup := time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.trimStore(context.Background())
+			if time.Since(lastCleanup) >= cleanupInterval {
+				l.mu.Lock()
+				l.cleanupOldFiles()
+				l.mu.Unlock()
+				lastCleanup = time.Now()
+			}
+		case <-l.stopChan:
+			return
+		}
+	}
+}
+
+// trimStore drops entries older than maxAge from both logs, and the oldest
+// application entries beyond maxEntries
+func (l *Logger) trimStore(ctx context.Context) {
+	before := time.Now().Add(-l.maxAge)
+	for _, name := range []string{storage.LogApp, storage.LogAudit} {
+		if err := l.store.TrimLog(ctx, name, before); err != nil {
+			log.Printf("Failed to trim %s log: %v", name, err)
+		}
+	}
+	if l.maxEntries > 0 {
+		if err := l.store.CapLog(ctx, storage.LogApp, l.maxEntries); err != nil {
+			log.Printf("Failed to cap %s log: %v", storage.LogApp, err)
+		}
+	}
+}
+
+// Usage reports how much storage the logs take up
+func (l *Logger) Usage(ctx context.Context) (*Usage, error) {
+	usage := &Usage{
+		MaxAge:     l.maxAge.String(),
+		MaxEntries: l.maxEntries,
+	}
+
+	var err error
+	if usage.App, err = l.store.LogUsage(ctx, storage.LogApp); err != nil {
+		return nil, fmt.Errorf("failed to get log usage: %w", err)
+	}
+	if usage.Audit, err = l.store.LogUsage(ctx, storage.LogAudit); err != nil {
+		return nil, fmt.Errorf("failed to get audit log usage: %w", err)
+	}
+
+	if l.logFile != nil {
+		files, _ := os.ReadDir(l.logDir)
+		for _, file := range files {
+			if info, err := file.Info(); err == nil && !file.IsDir() {
+				usage.FileBytes += info.Size()
+			}
+		}
+	}
+	return usage, nil
+}
+
+// StorageUsage reports log storage usage using the global logger
+func StorageUsage(ctx context.Context) (*Usage, error) {
+	if globalLogger == nil {
+		return nil, fmt.Errorf("logging is not initialized")
+	}
+	return globalLogger.Usage(ctx)
+}
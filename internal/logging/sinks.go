@@ -0,0 +1,187 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Audit sink types
+const (
+	SinkFile   = "file"
+	SinkSyslog = "syslog"
+	SinkHTTP   = "http"
+)
+
+// sinkBuffer is how many audit entries may wait for slow sinks before new
+// ones are dropped
+const sinkBuffer = 1000
+
+// SinkConfig is one external audit destination
+type SinkConfig struct {
+	// Type is file, syslog or http
+	Type    string `mapstructure:"type"`
+	// Path is the file that file sinks append JSON lines to
+	Path    string `mapstructure:"path"`
+	// Network and Address select a remote syslog server, e.g. udp and
+	// logs.example.com:514; both empty uses the local syslog daemon
+	Network string `mapstructure:"network"`
+	Address string `mapstructure:"address"`
+	// URL receives a JSON POST per entry for http sinks
+	URL     string `mapstructure:"url"`
+}
+
+// AuditSink receives a copy of every audit entry as it is written
+type AuditSink interface {
+	Write(entry AuditEntry) error
+	Close() error
+}
+
+// NewAuditSinks opens the configured audit sinks
+func NewAuditSinks(configs []SinkConfig) ([]AuditSink, error) {
+	sinks := make([]AuditSink, 0, len(configs))
+	for i, cfg := range configs {
+		sink, err := newAuditSink(cfg)
+		if err != nil {
+			for _, opened := range sinks {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("audit sink %d: %w", i+1, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+func newAuditSink(cfg SinkConfig) (AuditSink, error) {
+	switch cfg.Type {
+	case SinkFile:
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("file sink requires a path")
+		}
+		return newFileSink(cfg.Path)
+	case SinkSyslog:
+		return newSyslogSink(cfg.Network, cfg.Address)
+	case SinkHTTP:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("http sink requires a url")
+		}
+		return &httpSink{url: cfg.URL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown sink type '%s' (use file, syslog or http)", cfg.Type)
+}
+
+// fileSink appends entries to a file as JSON lines. Unlike audit.log it is
+// never rotated or cleaned up by Agentainer.
+type fileSink struct {
+	file *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (s *fileSink) Write(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+// httpSink posts each entry as JSON
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Write(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
+
+// SetAuditSinks starts copying audit entries to sinks. Entries are handed
+// over in the background so a slow sink never holds up the request that
+// is being audited.
+func (l *Logger) SetAuditSinks(sinks []AuditSink) {
+	if len(sinks) == 0 {
+		return
+	}
+	l.sinks = sinks
+	l.sinkQueue = make(chan AuditEntry, sinkBuffer)
+	l.sinksDone = make(chan struct{})
+	go l.runSinks()
+}
+
+func (l *Logger) runSinks() {
+	defer close(l.sinksDone)
+	for entry := range l.sinkQueue {
+		for _, sink := range l.sinks {
+			if err := sink.Write(entry); err != nil {
+				log.Printf("Failed to write audit entry to %T: %v", sink, err)
+			}
+		}
+	}
+}
+
+// sendToSinks queues an entry for the sinks, dropping it if they are too
+// far behind
+func (l *Logger) sendToSinks(entry AuditEntry) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	
+	if l.sinkQueue == nil {
+		return
+	}
+	select {
+	case l.sinkQueue <- entry:
+	default:
+		log.Printf("Audit sinks are %d entries behind, dropping %s entry", sinkBuffer, entry.Action)
+	}
+}
+
+// closeSinks waits for queued entries to be written and closes the sinks;
+// callers hold l.mu
+func (l *Logger) closeSinks() {
+	if l.sinkQueue == nil {
+		return
+	}
+	close(l.sinkQueue)
+	<-l.sinksDone
+	for _, sink := range l.sinks {
+		sink.Close()
+	}
+	l.sinkQueue = nil
+}
@@ -0,0 +1,34 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+)
+
+// syslogSink sends each entry as a JSON message with the auth facility
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(network, address string) (AuditSink, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, "agentainer-audit")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Write(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(data))
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
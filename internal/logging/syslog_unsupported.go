@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package logging
+
+import "fmt"
+
+func newSyslogSink(network, address string) (AuditSink, error) {
+	return nil, fmt.Errorf("syslog sinks are not supported on this platform")
+}
@@ -3,32 +3,57 @@ package requests
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/agentainer/agentainer-lab/internal/storage"
 )
 
+// ReplayHeader marks requests sent by the replay worker
+const ReplayHeader = "X-Agentainer-Replay"
+
+// replayToken is the value of ReplayHeader on the worker's requests. It is
+// generated per process so clients can't pass their requests off as replays.
+var replayToken = uuid.NewString()
+
+// IsReplay reports whether r was sent by this process's replay worker
+func IsReplay(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(ReplayHeader)), []byte(replayToken)) == 1
+}
+
 // ReplayWorker handles automatic replay of pending requests
 type ReplayWorker struct {
 	manager      *Manager
 	redisClient  *redis.Client
 	httpClient   *http.Client
 	stopCh       chan bool
+	// rateLimit is the default per-agent replay rate in requests/sec (0 = unlimited)
+	rateLimit    float64
+
+	// active holds agents whose backlog is currently being replayed
+	mu           sync.Mutex
+	active       map[string]bool
 }
 
-// NewReplayWorker creates a new replay worker
-func NewReplayWorker(manager *Manager, redisClient *redis.Client) *ReplayWorker {
+// NewReplayWorker creates a new replay worker. rateLimit paces replays to each
+// agent (requests/sec) unless the agent sets its own limit; 0 disables pacing.
+func NewReplayWorker(manager *Manager, redisClient *redis.Client, rateLimit float64) *ReplayWorker {
 	return &ReplayWorker{
 		manager:     manager,
 		redisClient: redisClient,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		stopCh: make(chan bool),
+		stopCh:    make(chan bool),
+		rateLimit: rateLimit,
+		active:    make(map[string]bool),
 	}
 }
 
@@ -73,21 +98,64 @@ func (w *ReplayWorker) processAgents(ctx context.Context) {
 		}
 
 		// Check if agent is running
-		isRunning := w.isAgentRunning(ctx, agentID)
+		agentData := w.getAgentData(ctx, agentID)
+		isRunning := agentData != nil && agentData["status"] == "running"
 		fmt.Printf("[ReplayWorker] Agent %s running status: %v\n", agentID, isRunning)
 		if !isRunning {
 			fmt.Printf("[ReplayWorker] Agent %s is not running, skipping\n", agentID)
 			continue
 		}
+		
+		// Replaying to an agent that is still warming up would only use up retries
+		if !w.isReady(ctx, agentID) {
+			fmt.Printf("[ReplayWorker] Agent %s is not ready, skipping\n", agentID)
+			continue
+		}
+
+		// A paced backlog can outlast the tick; don't start a second pass
+		if !w.acquire(agentID) {
+			fmt.Printf("[ReplayWorker] Agent %s is still replaying, skipping\n", agentID)
+			continue
+		}
 
 		fmt.Printf("[ReplayWorker] Processing pending requests for agent %s\n", agentID)
-		// Process pending requests for this agent
-		w.processPendingRequests(ctx, agentID)
+		// Each agent replays in its own goroutine so that pacing one agent
+		// doesn't hold up the others
+		go func(agentID string, rate float64) {
+			defer w.release(agentID)
+			w.processPendingRequests(ctx, agentID, rate)
+		}(agentID, w.replayRate(agentData))
+	}
+}
+
+// acquire marks an agent's backlog as being replayed, reporting false if it already is
+func (w *ReplayWorker) acquire(agentID string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.active[agentID] {
+		return false
+	}
+	w.active[agentID] = true
+	return true
+}
+
+func (w *ReplayWorker) release(agentID string) {
+	w.mu.Lock()
+	delete(w.active, agentID)
+	w.mu.Unlock()
+}
+
+// replayRate returns the agent's replay limit, falling back to the worker default
+func (w *ReplayWorker) replayRate(agentData map[string]interface{}) float64 {
+	if rate, ok := agentData["replay_rate_limit"].(float64); ok && rate > 0 {
+		return rate
 	}
+	return w.rateLimit
 }
 
-// processPendingRequests replays all pending requests for an agent
-func (w *ReplayWorker) processPendingRequests(ctx context.Context, agentID string) {
+// processPendingRequests replays all pending requests for an agent, spacing
+// them so that no more than rate requests are sent per second
+func (w *ReplayWorker) processPendingRequests(ctx context.Context, agentID string, rate float64) {
 	requests, err := w.manager.GetPendingRequests(ctx, agentID)
 	if err != nil {
 		fmt.Printf("Error getting pending requests for agent %s: %v\n", agentID, err)
@@ -96,6 +164,12 @@ func (w *ReplayWorker) processPendingRequests(ctx context.Context, agentID strin
 
 	fmt.Printf("[ReplayWorker] Found %d pending requests for agent %s\n", len(requests), agentID)
 	
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	var lastReplay time.Time
+	
 	for _, req := range requests {
 		// Skip if already processing or too many retries
 		if req.Status == StatusProcessing || req.RetryCount >= req.MaxRetries {
@@ -104,6 +178,11 @@ func (w *ReplayWorker) processPendingRequests(ctx context.Context, agentID strin
 			continue
 		}
 
+		if !lastReplay.IsZero() && !w.wait(ctx, time.Until(lastReplay.Add(interval))) {
+			return
+		}
+		lastReplay = time.Now()
+
 		fmt.Printf("[ReplayWorker] Replaying request %s: %s %s\n", req.ID, req.Method, req.Path)
 		// Replay the request
 		if err := w.replayRequest(ctx, agentID, req); err != nil {
@@ -145,7 +224,7 @@ func (w *ReplayWorker) replayRequest(ctx context.Context, agentID string, req *R
 
 	// Add tracking header
 	httpReq.Header.Set("X-Agentainer-Request-ID", req.ID)
-	httpReq.Header.Set("X-Agentainer-Replay", "true")
+	httpReq.Header.Set(ReplayHeader, replayToken)
 
 	// Execute request
 	resp, err := w.httpClient.Do(httpReq)
@@ -155,37 +234,63 @@ func (w *ReplayWorker) replayRequest(ctx context.Context, agentID string, req *R
 	defer resp.Body.Close()
 
 	// Store response
-	if err := w.manager.StoreResponse(ctx, agentID, req.ID, resp); err != nil {
+	if err := w.manager.StoreResponse(ctx, agentID, req.ID, resp, nil); err != nil {
 		fmt.Printf("Warning: Failed to store response for request %s: %v\n", req.ID, err)
 	}
 
 	return nil
 }
 
-// isAgentRunning checks if an agent is running
-func (w *ReplayWorker) isAgentRunning(ctx context.Context, agentID string) bool {
-	// Use the agent manager's GetAgent method to properly parse the agent data
-	key := fmt.Sprintf("agent:%s", agentID)
-	data, err := w.redisClient.Get(ctx, key).Result()
-	if err != nil {
-		// If not in Redis, agent doesn't exist
+// wait sleeps for d, returning false if the worker is stopped first
+func (w *ReplayWorker) wait(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
 		return false
+	case <-w.stopCh:
+		return false
+	}
+}
+
+// getAgentData loads an agent's stored JSON, returning nil if it can't be read
+func (w *ReplayWorker) getAgentData(ctx context.Context, agentID string) map[string]interface{} {
+	data, err := w.manager.store.Get(ctx, storage.CollectionAgents, agentID)
+	if err != nil {
+		// If not stored, agent doesn't exist
+		return nil
 	}
 	
 	// Parse the JSON to check status
-	// We need to import encoding/json for this
 	var agentData map[string]interface{}
-	if err := json.Unmarshal([]byte(data), &agentData); err != nil {
+	if err := json.Unmarshal(data, &agentData); err != nil {
 		fmt.Printf("[ReplayWorker] Failed to parse agent data for %s: %v\n", agentID, err)
-		return false
+		return nil
 	}
 	
-	// Check if status is "running"
-	if status, ok := agentData["status"].(string); ok {
-		return status == "running"
+	return agentData
+}
+
+// isReady reports whether the health monitor last found the agent ready.
+// Agents without a recorded status are treated as ready.
+func (w *ReplayWorker) isReady(ctx context.Context, agentID string) bool {
+	data, err := w.redisClient.Get(ctx, fmt.Sprintf("health:%s", agentID)).Bytes()
+	if err != nil {
+		return true
 	}
 	
-	return false
+	var status struct {
+		Ready *bool `json:"ready"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil || status.Ready == nil {
+		return true
+	}
+	return *status.Ready
 }
 
 // extractAgentID extracts agent ID from Redis key
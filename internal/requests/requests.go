@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"github.com/agentainer/agentainer-lab/internal/storage"
 )
 
 // RequestStatus represents the state of a request
@@ -23,6 +28,31 @@ const (
 	StatusFailed     RequestStatus = "failed"
 )
 
+// ErrNotDeadLettered is returned when requeueing a request that isn't in the
+// dead-letter queue
+var ErrNotDeadLettered = errors.New("request is not in the dead-letter queue")
+
+// ErrTooLargeToReplay is returned when replaying a request whose body was
+// over the size limit and so was never stored
+var ErrTooLargeToReplay = errors.New("request body was too large to store and cannot be replayed")
+
+// DefaultMaxBodySize is the largest request or response body stored unless
+// SetMaxBodySize is called
+const DefaultMaxBodySize = 1 << 20
+
+// PriorityHeader sets a request's replay priority; higher values replay first
+const PriorityHeader = "X-Agentainer-Priority"
+
+// Priorities are clamped to this range so the queue score keeps its precision
+const (
+	MinPriority = -100
+	MaxPriority = 100
+)
+
+// priorityScale separates priorities in the pending queue score; the arrival
+// sequence number stays well below it
+const priorityScale = 1e13
+
 // Request represents a stored HTTP request
 type Request struct {
 	ID            string            `json:"id"`
@@ -34,10 +64,14 @@ type Request struct {
 	Status        RequestStatus     `json:"status"`
 	RetryCount    int               `json:"retry_count"`
 	MaxRetries    int               `json:"max_retries"`
+	Priority      int               `json:"priority"`
 	CreatedAt     time.Time         `json:"created_at"`
 	ProcessedAt   *time.Time        `json:"processed_at,omitempty"`
 	Response      *Response         `json:"response,omitempty"`
 	Error         string            `json:"error,omitempty"`
+	// BodyTooLarge requests were proxied without storing their body, so
+	// they are never queued and can't be replayed
+	BodyTooLarge  bool              `json:"body_too_large,omitempty"`
 }
 
 // Response represents a stored HTTP response
@@ -46,32 +80,94 @@ type Response struct {
 	Headers    map[string]string `json:"headers"`
 	Body       []byte            `json:"body"`
 	ReceivedAt time.Time         `json:"received_at"`
+	Timing     *Timing           `json:"timing,omitempty"`
+	// Streamed responses were passed straight through, so Body is not kept
+	Streamed   bool              `json:"streamed,omitempty"`
+	// BodyTooLarge is set when Body was over the size limit and not kept
+	BodyTooLarge bool            `json:"body_too_large,omitempty"`
+}
+
+// Timing breaks down a proxied request's latency in milliseconds, measured
+// until the response headers arrived
+type Timing struct {
+	Proxy   float64 `json:"proxy_ms"`   // in Agentainer before forwarding
+	Connect float64 `json:"connect_ms"` // dialing the agent; 0 for a pooled connection
+	Agent   float64 `json:"agent_ms"`   // request sent to first response byte
+	Total   float64 `json:"total_ms"`
 }
 
-// Manager handles request persistence and replay
+// requestTTL is how long request records are kept
+const requestTTL = 24 * time.Hour
+
+// deadLetterTTL is how long dead-lettered records are kept, giving time to
+// inspect and requeue them
+const deadLetterTTL = 7 * 24 * time.Hour
+
+// Manager handles request persistence and replay. Request records are kept
+// in the store; queues, sequence numbers and stats live in Redis.
 type Manager struct {
 	redisClient *redis.Client
+	store       storage.Store
+	maxBodySize int64
 }
 
 // NewManager creates a new request manager
-func NewManager(redisClient *redis.Client) *Manager {
+func NewManager(redisClient *redis.Client, store storage.Store) *Manager {
 	return &Manager{
 		redisClient: redisClient,
+		store:       store,
+		maxBodySize: DefaultMaxBodySize,
+	}
+}
+
+// SetMaxBodySize sets the largest request or response body that is stored;
+// 0 stores bodies of any size
+func (m *Manager) SetMaxBodySize(size int64) {
+	m.maxBodySize = size
+}
+
+// bodyReader replays the part of a body read while checking its size,
+// followed by the rest, and closes the original
+type bodyReader struct {
+	io.Reader
+	io.Closer
+}
+
+// readBody reads a body for storing. A body over the size limit is not
+// read in full: data is nil and body streams the whole body on unchanged.
+func (m *Manager) readBody(original io.ReadCloser, contentLength int64) (data []byte, body io.ReadCloser, tooLarge bool, err error) {
+	if m.maxBodySize <= 0 {
+		data, err = io.ReadAll(original)
+		return data, io.NopCloser(bytes.NewReader(data)), false, err
+	}
+	if contentLength > m.maxBodySize {
+		return nil, original, true, nil
+	}
+
+	data, err = io.ReadAll(io.LimitReader(original, m.maxBodySize+1))
+	if err != nil {
+		return nil, nil, false, err
 	}
+	if int64(len(data)) > m.maxBodySize {
+		return nil, &bodyReader{Reader: io.MultiReader(bytes.NewReader(data), original), Closer: original}, true, nil
+	}
+	return data, io.NopCloser(bytes.NewReader(data)), false, nil
 }
 
 // StoreRequest saves a request for an agent
 func (m *Manager) StoreRequest(ctx context.Context, agentID string, req *http.Request) (*Request, error) {
 	// Read and store the body
 	var bodyBytes []byte
+	var tooLarge bool
 	if req.Body != nil {
+		var body io.ReadCloser
 		var err error
-		bodyBytes, err = io.ReadAll(req.Body)
+		bodyBytes, body, tooLarge, err = m.readBody(req.Body, req.ContentLength)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read request body: %w", err)
 		}
 		// Restore the body for further processing
-		req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		req.Body = body
 	}
 
 	// Extract headers
@@ -93,41 +189,124 @@ func (m *Manager) StoreRequest(ctx context.Context, agentID string, req *http.Re
 		Status:     StatusPending,
 		RetryCount: 0,
 		MaxRetries: 3,
+		Priority:   ParsePriority(req.Header.Get(PriorityHeader)),
 		CreatedAt:  time.Now(),
 	}
+	if tooLarge {
+		request.Status = StatusProcessing
+		request.BodyTooLarge = true
+	}
 
-	// Store in Redis
-	key := fmt.Sprintf("agent:%s:requests:%s", agentID, request.ID)
+	// Store the record
+	recordID := requestRecordID(agentID, request.ID)
 	data, err := json.Marshal(request)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	if err := m.redisClient.Set(ctx, key, data, 24*time.Hour).Err(); err != nil {
+	if err := m.store.Put(ctx, storage.CollectionRequests, recordID, data, requestTTL); err != nil {
 		return nil, fmt.Errorf("failed to store request: %w", err)
 	}
 
-	// Add to pending queue
-	queueKey := fmt.Sprintf("agent:%s:requests:pending", agentID)
-	if err := m.redisClient.RPush(ctx, queueKey, request.ID).Err(); err != nil {
-		return nil, fmt.Errorf("failed to add to pending queue: %w", err)
+	// Without its body the request can't be replayed, so it isn't queued
+	if tooLarge {
+		return request, nil
+	}
+	if err := m.enqueue(ctx, agentID, request); err != nil {
+		return nil, err
 	}
 
 	return request, nil
 }
 
-// StoreResponse updates a request with its response
-func (m *Manager) StoreResponse(ctx context.Context, agentID, requestID string, resp *http.Response) error {
+// enqueue adds a request to the pending queue, ordered by priority and then arrival
+func (m *Manager) enqueue(ctx context.Context, agentID string, request *Request) error {
+	queueKey, err := m.pendingQueue(ctx, agentID)
+	if err != nil {
+		return err
+	}
+	seq, err := m.redisClient.Incr(ctx, fmt.Sprintf("agent:%s:requests:seq", agentID)).Result()
+	if err != nil {
+		return fmt.Errorf("failed to assign queue position: %w", err)
+	}
+	score := float64(-request.Priority)*priorityScale + float64(seq)
+	if err := m.redisClient.ZAdd(ctx, queueKey, &redis.Z{Score: score, Member: request.ID}).Err(); err != nil {
+		return fmt.Errorf("failed to add to pending queue: %w", err)
+	}
+	return nil
+}
+
+// ParsePriority reads a priority header value, treating anything invalid as 0
+func ParsePriority(value string) int {
+	priority, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0
+	}
+	if priority < MinPriority {
+		return MinPriority
+	}
+	if priority > MaxPriority {
+		return MaxPriority
+	}
+	return priority
+}
+
+// pendingQueue returns the key of an agent's pending queue. Queues written
+// before priorities existed are plain lists in arrival order; they are
+// converted to the sorted set in place the first time they are touched.
+func (m *Manager) pendingQueue(ctx context.Context, agentID string) (string, error) {
+	queueKey := fmt.Sprintf("agent:%s:requests:pending", agentID)
+
+	keyType, err := m.redisClient.Type(ctx, queueKey).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get pending queue: %w", err)
+	}
+	if keyType != "list" {
+		return queueKey, nil
+	}
+
+	requestIDs, err := m.redisClient.LRange(ctx, queueKey, 0, -1).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get pending queue: %w", err)
+	}
+	members := make([]*redis.Z, 0, len(requestIDs))
+	for _, reqID := range requestIDs {
+		seq, err := m.redisClient.Incr(ctx, fmt.Sprintf("agent:%s:requests:seq", agentID)).Result()
+		if err != nil {
+			return "", fmt.Errorf("failed to assign queue position: %w", err)
+		}
+		members = append(members, &redis.Z{Score: float64(seq), Member: reqID})
+	}
+
+	pipe := m.redisClient.TxPipeline()
+	pipe.Del(ctx, queueKey)
+	if len(members) > 0 {
+		pipe.ZAdd(ctx, queueKey, members...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("failed to convert pending queue: %w", err)
+	}
+	return queueKey, nil
+}
+
+// StoreResponse updates a request with its response. timing may be nil.
+func (m *Manager) StoreResponse(ctx context.Context, agentID, requestID string, resp *http.Response, timing *Timing) error {
+	// Streaming bodies go to the client as they arrive; reading them here
+	// would hold the whole stream back, so only the metadata is kept
+	streamed := isStreaming(resp)
+	
 	// Read response body
 	var bodyBytes []byte
-	if resp.Body != nil {
+	var tooLarge bool
+	if resp.Body != nil && !streamed {
+		var body io.ReadCloser
 		var err error
-		bodyBytes, err = io.ReadAll(resp.Body)
+		bodyBytes, body, tooLarge, err = m.readBody(resp.Body, resp.ContentLength)
 		if err != nil {
 			return fmt.Errorf("failed to read response body: %w", err)
 		}
 		// Restore the body
-		resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		resp.Body = body
 	}
 
 	// Extract headers
@@ -144,13 +323,16 @@ func (m *Manager) StoreResponse(ctx context.Context, agentID, requestID string,
 		Headers:    headers,
 		Body:       bodyBytes,
 		ReceivedAt: time.Now(),
+		Timing:     timing,
+		Streamed:   streamed,
+		BodyTooLarge: tooLarge,
 	}
 
 	// Update request with response
-	key := fmt.Sprintf("agent:%s:requests:%s", agentID, requestID)
+	recordID := requestRecordID(agentID, requestID)
 	
 	// Get existing request
-	data, err := m.redisClient.Get(ctx, key).Bytes()
+	data, err := m.store.Get(ctx, storage.CollectionRequests, recordID)
 	if err != nil {
 		return fmt.Errorf("failed to get request: %w", err)
 	}
@@ -172,13 +354,14 @@ func (m *Manager) StoreResponse(ctx context.Context, agentID, requestID string,
 		return fmt.Errorf("failed to marshal updated request: %w", err)
 	}
 
-	if err := m.redisClient.Set(ctx, key, updatedData, 24*time.Hour).Err(); err != nil {
+	if err := m.store.Put(ctx, storage.CollectionRequests, recordID, updatedData, requestTTL); err != nil {
 		return fmt.Errorf("failed to update request: %w", err)
 	}
 
 	// Remove from pending queue
-	queueKey := fmt.Sprintf("agent:%s:requests:pending", agentID)
-	if err := m.redisClient.LRem(ctx, queueKey, 1, requestID).Err(); err != nil {
+	if queueKey, err := m.pendingQueue(ctx, agentID); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	} else if err := m.redisClient.ZRem(ctx, queueKey, requestID).Err(); err != nil {
 		// Log but don't fail
 		fmt.Printf("Warning: failed to remove from pending queue: %v\n", err)
 	}
@@ -193,20 +376,117 @@ func (m *Manager) StoreResponse(ctx context.Context, agentID, requestID string,
 	return nil
 }
 
-// GetPendingRequests returns all pending requests for an agent
+// isStreaming reports whether a response is an event or token stream that
+// must not be buffered
+func isStreaming(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/event-stream", "application/x-ndjson", "application/stream+json":
+		return true
+	}
+	return resp.StatusCode == http.StatusSwitchingProtocols
+}
+
+// GetPendingRequests returns all pending requests for an agent in replay
+// order: highest priority first, then in order of arrival
 func (m *Manager) GetPendingRequests(ctx context.Context, agentID string) ([]*Request, error) {
-	queueKey := fmt.Sprintf("agent:%s:requests:pending", agentID)
+	queueKey, err := m.pendingQueue(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
 	
 	// Get all request IDs from the queue
-	requestIDs, err := m.redisClient.LRange(ctx, queueKey, 0, -1).Result()
+	requestIDs, err := m.redisClient.ZRange(ctx, queueKey, 0, -1).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pending queue: %w", err)
 	}
 
+	return m.loadRequests(ctx, agentID, requestIDs), nil
+}
+
+// GetDeadLetterRequests returns the requests that exhausted their retries,
+// oldest first. Entries whose records have expired are dropped from the queue.
+func (m *Manager) GetDeadLetterRequests(ctx context.Context, agentID string) ([]*Request, error) {
+	requestIDs, err := m.redisClient.LRange(ctx, deadLetterKey(agentID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead-letter queue: %w", err)
+	}
+
+	live := requestIDs[:0]
+	for _, reqID := range requestIDs {
+		_, err := m.store.Get(ctx, storage.CollectionRequests, requestRecordID(agentID, reqID))
+		if errors.Is(err, storage.ErrNotFound) {
+			if remErr := m.redisClient.LRem(ctx, deadLetterKey(agentID), 0, reqID).Err(); remErr != nil {
+				fmt.Printf("Warning: failed to trim dead letter queue: %v\n", remErr)
+			}
+			continue
+		}
+		live = append(live, reqID)
+	}
+
+	return m.loadRequests(ctx, agentID, live), nil
+}
+
+// Requeue moves a request from the dead-letter queue back to pending with a
+// fresh retry budget
+func (m *Manager) Requeue(ctx context.Context, agentID, requestID string) (*Request, error) {
+	removed, err := m.redisClient.LRem(ctx, deadLetterKey(agentID), 0, requestID).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update dead-letter queue: %w", err)
+	}
+	if removed == 0 {
+		return nil, fmt.Errorf("%s: %w", requestID, ErrNotDeadLettered)
+	}
+
+	recordID := requestRecordID(agentID, requestID)
+	data, err := m.store.Get(ctx, storage.CollectionRequests, recordID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request: %w", err)
+	}
+
+	var request Request
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal request: %w", err)
+	}
+
+	request.Status = StatusPending
+	request.RetryCount = 0
+	request.Error = ""
+
+	updatedData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal updated request: %w", err)
+	}
+	if err := m.store.Put(ctx, storage.CollectionRequests, recordID, updatedData, requestTTL); err != nil {
+		return nil, fmt.Errorf("failed to update request: %w", err)
+	}
+
+	if err := m.enqueue(ctx, agentID, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// GetRequest returns a stored request
+func (m *Manager) GetRequest(ctx context.Context, agentID, requestID string) (*Request, error) {
+	data, err := m.store.Get(ctx, storage.CollectionRequests, requestRecordID(agentID, requestID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request: %w", err)
+	}
+
+	var request Request
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal request: %w", err)
+	}
+	return &request, nil
+}
+
+// loadRequests fetches stored requests by ID, skipping any that expired
+func (m *Manager) loadRequests(ctx context.Context, agentID string, requestIDs []string) []*Request {
 	var requests []*Request
 	for _, reqID := range requestIDs {
-		key := fmt.Sprintf("agent:%s:requests:%s", agentID, reqID)
-		data, err := m.redisClient.Get(ctx, key).Bytes()
+		recordID := requestRecordID(agentID, reqID)
+		data, err := m.store.Get(ctx, storage.CollectionRequests, recordID)
 		if err != nil {
 			// Skip if request not found
 			continue
@@ -220,16 +500,24 @@ func (m *Manager) GetPendingRequests(ctx context.Context, agentID string) ([]*Re
 
 		requests = append(requests, &request)
 	}
+	return requests
+}
+
+// requestRecordID identifies a request record in the store
+func requestRecordID(agentID, requestID string) string {
+	return fmt.Sprintf("%s:%s", agentID, requestID)
+}
 
-	return requests, nil
+func deadLetterKey(agentID string) string {
+	return fmt.Sprintf("agent:%s:requests:deadletter", agentID)
 }
 
 // MarkRequestFailed marks a request as failed
 func (m *Manager) MarkRequestFailed(ctx context.Context, agentID, requestID string, err error) error {
-	key := fmt.Sprintf("agent:%s:requests:%s", agentID, requestID)
+	recordID := requestRecordID(agentID, requestID)
 	
 	// Get existing request
-	data, getErr := m.redisClient.Get(ctx, key).Bytes()
+	data, getErr := m.store.Get(ctx, storage.CollectionRequests, recordID)
 	if getErr != nil {
 		return fmt.Errorf("failed to get request: %w", getErr)
 	}
@@ -244,19 +532,27 @@ func (m *Manager) MarkRequestFailed(ctx context.Context, agentID, requestID stri
 	request.Error = err.Error()
 	request.RetryCount++
 
-	// If we haven't exceeded max retries, keep it in pending
-	if request.RetryCount < request.MaxRetries {
+	// If we haven't exceeded max retries, keep it in pending. Requests that
+	// can't be replayed were never queued and fail outright.
+	ttl := requestTTL
+	if request.BodyTooLarge {
+		request.Error = fmt.Sprintf("%v (%v)", err, ErrTooLargeToReplay)
+	} else if request.RetryCount < request.MaxRetries {
 		request.Status = StatusPending
 	} else {
-		// Move to dead letter queue
-		deadLetterKey := fmt.Sprintf("agent:%s:requests:failed", agentID)
-		if pushErr := m.redisClient.RPush(ctx, deadLetterKey, requestID).Err(); pushErr != nil {
+		// Move to dead letter queue; the queue lasts as long as its newest record
+		ttl = deadLetterTTL
+		pipe := m.redisClient.TxPipeline()
+		pipe.RPush(ctx, deadLetterKey(agentID), requestID)
+		pipe.Expire(ctx, deadLetterKey(agentID), deadLetterTTL)
+		if _, pushErr := pipe.Exec(ctx); pushErr != nil {
 			fmt.Printf("Warning: failed to add to dead letter queue: %v\n", pushErr)
 		}
 
 		// Remove from pending
-		queueKey := fmt.Sprintf("agent:%s:requests:pending", agentID)
-		if remErr := m.redisClient.LRem(ctx, queueKey, 1, requestID).Err(); remErr != nil {
+		if queueKey, queueErr := m.pendingQueue(ctx, agentID); queueErr != nil {
+			fmt.Printf("Warning: %v\n", queueErr)
+		} else if remErr := m.redisClient.ZRem(ctx, queueKey, requestID).Err(); remErr != nil {
 			fmt.Printf("Warning: failed to remove from pending queue: %v\n", remErr)
 		}
 	}
@@ -267,7 +563,7 @@ func (m *Manager) MarkRequestFailed(ctx context.Context, agentID, requestID stri
 		return fmt.Errorf("failed to marshal updated request: %w", marshalErr)
 	}
 
-	if setErr := m.redisClient.Set(ctx, key, updatedData, 24*time.Hour).Err(); setErr != nil {
+	if setErr := m.store.Put(ctx, storage.CollectionRequests, recordID, updatedData, ttl); setErr != nil {
 		return fmt.Errorf("failed to update request: %w", setErr)
 	}
 
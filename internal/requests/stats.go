@@ -0,0 +1,151 @@
+package requests
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// latencySamples is how many recent latencies are kept per agent for percentiles
+const latencySamples = 1000
+
+// Stats summarises the traffic the proxy has forwarded to an agent
+type Stats struct {
+	AgentID     string           `json:"agent_id"`
+	Since       *time.Time       `json:"since,omitempty"`
+	Requests    int64            `json:"requests"`
+	// Errors counts 5xx responses and requests the agent never answered
+	Errors      int64            `json:"errors"`
+	ErrorRate   float64          `json:"error_rate"`
+	// StatusCodes buckets responses by class (2xx, 4xx, ...); "failed" counts
+	// requests that got no response
+	StatusCodes map[string]int64 `json:"status_codes"`
+	Latency     LatencyStats     `json:"latency_ms"`
+}
+
+// LatencyStats are response-header latencies in milliseconds. Avg covers all
+// requests; percentiles and Max cover the most recent ones only.
+type LatencyStats struct {
+	Avg     float64 `json:"avg"`
+	P50     float64 `json:"p50"`
+	P95     float64 `json:"p95"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+	Samples int     `json:"samples"`
+}
+
+func statsKey(agentID string) string {
+	return fmt.Sprintf("agent:%s:stats", agentID)
+}
+
+func latencyKey(agentID string) string {
+	return fmt.Sprintf("agent:%s:stats:latency", agentID)
+}
+
+// RecordStats counts one proxied request. A status of 0 means the agent
+// never responded.
+func (m *Manager) RecordStats(ctx context.Context, agentID string, status int, latency time.Duration) error {
+	bucket := "failed"
+	if status > 0 {
+		bucket = fmt.Sprintf("%dxx", status/100)
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+
+	key := statsKey(agentID)
+	pipe := m.redisClient.TxPipeline()
+	pipe.HSetNX(ctx, key, "since", time.Now().Unix())
+	pipe.HIncrBy(ctx, key, "requests", 1)
+	pipe.HIncrBy(ctx, key, "status:"+bucket, 1)
+	if status == 0 || status >= 500 {
+		pipe.HIncrBy(ctx, key, "errors", 1)
+	}
+	pipe.HIncrByFloat(ctx, key, "latency_sum", ms)
+	pipe.LPush(ctx, latencyKey(agentID), strconv.FormatFloat(ms, 'f', 3, 64))
+	pipe.LTrim(ctx, latencyKey(agentID), 0, latencySamples-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record request stats: %w", err)
+	}
+	return nil
+}
+
+// GetStats returns the request statistics recorded for an agent
+func (m *Manager) GetStats(ctx context.Context, agentID string) (*Stats, error) {
+	fields, err := m.redisClient.HGetAll(ctx, statsKey(agentID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request stats: %w", err)
+	}
+	samples, err := m.redisClient.LRange(ctx, latencyKey(agentID), 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get request latencies: %w", err)
+	}
+
+	stats := &Stats{
+		AgentID:     agentID,
+		StatusCodes: make(map[string]int64),
+	}
+	var latencySum float64
+	for field, value := range fields {
+		switch {
+		case field == "since":
+			if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+				since := time.Unix(unix, 0)
+				stats.Since = &since
+			}
+		case field == "requests":
+			stats.Requests, _ = strconv.ParseInt(value, 10, 64)
+		case field == "errors":
+			stats.Errors, _ = strconv.ParseInt(value, 10, 64)
+		case field == "latency_sum":
+			latencySum, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(field, "status:"):
+			stats.StatusCodes[strings.TrimPrefix(field, "status:")], _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	if stats.Requests > 0 {
+		stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+		stats.Latency.Avg = latencySum / float64(stats.Requests)
+	}
+
+	latencies := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		if ms, err := strconv.ParseFloat(sample, 64); err == nil {
+			latencies = append(latencies, ms)
+		}
+	}
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		stats.Latency.P50 = percentile(latencies, 0.50)
+		stats.Latency.P95 = percentile(latencies, 0.95)
+		stats.Latency.P99 = percentile(latencies, 0.99)
+		stats.Latency.Max = latencies[len(latencies)-1]
+		stats.Latency.Samples = len(latencies)
+	}
+	return stats, nil
+}
+
+// ResetStats clears the request statistics of an agent
+func (m *Manager) ResetStats(ctx context.Context, agentID string) error {
+	if err := m.redisClient.Del(ctx, statsKey(agentID), latencyKey(agentID)).Err(); err != nil {
+		return fmt.Errorf("failed to reset request stats: %w", err)
+	}
+	return nil
+}
+
+// percentile uses the nearest-rank method on sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
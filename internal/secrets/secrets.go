@@ -0,0 +1,245 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const listKey = "secrets:list"
+
+// ErrNotFound is returned for a secret that does not exist
+var ErrNotFound = errors.New("secret not found")
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// Secret describes a stored secret; its value is never included
+type Secret struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// record is what is stored in Redis under secret:<name>
+type record struct {
+	Secret
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Store keeps secret values in Redis encrypted with AES-256-GCM
+type Store struct {
+	redisClient *redis.Client
+	aead        cipher.AEAD
+}
+
+// NewStore creates a store that encrypts with a 32-byte master key
+func NewStore(redisClient *redis.Client, masterKey []byte) (*Store, error) {
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets master key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return &Store{
+		redisClient: redisClient,
+		aead:        aead,
+	}, nil
+}
+
+// LoadMasterKey derives the master key from a passphrase if one is given,
+// otherwise reads it from keyFile, generating the file on first use
+func LoadMasterKey(passphrase, keyFile string) ([]byte, error) {
+	if passphrase != "" {
+		key := sha256.Sum256([]byte(passphrase))
+		return key[:], nil
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("secrets key file %s is corrupt", keyFile)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read secrets key: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate secrets key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create secrets key directory: %w", err)
+	}
+	// O_EXCL so a concurrent first use can't overwrite a key already in use
+	file, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return LoadMasterKey("", keyFile)
+		}
+		return nil, fmt.Errorf("failed to create secrets key: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		return nil, fmt.Errorf("failed to write secrets key: %w", err)
+	}
+	return key, nil
+}
+
+// ValidateName checks that a secret name is safe to use as a key
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name '%s': use letters, digits, '.', '_' and '-' (max 128 characters)", name)
+	}
+	return nil
+}
+
+// Set creates or replaces a secret
+func (s *Store) Set(ctx context.Context, name, value string) (*Secret, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rec := record{Secret: Secret{Name: name, CreatedAt: now, UpdatedAt: now}}
+	if existing, err := s.load(ctx, name); err == nil {
+		rec.CreatedAt = existing.CreatedAt
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	// The name is authenticated so a ciphertext can't be swapped between secrets
+	rec.Ciphertext = s.aead.Seal(nonce, nonce, []byte(value), []byte(name))
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal secret: %w", err)
+	}
+	pipe := s.redisClient.TxPipeline()
+	pipe.Set(ctx, secretKey(name), data, 0)
+	pipe.SAdd(ctx, listKey, name)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to save secret: %w", err)
+	}
+	return &rec.Secret, nil
+}
+
+// Get decrypts and returns a secret's value
+func (s *Store) Get(ctx context.Context, name string) (string, error) {
+	rec, err := s.load(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(rec.Ciphertext) < nonceSize {
+		return "", fmt.Errorf("secret '%s' is corrupt", name)
+	}
+	plaintext, err := s.aead.Open(nil, rec.Ciphertext[:nonceSize], rec.Ciphertext[nonceSize:], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret '%s' (was the master key changed?)", name)
+	}
+	return string(plaintext), nil
+}
+
+// Exists reports whether a secret is stored
+func (s *Store) Exists(ctx context.Context, name string) (bool, error) {
+	n, err := s.redisClient.Exists(ctx, secretKey(name)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check secret: %w", err)
+	}
+	return n > 0, nil
+}
+
+// List returns all secrets sorted by name, without their values
+func (s *Store) List(ctx context.Context) ([]Secret, error) {
+	names, err := s.redisClient.SMembers(ctx, listKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	sort.Strings(names)
+
+	secrets := make([]Secret, 0, len(names))
+	for _, name := range names {
+		rec, err := s.load(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, rec.Secret)
+	}
+	return secrets, nil
+}
+
+// Delete removes a secret
+func (s *Store) Delete(ctx context.Context, name string) error {
+	pipe := s.redisClient.TxPipeline()
+	deleted := pipe.Del(ctx, secretKey(name))
+	pipe.SRem(ctx, listKey, name)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	if deleted.Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Resolve maps environment variable names to secret values given a map of
+// environment variable names to secret names
+func (s *Store) Resolve(ctx context.Context, refs map[string]string) (map[string]string, error) {
+	values := make(map[string]string, len(refs))
+	for envName, secretName := range refs {
+		value, err := s.Get(ctx, secretName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", envName, err)
+		}
+		values[envName] = value
+	}
+	return values, nil
+}
+
+func (s *Store) load(ctx context.Context, name string) (*record, error) {
+	data, err := s.redisClient.Get(ctx, secretKey(name)).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal secret: %w", err)
+	}
+	return &rec, nil
+}
+
+func secretKey(name string) string {
+	return fmt.Sprintf("secret:%s", name)
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// purgeInterval is how often expired records are deleted from Postgres
+const purgeInterval = time.Minute
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS agentainer_records (
+	collection TEXT NOT NULL,
+	id         TEXT NOT NULL,
+	data       BYTEA NOT NULL,
+	expires_at TIMESTAMPTZ,
+	PRIMARY KEY (collection, id)
+);
+CREATE TABLE IF NOT EXISTS agentainer_log_entries (
+	seq  BIGSERIAL PRIMARY KEY,
+	log  TEXT NOT NULL,
+	at   TIMESTAMPTZ NOT NULL,
+	data BYTEA NOT NULL
+);
+CREATE INDEX IF NOT EXISTS agentainer_log_entries_log_at ON agentainer_log_entries (log, at);
+`
+
+// PostgresStore keeps records and logs in two Postgres tables. Records are
+// stored byte for byte, so they read back exactly as the Redis store returns them.
+type PostgresStore struct {
+	db        *sql.DB
+	lastPurge atomic.Int64
+}
+
+// NewPostgresStore connects to Postgres and creates the tables if needed
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, postgresSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create postgres tables: %w", err)
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+func (s *PostgresStore) Get(ctx context.Context, collection, id string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT data FROM agentainer_records
+		 WHERE collection = $1 AND id = $2 AND (expires_at IS NULL OR expires_at > now())`,
+		collection, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *PostgresStore) Put(ctx context.Context, collection, id string, data []byte, ttl time.Duration) error {
+	var expiresAt *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+		s.purgeExpired(ctx)
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO agentainer_records (collection, id, data, expires_at) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (collection, id) DO UPDATE SET data = EXCLUDED.data, expires_at = EXCLUDED.expires_at`,
+		collection, id, data, expiresAt)
+	return err
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, collection, id string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM agentainer_records WHERE collection = $1 AND id = $2`, collection, id)
+	return err
+}
+
+func (s *PostgresStore) List(ctx context.Context, collection string) (map[string][]byte, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, data FROM agentainer_records WHERE collection = $1 AND expires_at IS NULL`, collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make(map[string][]byte)
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		records[id] = data
+	}
+	return records, rows.Err()
+}
+
+func (s *PostgresStore) Append(ctx context.Context, log string, at time.Time, data []byte) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO agentainer_log_entries (log, at, data) VALUES ($1, $2, $3)`, log, at, data)
+	return err
+}
+
+func (s *PostgresStore) Range(ctx context.Context, log string, from, to time.Time) ([][]byte, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT data FROM agentainer_log_entries WHERE log = $1 AND at >= $2 AND at <= $3 ORDER BY at, seq`,
+		log, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries [][]byte
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		entries = append(entries, data)
+	}
+	return entries, rows.Err()
+}
+
+func (s *PostgresStore) TrimLog(ctx context.Context, log string, before time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM agentainer_log_entries WHERE log = $1 AND at < $2`, log, before)
+	return err
+}
+
+func (s *PostgresStore) CapLog(ctx context.Context, log string, max int64) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM agentainer_log_entries WHERE log = $1 AND seq <= (
+			SELECT seq FROM agentainer_log_entries WHERE log = $1 ORDER BY seq DESC OFFSET $2 LIMIT 1)`,
+		log, max)
+	return err
+}
+
+// LogUsage counts the stored entry data, not table and index overhead
+func (s *PostgresStore) LogUsage(ctx context.Context, log string) (LogUsage, error) {
+	var usage LogUsage
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(octet_length(data)), 0) FROM agentainer_log_entries WHERE log = $1`,
+		log).Scan(&usage.Entries, &usage.Bytes)
+	return usage, err
+}
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// purgeExpired deletes expired records at most once per purgeInterval;
+// until then Get already skips them
+func (s *PostgresStore) purgeExpired(ctx context.Context) {
+	now := time.Now().UnixNano()
+	last := s.lastPurge.Load()
+	if now-last < int64(purgeInterval) || !s.lastPurge.CompareAndSwap(last, now) {
+		return
+	}
+	s.db.ExecContext(ctx, `DELETE FROM agentainer_records WHERE expires_at <= now()`)
+}
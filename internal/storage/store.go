@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Storage backends
+const (
+	BackendRedis    = "redis"
+	BackendPostgres = "postgres"
+)
+
+// Record collections and logs kept in a Store
+const (
+	CollectionAgents   = "agent"
+	CollectionRequests = "request"
+	LogApp             = "logs"
+	LogAudit           = "audit"
+)
+
+// ErrNotFound is returned by Get for records that don't exist or have expired
+var ErrNotFound = errors.New("record not found")
+
+// Store persists the records that have to survive a restart: agents,
+// request records and the application and audit logs. Queues, counters,
+// stats and pub/sub always live in Redis.
+type Store interface {
+	// Get returns a record, or ErrNotFound
+	Get(ctx context.Context, collection, id string) ([]byte, error)
+	// Put creates or replaces a record; a ttl above zero expires it
+	Put(ctx context.Context, collection, id string, data []byte, ttl time.Duration) error
+	// Delete removes a record; deleting a missing record is not an error
+	Delete(ctx context.Context, collection, id string) error
+	// List returns the records of a collection by ID. Records stored with a
+	// ttl are not listed.
+	List(ctx context.Context, collection string) (map[string][]byte, error)
+	// Append adds an entry to a time-ordered log
+	Append(ctx context.Context, log string, at time.Time, data []byte) error
+	// Range returns the log entries between from and to, oldest first
+	Range(ctx context.Context, log string, from, to time.Time) ([][]byte, error)
+	// TrimLog removes log entries older than before
+	TrimLog(ctx context.Context, log string, before time.Time) error
+	// CapLog removes the oldest log entries beyond max
+	CapLog(ctx context.Context, log string, max int64) error
+	// LogUsage returns how many entries a log holds and roughly how many
+	// bytes they take up
+	LogUsage(ctx context.Context, log string) (LogUsage, error)
+	Close() error
+}
+
+// LogUsage is the storage taken up by a log
+type LogUsage struct {
+	Entries int64 `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// NewStore opens the configured storage backend. Redis uses the existing
+// client; Postgres connects to dsn and creates its tables if needed.
+func NewStore(backend, dsn string, redisClient *redis.Client) (Store, error) {
+	switch backend {
+	case "", BackendRedis:
+		return NewRedisStore(redisClient), nil
+	case BackendPostgres:
+		if dsn == "" {
+			return nil, fmt.Errorf("the postgres storage backend requires storage.postgres_dsn")
+		}
+		return NewPostgresStore(dsn)
+	}
+	return nil, fmt.Errorf("unknown storage backend '%s' (use redis or postgres)", backend)
+}
+
+// RedisStore keeps records as plain keys, collection:id, with an
+// <collection>s:list set indexing the records stored without a ttl. Logs are
+// sorted sets, <log>:entries, scored by Unix time.
+type RedisStore struct {
+	redisClient *redis.Client
+}
+
+// NewRedisStore creates a Store on top of a Redis client
+func NewRedisStore(redisClient *redis.Client) *RedisStore {
+	return &RedisStore{redisClient: redisClient}
+}
+
+func recordKey(collection, id string) string {
+	// Request records keep their original agent:<agent>:requests:<id> keys
+	if collection == CollectionRequests {
+		if agentID, reqID, ok := strings.Cut(id, ":"); ok {
+			return fmt.Sprintf("agent:%s:requests:%s", agentID, reqID)
+		}
+	}
+	return fmt.Sprintf("%s:%s", collection, id)
+}
+
+func indexKey(collection string) string {
+	return fmt.Sprintf("%ss:list", collection)
+}
+
+func logKey(log string) string {
+	return fmt.Sprintf("%s:entries", log)
+}
+
+func (s *RedisStore) Get(ctx context.Context, collection, id string) ([]byte, error) {
+	data, err := s.redisClient.Get(ctx, recordKey(collection, id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *RedisStore) Put(ctx context.Context, collection, id string, data []byte, ttl time.Duration) error {
+	if err := s.redisClient.Set(ctx, recordKey(collection, id), data, ttl).Err(); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return nil
+	}
+	return s.redisClient.SAdd(ctx, indexKey(collection), id).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, collection, id string) error {
+	if err := s.redisClient.Del(ctx, recordKey(collection, id)).Err(); err != nil {
+		return err
+	}
+	return s.redisClient.SRem(ctx, indexKey(collection), id).Err()
+}
+
+func (s *RedisStore) List(ctx context.Context, collection string) (map[string][]byte, error) {
+	ids, err := s.redisClient.SMembers(ctx, indexKey(collection)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		data, err := s.redisClient.Get(ctx, recordKey(collection, id)).Bytes()
+		if err == redis.Nil {
+			// Indexed but gone, clean up
+			s.redisClient.SRem(ctx, indexKey(collection), id)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get %s %s: %w", collection, id, err)
+		}
+		records[id] = data
+	}
+	return records, nil
+}
+
+func (s *RedisStore) Append(ctx context.Context, log string, at time.Time, data []byte) error {
+	return s.redisClient.ZAdd(ctx, logKey(log), &redis.Z{
+		Score:  float64(at.Unix()),
+		Member: string(data),
+	}).Err()
+}
+
+func (s *RedisStore) Range(ctx context.Context, log string, from, to time.Time) ([][]byte, error) {
+	results, err := s.redisClient.ZRangeByScore(ctx, logKey(log), &redis.ZRangeBy{
+		Min: fmt.Sprintf("%d", from.Unix()),
+		Max: fmt.Sprintf("%d", to.Unix()),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([][]byte, len(results))
+	for i, result := range results {
+		entries[i] = []byte(result)
+	}
+	return entries, nil
+}
+
+func (s *RedisStore) TrimLog(ctx context.Context, log string, before time.Time) error {
+	return s.redisClient.ZRemRangeByScore(ctx, logKey(log), "0", fmt.Sprintf("%d", before.Unix())).Err()
+}
+
+func (s *RedisStore) CapLog(ctx context.Context, log string, max int64) error {
+	return s.redisClient.ZRemRangeByRank(ctx, logKey(log), 0, -max-1).Err()
+}
+
+// LogUsage reports Redis's own estimate of the memory used by the log
+func (s *RedisStore) LogUsage(ctx context.Context, log string) (LogUsage, error) {
+	entries, err := s.redisClient.ZCard(ctx, logKey(log)).Result()
+	if err != nil {
+		return LogUsage{}, err
+	}
+	if entries == 0 {
+		return LogUsage{}, nil
+	}
+	bytes, err := s.redisClient.MemoryUsage(ctx, logKey(log)).Result()
+	if err != nil && err != redis.Nil {
+		return LogUsage{}, err
+	}
+	return LogUsage{Entries: entries, Bytes: bytes}, nil
+}
+
+// Close leaves the Redis client open since it is shared
+func (s *RedisStore) Close() error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 1 * time.Minute
+
+	// A run that lasts this long resets the backoff
+	stableRun = 1 * time.Minute
+)
+
+// TaskStatus reports the liveness of a supervised background task
+type TaskStatus struct {
+	Name        string    `json:"name"`
+	Running     bool      `json:"running"`
+	Restarts    int       `json:"restarts"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastStarted time.Time `json:"last_started"`
+}
+
+// Supervisor runs long-lived background tasks, restarting them with backoff
+// when they panic or return an error
+type Supervisor struct {
+	mu    sync.RWMutex
+	tasks map[string]*TaskStatus
+	wg    sync.WaitGroup
+}
+
+// New creates a new supervisor
+func New() *Supervisor {
+	return &Supervisor{
+		tasks: make(map[string]*TaskStatus),
+	}
+}
+
+// Go runs fn in a supervised goroutine until ctx is cancelled or fn returns nil
+func (s *Supervisor) Go(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	s.tasks[name] = &TaskStatus{Name: name}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		backoff := initialBackoff
+		for {
+			started := time.Now()
+			s.update(name, func(t *TaskStatus) {
+				t.Running = true
+				t.LastStarted = started
+			})
+
+			err := s.run(ctx, name, fn)
+			if err == nil || ctx.Err() != nil {
+				s.update(name, func(t *TaskStatus) { t.Running = false })
+				return
+			}
+
+			log.Printf("Background task %s failed: %v (restarting in %s)", name, err, backoff)
+			s.update(name, func(t *TaskStatus) {
+				t.Running = false
+				t.Restarts++
+				t.LastError = err.Error()
+			})
+
+			if time.Since(started) >= stableRun {
+				backoff = initialBackoff
+			}
+
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}()
+}
+
+// Wait blocks until every supervised task has exited
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+// run calls fn, converting a panic into an error
+func (s *Supervisor) run(ctx context.Context, name string, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Background task %s panicked: %v\n%s", name, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
+
+func (s *Supervisor) update(name string, fn func(t *TaskStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tasks[name]; ok {
+		fn(t)
+	}
+}
+
+// Statuses returns the status of every supervised task, sorted by name
+func (s *Supervisor) Statuses() []TaskStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := make([]TaskStatus, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		statuses = append(statuses, *t)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Healthy reports whether every supervised task is currently running
+func (s *Supervisor) Healthy() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range s.tasks {
+		if !t.Running {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/agentainer/agentainer-lab/internal/agent"
+	"github.com/agentainer/agentainer-lab/internal/logging"
+)
+
+const (
+	driftKey = "system:drift"
+	// maxDrift is how many drift records are kept across all agents
+	maxDrift = 500
+)
+
+// Drift is a correction made because an agent's recorded status no longer
+// matched its container
+type Drift struct {
+	Time           time.Time    `json:"time"`
+	AgentID        string       `json:"agent_id"`
+	AgentName      string       `json:"agent_name"`
+	From           agent.Status `json:"from"`
+	To             agent.Status `json:"to"`
+	// ContainerState is Docker's state for the container, empty if it is gone
+	ContainerState string       `json:"container_state,omitempty"`
+	Message        string       `json:"message"`
+}
+
+// SyncResult reports what a synchronization pass changed
+type SyncResult struct {
+	Checked int               `json:"checked"`
+	// Updated lists agents whose record changed, including container ID
+	// and health updates that are not drift
+	Updated []string          `json:"updated"`
+	Drift   []Drift           `json:"drift"`
+	Failed  map[string]string `json:"failed"`
+}
+
+// recordDrift adds a status correction to the agent's events and to the
+// system-wide drift list
+func (s *StateSynchronizer) recordDrift(ctx context.Context, agentObj *agent.Agent, to agent.Status, containerState, message string) *Drift {
+	drift := &Drift{
+		Time:           time.Now(),
+		AgentID:        agentObj.ID,
+		AgentName:      agentObj.Name,
+		From:           agentObj.Status,
+		To:             to,
+		ContainerState: containerState,
+		Message:        message,
+	}
+
+	event := agent.Event{Type: agent.EventDrift, From: drift.From, To: to, User: "state-sync", Message: message}
+	if err := s.agentMgr.RecordEvent(ctx, agentObj.ID, event); err != nil {
+		logging.Warnf("sync", "Failed to record drift for agent %s: %v", agentObj.ID, err)
+	}
+
+	data, err := json.Marshal(drift)
+	if err != nil {
+		logging.Warnf("sync", "Failed to marshal drift for agent %s: %v", agentObj.ID, err)
+		return drift
+	}
+	pipe := s.redisClient.TxPipeline()
+	pipe.LPush(ctx, driftKey, data)
+	pipe.LTrim(ctx, driftKey, 0, maxDrift-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logging.Warnf("sync", "Failed to record drift for agent %s: %v", agentObj.ID, err)
+	}
+	return drift
+}
+
+// RecentDrift returns the most recent drift corrections, newest first.
+// A limit of zero returns all that are kept.
+func (s *StateSynchronizer) RecentDrift(ctx context.Context, limit int) ([]Drift, error) {
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(limit - 1)
+	}
+	entries, err := s.redisClient.LRange(ctx, driftKey, 0, stop).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get drift: %w", err)
+	}
+
+	drift := make([]Drift, 0, len(entries))
+	for _, entry := range entries {
+		var d Drift
+		if err := json.Unmarshal([]byte(entry), &d); err != nil {
+			continue
+		}
+		drift = append(drift, d)
+	}
+	return drift, nil
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
 	"github.com/agentainer/agentainer-lab/internal/agent"
+	"github.com/agentainer/agentainer-lab/internal/logging"
+	"github.com/agentainer/agentainer-lab/internal/storage"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
@@ -19,6 +20,8 @@ import (
 type StateSynchronizer struct {
 	dockerClient *client.Client
 	redisClient  *redis.Client
+	store        storage.Store
+	agentMgr     *agent.Manager
 	interval     time.Duration
 	
 	mu       sync.RWMutex
@@ -27,7 +30,7 @@ type StateSynchronizer struct {
 }
 
 // NewStateSynchronizer creates a new state synchronizer
-func NewStateSynchronizer(dockerClient *client.Client, redisClient *redis.Client, interval time.Duration) *StateSynchronizer {
+func NewStateSynchronizer(dockerClient *client.Client, redisClient *redis.Client, store storage.Store, agentMgr *agent.Manager, interval time.Duration) *StateSynchronizer {
 	if interval <= 0 {
 		interval = 30 * time.Second // Default interval
 	}
@@ -35,6 +38,8 @@ func NewStateSynchronizer(dockerClient *client.Client, redisClient *redis.Client
 	return &StateSynchronizer{
 		dockerClient: dockerClient,
 		redisClient:  redisClient,
+		store:        store,
+		agentMgr:     agentMgr,
 		interval:     interval,
 		stopChan:     make(chan struct{}),
 	}
@@ -42,15 +47,15 @@ func NewStateSynchronizer(dockerClient *client.Client, redisClient *redis.Client
 
 // Start begins the synchronization process
 func (s *StateSynchronizer) Start(ctx context.Context) error {
-	log.Printf("Starting state synchronizer with interval: %v", s.interval)
+	logging.Infof("sync", "Starting state synchronizer with interval: %v", s.interval)
 	
 	// Run initial sync immediately and log results
-	log.Println("Running initial state synchronization...")
-	if err := s.syncStates(ctx); err != nil {
-		log.Printf("ERROR: Initial sync failed: %v", err)
+	logging.Debugf("sync", "Running initial state synchronization...")
+	if _, err := s.syncStates(ctx); err != nil {
+		logging.Errorf("sync", "Initial sync failed: %v", err)
 		// Don't fail startup, just log the error
 	} else {
-		log.Println("Initial state synchronization completed successfully")
+		logging.Infof("sync", "Initial state synchronization completed successfully")
 	}
 	
 	// Start periodic sync
@@ -66,20 +71,23 @@ func (s *StateSynchronizer) Start(ctx context.Context) error {
 
 // Stop gracefully stops the synchronizer
 func (s *StateSynchronizer) Stop() {
-	log.Println("Stopping state synchronizer...")
+	logging.Infof("sync", "Stopping state synchronizer...")
 	close(s.stopChan)
 	s.wg.Wait()
 }
 
 // syncStates performs a full synchronization of all agent states
-func (s *StateSynchronizer) syncStates(ctx context.Context) error {
-	// Get all agent IDs from Redis
-	agentIDs, err := s.redisClient.SMembers(ctx, "agents:list").Result()
+func (s *StateSynchronizer) syncStates(ctx context.Context) (*SyncResult, error) {
+	records, err := s.store.List(ctx, storage.CollectionAgents)
 	if err != nil {
-		return fmt.Errorf("failed to get agent list: %w", err)
+		return nil, fmt.Errorf("failed to get agent list: %w", err)
+	}
+	agentIDs := make([]string, 0, len(records))
+	for agentID := range records {
+		agentIDs = append(agentIDs, agentID)
 	}
 	
-	log.Printf("Starting sync for %d agents: %v", len(agentIDs), agentIDs)
+	logging.Debugf("sync", "Starting sync for %d agents: %v", len(agentIDs), agentIDs)
 	
 	// Get all containers with agentainer labels
 	containerFilters := filters.NewArgs()
@@ -90,98 +98,148 @@ func (s *StateSynchronizer) syncStates(ctx context.Context) error {
 		Filters: containerFilters,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to list containers: %w", err)
+		return nil, fmt.Errorf("failed to list containers: %w", err)
 	}
 	
-	log.Printf("Found %d containers with agentainer labels", len(containers))
+	logging.Debugf("sync", "Found %d containers with agentainer labels", len(containers))
 	
 	// Create a map of agent ID to container for quick lookup
 	containerMap := make(map[string]types.Container)
 	for _, container := range containers {
 		if agentID, ok := container.Labels["agentainer.id"]; ok {
 			containerMap[agentID] = container
-			log.Printf("Found container %s for agent %s (state: %s)", 
+			logging.Debugf("sync", "Found container %s for agent %s (state: %s)", 
 				container.ID[:12], agentID, container.State)
 		}
 	}
 	
 	// Sync each agent
-	successCount := 0
-	failCount := 0
+	result := &SyncResult{
+		Checked: len(agentIDs),
+		Updated: []string{},
+		Drift:   []Drift{},
+		Failed:  make(map[string]string),
+	}
 	for _, agentID := range agentIDs {
-		if err := s.syncAgent(ctx, agentID, containerMap); err != nil {
-			log.Printf("Failed to sync agent %s: %v", agentID, err)
-			failCount++
-		} else {
-			successCount++
+		updated, drift, err := s.syncAgent(ctx, agentID, containerMap)
+		if err != nil {
+			logging.Warnf("sync", "Failed to sync agent %s: %v", agentID, err)
+			result.Failed[agentID] = err.Error()
+			continue
+		}
+		if updated {
+			result.Updated = append(result.Updated, agentID)
+		}
+		if drift != nil {
+			result.Drift = append(result.Drift, *drift)
 		}
 	}
 	
-	log.Printf("Sync completed: %d successful, %d failed", successCount, failCount)
+	logging.Debugf("sync", "Sync completed: %d successful, %d failed, %d drifted",
+		len(agentIDs)-len(result.Failed), len(result.Failed), len(result.Drift))
 	
-	return nil
+	return result, nil
 }
 
-// syncAgent syncs a single agent's state
-func (s *StateSynchronizer) syncAgent(ctx context.Context, agentID string, containerMap map[string]types.Container) error {
-	// Get agent from Redis
-	key := fmt.Sprintf("agent:%s", agentID)
-	data, err := s.redisClient.Get(ctx, key).Result()
-	if err == redis.Nil {
-		// Agent not found in Redis, remove from list
-		log.Printf("Agent %s not found in Redis, removing from list", agentID)
-		s.redisClient.SRem(ctx, "agents:list", agentID)
-		return nil
+// syncAgent syncs a single agent's state and reports whether its record
+// changed, along with the status correction made, if any
+func (s *StateSynchronizer) syncAgent(ctx context.Context, agentID string, containerMap map[string]types.Container) (bool, *Drift, error) {
+	data, err := s.store.Get(ctx, storage.CollectionAgents, agentID)
+	if err == storage.ErrNotFound {
+		// Removed since the sync started
+		return false, nil, nil
 	} else if err != nil {
-		return fmt.Errorf("failed to get agent: %w", err)
+		return false, nil, fmt.Errorf("failed to get agent: %w", err)
 	}
 	
 	var agentObj agent.Agent
-	if err := json.Unmarshal([]byte(data), &agentObj); err != nil {
-		return fmt.Errorf("failed to unmarshal agent: %w", err)
+	if err := json.Unmarshal(data, &agentObj); err != nil {
+		return false, nil, fmt.Errorf("failed to unmarshal agent: %w", err)
 	}
 	
 	// Log current state
-	log.Printf("Syncing agent %s (%s) - Current state: %s, Container ID: %s", 
+	logging.Debugf("sync", "Syncing agent %s (%s) - Current state: %s, Container ID: %s", 
 		agentID, agentObj.Name, agentObj.Status, agentObj.ContainerID)
 	
 	// Check container state
 	container, exists := containerMap[agentID]
 	updated := false
+	var drift *Drift
 	
 	if exists {
 		// Container exists, update agent state based on container state
 		newStatus := s.dockerStateToAgentStatus(container.State)
+		
+		// An agent that hit its restart limit stays failed until started again
+		if agentObj.Status == agent.StatusFailed && newStatus == agent.StatusStopped {
+			newStatus = agent.StatusFailed
+		}
+		
 		if agentObj.Status != newStatus {
-			log.Printf("Agent %s (%s): Docker container state is '%s', updating status from %s to %s", 
+			logging.Infof("sync", "Agent %s (%s): Docker container state is '%s', updating status from %s to %s", 
 				agentID, agentObj.Name, container.State, agentObj.Status, newStatus)
+			drift = s.recordDrift(ctx, &agentObj, newStatus, container.State, fmt.Sprintf("container state is '%s'", container.State))
 			agentObj.Status = newStatus
 			updated = true
 		}
 		
+		state, err := s.containerState(ctx, container.ID)
+		if err != nil {
+			logging.Warnf("sync", "Agent %s (%s): failed to inspect container: %v", agentID, agentObj.Name, err)
+		}
+		
+		// Mirror Docker's native HEALTHCHECK status, if the image defines one
+		containerHealth := ""
+		if container.State == "running" {
+			if state == nil {
+				containerHealth = agentObj.ContainerHealth
+			} else if state.Health != nil {
+				containerHealth = state.Health.Status
+			}
+		}
+		if agentObj.ContainerHealth != containerHealth {
+			logging.Infof("sync", "Agent %s (%s): container health changed from '%s' to '%s'", 
+				agentID, agentObj.Name, agentObj.ContainerHealth, containerHealth)
+			agentObj.ContainerHealth = containerHealth
+			updated = true
+		}
+		
+		// Keep the exit code and OOM flag, which Docker forgets once the
+		// container is removed or restarted again
+		if lastExit := agent.ContainerExit(state); lastExit != nil &&
+			(agentObj.LastExit == nil || !agentObj.LastExit.FinishedAt.Equal(lastExit.FinishedAt)) {
+			logging.Infof("sync", "Agent %s (%s): container %s at %s", 
+				agentID, agentObj.Name, lastExit.Reason, lastExit.FinishedAt.Format(time.RFC3339))
+			agentObj.LastExit = lastExit
+			updated = true
+		}
+		
 		// Update container ID if different
 		if agentObj.ContainerID != container.ID {
-			log.Printf("Agent %s (%s): container ID updated from %s to %s", 
+			logging.Infof("sync", "Agent %s (%s): container ID updated from %s to %s", 
 				agentID, agentObj.Name, agentObj.ContainerID, container.ID)
 			agentObj.ContainerID = container.ID
 			updated = true
 		}
 	} else {
 		// Container doesn't exist
-		log.Printf("Agent %s (%s): No container found with label agentainer.id=%s", 
+		logging.Debugf("sync", "Agent %s (%s): No container found with label agentainer.id=%s", 
 			agentID, agentObj.Name, agentID)
 			
 		if agentObj.Status == agent.StatusRunning || agentObj.Status == agent.StatusPaused {
-			log.Printf("Agent %s (%s): was %s but container not found, marking as stopped", 
+			logging.Infof("sync", "Agent %s (%s): was %s but container not found, marking as stopped", 
 				agentID, agentObj.Name, agentObj.Status)
+			drift = s.recordDrift(ctx, &agentObj, agent.StatusStopped, "", "container not found")
 			agentObj.Status = agent.StatusStopped
 			agentObj.ContainerID = ""
+			agentObj.ContainerHealth = ""
 			updated = true
 		} else if agentObj.ContainerID != "" {
 			// Clear container ID if it's set but container doesn't exist
-			log.Printf("Agent %s (%s): clearing non-existent container ID %s", 
+			logging.Infof("sync", "Agent %s (%s): clearing non-existent container ID %s", 
 				agentID, agentObj.Name, agentObj.ContainerID)
 			agentObj.ContainerID = ""
+			agentObj.ContainerHealth = ""
 			updated = true
 		}
 	}
@@ -192,24 +250,34 @@ func (s *StateSynchronizer) syncAgent(ctx context.Context, agentID string, conta
 		
 		updatedData, err := json.Marshal(agentObj)
 		if err != nil {
-			return fmt.Errorf("failed to marshal agent: %w", err)
+			return false, nil, fmt.Errorf("failed to marshal agent: %w", err)
 		}
 		
-		if err := s.redisClient.Set(ctx, key, updatedData, 0).Err(); err != nil {
-			return fmt.Errorf("failed to save agent: %w", err)
+		if err := s.store.Put(ctx, storage.CollectionAgents, agentID, updatedData, 0); err != nil {
+			return false, nil, fmt.Errorf("failed to save agent: %w", err)
 		}
 		
 		// Also update the status key for backward compatibility
 		statusKey := fmt.Sprintf("agent:%s:status", agentID)
 		if err := s.redisClient.Set(ctx, statusKey, string(agentObj.Status), 0).Err(); err != nil {
-			log.Printf("Failed to update status key: %v", err)
+			logging.Warnf("sync", "Failed to update status key: %v", err)
 		}
 		
 		// Publish status change event
 		s.publishStatusChange(ctx, agentID, agentObj.Status)
 	}
 	
-	return nil
+	return updated, drift, nil
+}
+
+// containerState returns the state of a container, including its native
+// Docker health status and last exit
+func (s *StateSynchronizer) containerState(ctx context.Context, containerID string) (*types.ContainerState, error) {
+	info, err := s.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	return info.State, nil
 }
 
 // dockerStateToAgentStatus converts Docker container state to agent status
@@ -238,8 +306,8 @@ func (s *StateSynchronizer) runPeriodicSync(ctx context.Context) {
 	for {
 		select {
 		case <-ticker.C:
-			if err := s.syncStates(ctx); err != nil {
-				log.Printf("Periodic sync failed: %v", err)
+			if _, err := s.syncStates(ctx); err != nil {
+				logging.Errorf("sync", "Periodic sync failed: %v", err)
 			}
 		case <-ctx.Done():
 			return
@@ -267,7 +335,12 @@ func (s *StateSynchronizer) watchDockerEvents(ctx context.Context) {
 		case event := <-events:
 			// Handle container state changes
 			if agentID, ok := event.Actor.Attributes["agentainer.id"]; ok {
-				log.Printf("Docker event for agent %s: %s", agentID, event.Action)
+				logging.Debugf("sync", "Docker event for agent %s: %s", agentID, event.Action)
+				
+				// Track policy-driven restarts before syncing so a restart limit hit is not overwritten
+				if err := s.agentMgr.ObserveContainerEvent(ctx, agentID, event.Action); err != nil {
+					logging.Warnf("sync", "Failed to track restarts for agent %s: %v", agentID, err)
+				}
 				
 				// Get fresh container list for this agent
 				containerFilters := filters.NewArgs()
@@ -278,7 +351,7 @@ func (s *StateSynchronizer) watchDockerEvents(ctx context.Context) {
 					Filters: containerFilters,
 				})
 				if err != nil {
-					log.Printf("Failed to list containers for agent %s: %v", agentID, err)
+					logging.Warnf("sync", "Failed to list containers for agent %s: %v", agentID, err)
 					continue
 				}
 				
@@ -288,14 +361,14 @@ func (s *StateSynchronizer) watchDockerEvents(ctx context.Context) {
 				}
 				
 				// Sync this specific agent
-				if err := s.syncAgent(ctx, agentID, containerMap); err != nil {
-					log.Printf("Failed to sync agent %s after event: %v", agentID, err)
+				if _, _, err := s.syncAgent(ctx, agentID, containerMap); err != nil {
+					logging.Warnf("sync", "Failed to sync agent %s after event: %v", agentID, err)
 				}
 			}
 			
 		case err := <-errs:
 			if err != nil {
-				log.Printf("Docker event error: %v", err)
+				logging.Warnf("sync", "Docker event error: %v", err)
 			}
 			return
 			
@@ -312,12 +385,12 @@ func (s *StateSynchronizer) watchDockerEvents(ctx context.Context) {
 func (s *StateSynchronizer) publishStatusChange(ctx context.Context, agentID string, status agent.Status) {
 	channel := fmt.Sprintf("agent:status:%s", agentID)
 	if err := s.redisClient.Publish(ctx, channel, string(status)).Err(); err != nil {
-		log.Printf("Failed to publish status change: %v", err)
+		logging.Warnf("sync", "Failed to publish status change: %v", err)
 	}
 }
 
-// SyncNow triggers an immediate synchronization
-func (s *StateSynchronizer) SyncNow(ctx context.Context) error {
-	log.Println("Triggering immediate state sync...")
+// SyncNow triggers an immediate synchronization and reports what it changed
+func (s *StateSynchronizer) SyncNow(ctx context.Context) (*SyncResult, error) {
+	logging.Debugf("sync", "Triggering immediate state sync...")
 	return s.syncStates(ctx)
 }
\ No newline at end of file
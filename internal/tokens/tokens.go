@@ -0,0 +1,311 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	listKey = "tokens:list"
+	// defaultKey holds the hash of the default token once it is rotated,
+	// superseding the one in the config; previousKey holds the hash it
+	// replaced for the grace period
+	defaultKey  = "tokens:default"
+	previousKey = "tokens:default:previous"
+	// tokenPrefix marks API tokens so they are easy to spot in leaks
+	tokenPrefix = "agt_"
+)
+
+// ErrNotFound is returned for a token that does not exist
+var ErrNotFound = errors.New("token not found")
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.@-]{0,63}$`)
+
+// Token describes an API token; the token itself is never stored, only
+// its SHA-256 hash
+type Token struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	// User is who requests made with the token are attributed to
+	User      string     `json:"user"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	Revoked   bool       `json:"revoked"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Store keeps API tokens in Redis
+type Store struct {
+	redisClient *redis.Client
+}
+
+// NewStore creates a token store
+func NewStore(redisClient *redis.Client) *Store {
+	return &Store{redisClient: redisClient}
+}
+
+// ValidateName checks a token name or user
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid name '%s': use letters, digits, '.', '_', '@' and '-' (max 64 characters)", name)
+	}
+	return nil
+}
+
+// Create generates a token for user and returns it with its secret value,
+// which cannot be retrieved again
+func (s *Store) Create(ctx context.Context, name, user, createdBy string) (*Token, string, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, "", err
+	}
+	if user == "" {
+		user = name
+	}
+	if err := ValidateName(user); err != nil {
+		return nil, "", err
+	}
+
+	id, err := randomHex(6)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+	value := tokenPrefix + secret
+
+	token := &Token{
+		ID:        id,
+		Name:      name,
+		User:      user,
+		CreatedAt: time.Now(),
+		CreatedBy: createdBy,
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal token: %w", err)
+	}
+	pipe := s.redisClient.TxPipeline()
+	pipe.Set(ctx, tokenKey(id), data, 0)
+	pipe.Set(ctx, hashKey(value), id, 0)
+	pipe.SAdd(ctx, listKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, "", fmt.Errorf("failed to save token: %w", err)
+	}
+	return token, value, nil
+}
+
+// Authenticate returns the active token matching value, or ErrNotFound
+func (s *Store) Authenticate(ctx context.Context, value string) (*Token, error) {
+	id, err := s.redisClient.Get(ctx, hashKey(value)).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up token: %w", err)
+	}
+
+	token, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if token.Revoked {
+		return nil, ErrNotFound
+	}
+
+	// Last use is tracked to the minute to avoid a write per request
+	now := time.Now()
+	if token.LastUsed == nil || now.Sub(*token.LastUsed) >= time.Minute {
+		token.LastUsed = &now
+		if err := s.save(ctx, token); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// Get returns a token by ID
+func (s *Store) Get(ctx context.Context, id string) (*Token, error) {
+	data, err := s.redisClient.Get(ctx, tokenKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token: %w", err)
+	}
+	return &token, nil
+}
+
+// List returns all tokens, revoked ones included, oldest first
+func (s *Store) List(ctx context.Context) ([]Token, error) {
+	ids, err := s.redisClient.SMembers(ctx, listKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+
+	list := make([]Token, 0, len(ids))
+	for _, id := range ids {
+		token, err := s.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *token)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+// Revoke stops a token from authenticating. The token is kept, marked
+// revoked, so audit entries made with it can still be traced.
+func (s *Store) Revoke(ctx context.Context, idOrName string) (*Token, error) {
+	token, err := s.find(ctx, idOrName)
+	if err != nil {
+		return nil, err
+	}
+	if token.Revoked {
+		return token, nil
+	}
+
+	now := time.Now()
+	token.Revoked = true
+	token.RevokedAt = &now
+	if err := s.save(ctx, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// find looks a token up by ID, then by name among active tokens
+func (s *Store) find(ctx context.Context, idOrName string) (*Token, error) {
+	token, err := s.Get(ctx, idOrName)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return token, err
+	}
+
+	list, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var match *Token
+	for i := range list {
+		if list[i].Name != idOrName || list[i].Revoked {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("more than one active token is named '%s'; revoke by ID", idOrName)
+		}
+		match = &list[i]
+	}
+	if match == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, idOrName)
+	}
+	return match, nil
+}
+
+// CheckDefault reports whether value is the default token. Until the token
+// is first rotated that is configured; afterwards it is the rotated token,
+// or the one before it while its grace period lasts.
+func (s *Store) CheckDefault(ctx context.Context, value, configured string) (bool, error) {
+	hashes, err := s.redisClient.MGet(ctx, defaultKey, previousKey).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to look up default token: %w", err)
+	}
+	current, _ := hashes[0].(string)
+	if current == "" {
+		return configured != "" && subtle.ConstantTimeCompare([]byte(value), []byte(configured)) == 1, nil
+	}
+
+	hash := []byte(hashValue(value))
+	if subtle.ConstantTimeCompare(hash, []byte(current)) == 1 {
+		return true, nil
+	}
+	previous, _ := hashes[1].(string)
+	return previous != "" && subtle.ConstantTimeCompare(hash, []byte(previous)) == 1, nil
+}
+
+// RotateDefault replaces the default token with a new one and returns it.
+// The token it replaces, the configured one if it was never rotated, stays
+// valid for grace.
+func (s *Store) RotateDefault(ctx context.Context, configured string, grace time.Duration) (string, error) {
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", err
+	}
+	value := tokenPrefix + secret
+
+	current, err := s.redisClient.Get(ctx, defaultKey).Result()
+	if err != nil && err != redis.Nil {
+		return "", fmt.Errorf("failed to look up default token: %w", err)
+	}
+	if current == "" && configured != "" {
+		current = hashValue(configured)
+	}
+
+	pipe := s.redisClient.TxPipeline()
+	pipe.Set(ctx, defaultKey, hashValue(value), 0)
+	if grace > 0 && current != "" {
+		pipe.Set(ctx, previousKey, current, grace)
+	} else {
+		pipe.Del(ctx, previousKey)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("failed to save default token: %w", err)
+	}
+	return value, nil
+}
+
+func (s *Store) save(ctx context.Context, token *Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %w", err)
+	}
+	if err := s.redisClient.Set(ctx, tokenKey(token.ID), data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+	return nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func tokenKey(id string) string {
+	return fmt.Sprintf("token:%s", id)
+}
+
+// hashKey indexes tokens by the hash of their value
+func hashKey(value string) string {
+	return fmt.Sprintf("token:hash:%s", hashValue(value))
+}
+
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -10,7 +10,8 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/go-redis/redis/v8"
+	"github.com/agentainer/agentainer-lab/internal/storage"
+	"github.com/agentainer/agentainer-lab/pkg/docker"
 )
 
 // Agent represents the agent structure for sync purposes
@@ -24,31 +25,31 @@ type Agent struct {
 
 // QuickSync performs an immediate synchronization of a specific agent or all agents
 type QuickSync struct {
-	dockerClient *client.Client
-	redisClient  *redis.Client
+	dockerClient     *client.Client
+	store            storage.Store
+	operationTimeout time.Duration
 }
 
 // NewQuickSync creates a new quick sync utility
-func NewQuickSync(dockerClient *client.Client, redisClient *redis.Client) *QuickSync {
+func NewQuickSync(dockerClient *client.Client, store storage.Store, operationTimeout time.Duration) *QuickSync {
 	return &QuickSync{
-		dockerClient: dockerClient,
-		redisClient:  redisClient,
+		dockerClient:     dockerClient,
+		store:            store,
+		operationTimeout: operationTimeout,
 	}
 }
 
 // SyncAgent synchronizes a specific agent's state immediately
 func (q *QuickSync) SyncAgent(ctx context.Context, agentID string) error {
-	// Get agent from Redis
-	key := fmt.Sprintf("agent:%s", agentID)
-	data, err := q.redisClient.Get(ctx, key).Result()
-	if err == redis.Nil {
+	data, err := q.store.Get(ctx, storage.CollectionAgents, agentID)
+	if err == storage.ErrNotFound {
 		return fmt.Errorf("agent not found")
 	} else if err != nil {
 		return fmt.Errorf("failed to get agent: %w", err)
 	}
 	
 	var agentObj Agent
-	if err := json.Unmarshal([]byte(data), &agentObj); err != nil {
+	if err := json.Unmarshal(data, &agentObj); err != nil {
 		return fmt.Errorf("failed to unmarshal agent: %w", err)
 	}
 	
@@ -56,18 +57,20 @@ func (q *QuickSync) SyncAgent(ctx context.Context, agentID string) error {
 	containerFilters := filters.NewArgs()
 	containerFilters.Add("label", fmt.Sprintf("agentainer.id=%s", agentID))
 	
-	containers, err := q.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+	listCtx, cancel := docker.WithOperationTimeout(ctx, q.operationTimeout)
+	defer cancel()
+	containers, err := q.dockerClient.ContainerList(listCtx, types.ContainerListOptions{
 		All:     true,
 		Filters: containerFilters,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to list containers: %w", err)
+		return fmt.Errorf("failed to list containers: %w", docker.CheckTimeout(err, q.operationTimeout))
 	}
 	
 	updated := false
 	if len(containers) > 0 {
 		container := containers[0]
-		newStatus := dockerStateToAgentStatus(container.State)
+		newStatus := keepFailed(agentObj.Status, dockerStateToAgentStatus(container.State))
 		
 		if agentObj.Status != newStatus || agentObj.ContainerID != container.ID {
 			agentObj.Status = newStatus
@@ -89,13 +92,8 @@ func (q *QuickSync) SyncAgent(ctx context.Context, agentID string) error {
 	if updated {
 		agentObj.UpdatedAt = time.Now()
 		
-		updatedData, err := json.Marshal(agentObj)
-		if err != nil {
-			return fmt.Errorf("failed to marshal agent: %w", err)
-		}
-		
-		if err := q.redisClient.Set(ctx, key, updatedData, 0).Err(); err != nil {
-			return fmt.Errorf("failed to save agent: %w", err)
+		if err := q.saveAgent(ctx, data, agentObj); err != nil {
+			return err
 		}
 		
 		log.Printf("Quick sync: Updated agent %s status to %s", agentID, agentObj.Status)
@@ -106,8 +104,8 @@ func (q *QuickSync) SyncAgent(ctx context.Context, agentID string) error {
 
 // SyncAll synchronizes all agents immediately
 func (q *QuickSync) SyncAll(ctx context.Context) error {
-	// Get all agent IDs
-	agentIDs, err := q.redisClient.SMembers(ctx, "agents:list").Result()
+	// Get all agents
+	records, err := q.store.List(ctx, storage.CollectionAgents)
 	if err != nil {
 		return fmt.Errorf("failed to get agent list: %w", err)
 	}
@@ -116,12 +114,14 @@ func (q *QuickSync) SyncAll(ctx context.Context) error {
 	containerFilters := filters.NewArgs()
 	containerFilters.Add("label", "agentainer.id")
 	
-	containers, err := q.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+	listCtx, cancel := docker.WithOperationTimeout(ctx, q.operationTimeout)
+	defer cancel()
+	containers, err := q.dockerClient.ContainerList(listCtx, types.ContainerListOptions{
 		All:     true,
 		Filters: containerFilters,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to list containers: %w", err)
+		return fmt.Errorf("failed to list containers: %w", docker.CheckTimeout(err, q.operationTimeout))
 	}
 	
 	// Create container map
@@ -133,8 +133,8 @@ func (q *QuickSync) SyncAll(ctx context.Context) error {
 	}
 	
 	// Sync each agent
-	for _, agentID := range agentIDs {
-		if err := q.syncAgentWithMap(ctx, agentID, containerMap); err != nil {
+	for agentID, data := range records {
+		if err := q.syncAgentWithMap(ctx, agentID, data, containerMap); err != nil {
 			log.Printf("Failed to sync agent %s: %v", agentID, err)
 		}
 	}
@@ -142,25 +142,15 @@ func (q *QuickSync) SyncAll(ctx context.Context) error {
 	return nil
 }
 
-func (q *QuickSync) syncAgentWithMap(ctx context.Context, agentID string, containerMap map[string]types.Container) error {
-	// Get agent from Redis
-	key := fmt.Sprintf("agent:%s", agentID)
-	data, err := q.redisClient.Get(ctx, key).Result()
-	if err == redis.Nil {
-		q.redisClient.SRem(ctx, "agents:list", agentID)
-		return nil
-	} else if err != nil {
-		return err
-	}
-	
+func (q *QuickSync) syncAgentWithMap(ctx context.Context, agentID string, data []byte, containerMap map[string]types.Container) error {
 	var agentObj Agent
-	if err := json.Unmarshal([]byte(data), &agentObj); err != nil {
+	if err := json.Unmarshal(data, &agentObj); err != nil {
 		return err
 	}
 	
 	updated := false
 	if container, exists := containerMap[agentID]; exists {
-		newStatus := dockerStateToAgentStatus(container.State)
+		newStatus := keepFailed(agentObj.Status, dockerStateToAgentStatus(container.State))
 		if agentObj.Status != newStatus || agentObj.ContainerID != container.ID {
 			agentObj.Status = newStatus
 			agentObj.ContainerID = container.ID
@@ -179,17 +169,47 @@ func (q *QuickSync) syncAgentWithMap(ctx context.Context, agentID string, contai
 	
 	if updated {
 		agentObj.UpdatedAt = time.Now()
-		updatedData, err := json.Marshal(agentObj)
-		if err != nil {
-			return err
-		}
-		
-		return q.redisClient.Set(ctx, key, updatedData, 0).Err()
+		return q.saveAgent(ctx, data, agentObj)
 	}
 	
 	return nil
 }
 
+// saveAgent writes the synced fields back onto the stored agent record,
+// leaving fields this package doesn't know about untouched
+func (q *QuickSync) saveAgent(ctx context.Context, data []byte, agentObj Agent) error {
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return fmt.Errorf("failed to unmarshal agent: %w", err)
+	}
+	
+	record["status"] = agentObj.Status
+	record["container_id"] = agentObj.ContainerID
+	record["updated_at"] = agentObj.UpdatedAt
+	if agentObj.Status != "running" {
+		delete(record, "container_health")
+	}
+	
+	updatedData, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent: %w", err)
+	}
+	
+	if err := q.store.Put(ctx, storage.CollectionAgents, agentObj.ID, updatedData, 0); err != nil {
+		return fmt.Errorf("failed to save agent: %w", err)
+	}
+	return nil
+}
+
+// keepFailed preserves a failed status (set when an agent hits its restart
+// limit) while its container is merely exited
+func keepFailed(current, next string) string {
+	if current == "failed" && next == "stopped" {
+		return current
+	}
+	return next
+}
+
 func dockerStateToAgentStatus(state string) string {
 	switch state {
 	case "running":
@@ -3,19 +3,31 @@ package docker
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/archive"
 )
 
+// SourceHashLabel is set on built images to the hash of what they were
+// built from, so an unchanged build can reuse the image
+const SourceHashLabel = "agentainer.source-hash"
+
+// contextExcludes are left out of build contexts sent to Docker
+var contextExcludes = []string{".git", "node_modules", "__pycache__"}
+
 // BuildProgress represents the progress of a Docker build
 type BuildProgress struct {
 	Status string
@@ -23,15 +35,50 @@ type BuildProgress struct {
 	Error  string
 }
 
+// BuildOptions control how an image is built
+type BuildOptions struct {
+	// BuildArgs are passed to the Dockerfile's ARG instructions
+	BuildArgs map[string]string
+	// Target is the stage of a multi-stage Dockerfile to build
+	Target    string
+	// NoCache rebuilds every layer instead of reusing cached ones
+	NoCache   bool
+	// SourceHash is recorded on the image as its SourceHashLabel
+	SourceHash string
+}
+
+// ParseBuildArgs parses KEY=VALUE build args. A bare KEY takes its value
+// from the environment, as with docker build.
+func ParseBuildArgs(args []string) (map[string]string, error) {
+	result := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid build arg '%s' (expected KEY=VALUE)", arg)
+		}
+		if !ok {
+			value, ok = os.LookupEnv(key)
+			if !ok {
+				return nil, fmt.Errorf("build arg %s has no value and is not set in the environment", key)
+			}
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
 // ImageBuilder handles Docker image building operations
 type ImageBuilder struct {
-	client *client.Client
+	client     *client.Client
+	registries []RegistryAuth
 }
 
-// NewImageBuilder creates a new image builder
-func NewImageBuilder(dockerClient *client.Client) *ImageBuilder {
+// NewImageBuilder creates a new image builder. Registry credentials are used
+// to pull private base images.
+func NewImageBuilder(dockerClient *client.Client, registries []RegistryAuth) *ImageBuilder {
 	return &ImageBuilder{
-		client: dockerClient,
+		client:     dockerClient,
+		registries: registries,
 	}
 }
 
@@ -96,7 +143,7 @@ func GenerateImageName(agentName string) string {
 }
 
 // BuildImage builds a Docker image from a Dockerfile
-func (b *ImageBuilder) BuildImage(ctx context.Context, dockerfilePath, imageName string, progressChan chan<- string) error {
+func (b *ImageBuilder) BuildImage(ctx context.Context, dockerfilePath, imageName string, opts BuildOptions, progressChan chan<- string) error {
 	defer close(progressChan)
 	
 	// Get the directory containing the Dockerfile
@@ -107,22 +154,53 @@ func (b *ImageBuilder) BuildImage(ctx context.Context, dockerfilePath, imageName
 	progressChan <- "Preparing build context..."
 	buildContext, err := archive.TarWithOptions(contextDir, &archive.TarOptions{
 		Compression:     archive.Uncompressed,
-		ExcludePatterns: []string{".git", "node_modules", "__pycache__"},
+		ExcludePatterns: contextExcludes,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create build context: %w", err)
 	}
 	defer buildContext.Close()
 	
+	progressChan <- fmt.Sprintf("Building image '%s' from %s...", imageName, dockerfilePath)
+	
+	return b.build(ctx, buildContext, dockerfileName, imageName, opts, progressChan)
+}
+
+// BuildFromContext builds a Docker image from an already-packaged tar build
+// context, such as one uploaded over the API
+func (b *ImageBuilder) BuildFromContext(ctx context.Context, buildContext io.Reader, dockerfileName, imageName string, opts BuildOptions, progressChan chan<- string) error {
+	defer close(progressChan)
+	
+	if dockerfileName == "" {
+		dockerfileName = "Dockerfile"
+	}
+	
+	progressChan <- fmt.Sprintf("Building image '%s' from uploaded context (%s)...", imageName, dockerfileName)
+	
+	return b.build(ctx, buildContext, dockerfileName, imageName, opts, progressChan)
+}
+
+// build runs the Docker build and forwards cleaned-up progress messages
+func (b *ImageBuilder) build(ctx context.Context, buildContext io.Reader, dockerfileName, imageName string, opts BuildOptions, progressChan chan<- string) error {
 	// Prepare build options
 	buildOptions := types.ImageBuildOptions{
 		Tags:       []string{imageName},
 		Dockerfile: dockerfileName,
 		Remove:     true,
 		PullParent: true,
+		NoCache:    opts.NoCache,
+		Target:     opts.Target,
+		AuthConfigs: buildAuthConfigs(b.registries),
+	}
+	if opts.SourceHash != "" {
+		buildOptions.Labels = map[string]string{SourceHashLabel: opts.SourceHash}
+	}
+	if len(opts.BuildArgs) > 0 {
+		buildOptions.BuildArgs = make(map[string]*string, len(opts.BuildArgs))
+		for key, value := range opts.BuildArgs {
+			buildOptions.BuildArgs[key] = &value
+		}
 	}
-	
-	progressChan <- fmt.Sprintf("Building image '%s' from %s...", imageName, dockerfilePath)
 	
 	// Start the build
 	response, err := b.client.ImageBuild(ctx, buildContext, buildOptions)
@@ -186,6 +264,106 @@ func (b *ImageBuilder) BuildImage(ctx context.Context, dockerfilePath, imageName
 	return nil
 }
 
+// SourceHash hashes a Dockerfile's build context, the files BuildImage would
+// send, together with the options that affect the result
+func SourceHash(dockerfilePath string, opts BuildOptions) (string, error) {
+	contextDir := filepath.Dir(dockerfilePath)
+	hash := sha256.New()
+	fmt.Fprintf(hash, "dockerfile=%s\ntarget=%s\n", filepath.Base(dockerfilePath), opts.Target)
+	keys := make([]string, 0, len(opts.BuildArgs))
+	for key := range opts.BuildArgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(hash, "arg=%s=%s\n", key, opts.BuildArgs[key])
+	}
+
+	// WalkDir visits entries in lexical order, so the hash is stable
+	err := filepath.WalkDir(contextDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		for _, exclude := range contextExcludes {
+			if rel == exclude {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s\x00%o\x00", filepath.ToSlash(rel), info.Mode())
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			io.WriteString(hash, target)
+		case info.Mode().IsRegular():
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(hash, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+		hash.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash build context: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// FindBuiltImage returns the newest local image built from sourceHash, or
+// "" if there is none
+func (b *ImageBuilder) FindBuiltImage(ctx context.Context, sourceHash string) (string, error) {
+	images, err := b.client.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", SourceHashLabel+"="+sourceHash)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var found string
+	var newest int64
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			if tag != "<none>:<none>" && (found == "" || image.Created > newest) {
+				found, newest = tag, image.Created
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+// ImageSize returns the size in bytes of a local image
+func (b *ImageBuilder) ImageSize(ctx context.Context, imageName string) (int64, error) {
+	info, _, err := b.client.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	return info.Size, nil
+}
+
 // CheckImageExists checks if a Docker image exists locally
 func (b *ImageBuilder) CheckImageExists(ctx context.Context, imageName string) bool {
 	_, _, err := b.client.ImageInspectWithRaw(ctx, imageName)
@@ -2,12 +2,17 @@ package docker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/docker/docker/client"
 )
 
-func NewClient(host string) (*client.Client, error) {
+// DefaultOperationTimeout bounds Docker API calls when no timeout is configured
+const DefaultOperationTimeout = 30 * time.Second
+
+func NewClient(host string, operationTimeout time.Duration) (*client.Client, error) {
 	if host == "" {
 		host = "unix:///var/run/docker.sock"
 	}
@@ -20,9 +25,33 @@ func NewClient(host string) (*client.Client, error) {
 		return nil, fmt.Errorf("failed to create docker client: %w", err)
 	}
 
-	if _, err := cli.Ping(context.Background()); err != nil {
-		return nil, fmt.Errorf("failed to ping docker daemon: %w", err)
+	ctx, cancel := WithOperationTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	if _, err := cli.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ping docker daemon: %w", CheckTimeout(err, operationTimeout))
 	}
 
 	return cli, nil
-}
\ No newline at end of file
+}
+
+// WithOperationTimeout derives a context that expires after the operation timeout.
+// A non-positive timeout falls back to DefaultOperationTimeout.
+func WithOperationTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultOperationTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// CheckTimeout replaces a context deadline error with a clear timeout message
+// so a wedged daemon is reported instead of surfacing as a generic failure
+func CheckTimeout(err error, timeout time.Duration) error {
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if timeout <= 0 {
+		timeout = DefaultOperationTimeout
+	}
+	return fmt.Errorf("Docker operation timed out after %s (is the Docker daemon responsive?): %w", timeout, err)
+}
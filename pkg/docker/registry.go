@@ -0,0 +1,138 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// DefaultRegistry is the registry of images whose name has no registry host
+const DefaultRegistry = "docker.io"
+
+// dockerHubAuthKey is the key Docker expects for Docker Hub credentials in a build
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// RegistryAuth holds credentials for a private image registry
+type RegistryAuth struct {
+	Host     string `mapstructure:"host" json:"host"`
+	Username string `mapstructure:"username" json:"username"`
+	Password string `mapstructure:"password" json:"password"`
+}
+
+// RegistryHost returns the registry an image reference is pulled from
+func RegistryHost(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if !found {
+		return DefaultRegistry
+	}
+	// Like Docker, only treat the first component as a host if it looks like one
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return normalizeRegistryHost(first)
+	}
+	return DefaultRegistry
+}
+
+// FindRegistryAuth returns the credentials for the registry an image comes
+// from, or nil if none are configured
+func FindRegistryAuth(auths []RegistryAuth, image string) *RegistryAuth {
+	host := RegistryHost(image)
+	for i := range auths {
+		if normalizeRegistryHost(auths[i].Host) == host {
+			return &auths[i]
+		}
+	}
+	return nil
+}
+
+func normalizeRegistryHost(host string) string {
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	host = strings.TrimSuffix(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "docker.io", "index.docker.io/v1":
+		return DefaultRegistry
+	}
+	return host
+}
+
+// PullImage pulls an image, authenticating with auth if it is set, and
+// waits for the pull to finish. Progress messages are sent to progressChan,
+// which is closed on return, if it is not nil.
+func PullImage(ctx context.Context, cli *client.Client, image string, auth *RegistryAuth, progressChan chan<- string) error {
+	if progressChan != nil {
+		defer close(progressChan)
+	}
+
+	options := types.ImagePullOptions{}
+	if auth != nil {
+		encoded, err := registry.EncodeAuthConfig(registry.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			ServerAddress: auth.Host,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode registry credentials: %w", err)
+		}
+		options.RegistryAuth = encoded
+	}
+
+	reader, err := cli.ImagePull(ctx, image, options)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	defer reader.Close()
+
+	// Errors such as a failed layer download arrive in the progress stream
+	decoder := json.NewDecoder(reader)
+	for {
+		var message jsonmessage.JSONMessage
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("error reading pull output: %w", err)
+		}
+		if message.Error != nil {
+			return fmt.Errorf("failed to pull image %s: %s", image, message.Error.Message)
+		}
+
+		if progressChan != nil && message.Status != "" {
+			status := message.Status
+			if message.ID != "" {
+				status = message.ID + ": " + status
+			}
+			if message.ProgressMessage != "" {
+				status += " " + message.ProgressMessage
+			}
+			progressChan <- status
+		}
+	}
+	return nil
+}
+
+// buildAuthConfigs maps registry credentials to the form ImageBuild expects
+// for pulling base images
+func buildAuthConfigs(auths []RegistryAuth) map[string]registry.AuthConfig {
+	if len(auths) == 0 {
+		return nil
+	}
+	configs := make(map[string]registry.AuthConfig, len(auths))
+	for _, auth := range auths {
+		key := normalizeRegistryHost(auth.Host)
+		if key == DefaultRegistry {
+			key = dockerHubAuthKey
+		}
+		configs[key] = registry.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			ServerAddress: key,
+		}
+	}
+	return configs
+}
@@ -23,6 +23,8 @@ type Metrics struct {
 	Network      NetStats  `json:"network"`
 	Disk         DiskStats `json:"disk"`
 	ContainerID  string    `json:"container_id"`
+	// Samples is how many raw samples an hourly average was made from
+	Samples      int       `json:"samples,omitempty"`
 }
 
 // CPUStats represents CPU usage statistics
@@ -60,6 +62,9 @@ type Collector struct {
 	storage      *storage.Storage
 	redisClient  *redis.Client
 	
+	retention    time.Duration
+	rawRetention time.Duration
+	
 	mu       sync.RWMutex
 	agents   map[string]*agentCollector
 	stopChan chan struct{}
@@ -79,12 +84,15 @@ func NewCollector(dockerClient *client.Client, storage *storage.Storage) *Collec
 		dockerClient: dockerClient,
 		storage:      storage,
 		redisClient:  storage.GetRedisClient(),
+		retention:    DefaultRetention,
+		rawRetention: DefaultRawRetention,
 		agents:       make(map[string]*agentCollector),
 		stopChan:     make(chan struct{}),
 	}
 }
 
-// Start begins metrics collection
+// Start begins metrics collection and blocks watching for agent events
+// until ctx is cancelled or the collector is stopped
 func (c *Collector) Start(ctx context.Context) error {
 	log.Println("Starting metrics collector...")
 	
@@ -100,10 +108,8 @@ func (c *Collector) Start(ctx context.Context) error {
 		}
 	}
 	
-	// Subscribe to agent events
-	go c.watchAgentEvents(ctx)
-	
-	return nil
+	// Watch agent events
+	return c.watchAgentEvents(ctx)
 }
 
 // Stop gracefully stops the collector
@@ -170,33 +176,31 @@ func (c *Collector) GetMetrics(agentID string) (*Metrics, error) {
 	return &metrics, nil
 }
 
-// GetMetricsHistory retrieves historical metrics for an agent
+// GetMetricsHistory retrieves historical metrics for an agent. Ranges longer
+// than the raw retention are returned as hourly averages.
 func (c *Collector) GetMetricsHistory(agentID string, duration time.Duration) ([]Metrics, error) {
 	ctx := context.Background()
 	endTime := time.Now()
 	startTime := endTime.Add(-duration)
+	min := fmt.Sprintf("%d", startTime.Unix())
+	max := fmt.Sprintf("%d", endTime.Unix())
 	
 	// Use Redis sorted set to store time-series data
-	key := fmt.Sprintf("metrics:history:%s", agentID)
-	results, err := c.redisClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{
-		Min: fmt.Sprintf("%d", startTime.Unix()),
-		Max: fmt.Sprintf("%d", endTime.Unix()),
-	}).Result()
-	
+	raw, err := c.rangeSamples(ctx, historyKey(agentID), min, max)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get metrics history: %w", err)
 	}
-	
-	metrics := make([]Metrics, 0, len(results))
-	for _, result := range results {
-		var m Metrics
-		if err := json.Unmarshal([]byte(result), &m); err != nil {
-			continue
-		}
-		metrics = append(metrics, m)
+	if !c.Downsampled(duration) {
+		return raw, nil
 	}
 	
-	return metrics, nil
+	// Older hours are already compacted; average the recent raw samples
+	// the same way
+	metrics, err := c.rangeSamples(ctx, hourlyKey(agentID), min, max)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metrics history: %w", err)
+	}
+	return append(metrics, downsample(raw)...), nil
 }
 
 func (c *Collector) collectMetrics(collector *agentCollector) {
@@ -221,6 +225,13 @@ func (c *Collector) collectMetrics(collector *agentCollector) {
 }
 
 func (c *Collector) collectOnce(collector *agentCollector) {
+	// A panicking collection must not take the agent's collection loop down with it
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Metrics collection for agent %s panicked: %v", collector.agentID, r)
+		}
+	}()
+	
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	
@@ -309,19 +320,14 @@ func (c *Collector) storeMetrics(metrics *Metrics) {
 	currentKey := fmt.Sprintf("metrics:current:%s", metrics.AgentID)
 	c.redisClient.Set(ctx, currentKey, data, 1*time.Hour)
 	
-	// Store in history (keep 24 hours of data)
-	historyKey := fmt.Sprintf("metrics:history:%s", metrics.AgentID)
-	c.redisClient.ZAdd(ctx, historyKey, &redis.Z{
+	// Store in history; the compactor downsamples and expires old samples
+	c.redisClient.ZAdd(ctx, historyKey(metrics.AgentID), &redis.Z{
 		Score:  float64(metrics.Timestamp.Unix()),
 		Member: string(data),
 	})
-	
-	// Clean up old data
-	cutoff := time.Now().Add(-24 * time.Hour).Unix()
-	c.redisClient.ZRemRangeByScore(ctx, historyKey, "0", fmt.Sprintf("%d", cutoff))
 }
 
-func (c *Collector) watchAgentEvents(ctx context.Context) {
+func (c *Collector) watchAgentEvents(ctx context.Context) error {
 	// Subscribe to agent status changes
 	pubsub := c.redisClient.Subscribe(ctx, "agent:status:*")
 	defer pubsub.Close()
@@ -329,7 +335,10 @@ func (c *Collector) watchAgentEvents(ctx context.Context) {
 	ch := pubsub.Channel()
 	for {
 		select {
-		case msg := <-ch:
+		case msg, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("agent event subscription closed")
+			}
 			// Parse agent ID from channel name
 			if len(msg.Channel) > 13 { // "agent:status:"
 				agentID := msg.Channel[13:]
@@ -347,9 +356,9 @@ func (c *Collector) watchAgentEvents(ctx context.Context) {
 				}
 			}
 		case <-ctx.Done():
-			return
+			return nil
 		case <-c.stopChan:
-			return
+			return nil
 		}
 	}
 }
\ No newline at end of file
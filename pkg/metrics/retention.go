@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// DefaultRetention is how long metrics history is kept by default
+	DefaultRetention = 7 * 24 * time.Hour
+	// DefaultRawRetention is how long samples are kept at full resolution
+	// before being downsampled into hourly averages
+	DefaultRawRetention = 24 * time.Hour
+	// compactInterval is how often the compactor runs
+	compactInterval = 10 * time.Minute
+)
+
+func historyKey(agentID string) string {
+	return fmt.Sprintf("metrics:history:%s", agentID)
+}
+
+func hourlyKey(agentID string) string {
+	return fmt.Sprintf("metrics:hourly:%s", agentID)
+}
+
+// SetRetention sets how long metrics history is kept and how much of it is
+// kept at full resolution. Zero values keep the defaults.
+func (c *Collector) SetRetention(retention, rawRetention time.Duration) {
+	if retention > 0 {
+		c.retention = retention
+	}
+	if rawRetention > 0 {
+		c.rawRetention = rawRetention
+	}
+}
+
+// Retention returns how long metrics history is kept
+func (c *Collector) Retention() time.Duration {
+	return c.retention
+}
+
+// Downsampled reports whether history over duration is served as hourly
+// averages rather than raw samples
+func (c *Collector) Downsampled(duration time.Duration) bool {
+	return duration > c.rawRetention && c.rawRetention < c.retention
+}
+
+// RunCompactor periodically downsamples raw samples older than the raw
+// retention into hourly averages and deletes history past retention. It
+// blocks until ctx is cancelled or the collector is stopped.
+func (c *Collector) RunCompactor(ctx context.Context) error {
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+	
+	for {
+		if err := c.Compact(ctx); err != nil {
+			log.Printf("Metrics compaction failed: %v", err)
+		}
+		
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		case <-c.stopChan:
+			return nil
+		}
+	}
+}
+
+// Compact runs one compaction pass over the history of every agent,
+// including removed agents whose history has not expired yet
+func (c *Collector) Compact(ctx context.Context) error {
+	agentIDs := make(map[string]bool)
+	for _, prefix := range []string{"metrics:history:", "metrics:hourly:"} {
+		iter := c.redisClient.Scan(ctx, 0, prefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			agentIDs[strings.TrimPrefix(iter.Val(), prefix)] = true
+		}
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("failed to scan metrics history: %w", err)
+		}
+	}
+	
+	for agentID := range agentIDs {
+		if err := c.compactAgent(ctx, agentID); err != nil {
+			log.Printf("Failed to compact metrics for agent %s: %v", agentID, err)
+		}
+	}
+	return nil
+}
+
+func (c *Collector) compactAgent(ctx context.Context, agentID string) error {
+	now := time.Now()
+	expired := now.Add(-c.retention).Unix()
+	
+	pipe := c.redisClient.TxPipeline()
+	if c.rawRetention < c.retention {
+		// Only whole hours are compacted, so an hour is never split between
+		// raw samples and its average
+		cutoff := now.Add(-c.rawRetention).Truncate(time.Hour).Unix()
+		raw, err := c.rangeSamples(ctx, historyKey(agentID), "-inf", fmt.Sprintf("(%d", cutoff))
+		if err != nil {
+			return err
+		}
+		
+		for _, avg := range downsample(raw) {
+			if avg.Timestamp.Unix() < expired {
+				continue
+			}
+			data, err := json.Marshal(avg)
+			if err != nil {
+				return fmt.Errorf("failed to marshal hourly metrics: %w", err)
+			}
+			score := fmt.Sprintf("%d", avg.Timestamp.Unix())
+			pipe.ZRemRangeByScore(ctx, hourlyKey(agentID), score, score)
+			pipe.ZAdd(ctx, hourlyKey(agentID), &redis.Z{
+				Score:  float64(avg.Timestamp.Unix()),
+				Member: string(data),
+			})
+		}
+		pipe.ZRemRangeByScore(ctx, historyKey(agentID), "-inf", fmt.Sprintf("(%d", cutoff))
+	} else {
+		pipe.ZRemRangeByScore(ctx, historyKey(agentID), "-inf", fmt.Sprintf("(%d", expired))
+	}
+	pipe.ZRemRangeByScore(ctx, hourlyKey(agentID), "-inf", fmt.Sprintf("(%d", expired))
+	
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store compacted metrics: %w", err)
+	}
+	return nil
+}
+
+// rangeSamples returns the samples in a history key with scores between min
+// and max, oldest first
+func (c *Collector) rangeSamples(ctx context.Context, key, min, max string) ([]Metrics, error) {
+	results, err := c.redisClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: min,
+		Max: max,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	
+	samples := make([]Metrics, 0, len(results))
+	for _, result := range results {
+		var m Metrics
+		if err := json.Unmarshal([]byte(result), &m); err != nil {
+			continue
+		}
+		samples = append(samples, m)
+	}
+	return samples, nil
+}
+
+// downsample averages samples into one per hour, stamped with the start of
+// the hour. Usage figures are averaged; cumulative counters and limits take
+// the last value of the hour.
+func downsample(samples []Metrics) []Metrics {
+	buckets := make(map[int64][]Metrics)
+	for _, m := range samples {
+		hour := m.Timestamp.Truncate(time.Hour).Unix()
+		buckets[hour] = append(buckets[hour], m)
+	}
+	
+	averages := make([]Metrics, 0, len(buckets))
+	for hour, bucket := range buckets {
+		sort.Slice(bucket, func(i, j int) bool { return bucket[i].Timestamp.Before(bucket[j].Timestamp) })
+		
+		avg := bucket[len(bucket)-1]
+		avg.Timestamp = time.Unix(hour, 0)
+		avg.Samples = 0
+		
+		var cpuPercent, memPercent float64
+		var memUsage, memCache uint64
+		for _, m := range bucket {
+			cpuPercent += m.CPU.UsagePercent
+			memPercent += m.Memory.UsagePercent
+			memUsage += m.Memory.Usage
+			memCache += m.Memory.Cache
+			avg.Samples += sampleCount(m)
+		}
+		n := len(bucket)
+		avg.CPU.UsagePercent = cpuPercent / float64(n)
+		avg.Memory.UsagePercent = memPercent / float64(n)
+		avg.Memory.Usage = memUsage / uint64(n)
+		avg.Memory.Cache = memCache / uint64(n)
+		
+		averages = append(averages, avg)
+	}
+	
+	sort.Slice(averages, func(i, j int) bool { return averages[i].Timestamp.Before(averages[j].Timestamp) })
+	return averages
+}
+
+// sampleCount is how many raw samples a history entry stands for
+func sampleCount(m Metrics) int {
+	if m.Samples > 0 {
+		return m.Samples
+	}
+	return 1
+}